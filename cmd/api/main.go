@@ -5,15 +5,22 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/scythe504/solana-indexer/internal/auth"
+	"github.com/scythe504/solana-indexer/internal/backfill"
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/scythe504/solana-indexer/internal/jobs"
+	"github.com/scythe504/solana-indexer/internal/kafka"
 	"github.com/scythe504/solana-indexer/internal/server"
 )
 
-func gracefulShutdown(apiServer *http.Server, done chan bool) {
+// gracefulShutdown stops the server on an interrupt signal, then calls
+// stoppers in order and closes the queue once nothing produces to it.
+func gracefulShutdown(apiServer *http.Server, queue kafka.Queue, stoppers []func(), done chan bool) {
 	// Create context that listens for the interrupt signal from the OS.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -31,22 +38,132 @@ func gracefulShutdown(apiServer *http.Server, done chan bool) {
 		log.Printf("Server forced to shutdown with error: %v", err)
 	}
 
+	for _, stop := range stoppers {
+		stop()
+	}
+
+	// Every request has returned, so this only waits for records still
+	// buffered by requests that timed out
+	queue.Close()
+
 	log.Println("Server exiting")
 
 	// Notify the main goroutine that the shutdown is complete
 	done <- true
 }
 
+// startInProcessWorker runs the consumer pool inside the API process when
+// RUN_WORKER is set to true, or when QUEUE_BACKEND is embedded so that a
+// single process ingests and indexes. The returned function stops the pool and
+// waits up to 10 seconds for the workers to commit and exit.
+func startInProcessWorker(queue kafka.Queue) func() {
+	if os.Getenv("RUN_WORKER") != "true" && !kafka.EmbeddedQueueEnabled() {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	workerDone := make(chan struct{})
+
+	go func() {
+		defer close(workerDone)
+		if err := queue.ConsumeWebhookPayload(ctx, kafka.WorkerConcurrency()); err != nil {
+			log.Printf("In-process worker failed to start: %v", err)
+		}
+	}()
+
+	return func() {
+		cancel()
+		select {
+		case <-workerDone:
+			log.Println("In-process worker stopped")
+		case <-time.After(10 * time.Second):
+			log.Println("In-process worker did not stop in time")
+		}
+	}
+}
+
+// startHeliusOutbox retries the Helius calls subscription changes could not
+// make straight away, every HELIUS_OUTBOX_INTERVAL (default 30s).
+func startHeliusOutbox() func() {
+	db := database.New()
+	return jobs.RunPeriodically("helius outbox", "HELIUS_OUTBOX_INTERVAL", 30*time.Second, func(ctx context.Context) error {
+		completed, err := db.ProcessHeliusOutbox(ctx)
+		if completed > 0 {
+			log.Printf("Completed %d pending helius calls", completed)
+		}
+		return err
+	})
+}
+
+// startWebhookReconciler runs the webhook reconciler every
+// HELIUS_RECONCILE_INTERVAL, which is unset by default. Drift is only
+// reported when HELIUS_RECONCILE_DRY_RUN is true.
+func startWebhookReconciler() func() {
+	dryRun := os.Getenv("HELIUS_RECONCILE_DRY_RUN") == "true"
+	db := database.New()
+	return jobs.RunPeriodically("webhook reconciler", "HELIUS_RECONCILE_INTERVAL", 0, func(ctx context.Context) error {
+		report, err := db.ReconcileWebhooks(ctx, dryRun)
+		if err != nil {
+			return err
+		}
+		if len(report.Drift) > 0 || len(report.UnwatchedAddresses) > 0 || report.LookupRowsDrifted > 0 {
+			log.Printf("Webhook reconciliation (dry run: %t) found %d drifted webhooks, %d unwatched addresses, %d drifted lookup rows",
+				dryRun, len(report.Drift), len(report.UnwatchedAddresses), report.LookupRowsDrifted)
+		}
+		return nil
+	})
+}
+
+// startBackfillRunner runs queued backfill jobs, checking for new ones every
+// BACKFILL_INTERVAL (default 10s).
+func startBackfillRunner(queue kafka.Queue) func() {
+	runner := backfill.NewRunner(database.New(), queue)
+	return jobs.RunPeriodically("backfill runner", "BACKFILL_INTERVAL", 10*time.Second, func(ctx context.Context) error {
+		ran, err := runner.RunPending(ctx)
+		if ran > 0 {
+			log.Printf("Ran %d backfill jobs", ran)
+		}
+		return err
+	})
+}
+
+// startGapDetector looks for transactions the webhooks missed every
+// GAP_CHECK_INTERVAL (default 5m).
+func startGapDetector(queue kafka.Queue) func() {
+	detector := backfill.NewGapDetector(database.New(), queue)
+	return jobs.RunPeriodically("gap detector", "GAP_CHECK_INTERVAL", 5*time.Minute, func(ctx context.Context) error {
+		report, err := detector.Run(ctx)
+		if report != nil && report.MissingTransactions > 0 {
+			log.Printf("Queued %d missing transactions of %d addresses", report.MissingTransactions, report.AddressesChecked)
+		}
+		return err
+	})
+}
+
 func main() {
 	auth.NewAuth()
 
-	server := server.NewServer()
+	// Every loop shares the queue, and with it the buffered producer, which
+	// is closed once they have all stopped
+	queue := kafka.NewQueue()
+	server := server.NewServer(queue)
+
+	// Stopped in this order once the server has shut down. The in-process
+	// worker goes first so that no request is still producing while the
+	// consumers commit their last batch
+	stoppers := []func(){
+		startInProcessWorker(queue),
+		startHeliusOutbox(),
+		startWebhookReconciler(),
+		startBackfillRunner(queue),
+		startGapDetector(queue),
+	}
 
 	// Create a done channel to signal when the shutdown is complete
 	done := make(chan bool, 1)
 
 	// Run graceful shutdown in a separate goroutine
-	go gracefulShutdown(server, done)
+	go gracefulShutdown(server, queue, stoppers, done)
 
 	err := server.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os/signal"
+	"syscall"
+
+	_ "github.com/joho/godotenv/autoload"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+// reconcile-lookup rebuilds subscription_lookup from the subscriptions table,
+// for data written before the lookup was kept in sync, and reassigns webhook
+// ids from the address lists Helius reports.
+func main() {
+	withHelius := flag.Bool("helius", true, "assign webhook ids from the address lists on Helius")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db := database.New()
+	defer db.Close()
+
+	report, err := db.ReconcileSubscriptionLookup(ctx, *withHelius)
+	if err != nil {
+		log.Fatalf("Lookup reconciliation failed: %v", err)
+	}
+
+	log.Printf("Reconciled %d subscriptions, deleted %d orphaned lookup rows, assigned %d webhook ids",
+		report.Subscriptions, report.OrphansDeleted, report.WebhookIdsAssigned)
+}
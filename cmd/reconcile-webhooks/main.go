@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	_ "github.com/joho/godotenv/autoload"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+// reconcile-webhooks compares the Helius webhooks with the active
+// subscriptions and prints the drift as JSON. It only fixes the drift when
+// run with -dry-run=false.
+func main() {
+	dryRun := flag.Bool("dry-run", true, "report the drift without changing Helius or the database")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db := database.New()
+	defer db.Close()
+
+	report, err := db.ReconcileWebhooks(ctx, *dryRun)
+	if err != nil {
+		log.Fatalf("Webhook reconciliation failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to print the report: %v", err)
+	}
+}
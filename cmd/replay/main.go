@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+// replay queues a replay of KAFKA_TOPIC into one subscription, whoever owns
+// it, and prints its progress as JSON until the worker finishes it. With
+// -job it only follows an existing replay.
+func main() {
+	subscriptionId := flag.String("subscription", "", "id of the subscription to replay into")
+	from := flag.String("from", "", "start of the time range, RFC 3339")
+	to := flag.String("to", "", "end of the time range, RFC 3339, defaults to now")
+	fromSlot := flag.Int64("from-slot", -1, "skip transactions before this slot")
+	toSlot := flag.Int64("to-slot", -1, "skip transactions after this slot")
+	jobId := flag.String("job", "", "follow an existing replay instead of queueing one")
+	follow := flag.Bool("follow", true, "print the progress until the replay ends")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db := database.New()
+	defer db.Close()
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	id := *jobId
+	if id == "" {
+		job, err := db.CreateReplayJob("", *subscriptionId, parseTime("from", *from, time.Time{}), parseTime("to", *to, time.Now()), optionalSlot(*fromSlot), optionalSlot(*toSlot))
+		if err != nil {
+			log.Fatalf("Failed to queue the replay: %v", err)
+		}
+		id = job.Id
+	}
+
+	for {
+		job, err := db.GetReplayJob(id)
+		if err != nil {
+			log.Fatalf("Failed to load replay %s: %v", id, err)
+		}
+		if err = encoder.Encode(job); err != nil {
+			log.Fatalf("Failed to print the replay: %v", err)
+		}
+
+		if !*follow || job.FinishedAt != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func parseTime(name string, value string, fallback time.Time) time.Time {
+	if value == "" {
+		if fallback.IsZero() {
+			log.Fatalf("-%s is required", name)
+		}
+		return fallback
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("Invalid -%s: %v", name, err)
+	}
+	return t
+}
+
+func optionalSlot(slot int64) *int64 {
+	if slot < 0 {
+		return nil
+	}
+	return &slot
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+
+	_ "github.com/joho/godotenv/autoload"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+// rotate-keys re-encrypts every stored user database credential and signing
+// secret with the active key from CREDENTIALS_ACTIVE_KEY_ID. Keep the previous key in
+// CREDENTIALS_KEYS until it has finished, so rows not yet rotated can still
+// be read.
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db := database.New()
+	defer db.Close()
+
+	rotated, err := db.RotateCredentialKeys(ctx)
+	if err != nil {
+		log.Fatalf("Key rotation stopped after %d rows: %v", rotated, err)
+	}
+
+	log.Printf("Rotated %d credentials and signing secrets", rotated)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/scythe504/solana-indexer/internal/jobs"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+	"github.com/scythe504/solana-indexer/internal/metrics"
+	"github.com/scythe504/solana-indexer/internal/replay"
+)
+
+// gracefulShutdown stops the worker on an interrupt signal, or once the pool
+// exits on its own, by calling stoppers in order, and sends done the error
+// the pool failed with.
+func gracefulShutdown(workerErr chan error, stoppers []func(), done chan error) {
+	// Create context that listens for the interrupt signal from the OS.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Listen for the interrupt signal, or for the pool exiting on its own.
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Println("shutting down gracefully, press Ctrl+C again to force")
+	case err = <-workerErr:
+		if err != nil {
+			log.Printf("worker error: %v, shutting down", err)
+		}
+	}
+
+	for _, stop := range stoppers {
+		stop()
+	}
+
+	log.Println("Worker exiting")
+
+	// Notify the main goroutine that the shutdown is complete
+	done <- err
+}
+
+// startWorker runs the consumer pool, sending workerErr the error it exits
+// with. The returned function stops the pool and gives the workers 10
+// seconds to finish and commit the batch they are currently processing.
+func startWorker(workerErr chan error) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	workerDone := make(chan struct{})
+
+	go func() {
+		defer close(workerDone)
+		// A failed pool stops through the same shutdown as a signal
+		workerErr <- kafka.NewQueue().ConsumeWebhookPayload(ctx, kafka.WorkerConcurrency())
+	}()
+
+	return func() {
+		cancel()
+		select {
+		case <-workerDone:
+		case <-time.After(10 * time.Second):
+			log.Println("Worker forced to shutdown before committing its last batch")
+		}
+	}
+}
+
+// startReplayRunner runs queued replay jobs, checking for new ones every
+// REPLAY_INTERVAL (default 10s). Replays read the Kafka topic, so there is
+// nothing to run with the embedded queue.
+func startReplayRunner() func() {
+	if kafka.EmbeddedQueueEnabled() {
+		return func() {}
+	}
+
+	kafkaManager := kafka.NewKafkaClientManager()
+	runner := replay.NewRunner(database.New(), kafkaManager)
+	stop := jobs.RunPeriodically("replay runner", "REPLAY_INTERVAL", 10*time.Second, func(ctx context.Context) error {
+		ran, err := runner.RunPending(ctx)
+		if ran > 0 {
+			log.Printf("Ran %d replay jobs", ran)
+		}
+		return err
+	})
+
+	return func() {
+		stop()
+		kafkaManager.Close()
+	}
+}
+
+func main() {
+	workerErr := make(chan error, 1)
+
+	// Create a done channel to signal when the shutdown is complete
+	done := make(chan error, 1)
+
+	// Serve the worker's counters when a metrics address is configured
+	if metricsAddr := os.Getenv("METRICS_ADDR"); metricsAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(metricsAddr, metrics.Handler()); err != nil {
+				log.Printf("metrics server error: %v", err)
+			}
+		}()
+	}
+
+	// Stopped in this order. Replays go first, the consumers close every
+	// sink once they exit
+	stoppers := []func(){
+		startReplayRunner(),
+		startWorker(workerErr),
+	}
+
+	// Run graceful shutdown in a separate goroutine
+	go gracefulShutdown(workerErr, stoppers, done)
+
+	// Wait for the graceful shutdown to complete
+	if err := <-done; err != nil {
+		log.Fatalf("Worker stopped after an error: %v", err)
+	}
+	log.Println("Graceful shutdown complete.")
+}
@@ -0,0 +1,163 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/scythe504/solana-indexer/internal/helius"
+	"github.com/scythe504/solana-indexer/internal/ingest"
+	"github.com/scythe504/solana-indexer/internal/jobs"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+)
+
+const (
+	defaultPageSize    = 100
+	defaultMaxAttempts = 5
+)
+
+// Runner works through queued backfill jobs. Each job pages
+// getSignaturesForAddress back from the newest transaction of its address,
+// fetches every transaction of a page and queues them,
+// checkpointing after each page.
+type Runner struct {
+	db          database.Service
+	helius      *helius.Client
+	queue       kafka.Queue
+	pageSize    int
+	maxAttempts int
+}
+
+// NewRunner reads BACKFILL_PAGE_SIZE (default 100, at most 1000) and
+// BACKFILL_MAX_ATTEMPTS (default 5).
+func NewRunner(db database.Service, queue kafka.Queue) *Runner {
+	pageSize, err := strconv.Atoi(os.Getenv("BACKFILL_PAGE_SIZE"))
+	if err != nil || pageSize <= 0 || pageSize > 1000 {
+		pageSize = defaultPageSize
+	}
+	maxAttempts, err := strconv.Atoi(os.Getenv("BACKFILL_MAX_ATTEMPTS"))
+	if err != nil || maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	return &Runner{
+		db:          db,
+		helius:      helius.NewClient(),
+		queue:       queue,
+		pageSize:    pageSize,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// RunPending runs claimed jobs until none is left and returns how many it
+// ran, failed runs included.
+func (r *Runner) RunPending(ctx context.Context) (int, error) {
+	return jobs.RunPending(ctx, r.claim)
+}
+
+func (r *Runner) claim(ctx context.Context, staleAfter time.Duration) (jobs.Job, error) {
+	job, err := r.db.ClaimBackfillJob(ctx, staleAfter)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	return &backfillJob{runner: r, job: job}, nil
+}
+
+// backfillJob is a claimed backfill job.
+type backfillJob struct {
+	runner *Runner
+	job    *database.BackfillJob
+}
+
+func (j *backfillJob) Run(ctx context.Context) error {
+	return j.runner.run(ctx, j.job)
+}
+
+func (j *backfillJob) Heartbeat(ctx context.Context) error {
+	return j.runner.db.HeartbeatBackfillJob(ctx, j.job.Id)
+}
+
+func (j *backfillJob) Finish(ctx context.Context, cause error) error {
+	if cause != nil {
+		log.Printf("Backfill job %s for subscription %s failed: %v", j.job.Id, j.job.SubscriptionId, cause)
+	}
+	return j.runner.db.FinishBackfillJob(ctx, j.job.Id, cause, j.runner.maxAttempts)
+}
+
+func (r *Runner) run(ctx context.Context, job *database.BackfillJob) error {
+	before := ""
+	if job.BeforeSignature != nil {
+		before = *job.BeforeSignature
+	}
+
+	for {
+		signatures, err := r.helius.GetSignaturesForAddress(ctx, job.TokenAddress, before, "", r.pageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list signatures: %w", err)
+		}
+		if len(signatures) == 0 {
+			return nil
+		}
+
+		keep, reachedEnd := withinRange(signatures, job.UntilSlot, job.UntilTime)
+
+		var payloads []kafka.WebhookPayload
+		for _, signature := range keep {
+			// Webhooks only send successful transactions, so neither does
+			// the backfill
+			if signature.Err != nil {
+				continue
+			}
+
+			txn, err := r.helius.GetTransaction(ctx, signature.Signature)
+			if errors.Is(err, helius.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to fetch transaction %s: %w", signature.Signature, err)
+			}
+
+			normalised, err := ingest.NormalizeRPCTransactions(txn)
+			if err != nil {
+				return fmt.Errorf("failed to normalise transaction %s: %w", signature.Signature, err)
+			}
+			payloads = append(payloads, normalised...)
+		}
+
+		if len(payloads) > 0 {
+			if err := kafka.PushBackfillToProducer(r.queue, payloads, job.SubscriptionId, job.Id); err != nil {
+				return err
+			}
+		}
+
+		before = signatures[len(signatures)-1].Signature
+		if err := r.db.CheckpointBackfillJob(ctx, job.Id, before, len(keep), len(payloads)); err != nil {
+			return err
+		}
+
+		if reachedEnd || len(signatures) < r.pageSize {
+			return nil
+		}
+	}
+}
+
+// withinRange returns the signatures of a page, newest first, that are not
+// older than untilSlot and untilTime, and whether the page went past them.
+// Signatures without a block time are kept.
+func withinRange(signatures []helius.SignatureInfo, untilSlot *int64, untilTime *time.Time) ([]helius.SignatureInfo, bool) {
+	for i, signature := range signatures {
+		tooOld := untilSlot != nil && signature.Slot < *untilSlot
+		if untilTime != nil && signature.BlockTime != nil && time.Unix(*signature.BlockTime, 0).Before(*untilTime) {
+			tooOld = true
+		}
+		if tooOld {
+			return signatures[:i], true
+		}
+	}
+	return signatures, false
+}
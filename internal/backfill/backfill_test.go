@@ -0,0 +1,34 @@
+package backfill
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/helius"
+)
+
+func TestWithinRange(t *testing.T) {
+	blockTime := func(unix int64) *int64 { return &unix }
+	page := []helius.SignatureInfo{
+		{Signature: "c", Slot: 300, BlockTime: blockTime(3000)},
+		{Signature: "b", Slot: 200, BlockTime: nil},
+		{Signature: "a", Slot: 100, BlockTime: blockTime(1000)},
+	}
+
+	untilSlot := int64(150)
+	keep, reachedEnd := withinRange(page, &untilSlot, nil)
+	if len(keep) != 2 || !reachedEnd {
+		t.Fatalf("expected to stop before slot 100, got %d signatures, end %t", len(keep), reachedEnd)
+	}
+
+	untilTime := time.Unix(2000, 0)
+	keep, reachedEnd = withinRange(page, nil, &untilTime)
+	if len(keep) != 2 || !reachedEnd {
+		t.Fatalf("expected to stop before block time 1000, got %d signatures, end %t", len(keep), reachedEnd)
+	}
+
+	keep, reachedEnd = withinRange(page, nil, nil)
+	if len(keep) != 3 || reachedEnd {
+		t.Fatalf("expected the whole page without a bound, got %d signatures, end %t", len(keep), reachedEnd)
+	}
+}
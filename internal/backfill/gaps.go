@@ -0,0 +1,234 @@
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/scythe504/solana-indexer/internal/helius"
+	"github.com/scythe504/solana-indexer/internal/ingest"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+	"github.com/scythe504/solana-indexer/internal/metrics"
+)
+
+const (
+	defaultSettleDelay = 2 * time.Minute
+	gapPageSize        = 1000
+)
+
+// GapDetector finds transactions of watched addresses the worker never
+// received, e.g. while the receiver was down or Helius dropped deliveries,
+// and produces them again.
+type GapDetector struct {
+	db     database.Service
+	helius *helius.Client
+	queue  kafka.Queue
+	// Transactions younger than this may still be on their way and are left
+	// to the next run
+	settleDelay time.Duration
+}
+
+// GapReport sums up a run of the gap detector.
+type GapReport struct {
+	AddressesChecked    int `json:"addresses_checked"`
+	SignaturesChecked   int `json:"signatures_checked"`
+	MissingTransactions int `json:"missing_transactions"`
+}
+
+// NewGapDetector reads GAP_SETTLE_DELAY (default 2m).
+func NewGapDetector(db database.Service, queue kafka.Queue) *GapDetector {
+	settleDelay, err := time.ParseDuration(os.Getenv("GAP_SETTLE_DELAY"))
+	if err != nil || settleDelay <= 0 {
+		settleDelay = defaultSettleDelay
+	}
+
+	return &GapDetector{
+		db:          db,
+		helius:      helius.NewClient(),
+		queue:       queue,
+		settleDelay: settleDelay,
+	}
+}
+
+// Run checks every watched address. An address that fails is logged and
+// checked again from the same point on the next run.
+func (d *GapDetector) Run(ctx context.Context) (*GapReport, error) {
+	watched, err := d.db.GetWatchedAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var report GapReport
+	for _, address := range watched {
+		if ctx.Err() != nil {
+			return &report, ctx.Err()
+		}
+
+		checked, missing, err := d.check(ctx, address)
+		if err != nil {
+			log.Printf("Gap check of %s failed: %v", address.Cursor.TokenAddress, err)
+			continue
+		}
+		report.AddressesChecked++
+		report.SignaturesChecked += checked
+		report.MissingTransactions += missing
+	}
+
+	return &report, nil
+}
+
+// check compares the transactions of an address since its last check with
+// the ones the worker received, and returns how many were checked and
+// missing.
+func (d *GapDetector) check(ctx context.Context, address database.WatchedAddress) (int, int, error) {
+	signatures, err := d.newSignatures(ctx, address)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(signatures) == 0 {
+		return 0, 0, nil
+	}
+
+	// Webhooks only send successful transactions
+	var successful []string
+	for _, signature := range signatures {
+		if signature.Err == nil {
+			successful = append(successful, signature.Signature)
+		}
+	}
+
+	var missing []kafka.WebhookPayload
+	if len(successful) > 0 {
+		unseen, err := d.db.FilterUnseenSignatures(ctx, address.Cursor.TokenAddress, successful)
+		if err != nil {
+			return 0, 0, err
+		}
+		if missing, err = d.fetchMissing(ctx, unseen, address.TransactionTypes); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if len(missing) > 0 {
+		if err := kafka.PushCatchUpToProducer(d.queue, missing, address.Cursor.TokenAddress); err != nil {
+			return 0, 0, err
+		}
+		metrics.MissingTransactions.Add(int64(len(missing)))
+	}
+
+	newest := signatures[0]
+	gaps := findGaps(signatures, missing)
+	if err := d.db.RecordGapCheck(ctx, address.Cursor.TokenAddress, newest.Slot, newest.Signature, gaps); err != nil {
+		return 0, 0, err
+	}
+
+	return len(signatures), len(missing), nil
+}
+
+// newSignatures pages the signatures of an address, newest first, back to
+// the last checked one, or to the creation of its oldest subscription on the
+// first check. Signatures younger than the settle delay are left out.
+func (d *GapDetector) newSignatures(ctx context.Context, address database.WatchedAddress) ([]helius.SignatureInfo, error) {
+	until := ""
+	if address.Cursor.CheckedSignature != nil {
+		until = *address.Cursor.CheckedSignature
+	}
+	settled := time.Now().Add(-d.settleDelay)
+
+	var (
+		signatures []helius.SignatureInfo
+		before     string
+	)
+	for {
+		page, err := d.helius.GetSignaturesForAddress(ctx, address.Cursor.TokenAddress, before, until, gapPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list signatures: %w", err)
+		}
+
+		for _, signature := range page {
+			if signature.BlockTime == nil {
+				continue
+			}
+			blockTime := time.Unix(*signature.BlockTime, 0)
+			if blockTime.After(settled) {
+				continue
+			}
+			if blockTime.Before(address.WatchedSince) {
+				return signatures, nil
+			}
+			signatures = append(signatures, signature)
+		}
+
+		if len(page) < gapPageSize {
+			return signatures, nil
+		}
+		before = page[len(page)-1].Signature
+	}
+}
+
+// fetchMissing returns the unseen transactions of a type the address'
+// subscriptions index. Helius leaves the other types out of webhooks, so
+// they were never missing.
+func (d *GapDetector) fetchMissing(ctx context.Context, unseen []string, txnTypes []string) ([]kafka.WebhookPayload, error) {
+	var missing []kafka.WebhookPayload
+	for chunk := range slices.Chunk(unseen, helius.MaxEnhancedTransactions) {
+		body, err := d.helius.GetEnhancedTransactions(ctx, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch transactions: %w", err)
+		}
+
+		payloads, err := ingest.NormalizeHelius(body)
+		if err != nil {
+			return nil, err
+		}
+		for _, payload := range payloads {
+			if slices.Contains(txnTypes, payload.Type) {
+				missing = append(missing, payload)
+			}
+		}
+	}
+
+	return missing, nil
+}
+
+// findGaps groups the missing transactions into runs of consecutive
+// signatures of the checked ones, which are newest first.
+func findGaps(signatures []helius.SignatureInfo, missing []kafka.WebhookPayload) []database.AddressGap {
+	types := make(map[string]string, len(missing))
+	for _, payload := range missing {
+		types[payload.Signature] = payload.Type
+	}
+
+	var (
+		gaps    []database.AddressGap
+		current *database.AddressGap
+	)
+	for _, signature := range signatures {
+		txnType, ok := types[signature.Signature]
+		if !ok {
+			// Failed transactions are never sent, so they do not end a gap
+			if signature.Err == nil {
+				current = nil
+			}
+			continue
+		}
+
+		if current == nil {
+			gaps = append(gaps, database.AddressGap{ToSlot: signature.Slot})
+			current = &gaps[len(gaps)-1]
+		}
+		current.FromSlot = signature.Slot
+		current.Signatures = append(current.Signatures, signature.Signature)
+		if !slices.Contains(current.TransactionTypes, txnType) {
+			current.TransactionTypes = append(current.TransactionTypes, txnType)
+		}
+	}
+
+	for i := range gaps {
+		slices.Sort(gaps[i].TransactionTypes)
+	}
+	return gaps
+}
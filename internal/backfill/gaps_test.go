@@ -0,0 +1,45 @@
+package backfill
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/scythe504/solana-indexer/internal/helius"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+)
+
+func TestFindGaps(t *testing.T) {
+	checked := []helius.SignatureInfo{
+		{Signature: "f", Slot: 600},
+		{Signature: "e", Slot: 500},
+		{Signature: "d", Slot: 400, Err: map[string]any{"InstructionError": []any{0, "Custom"}}},
+		{Signature: "c", Slot: 300},
+		{Signature: "b", Slot: 200},
+		{Signature: "a", Slot: 100},
+	}
+	missing := []kafka.WebhookPayload{
+		{Signature: "e", Type: "TRANSFER"},
+		{Signature: "c", Type: "TOKEN_MINT"},
+		{Signature: "a", Type: "TRANSFER"},
+	}
+
+	gaps := findGaps(checked, missing)
+	if len(gaps) != 2 {
+		t.Fatalf("expected 2 gaps, got %d", len(gaps))
+	}
+
+	// The failed transaction in between does not end the first gap
+	if gaps[0].ToSlot != 500 || gaps[0].FromSlot != 300 || !slices.Equal(gaps[0].Signatures, []string{"e", "c"}) {
+		t.Fatalf("unexpected first gap %+v", gaps[0])
+	}
+	if !slices.Equal(gaps[0].TransactionTypes, []string{"TOKEN_MINT", "TRANSFER"}) {
+		t.Fatalf("unexpected types of the first gap %v", gaps[0].TransactionTypes)
+	}
+	if gaps[1].ToSlot != 100 || gaps[1].FromSlot != 100 || !slices.Equal(gaps[1].Signatures, []string{"a"}) {
+		t.Fatalf("unexpected second gap %+v", gaps[1])
+	}
+
+	if gaps := findGaps(checked, nil); len(gaps) != 0 {
+		t.Fatalf("expected no gaps, got %d", len(gaps))
+	}
+}
@@ -0,0 +1,240 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/utils"
+)
+
+// Backfill jobs are claimed by a runner, which fetches a page of signatures,
+// produces its transactions and checkpoints before moving to the next page.
+// A running job whose runner stopped checkpointing is claimed again and
+// resumes from its last checkpoint.
+
+var (
+	// ErrSubscriptionPaused is returned when backfilling a paused
+	// subscription, which would not index anything
+	ErrSubscriptionPaused = errors.New("subscription is paused")
+	// ErrBackfillActive is returned when the subscription already has a
+	// pending or running backfill
+	ErrBackfillActive = errors.New("subscription already has a backfill in progress")
+)
+
+const backfillJobColumns = `
+	id,
+	subscription_id,
+	token_address,
+	until_slot,
+	until_time,
+	status,
+	before_signature,
+	signatures_scanned,
+	transactions_produced,
+	attempts,
+	last_error,
+	created_at,
+	updated_at,
+	finished_at
+`
+
+// CreateBackfillJob queues a backfill of the user's subscription back to
+// untilSlot or untilTime. A job with neither goes back to the first
+// transaction of the address.
+func (s *service) CreateBackfillJob(userId string, subscriptionId string, untilSlot *int64, untilTime *time.Time) (*BackfillJob, error) {
+	subscription, err := s.GetSubscriptionById(userId, subscriptionId)
+	if err != nil {
+		return nil, err
+	}
+	if !subscription.Status {
+		return nil, ErrSubscriptionPaused
+	}
+
+	now := time.Now()
+	row := s.db.QueryRow(`
+		INSERT INTO backfill_jobs (
+			id,
+			subscription_id,
+			token_address,
+			until_slot,
+			until_time,
+			status,
+			created_at,
+			updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (subscription_id) WHERE status IN ('pending', 'running') DO NOTHING
+		RETURNING `+backfillJobColumns,
+		utils.GenerateUUID(),
+		subscription.Id,
+		subscription.TokenAddress,
+		untilSlot,
+		untilTime,
+		BackfillPending,
+		now,
+		now,
+	)
+
+	job, err := scanBackfillJob(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrBackfillActive
+	}
+	if err != nil {
+		log.Printf("Failed to create a backfill job for subscription %s: %v", subscriptionId, err)
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// GetLatestBackfillJob returns the most recent backfill of the user's
+// subscription.
+func (s *service) GetLatestBackfillJob(userId string, subscriptionId string) (*BackfillJob, error) {
+	row := s.db.QueryRow(`
+		SELECT `+backfillJobColumns+`
+		FROM backfill_jobs
+		WHERE subscription_id = $1
+			AND subscription_id IN (SELECT id FROM subscriptions WHERE user_id = $2)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, subscriptionId, userId)
+
+	job, err := scanBackfillJob(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no backfill for subscription %s: %w", subscriptionId, err)
+		}
+		log.Println("Error querying backfill job:", err)
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// ClaimBackfillJob marks the oldest pending job, or a running one that has
+// not checkpointed or heartbeated for staleAfter, as running and returns it. Jobs that
+// failed before also wait staleAfter before they are retried. It returns nil
+// when there is nothing to run.
+func (s *service) ClaimBackfillJob(ctx context.Context, staleAfter time.Duration) (*BackfillJob, error) {
+	now := time.Now()
+	row := s.db.QueryRowContext(ctx, `
+		UPDATE backfill_jobs
+		SET status = $1, updated_at = $2
+		WHERE id = (
+			SELECT id
+			FROM backfill_jobs
+			WHERE (status = $3 AND (attempts = 0 OR updated_at < $4))
+				OR (status = $1 AND updated_at < $4)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+backfillJobColumns,
+		BackfillRunning, now, BackfillPending, now.Add(-staleAfter),
+	)
+
+	job, err := scanBackfillJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Println("Failed to claim a backfill job: ", err)
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// CheckpointBackfillJob records a finished page. The next page starts below
+// beforeSignature.
+func (s *service) CheckpointBackfillJob(ctx context.Context, id string, beforeSignature string, scanned int, produced int) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE backfill_jobs
+		SET before_signature = $1,
+			signatures_scanned = signatures_scanned + $2,
+			transactions_produced = transactions_produced + $3,
+			updated_at = $4
+		WHERE id = $5 AND status = $6
+	`, beforeSignature, scanned, produced, time.Now(), id, BackfillRunning)
+	if err != nil {
+		log.Printf("Failed to checkpoint backfill job %s: %v", id, err)
+		return err
+	}
+
+	return nil
+}
+
+// HeartbeatBackfillJob keeps a running job from going stale while it works
+// through a page.
+func (s *service) HeartbeatBackfillJob(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE backfill_jobs
+		SET updated_at = $1
+		WHERE id = $2 AND status = $3
+	`, time.Now(), id, BackfillRunning)
+	if err != nil {
+		log.Printf("Failed to heartbeat backfill job %s: %v", id, err)
+		return err
+	}
+
+	return nil
+}
+
+// FinishBackfillJob marks a job done, or records why it failed. A failed
+// job is given back to the queue until it has used maxAttempts.
+func (s *service) FinishBackfillJob(ctx context.Context, id string, cause error, maxAttempts int) error {
+	now := time.Now()
+
+	var err error
+	if cause == nil {
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE backfill_jobs
+			SET status = $1, last_error = NULL, updated_at = $2, finished_at = $2
+			WHERE id = $3
+		`, BackfillDone, now, id)
+	} else {
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE backfill_jobs
+			SET status = CASE WHEN attempts + 1 >= $1 THEN $2 ELSE $3 END,
+				attempts = attempts + 1,
+				last_error = $4,
+				updated_at = $5,
+				finished_at = CASE WHEN attempts + 1 >= $1 THEN $5 END
+			WHERE id = $6
+		`, maxAttempts, BackfillFailed, BackfillPending, cause.Error(), now, id)
+	}
+	if err != nil {
+		log.Printf("Failed to finish backfill job %s: %v", id, err)
+		return err
+	}
+
+	return nil
+}
+
+func scanBackfillJob(row *sql.Row) (*BackfillJob, error) {
+	var job BackfillJob
+	err := row.Scan(
+		&job.Id,
+		&job.SubscriptionId,
+		&job.TokenAddress,
+		&job.UntilSlot,
+		&job.UntilTime,
+		&job.Status,
+		&job.BeforeSignature,
+		&job.SignaturesScanned,
+		&job.TransactionsProduced,
+		&job.Attempts,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
@@ -0,0 +1,358 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/encryption"
+)
+
+const (
+	fieldPassword         = "db_password"
+	fieldConnectionString = "connection_string"
+	fieldSigningSecret    = "secret"
+)
+
+var credentialKeys struct {
+	once    sync.Once
+	keyring *encryption.Keyring
+	err     error
+}
+
+// credentialKeyring loads the key-encryption keys on first use.
+func credentialKeyring() (*encryption.Keyring, error) {
+	credentialKeys.once.Do(func() {
+		credentialKeys.keyring, credentialKeys.err = encryption.LoadKeyring()
+	})
+
+	return credentialKeys.keyring, credentialKeys.err
+}
+
+// sealedCredential holds the encrypted columns of a user_database_credentials
+// row.
+type sealedCredential struct {
+	keyId            string
+	wrappedKey       string
+	password         *string
+	connectionString *string
+}
+
+// sealCredential encrypts the secret fields of a credential under a new data
+// key wrapped with the active key.
+func sealCredential(credentialId string, password *string, connectionString *string) (*sealedCredential, error) {
+	keyring, err := credentialKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	envelope, err := keyring.NewEnvelope(credentialId)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := &sealedCredential{
+		keyId:      envelope.KeyId(),
+		wrappedKey: envelope.WrappedKey(),
+	}
+
+	if password != nil {
+		ciphertext, err := envelope.Seal(fieldPassword, *password)
+		if err != nil {
+			return nil, err
+		}
+		sealed.password = &ciphertext
+	}
+
+	if connectionString != nil {
+		ciphertext, err := envelope.Seal(fieldConnectionString, *connectionString)
+		if err != nil {
+			return nil, err
+		}
+		sealed.connectionString = &ciphertext
+	}
+
+	return sealed, nil
+}
+
+// openCredential decrypts the secret fields of a credential in place. Rows
+// without a key id were stored before encryption and are left as they are
+// until the next key rotation encrypts them.
+func openCredential(dbCred *UserDatabaseCredential, keyId *string, wrappedKey *string) error {
+	if keyId == nil || *keyId == "" {
+		return nil
+	}
+	if wrappedKey == nil {
+		return fmt.Errorf("credential %s has a key id but no data key", dbCred.ID)
+	}
+
+	keyring, err := credentialKeyring()
+	if err != nil {
+		return err
+	}
+
+	envelope, err := keyring.OpenEnvelope(*keyId, *wrappedKey, dbCred.ID)
+	if err != nil {
+		return err
+	}
+
+	if dbCred.Password != nil {
+		password, err := envelope.Open(fieldPassword, *dbCred.Password)
+		if err != nil {
+			return err
+		}
+		dbCred.Password = &password
+	}
+
+	if dbCred.ConnectionString != nil {
+		connectionString, err := envelope.Open(fieldConnectionString, *dbCred.ConnectionString)
+		if err != nil {
+			return err
+		}
+		dbCred.ConnectionString = &connectionString
+	}
+
+	return nil
+}
+
+// signingSecretScope binds a signing secret to its user, apart from the
+// user's database credentials.
+func signingSecretScope(userId string) string {
+	return "signing-secret:" + userId
+}
+
+// sealSigningSecret encrypts a user's signing secret under a new data key
+// wrapped with the active key.
+func sealSigningSecret(userId string, secret string) (keyId string, wrappedKey string, ciphertext string, err error) {
+	keyring, err := credentialKeyring()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	envelope, err := keyring.NewEnvelope(signingSecretScope(userId))
+	if err != nil {
+		return "", "", "", err
+	}
+
+	ciphertext, err = envelope.Seal(fieldSigningSecret, secret)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return envelope.KeyId(), envelope.WrappedKey(), ciphertext, nil
+}
+
+// openSigningSecret decrypts a user's signing secret. Secrets without a key
+// id were stored before encryption and are returned as they are.
+func openSigningSecret(userId string, secret string, keyId *string, wrappedKey *string) (string, error) {
+	if keyId == nil || *keyId == "" {
+		return secret, nil
+	}
+	if wrappedKey == nil {
+		return "", fmt.Errorf("signing secret of user %s has a key id but no data key", userId)
+	}
+
+	keyring, err := credentialKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	envelope, err := keyring.OpenEnvelope(*keyId, *wrappedKey, signingSecretScope(userId))
+	if err != nil {
+		return "", err
+	}
+
+	return envelope.Open(fieldSigningSecret, secret)
+}
+
+// RotateCredentialKeys re-encrypts every credential and signing secret not
+// yet under the active key, including rows stored before encryption, with a
+// fresh data key. Rows are rotated one transaction at a time, so it can be
+// stopped and rerun, and readers keep working throughout as long as the old
+// keys stay configured. It returns how many rows were rotated.
+func (s *service) RotateCredentialKeys(ctx context.Context) (int, error) {
+	keyring, err := credentialKeyring()
+	if err != nil {
+		return 0, err
+	}
+	activeKeyId := keyring.ActiveKeyId()
+
+	ids, err := s.unrotatedIds(ctx, `
+		SELECT id
+		FROM user_database_credentials
+		WHERE key_id IS DISTINCT FROM $1
+	`, activeKeyId)
+	if err != nil {
+		log.Println("Failed to list credentials to rotate: ", err)
+		return 0, err
+	}
+
+	rotated := 0
+	for _, id := range ids {
+		changed, err := s.rotateCredentialKey(ctx, id, activeKeyId)
+		if err != nil {
+			log.Printf("Failed to rotate credential %s: %v", id, err)
+			return rotated, err
+		}
+		if changed {
+			rotated++
+		}
+	}
+
+	userIds, err := s.unrotatedIds(ctx, `
+		SELECT user_id
+		FROM user_signing_secrets
+		WHERE key_id IS DISTINCT FROM $1
+	`, activeKeyId)
+	if err != nil {
+		log.Println("Failed to list signing secrets to rotate: ", err)
+		return rotated, err
+	}
+
+	for _, userId := range userIds {
+		changed, err := s.rotateSigningSecretKey(ctx, userId, activeKeyId)
+		if err != nil {
+			log.Printf("Failed to rotate signing secret of user %s: %v", userId, err)
+			return rotated, err
+		}
+		if changed {
+			rotated++
+		}
+	}
+
+	return rotated, nil
+}
+
+// unrotatedIds runs a query listing the ids of rows not under the active key.
+func (s *service) unrotatedIds(ctx context.Context, query string, activeKeyId string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, activeKeyId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (s *service) rotateCredentialKey(ctx context.Context, id string, activeKeyId string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var (
+		dbCred     = UserDatabaseCredential{ID: id}
+		keyId      *string
+		wrappedKey *string
+	)
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT db_password, connection_string, key_id, encrypted_data_key
+		FROM user_database_credentials
+		WHERE id = $1
+		FOR UPDATE
+	`, id).Scan(&dbCred.Password, &dbCred.ConnectionString, &keyId, &wrappedKey)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Rotated by a concurrent run since it was listed
+	if keyId != nil && *keyId == activeKeyId {
+		return false, nil
+	}
+
+	if err = openCredential(&dbCred, keyId, wrappedKey); err != nil {
+		return false, err
+	}
+
+	sealed, err := sealCredential(id, dbCred.Password, dbCred.ConnectionString)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE user_database_credentials
+		SET db_password = $1,
+			connection_string = $2,
+			key_id = $3,
+			encrypted_data_key = $4,
+			updated_at = $5
+		WHERE id = $6
+	`, sealed.password, sealed.connectionString, sealed.keyId, sealed.wrappedKey, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func (s *service) rotateSigningSecretKey(ctx context.Context, userId string, activeKeyId string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var (
+		secret     string
+		keyId      *string
+		wrappedKey *string
+	)
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT secret, key_id, encrypted_data_key
+		FROM user_signing_secrets
+		WHERE user_id = $1
+		FOR UPDATE
+	`, userId).Scan(&secret, &keyId, &wrappedKey)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Rotated by a concurrent run since it was listed
+	if keyId != nil && *keyId == activeKeyId {
+		return false, nil
+	}
+
+	if secret, err = openSigningSecret(userId, secret, keyId, wrappedKey); err != nil {
+		return false, err
+	}
+
+	sealedKeyId, sealedKey, ciphertext, err := sealSigningSecret(userId, secret)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE user_signing_secrets
+		SET secret = $1,
+			key_id = $2,
+			encrypted_data_key = $3,
+			updated_at = $4
+		WHERE user_id = $5
+	`, ciphertext, sealedKeyId, sealedKey, time.Now(), userId)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/scythe504/solana-indexer/internal/encryption"
+)
+
+// useTestKeyring makes the credential keyring a single test key.
+func useTestKeyring(t *testing.T) {
+	t.Helper()
+
+	keyring, err := encryption.ParseKeyring("test:"+base64.StdEncoding.EncodeToString(make([]byte, 32)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialKeys.once.Do(func() {})
+	credentialKeys.keyring, credentialKeys.err = keyring, nil
+}
+
+func TestSigningSecretEncryption(t *testing.T) {
+	useTestKeyring(t)
+
+	keyId, wrappedKey, ciphertext, err := sealSigningSecret("user-1", "whsec_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyId != "test" || strings.Contains(ciphertext, "whsec_secret") {
+		t.Fatalf("expected the secret sealed under the test key; got %s %q", keyId, ciphertext)
+	}
+
+	secret, err := openSigningSecret("user-1", ciphertext, &keyId, &wrappedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "whsec_secret" {
+		t.Errorf("expected the secret back; got %q", secret)
+	}
+
+	// A secret moved to another user cannot be opened
+	if _, err := openSigningSecret("user-2", ciphertext, &keyId, &wrappedKey); err == nil {
+		t.Error("expected the secret of another user to fail to open")
+	}
+
+	// Secrets stored before encryption are returned as they are
+	if secret, err := openSigningSecret("user-1", "whsec_plain", nil, nil); err != nil || secret != "whsec_plain" {
+		t.Errorf("expected a plaintext secret back; got %q, %v", secret, err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/scythe504/solana-indexer/internal/helius"
 )
 
 // Service represents a service that interacts with a database.
@@ -32,28 +33,84 @@ type Service interface {
 
 	// WebhookMethods
 	GetAllWebhooks() ([]HeliusWebhookConfig, error)
-	CreateWebhook(name string, txnType []IndexingStrategy, address string) error
+	CreateWebhook(name string, txnType []IndexingStrategy, address string) (*HeliusWebhookConfig, error)
 	GetWebhookConfigByName(name string) (HeliusWebhookConfig, error)
-	UpdateWebhook(heliusConfig []HeliusWebhookConfig, address string, txnType []IndexingStrategy) error
-	CreateOrUpdateWebhook(address string, txnType []IndexingStrategy) error
+	UpdateWebhook(heliusConfig []HeliusWebhookConfig, address string, txnType []IndexingStrategy) (*HeliusWebhookConfig, error)
+	CreateOrUpdateWebhook(address string, txnType []IndexingStrategy) (*HeliusWebhookConfig, error)
+	RotateWebhookSecret(name string, overlap time.Duration) (*HeliusWebhookConfig, error)
+	RemoveAddressFromWebhooks(address string) error
+	DeleteWebhook(ctx context.Context, name string) (*WebhookRebalanceReport, error)
+	ReconcileWebhooks(ctx context.Context, dryRun bool) (*WebhookReconcileReport, error)
+	ProcessHeliusOutbox(ctx context.Context) (int, error)
 
 	// User Database Methods
 	GetDatabaseConfig(userId string) (*UserDatabaseCredential, error)
 	CreateDatabaseForUser(userId string, dbCred UserDatabaseCredential) error
+	GetUserDatabase(dbCred *UserDatabaseCredential) (*sql.DB, func(), error)
+	RotateCredentialKeys(ctx context.Context) (int, error)
 
 	// SubscriptionMethods
 	GetSubscriptionsByWebhookId(webhookId string) ([]SubscriptionLookup, error)
 	// GetAddressData(publicAddress string) (*AddressRegistery, error)
 	// SubscribeToAddress(userId string) error
 	// RegisterAddress(token AddressRegistery) error
-	GetSubscriptionsByTxnType(txnType IndexingStrategy, recieverName string) ([]SubscriptionLookup, error)
+	GetSubscriptionsByTxnType(txnType IndexingStrategy, addresses []string, recieverName string) ([]SubscriptionLookup, error)
 	GetSubscriptionsByAddressAndTxnType(address string, txnType IndexingStrategy, recieverName string) ([]SubscriptionLookup, error)
-	CreateSubscription(tokenAddress string, strats []IndexingStrategy, userId string) error
+	CreateSubscription(tokenAddress string, strats []IndexingStrategy, userId string, sink SinkKind, destinationURL *string) error
 	GetAddressFromRegistery(address string) (*AddressRegistery, error)
+	GetSubscriptionsByUser(userId string) ([]Subscription, error)
+	GetSubscriptionById(userId string, id string) (*Subscription, error)
+	UpdateSubscription(userId string, id string, update SubscriptionUpdate) (*Subscription, error)
+	DeleteSubscription(userId string, id string) error
+	ReconcileSubscriptionLookup(ctx context.Context, withHelius bool) (*LookupReconcileReport, error)
+
+	// BackfillMethods
+	CreateBackfillJob(userId string, subscriptionId string, untilSlot *int64, untilTime *time.Time) (*BackfillJob, error)
+	GetLatestBackfillJob(userId string, subscriptionId string) (*BackfillJob, error)
+	ClaimBackfillJob(ctx context.Context, staleAfter time.Duration) (*BackfillJob, error)
+	CheckpointBackfillJob(ctx context.Context, id string, beforeSignature string, scanned int, produced int) error
+	HeartbeatBackfillJob(ctx context.Context, id string) error
+	FinishBackfillJob(ctx context.Context, id string, cause error, maxAttempts int) error
+
+	// ReplayMethods
+	CreateReplayJob(userId string, subscriptionId string, from time.Time, to time.Time, fromSlot *int64, toSlot *int64) (*ReplayJob, error)
+	GetReplayJob(id string) (*ReplayJob, error)
+	GetLatestReplayJob(userId string, subscriptionId string) (*ReplayJob, error)
+	ClaimReplayJob(ctx context.Context, staleAfter time.Duration) (*ReplayJob, error)
+	CheckpointReplayJob(ctx context.Context, id string, partitions []ReplayPartition, scanned int, written int) error
+	HeartbeatReplayJob(ctx context.Context, id string) error
+	FinishReplayJob(ctx context.Context, id string, cause error, maxAttempts int) error
+
+	// QueueMethods
+	EnqueueRecords(ctx context.Context, records []QueueRecord) error
+	ClaimQueueRecords(ctx context.Context, limit int) ([]QueueRecord, func(), error)
+	CompleteQueueRecords(ctx context.Context, ids []int64, requeue []QueueRecord) error
+
+	// GapMethods
+	RecordSeenSignatures(seen []SeenSignature) error
+	GetWatchedAddresses(ctx context.Context) ([]WatchedAddress, error)
+	FilterUnseenSignatures(ctx context.Context, address string, signatures []string) ([]string, error)
+	RecordGapCheck(ctx context.Context, address string, checkedSlot int64, checkedSignature string, gaps []AddressGap) error
+	GetSubscriptionCompleteness(userId string, subscriptionId string) (*SubscriptionCompleteness, error)
+
+	// DeadLetterMethods
+	CreateDeadLetterRecord(record DeadLetterRecord) error
+	GetDeadLetterRecords(limit int, offset int) ([]DeadLetterRecord, error)
+	GetDeadLetterRecordById(id string) (*DeadLetterRecord, error)
+	MarkDeadLetterReplayed(id string) error
+
+	// HttpSinkMethods
+	GetSigningSecret(userId string) (string, error)
+	RotateSigningSecret(userId string) (string, error)
+	UpsertHttpDelivery(delivery HttpDelivery) error
+	GetHttpDeliveryById(id string) (*HttpDelivery, error)
+	GetDeliveredHttpDeliveryIds(ids []string) (map[string]bool, error)
+	GetHttpDeliveries(userId string, subscriptionId string, status string, limit int, offset int) ([]HttpDelivery, error)
 }
 
 type service struct {
-	db *sql.DB
+	db     *sql.DB
+	helius *helius.Client
 }
 
 var (
@@ -86,7 +143,8 @@ func New() Service {
 		log.Fatal(err)
 	}
 	dbInstance = &service{
-		db: db,
+		db:     db,
+		helius: helius.NewClient(),
 	}
 	return dbInstance
 }
@@ -139,6 +197,11 @@ func (s *service) Health() map[string]string {
 		stats["message"] = "Many connections are being closed due to max lifetime, consider increasing max lifetime or revising the connection usage pattern."
 	}
 
+	// Add the pools held open to user databases by the indexing worker
+	for key, value := range getUserPools().stats() {
+		stats[key] = value
+	}
+
 	return stats
 }
 
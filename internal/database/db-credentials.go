@@ -1,8 +1,8 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
 	"time"
@@ -13,25 +13,16 @@ import (
 func (s *service) CreateDatabaseForUser(userId string, dbCred UserDatabaseCredential) error {
 	var connString string
 
-	var jsonBlob []byte
-	jsonBlob, _ = json.MarshalIndent(dbCred, "", " ")
-
-	fmt.Println(string(jsonBlob))
 	if dbCred.ConnectionString == nil {
-		fmt.Println("I came here for some reason")
 		connString = fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=require&search_path=public", *dbCred.User, *dbCred.Password, *dbCred.Host, *dbCred.Port, *dbCred.DatabaseName)
 	} else {
-		fmt.Println("I came here for some reason")
 		connString = *dbCred.ConnectionString
-		fmt.Printf("\n\n%s\n\n",*dbCred.ConnectionString)
 	}
 
-	fmt.Println("db-credentials.go :21", connString)
-
 	db, err := sql.Open("pgx", connString)
 
 	if err != nil {
-		log.Printf("Error occured while connecting to userId:%s, \nDatabase: %s\n Error", userId, connString)
+		log.Printf("Error occured while connecting to database for userId:%s, Error: %v", userId, err)
 		return err
 	}
 
@@ -44,6 +35,21 @@ func (s *service) CreateDatabaseForUser(userId string, dbCred UserDatabaseCreden
 		return err
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err = MigrateUserDatabase(ctx, db); err != nil {
+		log.Printf("Failed to prepare database for userId:%s, Error: %v", userId, err)
+		return err
+	}
+
+	credentialId := utils.GenerateUUID()
+	sealed, err := sealCredential(credentialId, dbCred.Password, dbCred.ConnectionString)
+	if err != nil {
+		log.Printf("Failed to encrypt database credentials for userId: %s, err: %v", userId, err)
+		return err
+	}
+
 	now := time.Now()
 
 	_, err = s.db.Exec(`
@@ -62,22 +68,26 @@ func (s *service) CreateDatabaseForUser(userId string, dbCred UserDatabaseCreden
 			last_connected_at,
 			created_at,
 			updated_at, 
-			error_message
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
-	`, utils.GenerateUUID(),
+			error_message,
+			key_id,
+			encrypted_data_key
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+	`, credentialId,
 		userId,
 		dbCred.DatabaseName,
 		dbCred.Host,
 		dbCred.User,
 		dbCred.Port,
-		dbCred.Password,
+		sealed.password,
 		dbCred.SSLMode,
-		dbCred.ConnectionString,
+		sealed.connectionString,
 		dbCred.ConnectionLimit,
 		now,
 		now,
 		now,
 		"",
+		sealed.keyId,
+		sealed.wrappedKey,
 	)
 
 	if err != nil {
@@ -88,7 +98,11 @@ func (s *service) CreateDatabaseForUser(userId string, dbCred UserDatabaseCreden
 }
 
 func (s *service) GetDatabaseConfig(userId string) (*UserDatabaseCredential, error) {
-	var databaseConfig UserDatabaseCredential
+	var (
+		databaseConfig UserDatabaseCredential
+		keyId          *string
+		wrappedKey     *string
+	)
 
 	err := s.db.QueryRow(`
 		SELECT id,
@@ -96,10 +110,14 @@ func (s *service) GetDatabaseConfig(userId string) (*UserDatabaseCredential, err
 			db_name,
 			host,
 			port,
-			user,
+			db_user,
 			db_password,
 			ssl_mode,
-			connection_string
+			connection_string,
+			connection_limit,
+			updated_at,
+			key_id,
+			encrypted_data_key
 		FROM user_database_credentials
 		  WHERE user_id = $1
 	`, userId).Scan(
@@ -112,6 +130,10 @@ func (s *service) GetDatabaseConfig(userId string) (*UserDatabaseCredential, err
 		&databaseConfig.Password,
 		&databaseConfig.SSLMode,
 		&databaseConfig.ConnectionString,
+		&databaseConfig.ConnectionLimit,
+		&databaseConfig.UpdatedAt,
+		&keyId,
+		&wrappedKey,
 	)
 
 	// TODO (not_important_for_now) - Maybe parse the connection string and fill connection string or host, port and other stuff 
@@ -121,5 +143,23 @@ func (s *service) GetDatabaseConfig(userId string) (*UserDatabaseCredential, err
 		return nil, err
 	}
 
+	if err = openCredential(&databaseConfig, keyId, wrappedKey); err != nil {
+		log.Printf("Failed to decrypt database credentials for userId: %s, err: %v", userId, err)
+		return nil, err
+	}
+
 	return &databaseConfig, nil
 }
+
+// GetUserDatabase returns a cached connection pool for the user database,
+// opening it on first use or after its credentials changed, and the function
+// releasing it. The pool stays open until it is released.
+func (s *service) GetUserDatabase(dbCred *UserDatabaseCredential) (*sql.DB, func(), error) {
+	db, release, err := getUserPools().get(dbCred)
+	if err != nil {
+		log.Printf("Failed to open pool for database credential %s: %v", dbCred.ID, err)
+		return nil, nil, err
+	}
+
+	return db, release, nil
+}
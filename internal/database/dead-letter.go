@@ -0,0 +1,162 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+func (s *service) CreateDeadLetterRecord(record DeadLetterRecord) error {
+	_, err := s.db.Exec(`
+		INSERT INTO dead_letter_records (
+			id,
+			receiver_name,
+			subscription_id,
+			payload,
+			attempts,
+			last_error,
+			source_topic,
+			source_partition,
+			source_offset,
+			created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, record.Id,
+		record.ReceiverName,
+		record.SubscriptionId,
+		string(record.Payload),
+		record.Attempts,
+		record.LastError,
+		record.SourceTopic,
+		record.SourcePartition,
+		record.SourceOffset,
+		record.CreatedAt,
+	)
+
+	if err != nil {
+		log.Println("Error occured while inserting dead letter record: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *service) GetDeadLetterRecords(limit int, offset int) ([]DeadLetterRecord, error) {
+	var records []DeadLetterRecord
+
+	rows, err := s.db.Query(`
+		SELECT
+			id,
+			receiver_name,
+			subscription_id,
+			payload,
+			attempts,
+			last_error,
+			source_topic,
+			source_partition,
+			source_offset,
+			created_at,
+			replayed_at
+		FROM dead_letter_records
+		ORDER BY created_at DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		log.Println("Error occured while fetching dead letter records", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanDeadLetterRecord(rows)
+		if err != nil {
+			log.Println("Error scanning dead letter record: ", err)
+			return nil, err
+		}
+
+		records = append(records, *record)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println("Error iterating through dead letter records: ", err)
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (s *service) GetDeadLetterRecordById(id string) (*DeadLetterRecord, error) {
+	row := s.db.QueryRow(`
+		SELECT
+			id,
+			receiver_name,
+			subscription_id,
+			payload,
+			attempts,
+			last_error,
+			source_topic,
+			source_partition,
+			source_offset,
+			created_at,
+			replayed_at
+		FROM dead_letter_records
+		WHERE id = $1
+	`, id)
+
+	record, err := scanDeadLetterRecord(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("dead letter record %s not found: %w", id, err)
+		}
+		log.Println("Error querying dead letter record by id:", err)
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (s *service) MarkDeadLetterReplayed(id string) error {
+	_, err := s.db.Exec(`
+		UPDATE dead_letter_records
+		SET replayed_at = $1
+		WHERE id = $2
+	`, time.Now(), id)
+
+	if err != nil {
+		log.Println("Failed to mark dead letter record as replayed: ", err)
+		return err
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDeadLetterRecord(row rowScanner) (*DeadLetterRecord, error) {
+	var (
+		record  DeadLetterRecord
+		payload string
+	)
+
+	err := row.Scan(
+		&record.Id,
+		&record.ReceiverName,
+		&record.SubscriptionId,
+		&payload,
+		&record.Attempts,
+		&record.LastError,
+		&record.SourceTopic,
+		&record.SourcePartition,
+		&record.SourceOffset,
+		&record.CreatedAt,
+		&record.ReplayedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	record.Payload = []byte(payload)
+	return &record, nil
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/scythe504/solana-indexer/internal/helius"
+)
+
+var (
+	migrateOnce sync.Once
+	migrateErr  error
+)
+
+// testService returns a service on the test container talking to fake as
+// Helius. The migrations are applied on first use and every table is
+// emptied, so each test starts from a fresh schema.
+func testService(t *testing.T, fake *helius.FakeServer) *service {
+	t.Helper()
+
+	db, err := sql.Open("pgx", fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", username, password, host, port, database))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrateOnce.Do(func() { migrateErr = applyMigrations(db) })
+	if migrateErr != nil {
+		t.Fatalf("failed to migrate the test database: %v", migrateErr)
+	}
+
+	_, err = db.Exec(`
+		DO $$
+		DECLARE r RECORD;
+		BEGIN
+			FOR r IN SELECT tablename FROM pg_tables WHERE schemaname = current_schema() LOOP
+				EXECUTE 'TRUNCATE TABLE ' || quote_ident(r.tablename) || ' CASCADE';
+			END LOOP;
+		END $$
+	`)
+	if err != nil {
+		t.Fatalf("failed to empty the test database: %v", err)
+	}
+
+	return &service{db: db, helius: fake.Client()}
+}
+
+// applyMigrations runs the Up section of every goose migration in order.
+func applyMigrations(db *sql.DB) error {
+	files, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no migrations found")
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		up, _, _ := strings.Cut(string(contents), "-- +goose Down")
+		var statements []string
+		for _, line := range strings.Split(up, "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "-- +goose") {
+				statements = append(statements, line)
+			}
+		}
+
+		if _, err := db.Exec(strings.Join(statements, "\n")); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+	}
+
+	return nil
+}
+
+// newAddress returns a fresh Solana address.
+func newAddress() string {
+	return solana.NewWallet().PublicKey().String()
+}
+
+// insertUser stores the test user every subscription belongs to.
+func insertUser(t *testing.T, s *service) string {
+	t.Helper()
+
+	now := time.Now()
+	_, err := s.db.Exec(`
+		INSERT INTO users (id, created_at, updated_at) VALUES ('test-user', $1, $1)
+		ON CONFLICT (id) DO NOTHING
+	`, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "test-user"
+}
+
+// registerAddress stores the address in the registry, so subscribing to it
+// does not look it up on chain.
+func registerAddress(t *testing.T, s *service, address string) {
+	t.Helper()
+
+	_, err := s.db.Exec(`
+		INSERT INTO address_registry (id, token_address, token_name, token_symbol, created_at)
+		VALUES ($1, $1, 'Test', 'TEST', $2)
+		ON CONFLICT (token_address) DO NOTHING
+	`, address, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// insertSubscription stores an active subscription of the test user to the
+// address, without touching Helius, and returns its id.
+func insertSubscription(t *testing.T, s *service, address string, strategies ...IndexingStrategy) string {
+	t.Helper()
+
+	userId := insertUser(t, s)
+	registerAddress(t, s, address)
+
+	names := make([]string, 0, len(strategies))
+	for _, strategy := range strategies {
+		names = append(names, string(strategy))
+	}
+
+	now := time.Now()
+	id := fmt.Sprintf("sub-%s-%d", address, now.UnixNano())
+	_, err := s.db.Exec(`
+		INSERT INTO subscriptions (id, user_id, token_address, indexing_strategy, table_name, created_at, updated_at, status)
+		VALUES ($1, $2, $3, $4, 'test_table', $5, $5, true)
+	`, id, userId, address, names, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// webhookOf returns the Helius id of the webhook recorded for the address.
+func webhookOf(t *testing.T, s *service, address string) string {
+	t.Helper()
+
+	webhookId, err := s.webhookWatching(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webhookId
+}
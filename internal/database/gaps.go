@@ -0,0 +1,336 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/utils"
+)
+
+// The worker records the signatures it receives for watched addresses in
+// address_signatures. The gap detector compares them with the chain history
+// of each address and queues what never arrived. address_cursors keeps, per
+// address, the newest transaction received and the newest one checked, so
+// each check only looks at what is new since the last one.
+
+// maxSubscriptionGaps bounds the gaps returned with a subscription's
+// completeness, the totals still cover all of them
+const maxSubscriptionGaps = 100
+
+// RecordSeenSignatures stores the signatures the worker received and moves
+// the cursor of every address to the newest one.
+func (s *service) RecordSeenSignatures(seen []SeenSignature) error {
+	if len(seen) == 0 {
+		return nil
+	}
+
+	var (
+		addresses  = make([]string, 0, len(seen))
+		signatures = make([]string, 0, len(seen))
+		slots      = make([]int64, 0, len(seen))
+		newest     = make(map[string]SeenSignature)
+	)
+	for _, signature := range seen {
+		addresses = append(addresses, signature.TokenAddress)
+		signatures = append(signatures, signature.Signature)
+		slots = append(slots, signature.Slot)
+		if current, ok := newest[signature.TokenAddress]; !ok || signature.Slot > current.Slot {
+			newest[signature.TokenAddress] = signature
+		}
+	}
+
+	var cursorAddresses, cursorSignatures []string
+	var cursorSlots []int64
+	for address, signature := range newest {
+		cursorAddresses = append(cursorAddresses, address)
+		cursorSignatures = append(cursorSignatures, signature.Signature)
+		cursorSlots = append(cursorSlots, signature.Slot)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println("Failed to begin a transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err = tx.Exec(`
+		INSERT INTO address_signatures (token_address, signature, slot, seen_at)
+		SELECT address, signature, slot, $4
+		FROM unnest($1::text[], $2::text[], $3::bigint[]) AS t(address, signature, slot)
+		ON CONFLICT DO NOTHING
+	`, addresses, signatures, slots, now); err != nil {
+		log.Println("Failed to record seen signatures: ", err)
+		return err
+	}
+
+	if _, err = tx.Exec(`
+		INSERT INTO address_cursors (token_address, last_slot, last_signature, updated_at)
+		SELECT address, slot, signature, $4
+		FROM unnest($1::text[], $2::text[], $3::bigint[]) AS t(address, signature, slot)
+		ON CONFLICT (token_address) DO UPDATE
+		SET last_slot = EXCLUDED.last_slot,
+			last_signature = EXCLUDED.last_signature,
+			updated_at = EXCLUDED.updated_at
+		WHERE address_cursors.last_slot IS NULL OR address_cursors.last_slot < EXCLUDED.last_slot
+	`, cursorAddresses, cursorSignatures, cursorSlots, now); err != nil {
+		log.Println("Failed to move address cursors: ", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetWatchedAddresses returns every address with an active subscription,
+// with the transaction types its subscriptions index.
+func (s *service) GetWatchedAddresses(ctx context.Context) ([]WatchedAddress, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			s.token_address,
+			array_to_json(s.indexing_strategy),
+			s.created_at,
+			c.last_slot,
+			c.last_signature,
+			c.checked_slot,
+			c.checked_signature,
+			c.checked_at
+		FROM subscriptions s
+		LEFT JOIN address_cursors c ON c.token_address = s.token_address
+		WHERE s.status = true
+		ORDER BY s.token_address
+	`)
+	if err != nil {
+		log.Println("Failed to list watched addresses: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		watched    []WatchedAddress
+		strategies = make(map[string][]IndexingStrategy)
+	)
+	for rows.Next() {
+		var (
+			cursor         AddressCursor
+			strategiesJson []byte
+			createdAt      time.Time
+		)
+		if err := rows.Scan(
+			&cursor.TokenAddress,
+			&strategiesJson,
+			&createdAt,
+			&cursor.LastSlot,
+			&cursor.LastSignature,
+			&cursor.CheckedSlot,
+			&cursor.CheckedSignature,
+			&cursor.CheckedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		var subscriptionStrategies []IndexingStrategy
+		if err := json.Unmarshal(strategiesJson, &subscriptionStrategies); err != nil {
+			return nil, err
+		}
+
+		// Rows are ordered by address, so each address is one run of rows
+		if len(watched) == 0 || watched[len(watched)-1].Cursor.TokenAddress != cursor.TokenAddress {
+			watched = append(watched, WatchedAddress{Cursor: cursor, WatchedSince: createdAt})
+		}
+		last := &watched[len(watched)-1]
+		if createdAt.Before(last.WatchedSince) {
+			last.WatchedSince = createdAt
+		}
+		strategies[cursor.TokenAddress] = append(strategies[cursor.TokenAddress], subscriptionStrategies...)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range watched {
+		watched[i].TransactionTypes = HeliusTransactionTypes(strategies[watched[i].Cursor.TokenAddress])
+	}
+
+	return watched, nil
+}
+
+// FilterUnseenSignatures returns the signatures the worker has not received
+// for address, in the order given.
+func (s *service) FilterUnseenSignatures(ctx context.Context, address string, signatures []string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT t.signature
+		FROM unnest($2::text[]) WITH ORDINALITY AS t(signature, position)
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM address_signatures a
+			WHERE a.token_address = $1 AND a.signature = t.signature
+		)
+		ORDER BY t.position
+	`, address, signatures)
+	if err != nil {
+		log.Printf("Failed to filter unseen signatures of %s: %v", address, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var unseen []string
+	for rows.Next() {
+		var signature string
+		if err := rows.Scan(&signature); err != nil {
+			return nil, err
+		}
+		unseen = append(unseen, signature)
+	}
+
+	return unseen, rows.Err()
+}
+
+// RecordGapCheck stores the gaps found for address and moves its checked
+// cursor. Seen signatures older than the checked one are no longer needed
+// and are pruned.
+func (s *service) RecordGapCheck(ctx context.Context, address string, checkedSlot int64, checkedSignature string, gaps []AddressGap) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Failed to begin a transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, gap := range gaps {
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO address_gaps (
+				id,
+				token_address,
+				from_slot,
+				to_slot,
+				signatures,
+				transaction_types,
+				detected_at
+			) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, utils.GenerateUUID(), address, gap.FromSlot, gap.ToSlot, gap.Signatures, gap.TransactionTypes, now); err != nil {
+			log.Printf("Failed to record a gap of %s: %v", address, err)
+			return err
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO address_cursors (token_address, checked_slot, checked_signature, checked_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (token_address) DO UPDATE
+		SET checked_slot = EXCLUDED.checked_slot,
+			checked_signature = EXCLUDED.checked_signature,
+			checked_at = EXCLUDED.checked_at,
+			updated_at = EXCLUDED.updated_at
+	`, address, checkedSlot, checkedSignature, now); err != nil {
+		log.Printf("Failed to move the checked cursor of %s: %v", address, err)
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		DELETE FROM address_signatures
+		WHERE token_address = $1 AND slot < $2
+	`, address, checkedSlot); err != nil {
+		log.Printf("Failed to prune seen signatures of %s: %v", address, err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetSubscriptionCompleteness returns the cursor of the subscription's
+// address and the gaps found since the subscription was created that hold
+// a transaction type it indexes.
+func (s *service) GetSubscriptionCompleteness(userId string, subscriptionId string) (*SubscriptionCompleteness, error) {
+	subscription, err := s.GetSubscriptionById(userId, subscriptionId)
+	if err != nil {
+		return nil, err
+	}
+
+	completeness := SubscriptionCompleteness{
+		SubscriptionId: subscription.Id,
+		Cursor:         AddressCursor{TokenAddress: subscription.TokenAddress},
+		Gaps:           []AddressGap{},
+	}
+
+	err = s.db.QueryRow(`
+		SELECT last_slot, last_signature, checked_slot, checked_signature, checked_at
+		FROM address_cursors
+		WHERE token_address = $1
+	`, subscription.TokenAddress).Scan(
+		&completeness.Cursor.LastSlot,
+		&completeness.Cursor.LastSignature,
+		&completeness.Cursor.CheckedSlot,
+		&completeness.Cursor.CheckedSignature,
+		&completeness.Cursor.CheckedAt,
+	)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Error querying address cursor:", err)
+		return nil, err
+	}
+
+	txnTypes := HeliusTransactionTypes(subscription.Strategies)
+	err = s.db.QueryRow(`
+		SELECT COALESCE(SUM(cardinality(signatures)), 0)
+		FROM address_gaps
+		WHERE token_address = $1 AND detected_at >= $2 AND transaction_types && $3::text[]
+	`, subscription.TokenAddress, subscription.CreatedAt, txnTypes).Scan(&completeness.MissingTransactions)
+	if err != nil {
+		log.Println("Error counting address gaps:", err)
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT
+			id,
+			token_address,
+			from_slot,
+			to_slot,
+			array_to_json(signatures),
+			array_to_json(transaction_types),
+			detected_at
+		FROM address_gaps
+		WHERE token_address = $1 AND detected_at >= $2 AND transaction_types && $3::text[]
+		ORDER BY detected_at DESC, to_slot DESC
+		LIMIT $4
+	`, subscription.TokenAddress, subscription.CreatedAt, txnTypes, maxSubscriptionGaps)
+	if err != nil {
+		log.Println("Error querying address gaps:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			gap                       AddressGap
+			signaturesJson, typesJson []byte
+		)
+		if err := rows.Scan(
+			&gap.Id,
+			&gap.TokenAddress,
+			&gap.FromSlot,
+			&gap.ToSlot,
+			&signaturesJson,
+			&typesJson,
+			&gap.DetectedAt,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(signaturesJson, &gap.Signatures); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(typesJson, &gap.TransactionTypes); err != nil {
+			return nil, err
+		}
+		completeness.Gaps = append(completeness.Gaps, gap)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &completeness, nil
+}
@@ -0,0 +1,277 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/utils"
+)
+
+// Subscription changes and the Helius calls they need cannot share a
+// transaction, so every change writes a helius_outbox entry alongside itself.
+// The entry is run right after the change commits, and whatever is left
+// pending, because the call failed or the process died before making it, is
+// retried by ProcessHeliusOutbox. A registration of a new subscription that
+// keeps failing is compensated by deleting the subscription.
+
+const (
+	defaultHeliusOutboxMaxAttempts = 5
+	// Entries younger than this are left to the request that wrote them
+	heliusOutboxGracePeriod = time.Minute
+	heliusOutboxBatchSize   = 100
+)
+
+func heliusOutboxMaxAttempts() int {
+	maxAttempts, err := strconv.Atoi(os.Getenv("HELIUS_OUTBOX_MAX_ATTEMPTS"))
+	if err != nil || maxAttempts <= 0 {
+		return defaultHeliusOutboxMaxAttempts
+	}
+	return maxAttempts
+}
+
+// enqueueHeliusOutbox records a Helius call in the caller's transaction and
+// returns the entry id. deleteOnFailure deletes the subscription if the call
+// fails for good.
+func enqueueHeliusOutbox(tx *sql.Tx, subscriptionId *string, tokenAddress string, action string, deleteOnFailure bool) (string, error) {
+	id := utils.GenerateUUID()
+	now := time.Now()
+
+	_, err := tx.Exec(`
+		INSERT INTO helius_outbox (
+			id,
+			subscription_id,
+			token_address,
+			action,
+			status,
+			attempts,
+			delete_on_failure,
+			created_at,
+			updated_at
+		) VALUES ($1, $2, $3, $4, $5, 0, $6, $7, $8)
+	`, id, subscriptionId, tokenAddress, action, HeliusOutboxPending, deleteOnFailure, now, now)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// ProcessHeliusOutbox runs the pending entries older than the grace period
+// and returns how many of them were completed.
+func (s *service) ProcessHeliusOutbox(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id
+		FROM helius_outbox
+		WHERE status = $1 AND updated_at < $2
+		ORDER BY created_at
+		LIMIT $3
+	`, HeliusOutboxPending, time.Now().Add(-heliusOutboxGracePeriod), heliusOutboxBatchSize)
+	if err != nil {
+		log.Println("Failed to list pending helius outbox entries: ", err)
+		return 0, err
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	completed := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return completed, ctx.Err()
+		}
+		// A failed entry stays pending or is compensated, either way the
+		// others are still worth running
+		if err := s.runHeliusOutboxEntry(ctx, id, false); err != nil {
+			log.Printf("Helius outbox entry %s failed: %v", id, err)
+			continue
+		}
+		completed++
+	}
+
+	return completed, nil
+}
+
+// runHeliusOutboxEntry makes the Helius call of a pending entry while holding
+// its row lock, so concurrent runs skip it. On failure the entry is retried
+// later, unless compensate is set or it ran out of attempts: the entry is
+// then marked failed, and deletes its subscription if it was written with
+// deleteOnFailure.
+func (s *service) runHeliusOutboxEntry(ctx context.Context, id string, compensate bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Failed to begin a transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var entry HeliusOutboxEntry
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, subscription_id, token_address, action, attempts, delete_on_failure
+		FROM helius_outbox
+		WHERE id = $1 AND status = $2
+		FOR UPDATE SKIP LOCKED
+	`, id, HeliusOutboxPending).Scan(
+		&entry.Id,
+		&entry.SubscriptionId,
+		&entry.TokenAddress,
+		&entry.Action,
+		&entry.Attempts,
+		&entry.DeleteOnFailure,
+	)
+	if err == sql.ErrNoRows {
+		// Done, or being run by someone else
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var callErr error
+	switch entry.Action {
+	case HeliusOutboxRegister:
+		callErr = s.registerOutboxEntry(ctx, tx, entry)
+	case HeliusOutboxUnregister:
+		callErr = s.unregisterOutboxEntry(ctx, tx, entry)
+	default:
+		callErr = fmt.Errorf("unknown helius outbox action %q", entry.Action)
+	}
+
+	if callErr == nil {
+		if err = finishHeliusOutboxEntry(ctx, tx, entry.Id, HeliusOutboxDone, entry.Attempts+1, nil); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	status := HeliusOutboxPending
+	if compensate || entry.Attempts+1 >= heliusOutboxMaxAttempts() {
+		status = HeliusOutboxFailed
+
+		if entry.DeleteOnFailure && entry.SubscriptionId != nil {
+			// Lookup rows go with it through the foreign key
+			if _, err = tx.ExecContext(ctx, `DELETE FROM subscriptions WHERE id = $1`, *entry.SubscriptionId); err != nil {
+				log.Printf("Failed to delete subscription %s after its helius registration failed: %v", *entry.SubscriptionId, err)
+				return err
+			}
+		}
+	}
+
+	lastError := callErr.Error()
+	if err = finishHeliusOutboxEntry(ctx, tx, entry.Id, status, entry.Attempts+1, &lastError); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("helius %s of %s failed: %w", entry.Action, entry.TokenAddress, callErr)
+}
+
+// registerOutboxEntry adds the address and the subscription's transaction
+// types to a Helius webhook, then points every lookup row of the address at
+// that webhook.
+func (s *service) registerOutboxEntry(ctx context.Context, tx *sql.Tx, entry HeliusOutboxEntry) error {
+	if entry.SubscriptionId == nil {
+		return fmt.Errorf("registration %s has no subscription", entry.Id)
+	}
+
+	subscription, err := scanSubscription(tx.QueryRowContext(ctx, `
+		SELECT
+			id,
+			user_id,
+			token_address,
+			array_to_json(indexing_strategy),
+			table_name,
+			sink,
+			destination_url,
+			created_at,
+			updated_at,
+			status
+		FROM subscriptions
+		WHERE id = $1
+	`, *entry.SubscriptionId))
+	if err == sql.ErrNoRows {
+		// Deleted before it was registered, nothing left to do
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !subscription.Status {
+		// Paused since, it is registered again when it is resumed
+		return nil
+	}
+
+	cfg, err := s.CreateOrUpdateWebhook(subscription.TokenAddress, subscription.Strategies)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE subscription_lookup
+		SET helius_webhook_id = $1,
+			last_updated = $2
+		WHERE token_address = $3
+			AND helius_webhook_id IS DISTINCT FROM $1
+	`, cfg.WebhookId, time.Now(), subscription.TokenAddress)
+	if err != nil {
+		log.Printf("Failed to assign webhook %s to lookup rows of %s: %v", cfg.WebhookName, subscription.TokenAddress, err)
+		return err
+	}
+
+	return nil
+}
+
+// unregisterOutboxEntry removes the address from Helius, unless it has been
+// subscribed to again since the entry was written. The address stays locked
+// until the removal is done, so a new subscription waits for it and is
+// registered afterwards.
+func (s *service) unregisterOutboxEntry(ctx context.Context, tx *sql.Tx, entry HeliusOutboxEntry) error {
+	if err := lockAddress(tx, entry.TokenAddress); err != nil {
+		return err
+	}
+
+	var subscribed bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM subscriptions WHERE token_address = $1)
+	`, entry.TokenAddress).Scan(&subscribed)
+	if err != nil {
+		return err
+	}
+	if subscribed {
+		return nil
+	}
+
+	return s.RemoveAddressFromWebhooks(entry.TokenAddress)
+}
+
+func finishHeliusOutboxEntry(ctx context.Context, tx *sql.Tx, id string, status string, attempts int, lastError *string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE helius_outbox
+		SET status = $1,
+			attempts = $2,
+			last_error = $3,
+			updated_at = $4
+		WHERE id = $5
+	`, status, attempts, lastError, time.Now(), id)
+	if err != nil {
+		log.Printf("Failed to update helius outbox entry %s: %v", id, err)
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/scythe504/solana-indexer/internal/helius"
+)
+
+func TestSubscriptionLifecycleThroughOutbox(t *testing.T) {
+	fake := helius.NewFakeServer("test-key")
+	defer fake.Close()
+	s := testService(t, fake)
+
+	userId := insertUser(t, s)
+	address := newAddress()
+	registerAddress(t, s, address)
+
+	// Subscribing registers the address and points the lookup rows at its webhook
+	if err := s.CreateSubscription(address, []IndexingStrategy{TokenCrossPlatformPrices}, userId, SinkPostgres, nil); err != nil {
+		t.Fatal(err)
+	}
+	subscriptions, err := s.GetSubscriptionsByUser(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 1 {
+		t.Fatalf("expected one subscription; got %d", len(subscriptions))
+	}
+	subscription := subscriptions[0]
+
+	webhooks := fake.Webhooks()
+	if len(webhooks) != 1 || !slices.Contains(webhooks[0].AccountAddresses, address) {
+		t.Fatalf("expected a webhook watching %s; got %+v", address, webhooks)
+	}
+	webhookId := webhooks[0].WebhookId
+	if got := webhookOf(t, s, address); got != webhookId {
+		t.Errorf("expected %s to be recorded on %s; got %q", address, webhookId, got)
+	}
+	assertLookupWebhook(t, s, subscription.Id, webhookId)
+	assertOutboxStatuses(t, s, address, HeliusOutboxDone)
+
+	// A failed update stays pending until the outbox processor retries it
+	fake.FailNext(1, http.StatusBadRequest, "")
+	strategies := []IndexingStrategy{TokenCrossPlatformPrices, TokensAvailableToBorrow}
+	if _, err := s.UpdateSubscription(userId, subscription.Id, SubscriptionUpdate{Strategies: &strategies}); err != nil {
+		t.Fatal(err)
+	}
+	assertOutboxStatuses(t, s, address, HeliusOutboxDone, HeliusOutboxPending)
+
+	backdateHeliusOutbox(t, s)
+	completed, err := s.ProcessHeliusOutbox(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed != 1 {
+		t.Errorf("expected the pending update to complete; got %d", completed)
+	}
+	assertOutboxStatuses(t, s, address, HeliusOutboxDone, HeliusOutboxDone)
+
+	webhooks = fake.Webhooks()
+	for _, txnType := range HeliusTransactionTypes(strategies) {
+		if !slices.Contains(webhooks[0].TransactionTypes, txnType) {
+			t.Errorf("expected the webhook to send %s; got %v", txnType, webhooks[0].TransactionTypes)
+		}
+	}
+	assertLookupWebhook(t, s, subscription.Id, webhookId)
+
+	// Deleting the last subscription removes the address from Helius
+	if err := s.DeleteSubscription(userId, subscription.Id); err != nil {
+		t.Fatal(err)
+	}
+	assertOutboxStatuses(t, s, address, HeliusOutboxDone, HeliusOutboxDone, HeliusOutboxDone)
+	for _, webhook := range fake.Webhooks() {
+		if slices.Contains(webhook.AccountAddresses, address) {
+			t.Errorf("expected %s to leave webhook %s", address, webhook.WebhookId)
+		}
+	}
+	if got := webhookOf(t, s, address); got != "" {
+		t.Errorf("expected %s to be unassigned; got %s", address, got)
+	}
+}
+
+func TestCreateSubscriptionCompensatesFailedRegistration(t *testing.T) {
+	fake := helius.NewFakeServer("test-key")
+	defer fake.Close()
+	s := testService(t, fake)
+
+	userId := insertUser(t, s)
+	address := newAddress()
+	registerAddress(t, s, address)
+
+	fake.FailNext(1, http.StatusBadRequest, "")
+	if err := s.CreateSubscription(address, []IndexingStrategy{TokenCrossPlatformPrices}, userId, SinkPostgres, nil); err == nil {
+		t.Fatal("expected the failed registration to fail the subscription")
+	}
+
+	subscriptions, err := s.GetSubscriptionsByUser(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 0 {
+		t.Errorf("expected the subscription to be deleted; got %d", len(subscriptions))
+	}
+	assertOutboxStatuses(t, s, address, HeliusOutboxFailed)
+	if len(fake.Webhooks()) != 0 {
+		t.Errorf("expected no webhook; got %+v", fake.Webhooks())
+	}
+}
+
+// assertOutboxStatuses checks the statuses of the address's outbox entries
+// in the order they were written.
+func assertOutboxStatuses(t *testing.T, s *service, address string, expected ...string) {
+	t.Helper()
+
+	rows, err := s.db.Query(`
+		SELECT status
+		FROM helius_outbox
+		WHERE token_address = $1
+		ORDER BY created_at
+	`, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var statuses []string
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, status)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(statuses, expected) {
+		t.Errorf("expected outbox statuses %v; got %v", expected, statuses)
+	}
+}
+
+// assertLookupWebhook checks every lookup row of the subscription points at
+// the webhook.
+func assertLookupWebhook(t *testing.T, s *service, subscriptionId string, webhookId string) {
+	t.Helper()
+
+	var rows, pointing int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE helius_webhook_id = $2)
+		FROM subscription_lookup
+		WHERE subscription_id = $1
+	`, subscriptionId, webhookId).Scan(&rows, &pointing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows == 0 || pointing != rows {
+		t.Errorf("expected every lookup row of %s on %s; %d of %d are", subscriptionId, webhookId, pointing, rows)
+	}
+}
+
+// backdateHeliusOutbox moves every entry past the grace period, so the
+// processor picks them up.
+func backdateHeliusOutbox(t *testing.T, s *service) {
+	t.Helper()
+
+	_, err := s.db.Exec(`UPDATE helius_outbox SET updated_at = updated_at - $1::interval`, "2 minutes")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentDeletesUnregisterTheAddress(t *testing.T) {
+	fake := helius.NewFakeServer("test-key")
+	defer fake.Close()
+	s := testService(t, fake)
+
+	address := newAddress()
+	ids := []string{
+		insertSubscription(t, s, address, TokenCrossPlatformPrices),
+		insertSubscription(t, s, address, TokensAvailableToBorrow),
+	}
+
+	// Each delete must see the other's, or neither removes the address
+	var wg sync.WaitGroup
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.DeleteSubscription("test-user", id)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var unregistered int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM helius_outbox
+		WHERE token_address = $1 AND action = $2
+	`, address, HeliusOutboxUnregister).Scan(&unregistered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unregistered != 1 {
+		t.Errorf("expected the address to be unregistered once; got %d", unregistered)
+	}
+}
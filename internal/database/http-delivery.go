@@ -0,0 +1,253 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// GetSigningSecret returns the user's signing secret, decrypted.
+func (s *service) GetSigningSecret(userId string) (string, error) {
+	var (
+		secret     string
+		keyId      *string
+		wrappedKey *string
+	)
+
+	err := s.db.QueryRow(`
+		SELECT secret, key_id, encrypted_data_key
+		FROM user_signing_secrets
+		WHERE user_id = $1
+	`, userId).Scan(&secret, &keyId, &wrappedKey)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("no signing secret for user %s: %w", userId, err)
+		}
+		log.Println("Error querying signing secret: ", err)
+		return "", err
+	}
+
+	secret, err = openSigningSecret(userId, secret, keyId, wrappedKey)
+	if err != nil {
+		log.Printf("Failed to decrypt signing secret of user %s: %v", userId, err)
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// RotateSigningSecret generates a new signing secret for the user, replacing
+// the previous one if there was one, and returns it. It is stored encrypted
+// like database credentials.
+func (s *service) RotateSigningSecret(userId string) (string, error) {
+	secret, err := generateSecret("whsec_")
+	if err != nil {
+		log.Println("Failed to generate signing secret: ", err)
+		return "", err
+	}
+
+	keyId, wrappedKey, ciphertext, err := sealSigningSecret(userId, secret)
+	if err != nil {
+		log.Println("Failed to encrypt signing secret: ", err)
+		return "", err
+	}
+	now := time.Now()
+
+	_, err = s.db.Exec(`
+		INSERT INTO user_signing_secrets (
+			user_id,
+			secret,
+			key_id,
+			encrypted_data_key,
+			created_at,
+			updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret,
+			key_id = EXCLUDED.key_id,
+			encrypted_data_key = EXCLUDED.encrypted_data_key,
+			updated_at = EXCLUDED.updated_at
+	`, userId, ciphertext, keyId, wrappedKey, now, now)
+
+	if err != nil {
+		log.Println("Error occured while storing signing secret: ", err)
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// UpsertHttpDelivery records the outcome of delivering a transaction. The
+// attempts of repeated deliveries with the same id are added up.
+func (s *service) UpsertHttpDelivery(delivery HttpDelivery) error {
+	_, err := s.db.Exec(`
+		INSERT INTO http_deliveries (
+			id,
+			user_id,
+			subscription_id,
+			signature,
+			url,
+			status,
+			attempts,
+			response_status,
+			last_error,
+			created_at,
+			updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (id) DO UPDATE
+		SET url = EXCLUDED.url,
+			status = EXCLUDED.status,
+			attempts = http_deliveries.attempts + EXCLUDED.attempts,
+			response_status = EXCLUDED.response_status,
+			last_error = EXCLUDED.last_error,
+			updated_at = EXCLUDED.updated_at
+	`, delivery.Id,
+		delivery.UserId,
+		delivery.SubscriptionId,
+		delivery.Signature,
+		delivery.URL,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.ResponseStatus,
+		delivery.LastError,
+		delivery.CreatedAt,
+		delivery.UpdatedAt,
+	)
+
+	if err != nil {
+		log.Println("Error occured while upserting http delivery: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *service) GetHttpDeliveryById(id string) (*HttpDelivery, error) {
+	row := s.db.QueryRow(`
+		SELECT
+			id,
+			user_id,
+			subscription_id,
+			signature,
+			url,
+			status,
+			attempts,
+			response_status,
+			last_error,
+			created_at,
+			updated_at
+		FROM http_deliveries
+		WHERE id = $1
+	`, id)
+
+	delivery, err := scanHttpDelivery(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("http delivery %s not found: %w", id, err)
+		}
+		log.Println("Error querying http delivery by id:", err)
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// GetDeliveredHttpDeliveryIds returns which of the given deliveries have
+// already been delivered.
+func (s *service) GetDeliveredHttpDeliveryIds(ids []string) (map[string]bool, error) {
+	rows, err := s.db.Query(`
+		SELECT id
+		FROM http_deliveries
+		WHERE id = ANY($1::text[]) AND status = $2
+	`, ids, HttpDeliveryDelivered)
+	if err != nil {
+		log.Println("Error querying delivered http deliveries: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	delivered := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		delivered[id] = true
+	}
+
+	return delivered, rows.Err()
+}
+
+// GetHttpDeliveries lists a user's deliveries, newest first. Empty
+// subscriptionId and status match every delivery.
+func (s *service) GetHttpDeliveries(userId string, subscriptionId string, status string, limit int, offset int) ([]HttpDelivery, error) {
+	var deliveries []HttpDelivery
+
+	rows, err := s.db.Query(`
+		SELECT
+			id,
+			user_id,
+			subscription_id,
+			signature,
+			url,
+			status,
+			attempts,
+			response_status,
+			last_error,
+			created_at,
+			updated_at
+		FROM http_deliveries
+		WHERE user_id = $1
+			AND ($2::text = '' OR subscription_id = $2::text)
+			AND ($3::text = '' OR status = $3::text)
+		ORDER BY created_at DESC
+		LIMIT $4 OFFSET $5
+	`, userId, subscriptionId, status, limit, offset)
+	if err != nil {
+		log.Println("Error occured while fetching http deliveries", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery, err := scanHttpDelivery(rows)
+		if err != nil {
+			log.Println("Error scanning http delivery: ", err)
+			return nil, err
+		}
+
+		deliveries = append(deliveries, *delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println("Error iterating through http deliveries: ", err)
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func scanHttpDelivery(row rowScanner) (*HttpDelivery, error) {
+	var delivery HttpDelivery
+
+	err := row.Scan(
+		&delivery.Id,
+		&delivery.UserId,
+		&delivery.SubscriptionId,
+		&delivery.Signature,
+		&delivery.URL,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.ResponseStatus,
+		&delivery.LastError,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"slices"
 	"time"
 )
 
@@ -59,23 +60,37 @@ type AddressRegistery struct {
 
 // This becomes your primary subscription table (many-to-many relationship)
 type Subscription struct {
-	Id           string             `db:"id"`
-	UserId       string             `db:"user_id"`                            // Index this
+	Id           string             `db:"id" json:"id"`
+	UserId       string             `db:"user_id" json:"user_id"`             // Index this
 	TokenAddress string             `db:"token_address" json:"token_address"` // Index this
 	Strategies   []IndexingStrategy `db:"indexing_strategy" json:"indexing_strategy"`
-	TableName    string             `db:"table_name"`
-	CreatedAt    time.Time          `db:"created_at"`
-	UpdatedAt    time.Time          `db:"updated_at"`
-	Status       bool               `db:"status"`
+	TableName    string             `db:"table_name" json:"table_name"`
+	Sink         SinkKind           `db:"sink" json:"sink"`
+	// Only set for the http sink
+	DestinationURL *string   `db:"destination_url" json:"destination_url"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
+	// false while the subscription is paused
+	Status bool `db:"status" json:"status"`
+}
+
+// Fields of a subscription a user can edit, nil fields are left unchanged
+type SubscriptionUpdate struct {
+	Strategies     *[]IndexingStrategy `json:"indexing_strategy"`
+	Status         *bool               `json:"status"`
+	DestinationURL *string             `json:"destination_url"`
 }
 
 // Replace this with a denormalized lookup table for faster processing
 type SubscriptionLookup struct {
 	Id              string           `db:"id"`
+	SubscriptionId  string           `db:"subscription_id"`
 	TokenAddress    string           `db:"token_address"` // Primary index field
 	UserId          string           `db:"user_id"`       // Individual user ID (not array)
 	Strategy        IndexingStrategy `db:"strategy"`      // Single strategy (not array)
 	TableName       string           `db:"table_name"`
+	Sink            SinkKind         `db:"sink"`
+	DestinationURL  *string          `db:"destination_url"`
 	HeliusWebhookId string           `db:"helius_webhook_id"`
 	LastUpdated     time.Time        `db:"last_updated"`
 }
@@ -87,8 +102,201 @@ type HeliusWebhookConfig struct {
 	AddressCount int32     `db:"address_count"`
 	CreatedAt    time.Time `db:"created_at"`
 	UpdatedAt    time.Time `db:"updated_at"`
+	// Secret Helius sends as "Bearer <secret>", nil for webhooks created
+	// before per-webhook secrets, which use HELIUS_WEBHOOK_SECRET
+	AuthSecret              *string    `db:"auth_secret"`
+	PreviousAuthSecret      *string    `db:"previous_auth_secret"`
+	PreviousSecretExpiresAt *time.Time `db:"previous_secret_expires_at"`
+	// Sorted transaction types the webhook sends, nil until the pool first
+	// edits a webhook created before they were recorded
+	TransactionTypes []string `db:"transaction_types"`
+}
+
+// A record the worker gave up on after exhausting its retries
+type DeadLetterRecord struct {
+	Id              string     `db:"id" json:"id"`
+	ReceiverName    string     `db:"receiver_name" json:"receiver_name"`
+	SubscriptionId  string     `db:"subscription_id" json:"subscription_id"`
+	Payload         []byte     `db:"payload" json:"payload"`
+	Attempts        int        `db:"attempts" json:"attempts"`
+	LastError       string     `db:"last_error" json:"last_error"`
+	SourceTopic     string     `db:"source_topic" json:"source_topic"`
+	SourcePartition int32      `db:"source_partition" json:"source_partition"`
+	SourceOffset    int64      `db:"source_offset" json:"source_offset"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+	ReplayedAt      *time.Time `db:"replayed_at" json:"replayed_at"`
+}
+
+// One attempt history of POSTing a transaction to a user's endpoint
+type HttpDelivery struct {
+	Id             string    `db:"id" json:"id"`
+	UserId         string    `db:"user_id" json:"user_id"`
+	SubscriptionId string    `db:"subscription_id" json:"subscription_id"`
+	Signature      string    `db:"signature" json:"signature"`
+	URL            string    `db:"url" json:"url"`
+	Status         string    `db:"status" json:"status"`
+	Attempts       int       `db:"attempts" json:"attempts"`
+	ResponseStatus *int      `db:"response_status" json:"response_status"`
+	LastError      *string   `db:"last_error" json:"last_error"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
+}
+
+const (
+	HttpDeliveryDelivered = "delivered"
+	HttpDeliveryFailed    = "failed"
+)
+
+// A Helius call a subscription change still owes, see helius-outbox.go
+type HeliusOutboxEntry struct {
+	Id             string  `db:"id" json:"id"`
+	SubscriptionId *string `db:"subscription_id" json:"subscription_id"`
+	TokenAddress   string  `db:"token_address" json:"token_address"`
+	Action         string  `db:"action" json:"action"`
+	Status         string  `db:"status" json:"status"`
+	Attempts       int     `db:"attempts" json:"attempts"`
+	LastError      *string `db:"last_error" json:"last_error"`
+	// Set on the registration of a new subscription, which is deleted if
+	// the registration fails for good
+	DeleteOnFailure bool      `db:"delete_on_failure" json:"delete_on_failure"`
+	CreatedAt       time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time `db:"updated_at" json:"updated_at"`
+}
+
+const (
+	HeliusOutboxRegister   = "register"
+	HeliusOutboxUnregister = "unregister"
+
+	HeliusOutboxPending = "pending"
+	HeliusOutboxDone    = "done"
+	HeliusOutboxFailed  = "failed"
+)
+
+// A historical backfill of a subscription's address, see backfill.go
+type BackfillJob struct {
+	Id             string `db:"id" json:"id"`
+	SubscriptionId string `db:"subscription_id" json:"subscription_id"`
+	TokenAddress   string `db:"token_address" json:"token_address"`
+	// Where the backfill stops, transactions before either are skipped
+	UntilSlot            *int64     `db:"until_slot" json:"until_slot"`
+	UntilTime            *time.Time `db:"until_time" json:"until_time"`
+	Status               string     `db:"status" json:"status"`
+	BeforeSignature      *string    `db:"before_signature" json:"before_signature"`
+	SignaturesScanned    int64      `db:"signatures_scanned" json:"signatures_scanned"`
+	TransactionsProduced int64      `db:"transactions_produced" json:"transactions_produced"`
+	Attempts             int        `db:"attempts" json:"attempts"`
+	LastError            *string    `db:"last_error" json:"last_error"`
+	CreatedAt            time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt            time.Time  `db:"updated_at" json:"updated_at"`
+	FinishedAt           *time.Time `db:"finished_at" json:"finished_at"`
+}
+
+const (
+	BackfillPending = "pending"
+	BackfillRunning = "running"
+	BackfillDone    = "done"
+	BackfillFailed  = "failed"
+)
+
+// A signature the worker received for a watched address
+type SeenSignature struct {
+	TokenAddress string
+	Signature    string
+	Slot         int64
 }
 
+// How far a watched address has been received and checked for gaps, see
+// gaps.go
+type AddressCursor struct {
+	TokenAddress     string     `db:"token_address" json:"token_address"`
+	LastSlot         *int64     `db:"last_slot" json:"last_slot"`
+	LastSignature    *string    `db:"last_signature" json:"last_signature"`
+	CheckedSlot      *int64     `db:"checked_slot" json:"checked_slot"`
+	CheckedSignature *string    `db:"checked_signature" json:"checked_signature"`
+	CheckedAt        *time.Time `db:"checked_at" json:"checked_at"`
+}
+
+// An address with active subscriptions, as the gap detector checks it
+type WatchedAddress struct {
+	Cursor AddressCursor
+	// Transaction types the address' active subscriptions index
+	TransactionTypes []string
+	// Creation of the oldest active subscription, nothing older is checked
+	WatchedSince time.Time
+}
+
+// A run of transactions of an address the worker never received
+type AddressGap struct {
+	Id               string    `db:"id" json:"id"`
+	TokenAddress     string    `db:"token_address" json:"token_address"`
+	FromSlot         int64     `db:"from_slot" json:"from_slot"`
+	ToSlot           int64     `db:"to_slot" json:"to_slot"`
+	Signatures       []string  `db:"signatures" json:"signatures"`
+	TransactionTypes []string  `db:"transaction_types" json:"transaction_types"`
+	DetectedAt       time.Time `db:"detected_at" json:"detected_at"`
+}
+
+// What the gap detector found for a subscription since it was created
+type SubscriptionCompleteness struct {
+	SubscriptionId string        `json:"subscription_id"`
+	Cursor         AddressCursor `json:"cursor"`
+	// Transactions that were missing and fetched again
+	MissingTransactions int          `json:"missing_transactions"`
+	Gaps                []AddressGap `json:"gaps"`
+}
+
+// A replay of KAFKA_TOPIC into one subscription, see replay.go. It goes
+// through the same statuses as a backfill job.
+type ReplayJob struct {
+	Id             string    `db:"id" json:"id"`
+	SubscriptionId string    `db:"subscription_id" json:"subscription_id"`
+	FromTime       time.Time `db:"from_time" json:"from_time"`
+	ToTime         time.Time `db:"to_time" json:"to_time"`
+	// Transactions outside these slots are skipped
+	FromSlot   *int64            `db:"from_slot" json:"from_slot"`
+	ToSlot     *int64            `db:"to_slot" json:"to_slot"`
+	Status     string            `db:"status" json:"status"`
+	Partitions []ReplayPartition `db:"partitions" json:"partitions"`
+	// Records in the time range, known once the offsets are looked up
+	RecordsTotal        int64      `db:"records_total" json:"records_total"`
+	RecordsScanned      int64      `db:"records_scanned" json:"records_scanned"`
+	TransactionsWritten int64      `db:"transactions_written" json:"transactions_written"`
+	Attempts            int        `db:"attempts" json:"attempts"`
+	LastError           *string    `db:"last_error" json:"last_error"`
+	CreatedAt           time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt           time.Time  `db:"updated_at" json:"updated_at"`
+	FinishedAt          *time.Time `db:"finished_at" json:"finished_at"`
+}
+
+// The offsets of a partition a replay reads, from StartOffset up to but
+// excluding EndOffset
+type ReplayPartition struct {
+	Partition   int32 `json:"partition"`
+	StartOffset int64 `json:"start_offset"`
+	EndOffset   int64 `json:"end_offset"`
+	NextOffset  int64 `json:"next_offset"`
+}
+
+// A record of the embedded queue, see queue.go
+type QueueRecord struct {
+	Id      int64             `db:"id" json:"id"`
+	Key     string            `db:"record_key" json:"record_key"`
+	Value   []byte            `db:"value" json:"value"`
+	Headers map[string]string `db:"headers" json:"headers"`
+	// The record is not delivered before this time, for retries
+	AvailableAt time.Time `db:"available_at" json:"available_at"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+// Where the indexer writes a subscription's transactions
+type SinkKind string
+
+const (
+	SinkPostgres SinkKind = "postgres"
+	SinkFile     SinkKind = "file"
+	SinkHTTP     SinkKind = "http"
+)
+
 type IndexingStrategy string
 
 const (
@@ -154,3 +362,15 @@ var StrategyTransactionTypes = map[IndexingStrategy][]string{
 		"CANCEL_ORDER",
 	},
 }
+
+// HeliusTransactionTypes returns the Helius transaction types a webhook has
+// to send for the strategies, sorted and without duplicates.
+func HeliusTransactionTypes(strategies []IndexingStrategy) []string {
+	var txnTypes []string
+	for _, strategy := range strategies {
+		txnTypes = append(txnTypes, StrategyTransactionTypes[strategy]...)
+	}
+
+	slices.Sort(txnTypes)
+	return slices.Compact(txnTypes)
+}
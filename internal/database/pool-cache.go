@@ -0,0 +1,310 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPoolCacheSize   = 100
+	defaultPoolIdleTimeout = 10 * time.Minute
+	defaultConnectionLimit = 20
+)
+
+// poolCache keeps one *sql.DB per user database credential so the worker
+// reuses connections instead of opening a new pool for every payload. The
+// least recently used pool leaves the cache once it is full, and pools that
+// have not been used for idleTimeout are closed by a background sweep.
+// Pools are reference counted: one that leaves the cache while a writer
+// still holds it is closed when that writer releases it.
+type poolCache struct {
+	mu          sync.Mutex
+	maxSize     int
+	idleTimeout time.Duration
+	entries     map[string]*list.Element
+	order       *list.List
+	open        func(cred *UserDatabaseCredential) (*sql.DB, error)
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+type poolEntry struct {
+	credentialId string
+	fingerprint  string
+	db           *sql.DB
+	lastUsed     time.Time
+	refs         int
+	// Set once the entry left the cache, the last release closes it
+	removed bool
+}
+
+var (
+	userPools     *poolCache
+	userPoolsOnce sync.Once
+)
+
+func getUserPools() *poolCache {
+	userPoolsOnce.Do(func() {
+		maxSize, err := strconv.Atoi(os.Getenv("USER_DB_POOL_CACHE_SIZE"))
+		if err != nil || maxSize <= 0 {
+			maxSize = defaultPoolCacheSize
+		}
+		idleTimeout, err := time.ParseDuration(os.Getenv("USER_DB_POOL_IDLE_TIMEOUT"))
+		if err != nil || idleTimeout <= 0 {
+			idleTimeout = defaultPoolIdleTimeout
+		}
+
+		userPools = newPoolCache(maxSize, idleTimeout, openUserDatabase)
+		go userPools.sweep()
+	})
+
+	return userPools
+}
+
+func newPoolCache(maxSize int, idleTimeout time.Duration, open func(cred *UserDatabaseCredential) (*sql.DB, error)) *poolCache {
+	return &poolCache{
+		maxSize:     maxSize,
+		idleTimeout: idleTimeout,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+		open:        open,
+	}
+}
+
+// ConnString builds the connection string for a user database, preferring
+// the stored connection string when one was provided.
+func (c *UserDatabaseCredential) ConnString() string {
+	if c.ConnectionString != nil && *c.ConnectionString != "" {
+		return *c.ConnectionString
+	}
+
+	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s", deref(c.User), deref(c.Password), deref(c.Host), derefPort(c.Port), deref(c.DatabaseName), deref(c.SSLMode))
+}
+
+// fingerprint changes whenever anything that affects the pool changes, so a
+// cached pool is replaced as soon as the stored credentials are edited.
+func (c *UserDatabaseCredential) fingerprint() string {
+	limit := 0
+	if c.ConnectionLimit != nil {
+		limit = int(*c.ConnectionLimit)
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d", c.ConnString(), limit)))
+	return hex.EncodeToString(sum[:])
+}
+
+func openUserDatabase(cred *UserDatabaseCredential) (*sql.DB, error) {
+	db, err := sql.Open("pgx", cred.ConnString())
+	if err != nil {
+		return nil, err
+	}
+
+	limit := defaultConnectionLimit
+	if cred.ConnectionLimit != nil && *cred.ConnectionLimit > 0 {
+		limit = int(*cred.ConnectionLimit)
+	}
+	db.SetMaxOpenConns(limit)
+	db.SetMaxIdleConns(limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Every pool is opened through here, so this runs once per connected
+	// database rather than once per write
+	if err = MigrateUserDatabase(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// get returns the pool for cred, opening one if it is not cached or if the
+// credentials changed since it was opened, and a release function the
+// caller must call once it is done with the pool.
+func (p *poolCache) get(cred *UserDatabaseCredential) (*sql.DB, func(), error) {
+	fingerprint := cred.fingerprint()
+
+	p.mu.Lock()
+	if el, ok := p.entries[cred.ID]; ok {
+		entry := el.Value.(*poolEntry)
+		if entry.fingerprint == fingerprint {
+			p.hits++
+			release := p.acquireLocked(el)
+			p.mu.Unlock()
+			return entry.db, release, nil
+		}
+
+		log.Printf("Credentials changed for database credential %s, reopening pool", cred.ID)
+		p.removeLocked(el)
+	}
+	p.misses++
+	p.mu.Unlock()
+
+	// Open outside the lock so a slow customer database does not block
+	// lookups for everyone else
+	db, err := p.open(cred)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Another worker may have opened the same pool in the meantime
+	if el, ok := p.entries[cred.ID]; ok {
+		entry := el.Value.(*poolEntry)
+		if entry.fingerprint == fingerprint {
+			go closePool(cred.ID, db)
+			return entry.db, p.acquireLocked(el), nil
+		}
+		p.removeLocked(el)
+	}
+
+	el := p.order.PushFront(&poolEntry{
+		credentialId: cred.ID,
+		fingerprint:  fingerprint,
+		db:           db,
+	})
+	p.entries[cred.ID] = el
+	release := p.acquireLocked(el)
+
+	for p.order.Len() > p.maxSize {
+		p.removeLocked(p.order.Back())
+		p.evictions++
+	}
+
+	return db, release, nil
+}
+
+// acquireLocked takes a reference on the entry and returns the function
+// releasing it.
+func (p *poolCache) acquireLocked(el *list.Element) func() {
+	entry := el.Value.(*poolEntry)
+	entry.refs++
+	entry.lastUsed = time.Now()
+	p.order.MoveToFront(el)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			entry.refs--
+			if entry.removed {
+				if entry.refs == 0 {
+					go closePool(entry.credentialId, entry.db)
+				}
+				return
+			}
+			entry.lastUsed = time.Now()
+			p.order.MoveToFront(el)
+		})
+	}
+}
+
+// removeLocked drops the entry from the cache, and closes its pool unless a
+// writer still holds it.
+func (p *poolCache) removeLocked(el *list.Element) {
+	entry := el.Value.(*poolEntry)
+	p.order.Remove(el)
+	delete(p.entries, entry.credentialId)
+	entry.removed = true
+
+	if entry.refs == 0 {
+		// Close waits for in-flight queries, so do it off the lock
+		go closePool(entry.credentialId, entry.db)
+	}
+}
+
+func closePool(credentialId string, db *sql.DB) {
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close pool for database credential %s: %v", credentialId, err)
+	}
+}
+
+// sweep periodically closes pools that have been idle for idleTimeout.
+func (p *poolCache) sweep() {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.evictIdle(time.Now())
+	}
+}
+
+func (p *poolCache) evictIdle(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for el := p.order.Back(); el != nil; {
+		entry := el.Value.(*poolEntry)
+		if now.Sub(entry.lastUsed) < p.idleTimeout {
+			// Entries are ordered by last use, the rest are more recent
+			return
+		}
+		prev := el.Prev()
+		// A pool held since before the timeout is busy, not idle
+		if entry.refs == 0 {
+			p.removeLocked(el)
+			p.evictions++
+		}
+		el = prev
+	}
+}
+
+// stats summarises the cache and the connections held by its pools.
+func (p *poolCache) stats() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var open, inUse, idle int
+	for el := p.order.Front(); el != nil; el = el.Next() {
+		dbStats := el.Value.(*poolEntry).db.Stats()
+		open += dbStats.OpenConnections
+		inUse += dbStats.InUse
+		idle += dbStats.Idle
+	}
+
+	return map[string]string{
+		"user_pools":                  strconv.Itoa(p.order.Len()),
+		"user_pools_max":              strconv.Itoa(p.maxSize),
+		"user_pools_hits":             strconv.FormatInt(p.hits, 10),
+		"user_pools_misses":           strconv.FormatInt(p.misses, 10),
+		"user_pools_evictions":        strconv.FormatInt(p.evictions, 10),
+		"user_pools_open_connections": strconv.Itoa(open),
+		"user_pools_in_use":           strconv.Itoa(inUse),
+		"user_pools_idle":             strconv.Itoa(idle),
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func derefPort(port *uint16) uint16 {
+	if port == nil {
+		return 5432
+	}
+	return *port
+}
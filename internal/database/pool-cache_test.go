@@ -0,0 +1,204 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConnector lets the pool cache open pools without a database, and
+// records when they are closed.
+type fakeConnector struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return nil, errors.New("fake pools do not connect")
+}
+
+func (c *fakeConnector) Driver() driver.Driver { return nil }
+
+func (c *fakeConnector) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// fakePools returns a pool cache opening fake pools, and the connectors of
+// the pools it opened by credential id, in order.
+func fakePools(maxSize int, idleTimeout time.Duration) (*poolCache, func(id string) []*fakeConnector) {
+	var (
+		mu     sync.Mutex
+		opened = make(map[string][]*fakeConnector)
+	)
+
+	cache := newPoolCache(maxSize, idleTimeout, func(cred *UserDatabaseCredential) (*sql.DB, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		connector := &fakeConnector{closed: make(chan struct{})}
+		opened[cred.ID] = append(opened[cred.ID], connector)
+		return sql.OpenDB(connector), nil
+	})
+
+	return cache, func(id string) []*fakeConnector {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*fakeConnector(nil), opened[id]...)
+	}
+}
+
+func credential(id string, host string) *UserDatabaseCredential {
+	return &UserDatabaseCredential{ID: id, Host: &host}
+}
+
+func waitClosed(t *testing.T, connector *fakeConnector) {
+	t.Helper()
+	select {
+	case <-connector.closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the pool to be closed")
+	}
+}
+
+func isClosed(connector *fakeConnector) bool {
+	// Pools are closed off the cache lock, give that a moment
+	select {
+	case <-connector.closed:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func TestPoolCacheReusesPools(t *testing.T) {
+	cache, opened := fakePools(10, time.Hour)
+
+	first, releaseFirst, err := cache.get(credential("cred-1", "db.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, releaseSecond, err := cache.get(credential("cred-1", "db.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseFirst()
+	releaseSecond()
+
+	if first != second || len(opened("cred-1")) != 1 {
+		t.Errorf("expected one pool per credential; opened %d", len(opened("cred-1")))
+	}
+	if cache.hits != 1 || cache.misses != 1 {
+		t.Errorf("expected 1 hit and 1 miss; got %d and %d", cache.hits, cache.misses)
+	}
+}
+
+func TestPoolCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache, opened := fakePools(2, time.Hour)
+
+	for _, id := range []string{"cred-1", "cred-2"} {
+		_, release, err := cache.get(credential(id, "db.example.com"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+
+	// cred-1 is used again, leaving cred-2 the least recently used
+	_, release, err := cache.get(credential("cred-1", "db.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	_, releaseThird, err := cache.get(credential("cred-3", "db.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitClosed(t, opened("cred-2")[0])
+	if isClosed(opened("cred-1")[0]) {
+		t.Error("the most recently used pool was evicted")
+	}
+
+	// cred-3 is still held when cred-4 pushes it out
+	_, release, err = cache.get(credential("cred-1", "db.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	_, releaseFourth, err := cache.get(credential("cred-4", "db.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseFourth()
+
+	if isClosed(opened("cred-3")[0]) {
+		t.Fatal("an evicted pool was closed while it was still held")
+	}
+	releaseThird()
+	waitClosed(t, opened("cred-3")[0])
+
+	if cache.evictions != 2 || cache.order.Len() != 2 {
+		t.Errorf("expected 2 evictions leaving 2 pools; got %d leaving %d", cache.evictions, cache.order.Len())
+	}
+}
+
+func TestPoolCacheSweepsIdlePools(t *testing.T) {
+	cache, opened := fakePools(10, time.Minute)
+
+	_, releaseIdle, err := cache.get(credential("idle", "db.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseIdle()
+	_, releaseBusy, err := cache.get(credential("busy", "db.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.evictIdle(time.Now().Add(2 * time.Minute))
+
+	waitClosed(t, opened("idle")[0])
+	if isClosed(opened("busy")[0]) {
+		t.Error("a pool in use was swept")
+	}
+	if _, ok := cache.entries["idle"]; ok {
+		t.Error("expected the idle pool to leave the cache")
+	}
+
+	releaseBusy()
+	cache.evictIdle(time.Now().Add(2 * time.Minute))
+	waitClosed(t, opened("busy")[0])
+}
+
+func TestPoolCacheReopensOnCredentialChange(t *testing.T) {
+	cache, opened := fakePools(10, time.Hour)
+
+	old, releaseOld, err := cache.get(credential("cred-1", "old.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, releaseCurrent, err := cache.get(credential("cred-1", "new.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseCurrent()
+
+	if old == current || len(opened("cred-1")) != 2 {
+		t.Fatalf("expected edited credentials to open a new pool; opened %d", len(opened("cred-1")))
+	}
+	if isClosed(opened("cred-1")[0]) {
+		t.Fatal("the old pool was closed while it was still held")
+	}
+
+	releaseOld()
+	waitClosed(t, opened("cred-1")[0])
+	if isClosed(opened("cred-1")[1]) {
+		t.Error("releasing the old pool closed its replacement")
+	}
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// The embedded queue keeps records in queue_records, spread over
+// queueShards shards by the hash of their key like Kafka partitions. A
+// worker claims a shard with a session advisory lock and handles its oldest
+// available records while holding it, so the records of a key are never
+// handled by two workers at once or out of order. A worker that dies mid
+// batch loses its connection and with it the lock, and its records are
+// delivered again to the next worker taking the shard. Handled records are
+// deleted, and retries are inserted as new records in the same transaction.
+
+const (
+	queueShards = 64
+	// First key of the shard advisory locks, the shard is the second
+	queueShardLock = 72061959
+)
+
+// EnqueueRecords stores records, available straight away unless they carry
+// an AvailableAt.
+func (s *service) EnqueueRecords(ctx context.Context, records []QueueRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Failed to begin a transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertQueueRecords(ctx, tx, records); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ClaimQueueRecords locks a shard with available records and returns up to
+// limit of them, oldest first. The shard stays locked until release is
+// called, once the records are completed. It returns no records when every
+// shard with available records is locked by another worker.
+func (s *service) ClaimQueueRecords(ctx context.Context, limit int) ([]QueueRecord, func(), error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		log.Println("Failed to get a connection for a queue shard: ", err)
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	shards, err := availableQueueShards(ctx, conn, now)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	for _, shard := range shards {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, $2)`, queueShardLock, shard).Scan(&locked); err != nil {
+			log.Println("Failed to lock a queue shard: ", err)
+			conn.Close()
+			return nil, nil, err
+		}
+		if !locked {
+			continue
+		}
+
+		release := func() {
+			conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, $2)`, queueShardLock, shard)
+			conn.Close()
+		}
+
+		records, err := shardQueueRecords(ctx, conn, shard, now, limit)
+		if err != nil || len(records) == 0 {
+			// Another worker handled the shard since it was listed
+			release()
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		return records, release, nil
+	}
+
+	conn.Close()
+	return nil, func() {}, nil
+}
+
+// availableQueueShards returns the shards with available records, the one
+// holding the oldest record first.
+func availableQueueShards(ctx context.Context, conn *sql.Conn, now time.Time) ([]int, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT shard
+		FROM queue_records
+		WHERE available_at <= $1
+		GROUP BY shard
+		ORDER BY MIN(id)
+	`, now)
+	if err != nil {
+		log.Println("Failed to list queue shards: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shards []int
+	for rows.Next() {
+		var shard int
+		if err := rows.Scan(&shard); err != nil {
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+
+	return shards, rows.Err()
+}
+
+func shardQueueRecords(ctx context.Context, conn *sql.Conn, shard int, now time.Time, limit int) ([]QueueRecord, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT id, record_key, value, headers, available_at, created_at
+		FROM queue_records
+		WHERE shard = $1 AND available_at <= $2
+		ORDER BY id
+		LIMIT $3
+	`, shard, now, limit)
+	if err != nil {
+		log.Println("Failed to claim queue records: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []QueueRecord
+	for rows.Next() {
+		var (
+			record      QueueRecord
+			headersJson []byte
+		)
+		if err := rows.Scan(&record.Id, &record.Key, &record.Value, &headersJson, &record.AvailableAt, &record.CreatedAt); err != nil {
+			log.Println("Failed to scan a queue record: ", err)
+			return nil, err
+		}
+		if err := json.Unmarshal(headersJson, &record.Headers); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// CompleteQueueRecords deletes handled records and enqueues the retries
+// they produced, atomically.
+func (s *service) CompleteQueueRecords(ctx context.Context, ids []int64, requeue []QueueRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Failed to begin a transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertQueueRecords(ctx, tx, requeue); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		DELETE FROM queue_records
+		WHERE id = ANY($1::bigint[])
+	`, ids); err != nil {
+		log.Printf("Failed to delete %d queue records: %v", len(ids), err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertQueueRecords(ctx context.Context, tx *sql.Tx, records []QueueRecord) error {
+	now := time.Now()
+	for _, record := range records {
+		headersJson, err := json.Marshal(record.Headers)
+		if err != nil {
+			return err
+		}
+
+		availableAt := record.AvailableAt
+		if availableAt.IsZero() {
+			availableAt = now
+		}
+
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO queue_records (
+				record_key,
+				shard,
+				value,
+				headers,
+				available_at,
+				created_at
+			) VALUES ($1, (hashtext($1) & 2147483647) % $6, $2, $3, $4, $5)
+		`, record.Key, record.Value, headersJson, availableAt, now, queueShards); err != nil {
+			log.Println("Failed to enqueue a record: ", err)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/scythe504/solana-indexer/internal/helius"
+)
+
+func TestClaimQueueRecordsLocksTheShard(t *testing.T) {
+	fake := helius.NewFakeServer("test-key")
+	defer fake.Close()
+	s := testService(t, fake)
+	ctx := context.Background()
+
+	records := []QueueRecord{
+		{Key: "mint-1", Value: []byte("1"), Headers: map[string]string{}},
+		{Key: "mint-1", Value: []byte("2"), Headers: map[string]string{}},
+	}
+	if err := s.EnqueueRecords(ctx, records); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, release, err := s.ClaimQueueRecords(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || string(claimed[0].Value) != "1" {
+		t.Fatalf("expected the oldest record of mint-1; got %+v", claimed)
+	}
+
+	// The second record of the key waits for the first to be completed
+	others, releaseOthers, err := s.ClaimQueueRecords(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseOthers()
+	if len(others) != 0 {
+		t.Errorf("expected the shard of mint-1 to be locked; got %+v", others)
+	}
+
+	if err := s.CompleteQueueRecords(ctx, []int64{claimed[0].Id}, nil); err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	next, releaseNext, err := s.ClaimQueueRecords(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseNext()
+	if len(next) != 1 || string(next[0].Value) != "2" {
+		t.Errorf("expected the second record of mint-1 once the first completed; got %+v", next)
+	}
+}
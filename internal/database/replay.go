@@ -0,0 +1,296 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/utils"
+)
+
+// Replay jobs are claimed by the worker, which looks up the offsets of the
+// time range on its first run and then reads every partition, checkpointing
+// the next offset of each after every batch it has written. Like backfill
+// jobs, a running job that stopped checkpointing is claimed again.
+
+// ErrReplayActive is returned when the subscription already has a pending or
+// running replay
+var ErrReplayActive = errors.New("subscription already has a replay in progress")
+
+const replayJobColumns = `
+	id,
+	subscription_id,
+	from_time,
+	to_time,
+	from_slot,
+	to_slot,
+	status,
+	partitions,
+	records_total,
+	records_scanned,
+	transactions_written,
+	attempts,
+	last_error,
+	created_at,
+	updated_at,
+	finished_at
+`
+
+// Done reports whether every record of the partition has been replayed.
+func (p ReplayPartition) Done() bool {
+	return p.NextOffset >= p.EndOffset
+}
+
+// CreateReplayJob queues a replay of the records produced between from and
+// to into the subscription, optionally narrowed to a slot range. An empty
+// userId skips the ownership check, for admin tools.
+func (s *service) CreateReplayJob(userId string, subscriptionId string, from time.Time, to time.Time, fromSlot *int64, toSlot *int64) (*ReplayJob, error) {
+	var active bool
+	err := s.db.QueryRow(`
+		SELECT status
+		FROM subscriptions
+		WHERE id = $1 AND ($2 = '' OR user_id = $2)
+	`, subscriptionId, userId).Scan(&active)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("subscription %s not found: %w", subscriptionId, err)
+		}
+		log.Println("Error querying subscription status:", err)
+		return nil, err
+	}
+	if !active {
+		return nil, ErrSubscriptionPaused
+	}
+
+	now := time.Now()
+	row := s.db.QueryRow(`
+		INSERT INTO replay_jobs (
+			id,
+			subscription_id,
+			from_time,
+			to_time,
+			from_slot,
+			to_slot,
+			status,
+			created_at,
+			updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (subscription_id) WHERE status IN ('pending', 'running') DO NOTHING
+		RETURNING `+replayJobColumns,
+		utils.GenerateUUID(),
+		subscriptionId,
+		from,
+		to,
+		fromSlot,
+		toSlot,
+		BackfillPending,
+		now,
+		now,
+	)
+
+	job, err := scanReplayJob(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrReplayActive
+	}
+	if err != nil {
+		log.Printf("Failed to create a replay job for subscription %s: %v", subscriptionId, err)
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// GetReplayJob returns a replay by id, for admin tools.
+func (s *service) GetReplayJob(id string) (*ReplayJob, error) {
+	row := s.db.QueryRow(`
+		SELECT `+replayJobColumns+`
+		FROM replay_jobs
+		WHERE id = $1
+	`, id)
+
+	job, err := scanReplayJob(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("replay %s not found: %w", id, err)
+		}
+		log.Println("Error querying replay job:", err)
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// GetLatestReplayJob returns the most recent replay into the user's
+// subscription.
+func (s *service) GetLatestReplayJob(userId string, subscriptionId string) (*ReplayJob, error) {
+	row := s.db.QueryRow(`
+		SELECT `+replayJobColumns+`
+		FROM replay_jobs
+		WHERE subscription_id = $1
+			AND subscription_id IN (SELECT id FROM subscriptions WHERE user_id = $2)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, subscriptionId, userId)
+
+	job, err := scanReplayJob(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no replay for subscription %s: %w", subscriptionId, err)
+		}
+		log.Println("Error querying replay job:", err)
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// ClaimReplayJob marks the oldest pending job, or a running one that has
+// not checkpointed or heartbeated for staleAfter, as running and returns it. It returns
+// nil when there is nothing to run.
+func (s *service) ClaimReplayJob(ctx context.Context, staleAfter time.Duration) (*ReplayJob, error) {
+	now := time.Now()
+	row := s.db.QueryRowContext(ctx, `
+		UPDATE replay_jobs
+		SET status = $1, updated_at = $2
+		WHERE id = (
+			SELECT id
+			FROM replay_jobs
+			WHERE (status = $3 AND (attempts = 0 OR updated_at < $4))
+				OR (status = $1 AND updated_at < $4)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+replayJobColumns,
+		BackfillRunning, now, BackfillPending, now.Add(-staleAfter),
+	)
+
+	job, err := scanReplayJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Println("Failed to claim a replay job: ", err)
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// CheckpointReplayJob records the next offset of every partition after a
+// written batch.
+func (s *service) CheckpointReplayJob(ctx context.Context, id string, partitions []ReplayPartition, scanned int, written int) error {
+	partitionsJson, err := json.Marshal(partitions)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, partition := range partitions {
+		total += partition.EndOffset - partition.StartOffset
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE replay_jobs
+		SET partitions = $1,
+			records_total = $2,
+			records_scanned = records_scanned + $3,
+			transactions_written = transactions_written + $4,
+			updated_at = $5
+		WHERE id = $6 AND status = $7
+	`, partitionsJson, total, scanned, written, time.Now(), id, BackfillRunning)
+	if err != nil {
+		log.Printf("Failed to checkpoint replay job %s: %v", id, err)
+		return err
+	}
+
+	return nil
+}
+
+// HeartbeatReplayJob keeps a running job from going stale while it waits
+// for records.
+func (s *service) HeartbeatReplayJob(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE replay_jobs
+		SET updated_at = $1
+		WHERE id = $2 AND status = $3
+	`, time.Now(), id, BackfillRunning)
+	if err != nil {
+		log.Printf("Failed to heartbeat replay job %s: %v", id, err)
+		return err
+	}
+
+	return nil
+}
+
+// FinishReplayJob marks a job done, or records why it failed. A failed job
+// is given back to the queue until it has used maxAttempts.
+func (s *service) FinishReplayJob(ctx context.Context, id string, cause error, maxAttempts int) error {
+	now := time.Now()
+
+	var err error
+	if cause == nil {
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE replay_jobs
+			SET status = $1, last_error = NULL, updated_at = $2, finished_at = $2
+			WHERE id = $3
+		`, BackfillDone, now, id)
+	} else {
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE replay_jobs
+			SET status = CASE WHEN attempts + 1 >= $1 THEN $2 ELSE $3 END,
+				attempts = attempts + 1,
+				last_error = $4,
+				updated_at = $5,
+				finished_at = CASE WHEN attempts + 1 >= $1 THEN $5 END
+			WHERE id = $6
+		`, maxAttempts, BackfillFailed, BackfillPending, cause.Error(), now, id)
+	}
+	if err != nil {
+		log.Printf("Failed to finish replay job %s: %v", id, err)
+		return err
+	}
+
+	return nil
+}
+
+func scanReplayJob(row *sql.Row) (*ReplayJob, error) {
+	var (
+		job            ReplayJob
+		partitionsJson []byte
+	)
+	err := row.Scan(
+		&job.Id,
+		&job.SubscriptionId,
+		&job.FromTime,
+		&job.ToTime,
+		&job.FromSlot,
+		&job.ToSlot,
+		&job.Status,
+		&partitionsJson,
+		&job.RecordsTotal,
+		&job.RecordsScanned,
+		&job.TransactionsWritten,
+		&job.Attempts,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Partitions are looked up on the first run
+	if partitionsJson != nil {
+		if err := json.Unmarshal(partitionsJson, &job.Partitions); err != nil {
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
@@ -0,0 +1,470 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/utils"
+)
+
+func (s *service) GetSubscriptionsByUser(userId string) ([]Subscription, error) {
+	var subscriptions []Subscription
+
+	rows, err := s.db.Query(`
+		SELECT
+			id,
+			user_id,
+			token_address,
+			array_to_json(indexing_strategy),
+			table_name,
+			sink,
+			destination_url,
+			created_at,
+			updated_at,
+			status
+		FROM subscriptions
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userId)
+	if err != nil {
+		log.Println("Error occured while fetching subscriptions", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			log.Println("Error scanning subscription: ", err)
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, *subscription)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println("Error iterating through subscriptions: ", err)
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+func (s *service) GetSubscriptionById(userId string, id string) (*Subscription, error) {
+	row := s.db.QueryRow(`
+		SELECT
+			id,
+			user_id,
+			token_address,
+			array_to_json(indexing_strategy),
+			table_name,
+			sink,
+			destination_url,
+			created_at,
+			updated_at,
+			status
+		FROM subscriptions
+		WHERE id = $1 AND user_id = $2
+	`, id, userId)
+
+	subscription, err := scanSubscription(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("subscription %s not found: %w", id, err)
+		}
+		log.Println("Error querying subscription by id:", err)
+		return nil, err
+	}
+
+	return subscription, nil
+}
+
+// UpdateSubscription edits a subscription and its lookup rows. New strategies
+// and resumed subscriptions are registered with Helius through the outbox,
+// which also points the lookup rows at the webhook watching the address. A
+// failed call leaves the change stored and is retried by the outbox
+// processor. Removed strategies leave their types on the webhook, which
+// other addresses may need; ReconcileWebhooks drops the unneeded ones.
+func (s *service) UpdateSubscription(userId string, id string, update SubscriptionUpdate) (*Subscription, error) {
+	subscription, err := s.GetSubscriptionById(userId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var strategiesChanged, resumed bool
+	if update.Strategies != nil {
+		strategiesChanged = !slices.Equal(subscription.Strategies, *update.Strategies)
+		subscription.Strategies = *update.Strategies
+	}
+	if update.Status != nil {
+		resumed = *update.Status && !subscription.Status
+		subscription.Status = *update.Status
+	}
+
+	if update.DestinationURL != nil {
+		subscription.DestinationURL = update.DestinationURL
+	}
+	subscription.UpdatedAt = time.Now()
+
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		log.Println("Failed to begin a transaction: ", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = lockAddress(tx, subscription.TokenAddress); err != nil {
+		log.Printf("Failed to lock address %s: %v", subscription.TokenAddress, err)
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE subscriptions
+		SET indexing_strategy = $1,
+			status = $2,
+			destination_url = $3,
+			updated_at = $4
+		WHERE id = $5 AND user_id = $6
+	`, subscription.Strategies,
+		subscription.Status,
+		subscription.DestinationURL,
+		subscription.UpdatedAt,
+		id,
+		userId,
+	)
+	if err != nil {
+		log.Println("Failed to update subscription: ", err)
+		return nil, err
+	}
+
+	if err = syncSubscriptionLookup(tx, *subscription); err != nil {
+		log.Printf("Failed to update lookup rows for subscription %s: %v", id, err)
+		return nil, err
+	}
+
+	// The webhook reconciler drops addresses of paused subscriptions, so
+	// resuming registers the address again
+	var entryId string
+	if (strategiesChanged || resumed) && subscription.Status {
+		entryId, err = enqueueHeliusOutbox(tx, &subscription.Id, subscription.TokenAddress, HeliusOutboxRegister, false)
+		if err != nil {
+			log.Println("Failed to queue the helius registration for subscription:", err)
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if entryId != "" {
+		if err := s.runHeliusOutboxEntry(context.Background(), entryId, false); err != nil {
+			log.Printf("Failed to update helius webhook for subscription %s, it is retried by the outbox: %v", id, err)
+		}
+	}
+
+	return subscription, nil
+}
+
+// DeleteSubscription removes a subscription and its lookup rows. Once no
+// subscription is left for the address it is removed from Helius too,
+// through the outbox so a failed call is retried.
+func (s *service) DeleteSubscription(userId string, id string) error {
+	subscription, err := s.GetSubscriptionById(userId, id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		log.Println("Failed to begin a transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Without it, concurrent deletes of the last two subscribers would each
+	// count the other and neither would remove the address from Helius
+	if err = lockAddress(tx, subscription.TokenAddress); err != nil {
+		log.Printf("Failed to lock address %s: %v", subscription.TokenAddress, err)
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM subscription_lookup WHERE subscription_id = $1`, id); err != nil {
+		log.Println("Failed to delete lookup rows: ", err)
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM subscriptions WHERE id = $1 AND user_id = $2`, id, userId); err != nil {
+		log.Println("Failed to delete subscription: ", err)
+		return err
+	}
+
+	var remaining int
+	err = tx.QueryRow(`
+		SELECT COUNT(*)
+		FROM subscriptions
+		WHERE token_address = $1
+	`, subscription.TokenAddress).Scan(&remaining)
+	if err != nil {
+		log.Println("Failed to count remaining subscribers: ", err)
+		return err
+	}
+
+	var entryId string
+	if remaining == 0 {
+		entryId, err = enqueueHeliusOutbox(tx, nil, subscription.TokenAddress, HeliusOutboxUnregister, false)
+		if err != nil {
+			log.Println("Failed to queue the helius removal: ", err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if entryId != "" {
+		// The subscription is gone either way, the address only costs Helius
+		// credits until the outbox processor removes it on a later attempt
+		if err := s.runHeliusOutboxEntry(context.Background(), entryId, false); err != nil {
+			log.Printf("Failed to remove address %s from helius after deleting subscription %s: %v", subscription.TokenAddress, id, err)
+		}
+	}
+
+	return nil
+}
+
+// subscriptionAddressLock namespaces the per-address advisory locks.
+const subscriptionAddressLock = 72061958
+
+// lockAddress serialises the transactions changing the subscriptions of an
+// address, and the Helius removal of it, until tx ends.
+func lockAddress(tx *sql.Tx, address string) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, hashtext($2))`, subscriptionAddressLock, address)
+	return err
+}
+
+// syncSubscriptionLookup rewrites the lookup rows of a subscription: one row
+// per strategy while it is active, none while it is paused. Every row gets
+// the Helius webhook watching the address, taken from the subscription's
+// previous rows or from another subscription to the same address; rows of a
+// brand new address get it once the address is registered with Helius.
+func syncSubscriptionLookup(tx *sql.Tx, subscription Subscription) error {
+	var webhookId sql.NullString
+	err := tx.QueryRow(`
+		SELECT helius_webhook_id
+		FROM subscription_lookup
+		WHERE (subscription_id = $1 OR token_address = $2)
+			AND helius_webhook_id IS NOT NULL
+		ORDER BY (subscription_id = $1) IS TRUE DESC
+		LIMIT 1
+	`, subscription.Id, subscription.TokenAddress).Scan(&webhookId)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM subscription_lookup WHERE subscription_id = $1`, subscription.Id); err != nil {
+		return err
+	}
+
+	if !subscription.Status {
+		return nil
+	}
+
+	now := time.Now()
+	seen := make(map[IndexingStrategy]bool)
+	for _, strategy := range subscription.Strategies {
+		if seen[strategy] {
+			continue
+		}
+		seen[strategy] = true
+
+		_, err = tx.Exec(`
+			INSERT INTO subscription_lookup (
+				id,
+				subscription_id,
+				token_address,
+				user_id,
+				strategy,
+				table_name,
+				sink,
+				destination_url,
+				helius_webhook_id,
+				last_updated
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`, utils.GenerateUUID(),
+			subscription.Id,
+			subscription.TokenAddress,
+			subscription.UserId,
+			strategy,
+			subscription.TableName,
+			subscription.Sink,
+			subscription.DestinationURL,
+			webhookId,
+			now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LookupReconcileReport summarises a ReconcileSubscriptionLookup run.
+type LookupReconcileReport struct {
+	Subscriptions      int   `json:"subscriptions"`
+	OrphansDeleted     int64 `json:"orphans_deleted"`
+	WebhookIdsAssigned int64 `json:"webhook_ids_assigned"`
+}
+
+// ReconcileSubscriptionLookup rebuilds subscription_lookup from subscriptions.
+// Rows not linked to a subscription are deleted, every subscription's rows
+// are rewritten, and when withHelius is set the webhook id of every row, and
+// the address counts and lists of the webhook pool, are taken from what
+// Helius reports for our webhooks.
+func (s *service) ReconcileSubscriptionLookup(ctx context.Context, withHelius bool) (*LookupReconcileReport, error) {
+	report := &LookupReconcileReport{}
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM subscription_lookup WHERE subscription_id IS NULL`)
+	if err != nil {
+		log.Println("Failed to delete orphaned lookup rows: ", err)
+		return nil, err
+	}
+	report.OrphansDeleted, _ = result.RowsAffected()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			id,
+			user_id,
+			token_address,
+			array_to_json(indexing_strategy),
+			table_name,
+			sink,
+			destination_url,
+			created_at,
+			updated_at,
+			status
+		FROM subscriptions
+	`)
+	if err != nil {
+		log.Println("Failed to list subscriptions: ", err)
+		return nil, err
+	}
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		subscriptions = append(subscriptions, *subscription)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, subscription := range subscriptions {
+		if err = s.reconcileSubscription(ctx, subscription); err != nil {
+			log.Printf("Failed to reconcile lookup rows for subscription %s: %v", subscription.Id, err)
+			return report, err
+		}
+		report.Subscriptions++
+	}
+
+	if !withHelius {
+		return report, nil
+	}
+
+	configs, err := s.GetAllWebhooks()
+	if err != nil {
+		return report, err
+	}
+
+	for _, cfg := range configs {
+		webhook, err := s.GetCurrentWebhookConfig(cfg.WebhookId)
+		if err != nil {
+			return report, err
+		}
+		if webhook == nil {
+			log.Printf("Webhook %s was not found on helius, skipping it", cfg.WebhookName)
+			continue
+		}
+
+		// Helius is the source of truth for what each webhook watches
+		if err = s.recordWebhookState(&cfg, webhook); err != nil {
+			return report, err
+		}
+		if err = s.assignAddresses(cfg.WebhookId, webhook.AccountAddresses); err != nil {
+			log.Printf("Failed to record the addresses of webhook %s: %v", cfg.WebhookName, err)
+			return report, err
+		}
+
+		result, err := s.db.ExecContext(ctx, `
+			UPDATE subscription_lookup
+			SET helius_webhook_id = $1,
+				last_updated = $2
+			WHERE token_address = ANY($3)
+				AND helius_webhook_id IS DISTINCT FROM $1
+		`, cfg.WebhookId, time.Now(), webhook.AccountAddresses)
+		if err != nil {
+			log.Printf("Failed to assign webhook %s to lookup rows: %v", cfg.WebhookName, err)
+			return report, err
+		}
+
+		assigned, _ := result.RowsAffected()
+		report.WebhookIdsAssigned += assigned
+	}
+
+	return report, nil
+}
+
+func (s *service) reconcileSubscription(ctx context.Context, subscription Subscription) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = syncSubscriptionLookup(tx, subscription); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func scanSubscription(row rowScanner) (*Subscription, error) {
+	var (
+		subscription Subscription
+		strategies   []byte
+	)
+
+	err := row.Scan(
+		&subscription.Id,
+		&subscription.UserId,
+		&subscription.TokenAddress,
+		&strategies,
+		&subscription.TableName,
+		&subscription.Sink,
+		&subscription.DestinationURL,
+		&subscription.CreatedAt,
+		&subscription.UpdatedAt,
+		&subscription.Status,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(strategies, &subscription.Strategies); err != nil {
+		return nil, err
+	}
+
+	return &subscription, nil
+}
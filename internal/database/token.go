@@ -1,15 +1,10 @@
 package database
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"os"
 	"time"
 
 	"github.com/gagliardetto/solana-go"
@@ -22,9 +17,13 @@ func (s *service) GetSubscriptionsByWebhookId(webhookId string) ([]SubscriptionL
 	rows, err := s.db.Query(`
 		SELECT 
 			id,
+			COALESCE(subscription_id, ''),
 			token_address,
 			user_id, 
+			strategy,
 			table_name,
+			sink,
+			destination_url,
 			helius_webhook_id, 
 			last_updated
 		FROM subscription_lookup
@@ -41,10 +40,13 @@ func (s *service) GetSubscriptionsByWebhookId(webhookId string) ([]SubscriptionL
 
 		err := rows.Scan(
 			&token_subscription.Id,
+			&token_subscription.SubscriptionId,
 			&token_subscription.TokenAddress,
 			&token_subscription.UserId,
 			&token_subscription.Strategy,
 			&token_subscription.TableName,
+			&token_subscription.Sink,
+			&token_subscription.DestinationURL,
 			&token_subscription.HeliusWebhookId,
 			&token_subscription.LastUpdated,
 		)
@@ -149,32 +151,39 @@ func (s *service) GetSubscriptionsByAddressAndTxnType(address string, txnType In
 
 	rows, err := s.db.Query(`
 		SELECT id,
+			COALESCE(subscription_id, ''),
 			token_address,
+			user_id,
 			strategy,
 			table_name,
+			sink,
+			destination_url,
 			helius_webhook_id,
 			last_updated
 		 FROM subscription_lookup
-		  WHERE (
-			token_address = $1,
-			strategy = $2
-		  )
-	`, address, txnType)
+		  WHERE token_address = $1
+			AND strategy = $2
+			AND helius_webhook_id = $3
+	`, address, txnType, heliusConfig.WebhookId)
 
 	if err != nil {
 		log.Println("Query failed for subscription_lookup", err)
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var subscription SubscriptionLookup
 
 		err := rows.Scan(
 			&subscription.Id,
+			&subscription.SubscriptionId,
 			&subscription.TokenAddress,
 			&subscription.UserId,
 			&subscription.Strategy,
 			&subscription.TableName,
+			&subscription.Sink,
+			&subscription.DestinationURL,
 			&subscription.HeliusWebhookId,
 			&subscription.LastUpdated,
 		)
@@ -186,28 +195,30 @@ func (s *service) GetSubscriptionsByAddressAndTxnType(address string, txnType In
 
 		subscriptions = append(subscriptions, subscription)
 	}
-	defer rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Println("Error iterating through subscription_lookup rows: ", err)
+		return nil, err
+	}
 
 	return subscriptions, nil
 }
 
 func (s *service) CheckIfSubscriptionsAlreadyExistByUser(userId string, tokenAddress string) (bool, error) {
-	row, err := s.db.Query(`
-		SELECT 
-			tokenAddress
-		FROM subscriptions
-		WHERE user_id = $1 AND token_address = $2
-	`, userId, tokenAddress)
+	var exists bool
 
-	if err != nil && err != sql.ErrNoRows {
-		return false, fmt.Errorf("error occured while fetching the userId and tokenAddress from subscriptions")
-	}
+	err := s.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM subscriptions
+			WHERE user_id = $1 AND token_address = $2
+		)
+	`, userId, tokenAddress).Scan(&exists)
 
-	if row != nil {
-		return true, nil
-	} else {
-		return false, nil
+	if err != nil {
+		return false, fmt.Errorf("error occured while fetching the userId and tokenAddress from subscriptions: %w", err)
 	}
+
+	return exists, nil
 }
 
 func (s *service) GetAddressFromRegistery(address string) (*AddressRegistery, error) {
@@ -239,54 +250,67 @@ func (s *service) GetAddressFromRegistery(address string) (*AddressRegistery, er
 	return &reg, nil
 }
 
-func (s *service) GetSubscriptionsByTxnType(txnType IndexingStrategy, receiverName string) ([]SubscriptionLookup, error) {
+// GetSubscriptionsByTxnType returns the lookup rows of a strategy for any of
+// the addresses, watched by the named Helius webhook. An empty receiverName
+// returns the rows of every webhook, for transactions that did not come from
+// Helius.
+func (s *service) GetSubscriptionsByTxnType(txnType IndexingStrategy, addresses []string, receiverName string) ([]SubscriptionLookup, error) {
 	var subscriptions []SubscriptionLookup
 
 	var heliusConfig HeliusWebhookConfig
-	err := s.db.QueryRow(`SELECT 
-		id,
-		webhook_id
-	 FROM helius_webhook_config
-	  WHERE webhook_name = $1
-	`, receiverName).Scan(
-		&heliusConfig.Id,
-		&heliusConfig.WebhookId,
-	)
+	if receiverName != "" {
+		err := s.db.QueryRow(`SELECT 
+			id,
+			webhook_id
+		 FROM helius_webhook_config
+		  WHERE webhook_name = $1
+		`, receiverName).Scan(
+			&heliusConfig.Id,
+			&heliusConfig.WebhookId,
+		)
 
-	if err != nil {
-		log.Printf("Error occured while trying to get helius configs: %v", err)
-		return nil, err
+		if err != nil {
+			log.Printf("Error occured while trying to get helius configs: %v", err)
+			return nil, err
+		}
 	}
 
 	rows, err := s.db.Query(`
 		SELECT 
 			id,
+			COALESCE(subscription_id, ''),
 			token_address,
+			user_id,
 			strategy,
 			table_name,
+			sink,
+			destination_url,
 			helius_webhook_id,
 			last_updated
 		 FROM subscription_lookup
-		  WHERE ( 
-			strategy = $1,
-			helius_webhook_id = $2
-		   )
-	`, txnType, heliusConfig.WebhookId)
+		  WHERE strategy = $1
+			AND token_address = ANY($2)
+			AND ($3::text = '' OR helius_webhook_id = $3)
+	`, txnType, addresses, heliusConfig.WebhookId)
 
 	if err != nil {
 		log.Println("Query failed for subscription_lookup", err)
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var subscription SubscriptionLookup
 
 		err := rows.Scan(
 			&subscription.Id,
+			&subscription.SubscriptionId,
 			&subscription.TokenAddress,
 			&subscription.UserId,
 			&subscription.Strategy,
 			&subscription.TableName,
+			&subscription.Sink,
+			&subscription.DestinationURL,
 			&subscription.HeliusWebhookId,
 			&subscription.LastUpdated,
 		)
@@ -298,16 +322,29 @@ func (s *service) GetSubscriptionsByTxnType(txnType IndexingStrategy, receiverNa
 
 		subscriptions = append(subscriptions, subscription)
 	}
-	defer rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Println("Error iterating through subscription_lookup rows: ", err)
+		return nil, err
+	}
 
 	return subscriptions, nil
 }
-func (s *service) CreateSubscription(tokenAddress string, strats []IndexingStrategy, userId string) error {
+
+// CreateSubscription stores a subscription with its lookup rows and registers
+// the address with Helius. The registration goes through the Helius outbox,
+// a failed call deletes the subscription and is returned.
+func (s *service) CreateSubscription(tokenAddress string, strats []IndexingStrategy, userId string, sink SinkKind, destinationURL *string) error {
 	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
 	if err != nil {
 		log.Println("Failed to begin a transaction: ", err)
 		return err
 	}
+	defer tx.Rollback()
+
+	if err = lockAddress(tx, tokenAddress); err != nil {
+		log.Printf("Failed to lock address %s: %v", tokenAddress, err)
+		return err
+	}
 
 	addressIsPresent, err := s.CheckIfSubscriptionsAlreadyExistByUser(userId, tokenAddress)
 
@@ -320,7 +357,6 @@ func (s *service) CreateSubscription(tokenAddress string, strats []IndexingStrat
 		return fmt.Errorf("indexing for this address already exists, please edit the txn types if you need to index more data for the given address")
 	}
 
-	defer tx.Rollback()
 	token, err := s.GetAddressFromRegistery(tokenAddress)
 
 	var addressReg *AddressRegistery
@@ -337,7 +373,7 @@ func (s *service) CreateSubscription(tokenAddress string, strats []IndexingStrat
 		}
 
 		if solana.MustPublicKeyFromBase58(addressOwner) == solana.TokenProgramID {
-			addressReg, err = FetchTokenDataFromHelius(tokenAddress)
+			addressReg, err = s.fetchTokenData(tokenAddress)
 			if err != nil {
 				log.Println("Failed to fetch token data from helius")
 				return err
@@ -384,16 +420,20 @@ func (s *service) CreateSubscription(tokenAddress string, strats []IndexingStrat
 			token_address,
 			indexing_strategy,
 			table_name,
+			sink,
+			destination_url,
 			created_at,
 			updated_at,
 			status
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 	`,
 		uuid,
 		userId,
 		finalTokenAddress,
 		strats,
 		tableName,
+		sink,
+		destinationURL,
 		now,
 		now,
 		true,
@@ -403,71 +443,55 @@ func (s *service) CreateSubscription(tokenAddress string, strats []IndexingStrat
 		return err
 	}
 
-	return tx.Commit()
-}
-
-func FetchTokenDataFromHelius(tokenAddress string) (*AddressRegistery, error) {
-	// Helius API endpoint for token metadata
-	url := fmt.Sprintf("%s/?api-key=%s", os.Getenv("HELIUS_RPC_URL"), os.Getenv("HELIUS_API_KEY"))
-
-	// Prepare request payload
-	body := map[string]any{
-		"jsonrpc": "2.0",
-		"id":      "test",
-		"method":  "getAsset",
-		"params": map[string]any{
-			"id": tokenAddress,
-		},
-	}
-
-	jsonPayload, err := json.Marshal(body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %w", err)
-	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	subscription := Subscription{
+		Id:             uuid,
+		UserId:         userId,
+		TokenAddress:   finalTokenAddress,
+		Strategies:     strats,
+		TableName:      tableName,
+		Sink:           sink,
+		DestinationURL: destinationURL,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Status:         true,
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := http.Client{
-		Timeout: 10 * time.Second,
+	if err = syncSubscriptionLookup(tx, subscription); err != nil {
+		log.Println("Failed to create lookup rows for subscription:", err)
+		return err
 	}
 
-	resp, err := client.Do(req)
+	entryId, err := enqueueHeliusOutbox(tx, &subscription.Id, subscription.TokenAddress, HeliusOutboxRegister, true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		log.Println("Failed to queue the helius registration for subscription:", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	payload, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+	if err = tx.Commit(); err != nil {
+		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("helius API returned non-200 status: %d, body: %s",
-			resp.StatusCode, string(payload))
-	}
+	// Registered straight away so the caller learns about a failed Helius call,
+	// which deletes the subscription again
+	return s.runHeliusOutboxEntry(context.Background(), entryId, true)
+}
 
-	var tokenMetadata utils.HeliusRpcResponse
-	if err := json.Unmarshal(payload, &tokenMetadata); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+// fetchTokenData reads a token's name and symbol from the DAS API.
+func (s *service) fetchTokenData(tokenAddress string) (*AddressRegistery, error) {
+	asset, err := s.helius.GetAsset(context.Background(), tokenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch asset %s: %w", tokenAddress, err)
 	}
 
-	fmt.Println(tokenMetadata)
-
 	// Check if we got any metadata
-	if tokenMetadata.Result.Content.Metadata == nil {
+	if asset.Content.Metadata == nil {
 		return nil, fmt.Errorf("no metadata found for token address %s", tokenAddress)
 	}
 
 	return &AddressRegistery{
 		Id:            utils.GenerateUUID(),
 		TokenAddress:  tokenAddress,
-		TokenName:     tokenMetadata.Result.Content.Metadata.Name,
-		TokenSymbol:   tokenMetadata.Result.Content.Metadata.Symbol,
+		TokenName:     asset.Content.Metadata.Name,
+		TokenSymbol:   asset.Content.Metadata.Symbol,
 		CreatedAt:     time.Now(),
 		LastFetchedAt: &time.Time{},
 	}, nil
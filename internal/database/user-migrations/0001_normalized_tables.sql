@@ -0,0 +1,50 @@
+-- Normalized copies of every indexed transaction, shared by all of a
+-- user's subscriptions in the same database
+CREATE TABLE IF NOT EXISTS normalized_webhook_payloads (
+    id VARCHAR(255) PRIMARY KEY,
+    signature VARCHAR(255) NOT NULL UNIQUE,
+    slot BIGINT NOT NULL,
+    timestamp TIMESTAMP NOT NULL,
+    source VARCHAR(100),
+    fee INTEGER,
+    fee_payer VARCHAR(255),
+    transaction_type VARCHAR(50),
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS normalized_account_data (
+    id SERIAL PRIMARY KEY,
+    payload_id VARCHAR(255) REFERENCES normalized_webhook_payloads(id),
+    account VARCHAR(255) NOT NULL,
+    native_balance_change NUMERIC(20,0)
+);
+
+CREATE TABLE IF NOT EXISTS normalized_token_balance_changes (
+    id SERIAL PRIMARY KEY,
+    account_data_id INTEGER REFERENCES normalized_account_data(id),
+    mint VARCHAR(255) NOT NULL,
+    token_account VARCHAR(255) NOT NULL,
+    user_account VARCHAR(255) NOT NULL,
+    token_amount VARCHAR(100) NOT NULL,
+    decimals SMALLINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS normalized_native_transfers (
+    id SERIAL PRIMARY KEY,
+    payload_id VARCHAR(255) REFERENCES normalized_webhook_payloads(id),
+    from_user_account VARCHAR(255) NOT NULL,
+    to_user_account VARCHAR(255) NOT NULL,
+    amount NUMERIC(20,0) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS normalized_token_transfers (
+    id SERIAL PRIMARY KEY,
+    payload_id VARCHAR(255) REFERENCES normalized_webhook_payloads(id),
+    from_token_account VARCHAR(255) NOT NULL,
+    from_user_account VARCHAR(255) NOT NULL,
+    to_token_account VARCHAR(255) NOT NULL,
+    to_user_account VARCHAR(255) NOT NULL,
+    mint VARCHAR(255) NOT NULL,
+    token_amount NUMERIC(20,8),
+    token_standard VARCHAR(50)
+);
@@ -0,0 +1,10 @@
+-- Indexes for the common lookups users run against the normalized tables
+CREATE INDEX IF NOT EXISTS idx_normalized_webhook_payloads_slot ON normalized_webhook_payloads(slot);
+CREATE INDEX IF NOT EXISTS idx_normalized_webhook_payloads_timestamp ON normalized_webhook_payloads(timestamp);
+CREATE INDEX IF NOT EXISTS idx_normalized_account_data_payload_id ON normalized_account_data(payload_id);
+CREATE INDEX IF NOT EXISTS idx_normalized_account_data_account ON normalized_account_data(account);
+CREATE INDEX IF NOT EXISTS idx_normalized_token_balance_changes_account_data_id ON normalized_token_balance_changes(account_data_id);
+CREATE INDEX IF NOT EXISTS idx_normalized_token_balance_changes_mint ON normalized_token_balance_changes(mint);
+CREATE INDEX IF NOT EXISTS idx_normalized_native_transfers_payload_id ON normalized_native_transfers(payload_id);
+CREATE INDEX IF NOT EXISTS idx_normalized_token_transfers_payload_id ON normalized_token_transfers(payload_id);
+CREATE INDEX IF NOT EXISTS idx_normalized_token_transfers_mint ON normalized_token_transfers(mint);
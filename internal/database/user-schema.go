@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations for the tables the indexer owns in user databases. Files are
+// named <version>_<name>.sql and applied in version order; an applied file
+// must never be edited, add a new version instead.
+//
+//go:embed user-migrations/*.sql
+var userMigrationFiles embed.FS
+
+// userSchemaLockId serialises migrations when several workers connect to
+// the same user database at once.
+const userSchemaLockId = 72061957
+
+type userMigration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadUserMigrations reads the migrations at the root of fsys in version
+// order.
+func loadUserMigrations(fsys fs.FS) ([]userMigration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]userMigration, 0, len(entries))
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		versionStr, _, found := strings.Cut(name, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !found || err != nil {
+			return nil, fmt.Errorf("invalid user migration file name %q", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("user migrations %q and %q share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, userMigration{
+			version: version,
+			name:    name,
+			sql:     string(contents),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
+
+// MigrateUserDatabase brings the indexer tables in a user database up to the
+// latest version. Each migration runs in its own transaction together with
+// its sol_indexer_schema_version row, so a failed migration leaves the
+// database at the previous version.
+func MigrateUserDatabase(ctx context.Context, db *sql.DB) error {
+	migrationsDir, err := fs.Sub(userMigrationFiles, "user-migrations")
+	if err != nil {
+		return err
+	}
+	migrations, err := loadUserMigrations(migrationsDir)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS sol_indexer_schema_version (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema version table: %w", err)
+	}
+
+	for _, migration := range migrations {
+		if err := applyUserMigration(ctx, db, migration); err != nil {
+			return fmt.Errorf("failed to apply user migration %s: %w", migration.name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyUserMigration(ctx context.Context, db *sql.DB, migration userMigration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, userSchemaLockId); err != nil {
+		return err
+	}
+
+	var applied bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM sol_indexer_schema_version WHERE version = $1)
+	`, migration.version).Scan(&applied)
+	if err != nil {
+		return err
+	}
+
+	if applied {
+		return nil
+	}
+
+	if _, err = tx.ExecContext(ctx, migration.sql); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO sol_indexer_schema_version (version, name, applied_at)
+		VALUES ($1, $2, $3)
+	`, migration.version, migration.name, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("Applied user migration %s", migration.name)
+	return nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadUserMigrations(t *testing.T) {
+	migrations, err := loadUserMigrations(fstest.MapFS{
+		"10_late.sql":  {Data: []byte("SELECT 10")},
+		"2_second.sql": {Data: []byte("SELECT 2")},
+		"1_first.sql":  {Data: []byte("SELECT 1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Versions sort as numbers, not as file names
+	var names []string
+	for _, migration := range migrations {
+		names = append(names, migration.name)
+	}
+	if strings.Join(names, ",") != "1_first,2_second,10_late" {
+		t.Errorf("expected migrations in version order; got %v", names)
+	}
+	if migrations[2].version != 10 || migrations[2].sql != "SELECT 10" {
+		t.Errorf("unexpected last migration %+v", migrations[2])
+	}
+}
+
+func TestLoadUserMigrationsRejectsBadFiles(t *testing.T) {
+	cases := []struct {
+		name  string
+		files fstest.MapFS
+		err   string
+	}{
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"1_first.sql":  {Data: []byte("SELECT 1")},
+				"01_again.sql": {Data: []byte("SELECT 1")},
+			},
+			err: "share version 1",
+		},
+		{
+			name:  "no name",
+			files: fstest.MapFS{"1.sql": {Data: []byte("SELECT 1")}},
+			err:   "invalid user migration file name",
+		},
+		{
+			name:  "no version",
+			files: fstest.MapFS{"first_table.sql": {Data: []byte("SELECT 1")}},
+			err:   "invalid user migration file name",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := loadUserMigrations(c.files)
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expected an error containing %q; got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestEmbeddedUserMigrations(t *testing.T) {
+	migrationsDir, err := fs.Sub(userMigrationFiles, "user-migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := loadUserMigrations(migrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Error("expected embedded user migrations")
+	}
+}
@@ -0,0 +1,521 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/helius"
+)
+
+// Addresses are sharded over as many Helius webhooks as they need. Every
+// address is watched by one webhook, recorded in helius_webhook_addresses.
+// A new address goes to the least loaded webhook with room, preferring one
+// that already sends the transaction types it needs, and a webhook-N is
+// created once all of them are full. Changes to the pool hold an advisory
+// lock: Helius replaces a whole webhook on every PUT, so two concurrent
+// edits would lose one of them.
+
+const (
+	// Helius caps the number of addresses a single webhook can watch
+	maxWebhookAddresses = 99999
+	// Arbitrary key of the advisory lock guarding the pool
+	webhookPoolLockKey = 5_049_200_415
+)
+
+var errNoWebhookCapacity = errors.New("every helius webhook is watching the maximum number of addresses")
+
+// webhookCapacity is how many addresses the pool puts on one webhook,
+// HELIUS_WEBHOOK_CAPACITY or the Helius maximum.
+func webhookCapacity() int {
+	capacity, err := strconv.Atoi(os.Getenv("HELIUS_WEBHOOK_CAPACITY"))
+	if err != nil || capacity <= 0 || capacity > maxWebhookAddresses {
+		return maxWebhookAddresses
+	}
+	return capacity
+}
+
+// withWebhookPoolLock runs fn while holding the pool's advisory lock, which
+// is tied to a connection of its own and released with it.
+func (s *service) withWebhookPoolLock(ctx context.Context, fn func() error) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		log.Println("Failed to get a connection for the webhook pool lock: ", err)
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, webhookPoolLockKey); err != nil {
+		log.Println("Failed to take the webhook pool lock: ", err)
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, webhookPoolLockKey)
+
+	return fn()
+}
+
+// CreateOrUpdateWebhook makes sure a Helius webhook watches the address for
+// the strategies' transaction types. It returns the config of the webhook
+// watching the address.
+func (s *service) CreateOrUpdateWebhook(address string, txnType []IndexingStrategy) (*HeliusWebhookConfig, error) {
+	var cfg *HeliusWebhookConfig
+
+	err := s.withWebhookPoolLock(context.Background(), func() error {
+		heliusConfig, err := s.GetAllWebhooks()
+		if err != nil {
+			log.Println("Error occured while fetching webhooks", err)
+			return err
+		}
+
+		cfg, err = s.UpdateWebhook(heliusConfig, address, txnType)
+		if !errors.Is(err, errNoWebhookCapacity) {
+			return err
+		}
+
+		cfg, err = s.CreateWebhook(nextWebhookName(heliusConfig), txnType, address)
+		if err != nil {
+			log.Println("Error occured while creating webhooks", err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// UpdateWebhook adds the address and the strategies' transaction types to
+// the webhook already watching the address, or else to the one pickWebhook
+// chooses. It returns errNoWebhookCapacity when every webhook is full.
+// Callers are expected to hold the webhook pool lock.
+func (s *service) UpdateWebhook(heliusConfig []HeliusWebhookConfig, address string, txnType []IndexingStrategy) (*HeliusWebhookConfig, error) {
+	txnTypes := HeliusTransactionTypes(txnType)
+
+	webhookId, err := s.webhookWatching(address)
+	if err != nil {
+		log.Println("Failed to look up the webhook watching the address: ", err)
+		return nil, err
+	}
+
+	var target *HeliusWebhookConfig
+	if webhookId != "" {
+		target = findWebhook(heliusConfig, webhookId)
+	}
+	if target == nil {
+		target = pickWebhook(heliusConfig, txnTypes, webhookCapacity(), "")
+	}
+	if target == nil {
+		return nil, errNoWebhookCapacity
+	}
+
+	if err = s.addAddressesToWebhook(target, []string{address}, txnTypes); err != nil {
+		log.Printf("Failed to add address %s to webhook %s: %v", address, target.WebhookName, err)
+		return nil, err
+	}
+
+	return target, nil
+}
+
+// RemoveAddressFromWebhooks stops Helius from watching the address. Only the
+// webhook recorded for it is edited, or every webhook for addresses added
+// before the pool kept track of them.
+func (s *service) RemoveAddressFromWebhooks(address string) error {
+	return s.withWebhookPoolLock(context.Background(), func() error {
+		configs, err := s.GetAllWebhooks()
+		if err != nil {
+			return err
+		}
+
+		webhookId, err := s.webhookWatching(address)
+		if err != nil {
+			return err
+		}
+		if cfg := findWebhook(configs, webhookId); cfg != nil {
+			configs = []HeliusWebhookConfig{*cfg}
+		}
+
+		for i := range configs {
+			cfg := &configs[i]
+			webhook, err := s.editWebhook(cfg.WebhookId, func(webhook *helius.Webhook) bool {
+				remaining := slices.DeleteFunc(slices.Clone(webhook.AccountAddresses), func(account string) bool {
+					return account == address
+				})
+				removed := len(remaining) != len(webhook.AccountAddresses)
+				webhook.AccountAddresses = remaining
+				return removed
+			})
+			if err != nil {
+				log.Printf("Failed to remove address %s from webhook %s: %v", address, cfg.WebhookName, err)
+				return err
+			}
+
+			if err = s.recordWebhookState(cfg, webhook); err != nil {
+				return err
+			}
+		}
+
+		if _, err = s.db.Exec(`DELETE FROM helius_webhook_addresses WHERE token_address = $1`, address); err != nil {
+			log.Println("Failed to forget the webhook of the address: ", err)
+			return err
+		}
+
+		return nil
+	})
+}
+
+// WebhookRebalanceReport summarises a DeleteWebhook run.
+type WebhookRebalanceReport struct {
+	Webhook          string   `json:"webhook"`
+	AddressesMoved   int      `json:"addresses_moved"`
+	AddressesDropped int      `json:"addresses_dropped"`
+	Targets          []string `json:"targets"`
+}
+
+// DeleteWebhook moves the addresses of a webhook that still have active
+// subscriptions to the rest of the pool, creating webhooks if it is full,
+// then deletes the webhook from Helius and its config. Addresses are added
+// to their new webhook before the old one is deleted, so transactions in
+// between may arrive twice and are deduplicated by the worker.
+func (s *service) DeleteWebhook(ctx context.Context, name string) (*WebhookRebalanceReport, error) {
+	report := &WebhookRebalanceReport{Webhook: name, Targets: []string{}}
+
+	err := s.withWebhookPoolLock(ctx, func() error {
+		cfg, err := s.GetWebhookConfigByName(name)
+		if err != nil {
+			return err
+		}
+
+		current, err := s.GetCurrentWebhookConfig(cfg.WebhookId)
+		if err != nil {
+			return err
+		}
+
+		addresses, err := s.webhookAddresses(ctx, cfg.WebhookId)
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			addresses = append(addresses, current.AccountAddresses...)
+		}
+		slices.Sort(addresses)
+		addresses = slices.Compact(addresses)
+
+		subscribed, txnTypes, err := s.subscribedAddresses(ctx, addresses)
+		if err != nil {
+			return err
+		}
+		report.AddressesDropped = len(addresses) - len(subscribed)
+
+		pool, err := s.GetAllWebhooks()
+		if err != nil {
+			return err
+		}
+
+		targets, err := s.placeAddresses(ctx, pool, cfg.Id, subscribed, txnTypes)
+		if err != nil {
+			return err
+		}
+		report.AddressesMoved = len(subscribed)
+		report.Targets = append(report.Targets, targets...)
+
+		if current != nil {
+			err = s.helius.DeleteWebhook(ctx, cfg.WebhookId)
+			if err != nil && !errors.Is(err, helius.ErrNotFound) {
+				log.Printf("Failed to delete webhook %s from helius: %v", name, err)
+				return err
+			}
+		}
+
+		// Address rows go with it through the foreign key
+		if _, err = s.db.ExecContext(ctx, `DELETE FROM helius_webhook_config WHERE id = $1`, cfg.Id); err != nil {
+			log.Printf("Failed to delete the config of webhook %s: %v", name, err)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// placeAddresses spreads addresses over the pool, skipping the webhook with
+// id exclude, and points their lookup rows at their new webhook. It returns
+// the names of the webhooks the addresses went to.
+func (s *service) placeAddresses(ctx context.Context, pool []HeliusWebhookConfig, exclude string, addresses []string, txnTypes []string) ([]string, error) {
+	capacity := webhookCapacity()
+	var targets []string
+
+	for len(addresses) > 0 {
+		var (
+			target *HeliusWebhookConfig
+			chunk  []string
+		)
+
+		if picked := pickWebhook(pool, txnTypes, capacity, exclude); picked != nil {
+			chunk = addresses[:min(capacity-int(picked.AddressCount), len(addresses))]
+			if err := s.addAddressesToWebhook(picked, chunk, txnTypes); err != nil {
+				log.Printf("Failed to move addresses to webhook %s: %v", picked.WebhookName, err)
+				return nil, err
+			}
+			target = picked
+		} else {
+			chunk = addresses[:min(capacity, len(addresses))]
+			created, err := s.createWebhook(nextWebhookName(pool), txnTypes, chunk)
+			if err != nil {
+				log.Println("Failed to create a webhook for moved addresses: ", err)
+				return nil, err
+			}
+			pool = append(pool, *created)
+			target = created
+		}
+
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE subscription_lookup
+			SET helius_webhook_id = $1,
+				last_updated = $2
+			WHERE token_address = ANY($3)
+		`, target.WebhookId, time.Now(), chunk)
+		if err != nil {
+			log.Printf("Failed to point lookup rows at webhook %s: %v", target.WebhookName, err)
+			return nil, err
+		}
+
+		if !slices.Contains(targets, target.WebhookName) {
+			targets = append(targets, target.WebhookName)
+		}
+		addresses = addresses[len(chunk):]
+	}
+
+	return targets, nil
+}
+
+// addAddressesToWebhook adds the addresses and transaction types a webhook
+// is missing, then records what Helius reports back.
+func (s *service) addAddressesToWebhook(target *HeliusWebhookConfig, addresses []string, txnTypes []string) error {
+	webhook, err := s.editWebhook(target.WebhookId, func(webhook *helius.Webhook) bool {
+		changed := false
+
+		for _, address := range addresses {
+			if !slices.Contains(webhook.AccountAddresses, address) {
+				webhook.AccountAddresses = append(webhook.AccountAddresses, address)
+				changed = true
+			}
+		}
+
+		for _, txnType := range txnTypes {
+			if !slices.Contains(webhook.TransactionTypes, txnType) {
+				webhook.TransactionTypes = append(webhook.TransactionTypes, txnType)
+				changed = true
+			}
+		}
+
+		return changed
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = s.recordWebhookState(target, webhook); err != nil {
+		return err
+	}
+
+	return s.assignAddresses(target.WebhookId, addresses)
+}
+
+// recordWebhookState stores the address count and transaction types Helius
+// reports for a webhook, so the pool never works from drifted counts.
+func (s *service) recordWebhookState(cfg *HeliusWebhookConfig, webhook *helius.Webhook) error {
+	txnTypes := slices.Clone(webhook.TransactionTypes)
+	slices.Sort(txnTypes)
+	txnTypes = slices.Compact(txnTypes)
+
+	_, err := s.db.Exec(`
+		UPDATE helius_webhook_config
+		SET address_count = $1,
+			transaction_types = $2,
+			updated_at = $3
+		WHERE id = $4
+	`, len(webhook.AccountAddresses), txnTypes, time.Now(), cfg.Id)
+	if err != nil {
+		log.Printf("Failed to record the state of webhook %s: %v", cfg.WebhookName, err)
+		return err
+	}
+
+	cfg.AddressCount = int32(len(webhook.AccountAddresses))
+	cfg.TransactionTypes = txnTypes
+	return nil
+}
+
+// assignAddresses records the webhook watching each of the addresses.
+func (s *service) assignAddresses(webhookId string, addresses []string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO helius_webhook_addresses (token_address, webhook_id, created_at)
+		SELECT address, $2, $3
+		FROM unnest($1::text[]) AS address
+		ON CONFLICT (token_address) DO UPDATE SET webhook_id = EXCLUDED.webhook_id
+	`, addresses, webhookId, time.Now())
+	return err
+}
+
+// webhookWatching returns the Helius id of the webhook recorded for the
+// address, or "" when there is none.
+func (s *service) webhookWatching(address string) (string, error) {
+	var webhookId string
+	err := s.db.QueryRow(`
+		SELECT webhook_id
+		FROM helius_webhook_addresses
+		WHERE token_address = $1
+	`, address).Scan(&webhookId)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return webhookId, err
+}
+
+func (s *service) webhookAddresses(ctx context.Context, webhookId string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT token_address
+		FROM helius_webhook_addresses
+		WHERE webhook_id = $1
+	`, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addresses []string
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+
+	return addresses, rows.Err()
+}
+
+// subscribedAddresses returns which of the addresses still have an active
+// subscription, and the transaction types those subscriptions need.
+func (s *service) subscribedAddresses(ctx context.Context, addresses []string) ([]string, []string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT token_address, array_to_json(indexing_strategy)
+		FROM subscriptions
+		WHERE token_address = ANY($1) AND status = true
+		ORDER BY token_address
+	`, addresses)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var (
+		subscribed []string
+		strategies []IndexingStrategy
+	)
+	for rows.Next() {
+		var (
+			address string
+			raw     []byte
+			strats  []IndexingStrategy
+		)
+		if err := rows.Scan(&address, &raw); err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(raw, &strats); err != nil {
+			return nil, nil, err
+		}
+
+		if len(subscribed) == 0 || subscribed[len(subscribed)-1] != address {
+			subscribed = append(subscribed, address)
+		}
+		strategies = append(strategies, strats...)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return subscribed, HeliusTransactionTypes(strategies), nil
+}
+
+// pickWebhook chooses the webhook new addresses needing txnTypes go to. Only
+// webhooks below capacity, other than the one with id exclude, are
+// considered. One sending exactly txnTypes is preferred, then one sending
+// all of them, then any other, and the least loaded wins within each of
+// those. It returns nil when every webhook is full.
+func pickWebhook(configs []HeliusWebhookConfig, txnTypes []string, capacity int, exclude string) *HeliusWebhookConfig {
+	var (
+		best     *HeliusWebhookConfig
+		bestRank int
+	)
+
+	for i := range configs {
+		cfg := &configs[i]
+		if cfg.Id == exclude || int(cfg.AddressCount) >= capacity {
+			continue
+		}
+
+		rank := 2
+		if slices.Equal(cfg.TransactionTypes, txnTypes) {
+			rank = 0
+		} else if containsAll(cfg.TransactionTypes, txnTypes) {
+			rank = 1
+		}
+
+		if best == nil || rank < bestRank || (rank == bestRank && cfg.AddressCount < best.AddressCount) {
+			best = cfg
+			bestRank = rank
+		}
+	}
+
+	return best
+}
+
+func containsAll(set []string, values []string) bool {
+	for _, value := range values {
+		if !slices.Contains(set, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func findWebhook(configs []HeliusWebhookConfig, webhookId string) *HeliusWebhookConfig {
+	if webhookId == "" {
+		return nil
+	}
+	for i := range configs {
+		if configs[i].WebhookId == webhookId {
+			return &configs[i]
+		}
+	}
+	return nil
+}
+
+// nextWebhookName returns webhook-N for the first N above every webhook
+// named that way, so names stay unique after webhooks are deleted.
+func nextWebhookName(configs []HeliusWebhookConfig) string {
+	next := 0
+	for _, cfg := range configs {
+		suffix, found := strings.CutPrefix(cfg.WebhookName, "webhook-")
+		if !found {
+			continue
+		}
+		if n, err := strconv.Atoi(suffix); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return fmt.Sprintf("webhook-%d", next)
+}
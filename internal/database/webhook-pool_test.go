@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/scythe504/solana-indexer/internal/helius"
+)
+
+func TestPickWebhook(t *testing.T) {
+	swaps := []string{"SWAP"}
+	swapsAndTransfers := []string{"SWAP", "TRANSFER"}
+
+	cases := []struct {
+		name     string
+		configs  []HeliusWebhookConfig
+		exclude  string
+		expected string
+	}{
+		{
+			name:     "empty pool",
+			expected: "",
+		},
+		{
+			name: "every webhook full",
+			configs: []HeliusWebhookConfig{
+				{Id: "a", AddressCount: 10, TransactionTypes: swaps},
+				{Id: "b", AddressCount: 12, TransactionTypes: swaps},
+			},
+			expected: "",
+		},
+		{
+			name: "exact types beat a less loaded superset",
+			configs: []HeliusWebhookConfig{
+				{Id: "superset", AddressCount: 1, TransactionTypes: swapsAndTransfers},
+				{Id: "exact", AddressCount: 8, TransactionTypes: swaps},
+			},
+			expected: "exact",
+		},
+		{
+			name: "superset beats a less loaded webhook missing types",
+			configs: []HeliusWebhookConfig{
+				{Id: "other", AddressCount: 0, TransactionTypes: []string{"NFT_SALE"}},
+				{Id: "superset", AddressCount: 5, TransactionTypes: swapsAndTransfers},
+			},
+			expected: "superset",
+		},
+		{
+			name: "least loaded wins within a rank",
+			configs: []HeliusWebhookConfig{
+				{Id: "busy", AddressCount: 7, TransactionTypes: swaps},
+				{Id: "quiet", AddressCount: 2, TransactionTypes: swaps},
+			},
+			expected: "quiet",
+		},
+		{
+			name: "full webhooks are skipped whatever their types",
+			configs: []HeliusWebhookConfig{
+				{Id: "full", AddressCount: 10, TransactionTypes: swaps},
+				{Id: "other", AddressCount: 3, TransactionTypes: nil},
+			},
+			expected: "other",
+		},
+		{
+			name: "excluded webhook is skipped",
+			configs: []HeliusWebhookConfig{
+				{Id: "leaving", AddressCount: 0, TransactionTypes: swaps},
+				{Id: "other", AddressCount: 9, TransactionTypes: nil},
+			},
+			exclude:  "leaving",
+			expected: "other",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			picked := pickWebhook(c.configs, swaps, 10, c.exclude)
+
+			got := ""
+			if picked != nil {
+				got = picked.Id
+			}
+			if got != c.expected {
+				t.Errorf("expected %q; got %q", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestNextWebhookName(t *testing.T) {
+	cases := []struct {
+		name     string
+		names    []string
+		expected string
+	}{
+		{name: "empty pool", expected: "webhook-0"},
+		{name: "one webhook", names: []string{"webhook-0"}, expected: "webhook-1"},
+		{name: "gap after a deletion", names: []string{"webhook-0", "webhook-3"}, expected: "webhook-4"},
+		{name: "unordered", names: []string{"webhook-5", "webhook-2"}, expected: "webhook-6"},
+		{name: "other names are ignored", names: []string{"legacy", "webhook-x", "webhook-1"}, expected: "webhook-2"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			configs := make([]HeliusWebhookConfig, 0, len(c.names))
+			for _, name := range c.names {
+				configs = append(configs, HeliusWebhookConfig{WebhookName: name})
+			}
+
+			if got := nextWebhookName(configs); got != c.expected {
+				t.Errorf("expected %s; got %s", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlaceAddressesChunksByCapacity(t *testing.T) {
+	t.Setenv("HELIUS_WEBHOOK_CAPACITY", "2")
+	fake := helius.NewFakeServer("test-key")
+	defer fake.Close()
+	s := testService(t, fake)
+
+	txnTypes := HeliusTransactionTypes([]IndexingStrategy{TokenCrossPlatformPrices})
+	if _, err := s.createWebhook("webhook-0", txnTypes, []string{newAddress()}); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := s.GetAllWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addresses := []string{newAddress(), newAddress(), newAddress(), newAddress()}
+	targets, err := s.placeAddresses(context.Background(), pool, "", addresses, txnTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// webhook-0 had room for one, the rest fill new webhooks two at a time
+	if !slices.Equal(targets, []string{"webhook-0", "webhook-1", "webhook-2"}) {
+		t.Fatalf("unexpected targets %v", targets)
+	}
+
+	webhooks := fake.Webhooks()
+	if len(webhooks) != 3 {
+		t.Fatalf("expected 3 webhooks; got %d", len(webhooks))
+	}
+	for i, expected := range []int{2, 2, 1} {
+		if got := len(webhooks[i].AccountAddresses); got != expected {
+			t.Errorf("webhook %d: expected %d addresses; got %d", i, expected, got)
+		}
+	}
+
+	for i, address := range addresses {
+		expected := webhooks[min((i+1)/2, 2)].WebhookId
+		if got := webhookOf(t, s, address); got != expected {
+			t.Errorf("address %d: expected webhook %s; got %q", i, expected, got)
+		}
+	}
+}
+
+func TestDeleteWebhookRebalances(t *testing.T) {
+	t.Setenv("HELIUS_WEBHOOK_CAPACITY", "2")
+	fake := helius.NewFakeServer("test-key")
+	defer fake.Close()
+	s := testService(t, fake)
+
+	txnTypes := HeliusTransactionTypes([]IndexingStrategy{TokenCrossPlatformPrices})
+	subscribed, unsubscribed, staying := newAddress(), newAddress(), newAddress()
+
+	leaving, err := s.createWebhook("webhook-0", txnTypes, []string{subscribed, unsubscribed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining, err := s.createWebhook("webhook-1", txnTypes, []string{staying})
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertSubscription(t, s, subscribed, TokenCrossPlatformPrices)
+	insertSubscription(t, s, staying, TokenCrossPlatformPrices)
+
+	report, err := s.DeleteWebhook(context.Background(), "webhook-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.AddressesMoved != 1 || report.AddressesDropped != 1 || !slices.Equal(report.Targets, []string{"webhook-1"}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	webhooks := fake.Webhooks()
+	if len(webhooks) != 1 || webhooks[0].WebhookId != remaining.WebhookId {
+		t.Fatalf("expected only webhook-1 to be left on helius; got %+v", webhooks)
+	}
+	if !slices.Contains(webhooks[0].AccountAddresses, subscribed) || !slices.Contains(webhooks[0].AccountAddresses, staying) {
+		t.Errorf("expected webhook-1 to watch the moved address; got %v", webhooks[0].AccountAddresses)
+	}
+
+	if got := webhookOf(t, s, subscribed); got != remaining.WebhookId {
+		t.Errorf("expected the moved address on webhook-1; got %q", got)
+	}
+	if got := webhookOf(t, s, unsubscribed); got != "" {
+		t.Errorf("expected the unsubscribed address to be dropped; got %q", got)
+	}
+	if _, err := s.GetWebhookConfigByName(leaving.WebhookName); err == nil {
+		t.Error("expected the config of the deleted webhook to be gone")
+	}
+}
@@ -0,0 +1,312 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/helius"
+	"github.com/scythe504/solana-indexer/internal/metrics"
+)
+
+// WebhookDrift is how a webhook on Helius differs from what the active
+// subscriptions of its addresses need.
+type WebhookDrift struct {
+	Webhook         string `json:"webhook"`
+	WebhookId       string `json:"webhook_id"`
+	MissingOnHelius bool   `json:"missing_on_helius,omitempty"`
+	// Watched without an active subscription, or already watched by an
+	// earlier webhook
+	ExtraAddresses          []string `json:"extra_addresses,omitempty"`
+	MissingTransactionTypes []string `json:"missing_transaction_types,omitempty"`
+	ExtraTransactionTypes   []string `json:"extra_transaction_types,omitempty"`
+	RecordedAddressCount    int32    `json:"recorded_address_count"`
+	AddressCount            int      `json:"address_count"`
+}
+
+func (d WebhookDrift) drifted() bool {
+	return d.MissingOnHelius ||
+		len(d.ExtraAddresses) > 0 ||
+		len(d.MissingTransactionTypes) > 0 ||
+		len(d.ExtraTransactionTypes) > 0 ||
+		int(d.RecordedAddressCount) != d.AddressCount
+}
+
+// WebhookReconcileReport summarises a ReconcileWebhooks run. In a dry run it
+// lists the drift that would have been fixed.
+type WebhookReconcileReport struct {
+	DryRun          bool           `json:"dry_run"`
+	WebhooksChecked int            `json:"webhooks_checked"`
+	Drift           []WebhookDrift `json:"drift"`
+	// Addresses of active subscriptions no webhook watches
+	UnwatchedAddresses []string `json:"unwatched_addresses"`
+	// Lookup rows pointing at another webhook than the one watching them
+	LookupRowsDrifted int64 `json:"lookup_rows_drifted"`
+}
+
+// ReconcileWebhooks compares every webhook on Helius with the active
+// subscriptions. Unless dryRun is set the drift is fixed: addresses nothing
+// subscribes to and unneeded transaction types are removed, missing types
+// are added, configs of webhooks gone from Helius are deleted, unwatched
+// addresses are placed in the pool and lookup rows are pointed at the
+// webhook watching them.
+func (s *service) ReconcileWebhooks(ctx context.Context, dryRun bool) (*WebhookReconcileReport, error) {
+	report := &WebhookReconcileReport{
+		DryRun:             dryRun,
+		Drift:              []WebhookDrift{},
+		UnwatchedAddresses: []string{},
+	}
+
+	err := s.withWebhookPoolLock(ctx, func() error {
+		wanted, err := s.activeAddressTypes(ctx)
+		if err != nil {
+			log.Println("Failed to list the addresses of active subscriptions: ", err)
+			return err
+		}
+
+		configs, err := s.GetAllWebhooks()
+		if err != nil {
+			return err
+		}
+
+		watched := make(map[string]bool)
+		for i := range configs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			drift, err := s.reconcileWebhook(ctx, &configs[i], wanted, watched, dryRun)
+			if err != nil {
+				log.Printf("Failed to reconcile webhook %s: %v", configs[i].WebhookName, err)
+				return err
+			}
+			report.WebhooksChecked++
+			if drift.drifted() {
+				report.Drift = append(report.Drift, drift)
+				countDrift(drift)
+			}
+		}
+
+		for address := range wanted {
+			if !watched[address] {
+				report.UnwatchedAddresses = append(report.UnwatchedAddresses, address)
+			}
+		}
+		slices.Sort(report.UnwatchedAddresses)
+		metrics.WebhookDrift.Add("unwatched_address", int64(len(report.UnwatchedAddresses)))
+
+		if !dryRun && len(report.UnwatchedAddresses) > 0 {
+			if err = s.placeUnwatched(ctx, report.UnwatchedAddresses, wanted); err != nil {
+				return err
+			}
+		}
+
+		report.LookupRowsDrifted, err = s.reconcileLookupWebhookIds(ctx, dryRun)
+		metrics.WebhookDrift.Add("lookup_row", report.LookupRowsDrifted)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// reconcileWebhook diffs one webhook against the addresses it should watch.
+// Addresses already in watched belong to an earlier webhook and count as
+// extra here, the ones it keeps are added to watched.
+func (s *service) reconcileWebhook(ctx context.Context, cfg *HeliusWebhookConfig, wanted map[string][]string, watched map[string]bool, dryRun bool) (WebhookDrift, error) {
+	drift := WebhookDrift{
+		Webhook:              cfg.WebhookName,
+		WebhookId:            cfg.WebhookId,
+		RecordedAddressCount: cfg.AddressCount,
+	}
+
+	current, err := s.GetCurrentWebhookConfig(cfg.WebhookId)
+	if err != nil {
+		return drift, err
+	}
+	if current == nil {
+		drift.MissingOnHelius = true
+		if dryRun {
+			return drift, nil
+		}
+		// Its addresses are unwatched now and get placed with the others,
+		// lookup rows are cleared by the foreign key
+		_, err = s.db.ExecContext(ctx, `DELETE FROM helius_webhook_config WHERE id = $1`, cfg.Id)
+		return drift, err
+	}
+	drift.AddressCount = len(current.AccountAddresses)
+
+	// Not nil, Helius and ANY($2) both need an empty list
+	keep := []string{}
+	var needed []string
+	for _, address := range current.AccountAddresses {
+		txnTypes, ok := wanted[address]
+		if !ok || watched[address] {
+			drift.ExtraAddresses = append(drift.ExtraAddresses, address)
+			continue
+		}
+		watched[address] = true
+		keep = append(keep, address)
+		needed = append(needed, txnTypes...)
+	}
+	slices.Sort(needed)
+	needed = slices.Compact(needed)
+
+	for _, txnType := range needed {
+		if !slices.Contains(current.TransactionTypes, txnType) {
+			drift.MissingTransactionTypes = append(drift.MissingTransactionTypes, txnType)
+		}
+	}
+	// A webhook left without addresses keeps its types for the next ones
+	if len(keep) > 0 {
+		for _, txnType := range current.TransactionTypes {
+			if !slices.Contains(needed, txnType) {
+				drift.ExtraTransactionTypes = append(drift.ExtraTransactionTypes, txnType)
+			}
+		}
+	}
+
+	if dryRun {
+		return drift, nil
+	}
+
+	webhook := current
+	if len(drift.ExtraAddresses) > 0 || len(drift.MissingTransactionTypes) > 0 || len(drift.ExtraTransactionTypes) > 0 {
+		webhook, err = s.editWebhook(cfg.WebhookId, func(webhook *helius.Webhook) bool {
+			webhook.AccountAddresses = keep
+			if len(keep) > 0 {
+				webhook.TransactionTypes = needed
+			}
+			return true
+		})
+		if err != nil {
+			return drift, err
+		}
+	}
+
+	if err = s.recordWebhookState(cfg, webhook); err != nil {
+		return drift, err
+	}
+
+	if err = s.assignAddresses(cfg.WebhookId, keep); err != nil {
+		return drift, err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		DELETE FROM helius_webhook_addresses
+		WHERE webhook_id = $1 AND NOT (token_address = ANY($2))
+	`, cfg.WebhookId, keep)
+	return drift, err
+}
+
+// placeUnwatched adds unwatched addresses to the pool, grouped by the
+// transaction types they need so each group can share a webhook.
+func (s *service) placeUnwatched(ctx context.Context, addresses []string, wanted map[string][]string) error {
+	groups := make(map[string][]string)
+	for _, address := range addresses {
+		key := strings.Join(wanted[address], ",")
+		groups[key] = append(groups[key], address)
+	}
+
+	for key, group := range groups {
+		pool, err := s.GetAllWebhooks()
+		if err != nil {
+			return err
+		}
+
+		targets, err := s.placeAddresses(ctx, pool, "", group, strings.Split(key, ","))
+		if err != nil {
+			log.Println("Failed to place unwatched addresses: ", err)
+			return err
+		}
+		log.Printf("Placed %d unwatched addresses on %s", len(group), strings.Join(targets, ", "))
+	}
+
+	return nil
+}
+
+// reconcileLookupWebhookIds points lookup rows at the webhook recorded for
+// their address and returns how many pointed elsewhere.
+func (s *service) reconcileLookupWebhookIds(ctx context.Context, dryRun bool) (int64, error) {
+	if dryRun {
+		var drifted int64
+		err := s.db.QueryRowContext(ctx, `
+			SELECT COUNT(*)
+			FROM subscription_lookup l
+			JOIN helius_webhook_addresses a ON a.token_address = l.token_address
+			WHERE l.helius_webhook_id IS DISTINCT FROM a.webhook_id
+		`).Scan(&drifted)
+		return drifted, err
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE subscription_lookup l
+		SET helius_webhook_id = a.webhook_id,
+			last_updated = $1
+		FROM helius_webhook_addresses a
+		WHERE a.token_address = l.token_address
+			AND l.helius_webhook_id IS DISTINCT FROM a.webhook_id
+	`, time.Now())
+	if err != nil {
+		log.Println("Failed to point lookup rows at their webhook: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// activeAddressTypes maps every address with an active subscription to the
+// sorted transaction types its subscriptions need.
+func (s *service) activeAddressTypes(ctx context.Context) (map[string][]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT token_address, array_to_json(indexing_strategy)
+		FROM subscriptions
+		WHERE status = true
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	strategies := make(map[string][]IndexingStrategy)
+	for rows.Next() {
+		var (
+			address string
+			raw     []byte
+			strats  []IndexingStrategy
+		)
+		if err := rows.Scan(&address, &raw); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &strats); err != nil {
+			return nil, err
+		}
+		strategies[address] = append(strategies[address], strats...)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string][]string, len(strategies))
+	for address, strats := range strategies {
+		wanted[address] = HeliusTransactionTypes(strats)
+	}
+
+	return wanted, nil
+}
+
+func countDrift(drift WebhookDrift) {
+	if drift.MissingOnHelius {
+		metrics.WebhookDrift.Add("missing_webhook", 1)
+	}
+	metrics.WebhookDrift.Add("extra_address", int64(len(drift.ExtraAddresses)))
+	metrics.WebhookDrift.Add("missing_transaction_type", int64(len(drift.MissingTransactionTypes)))
+	metrics.WebhookDrift.Add("extra_transaction_type", int64(len(drift.ExtraTransactionTypes)))
+	if int(drift.RecordedAddressCount) != drift.AddressCount {
+		metrics.WebhookDrift.Add("address_count", 1)
+	}
+}
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/scythe504/solana-indexer/internal/helius"
+)
+
+func TestReconcileWebhooksFixesDrift(t *testing.T) {
+	fake := helius.NewFakeServer("test-key")
+	defer fake.Close()
+	s := testService(t, fake)
+	ctx := context.Background()
+
+	priceTypes := HeliusTransactionTypes([]IndexingStrategy{TokenCrossPlatformPrices})
+	subscribed, unsubscribed, unwatched := newAddress(), newAddress(), newAddress()
+
+	cfg, err := s.createWebhook("webhook-0", priceTypes, []string{subscribed, unsubscribed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The subscription needs more types than the webhook sends, and nothing
+	// subscribes to the second address anymore
+	insertSubscription(t, s, subscribed, TokenCrossPlatformPrices, TokensAvailableToBorrow)
+	insertSubscription(t, s, unwatched, TokenCrossPlatformPrices)
+
+	// Someone added a type by hand on Helius
+	client := fake.Client()
+	webhook, err := client.GetWebhook(ctx, cfg.WebhookId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook.TransactionTypes = append(webhook.TransactionTypes, "NFT_SALE")
+	if _, err := client.EditWebhook(ctx, cfg.WebhookId, webhook.Request()); err != nil {
+		t.Fatal(err)
+	}
+
+	needed := HeliusTransactionTypes([]IndexingStrategy{TokenCrossPlatformPrices, TokensAvailableToBorrow})
+	var missing []string
+	for _, txnType := range needed {
+		if !slices.Contains(priceTypes, txnType) {
+			missing = append(missing, txnType)
+		}
+	}
+
+	report, err := s.ReconcileWebhooks(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drift) != 1 {
+		t.Fatalf("expected one drifted webhook; got %+v", report.Drift)
+	}
+	drift := report.Drift[0]
+	if !slices.Equal(drift.ExtraAddresses, []string{unsubscribed}) {
+		t.Errorf("expected %s to be extra; got %v", unsubscribed, drift.ExtraAddresses)
+	}
+	if !slices.Equal(drift.MissingTransactionTypes, missing) {
+		t.Errorf("expected missing types %v; got %v", missing, drift.MissingTransactionTypes)
+	}
+	if !slices.Equal(drift.ExtraTransactionTypes, []string{"NFT_SALE"}) {
+		t.Errorf("expected NFT_SALE to be extra; got %v", drift.ExtraTransactionTypes)
+	}
+	if !slices.Equal(report.UnwatchedAddresses, []string{unwatched}) {
+		t.Errorf("expected %s to be unwatched; got %v", unwatched, report.UnwatchedAddresses)
+	}
+	if got := fake.Webhooks()[0].AccountAddresses; len(got) != 2 || !slices.Contains(got, unsubscribed) {
+		t.Fatalf("a dry run changed the webhook: %v", got)
+	}
+
+	if _, err := s.ReconcileWebhooks(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	webhooks := fake.Webhooks()
+	if len(webhooks) != 1 {
+		t.Fatalf("expected the unwatched address to join the webhook; got %d webhooks", len(webhooks))
+	}
+	addresses := slices.Clone(webhooks[0].AccountAddresses)
+	slices.Sort(addresses)
+	expected := []string{subscribed, unwatched}
+	slices.Sort(expected)
+	if !slices.Equal(addresses, expected) {
+		t.Errorf("expected the webhook to watch %v; got %v", expected, addresses)
+	}
+	txnTypes := slices.Clone(webhooks[0].TransactionTypes)
+	slices.Sort(txnTypes)
+	if !slices.Equal(txnTypes, needed) {
+		t.Errorf("expected the webhook to send %v; got %v", needed, txnTypes)
+	}
+	if got := webhookOf(t, s, unsubscribed); got != "" {
+		t.Errorf("expected the unsubscribed address to be forgotten; got %q", got)
+	}
+
+	report, err = s.ReconcileWebhooks(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drift) != 0 || len(report.UnwatchedAddresses) != 0 {
+		t.Errorf("expected no drift left; got %+v", report)
+	}
+}
+
+func TestReconcileWebhooksDropsDuplicateWatchers(t *testing.T) {
+	fake := helius.NewFakeServer("test-key")
+	defer fake.Close()
+	s := testService(t, fake)
+	ctx := context.Background()
+
+	txnTypes := HeliusTransactionTypes([]IndexingStrategy{TokenCrossPlatformPrices})
+	address := newAddress()
+	insertSubscription(t, s, address, TokenCrossPlatformPrices)
+
+	first, err := s.createWebhook("webhook-0", txnTypes, []string{address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.createWebhook("webhook-1", txnTypes, []string{address})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.ReconcileWebhooks(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drift) != 1 || report.Drift[0].Webhook != "webhook-1" || !slices.Equal(report.Drift[0].ExtraAddresses, []string{address}) {
+		t.Errorf("expected the later webhook to hold the duplicate; got %+v", report.Drift)
+	}
+
+	webhooks := fake.Webhooks()
+	if !slices.Equal(webhooks[0].AccountAddresses, []string{address}) || len(webhooks[1].AccountAddresses) != 0 {
+		t.Errorf("expected only webhook-0 to watch the address; got %v and %v", webhooks[0].AccountAddresses, webhooks[1].AccountAddresses)
+	}
+	// Left without addresses, it keeps its types for the next ones
+	if !slices.Equal(webhooks[1].TransactionTypes, txnTypes) {
+		t.Errorf("expected the emptied webhook to keep its types; got %v", webhooks[1].TransactionTypes)
+	}
+	if got := webhookOf(t, s, address); got != first.WebhookId {
+		t.Errorf("expected the address to be recorded on %s; got %q (%s is the duplicate)", first.WebhookId, got, second.WebhookId)
+	}
+}
+
+func TestReconcileWebhooksReplacesMissingWebhook(t *testing.T) {
+	fake := helius.NewFakeServer("test-key")
+	defer fake.Close()
+	s := testService(t, fake)
+	ctx := context.Background()
+
+	txnTypes := HeliusTransactionTypes([]IndexingStrategy{TokenCrossPlatformPrices})
+	address := newAddress()
+	insertSubscription(t, s, address, TokenCrossPlatformPrices)
+
+	cfg, err := s.createWebhook("webhook-0", txnTypes, []string{address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Deleted on Helius behind the indexer's back
+	if err := fake.Client().DeleteWebhook(ctx, cfg.WebhookId); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.ReconcileWebhooks(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drift) != 1 || !report.Drift[0].MissingOnHelius {
+		t.Errorf("expected the webhook to be reported missing; got %+v", report.Drift)
+	}
+	if !slices.Equal(report.UnwatchedAddresses, []string{address}) {
+		t.Errorf("expected its address to be unwatched; got %v", report.UnwatchedAddresses)
+	}
+
+	webhooks := fake.Webhooks()
+	if len(webhooks) != 1 || !slices.Equal(webhooks[0].AccountAddresses, []string{address}) {
+		t.Fatalf("expected a new webhook watching the address; got %+v", webhooks)
+	}
+	if got := webhookOf(t, s, address); got != webhooks[0].WebhookId {
+		t.Errorf("expected the address to be recorded on the new webhook; got %q", got)
+	}
+}
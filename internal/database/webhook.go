@@ -1,93 +1,64 @@
 package database
 
 import (
-	"bytes"
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
-	"slices"
 	"time"
 
 	"github.com/gagliardetto/solana-go"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/scythe504/solana-indexer/internal/helius"
 	"github.com/scythe504/solana-indexer/internal/utils"
 )
 
 var (
-	heliusApiKey        = os.Getenv("HELIUS_API_KEY")
-	heliusApiUrl        = os.Getenv("HELIUS_API_URL")
 	heliusWebhookSecret = os.Getenv("HELIUS_WEBHOOK_SECRET")
 	publicUrl           = os.Getenv("PUBLIC_URL")
 )
 
-func (s *service) CreateWebhook(name string, txnType []IndexingStrategy, address string) error {
+// CreateWebhook registers a new Helius webhook watching the address for the
+// strategies' transaction types and stores its config. Callers are expected
+// to hold the webhook pool lock, see webhook-pool.go.
+func (s *service) CreateWebhook(name string, txnType []IndexingStrategy, address string) (*HeliusWebhookConfig, error) {
+	return s.createWebhook(name, HeliusTransactionTypes(txnType), []string{address})
+}
 
+// createWebhook registers a new Helius webhook watching the addresses for
+// the transaction types and records it in the pool.
+func (s *service) createWebhook(name string, txnTypes []string, addresses []string) (*HeliusWebhookConfig, error) {
 	// TODO-Need to check whether the address is a wallet address or token/NFT address
-	publicKey, err := solana.PublicKeyFromBase58(address)
-
-	if err != nil {
-		log.Println("Error parsing public key: ", err)
-		return err
-	}
-
-	body := map[string]interface{}{
-		"webhookURL":       fmt.Sprintf("%s/webhook/%s", publicUrl, name),
-		"webhookType":      "enhanced",
-		"transactionTypes": txnType,
-		"accountAddresses": []solana.PublicKey{publicKey},
-		"txnStatus":        utils.TxnStatusSuccess,
-	}
-
-	// Only add auth header if secret is available
-	if heliusWebhookSecret != "" {
-		body["authHeader"] = fmt.Sprintf("Bearer %s", heliusWebhookSecret)
-	}
-
-	jsonBody, err := json.Marshal(body)
-	if err != nil {
-		log.Printf("Error marshaling webhook request body: %v", err)
-		return err
-	}
-
-	url := fmt.Sprintf("%s/webhooks?api-key=%s", heliusApiUrl, heliusApiKey)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		return err
+	for _, address := range addresses {
+		if _, err := solana.PublicKeyFromBase58(address); err != nil {
+			log.Println("Error parsing public key: ", err)
+			return nil, err
+		}
 	}
 
-	// Set content type header
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// Every webhook gets its own secret, checked by the receiver endpoint
+	authSecret, err := generateSecret("")
 	if err != nil {
-		log.Printf("Error sending webhook creation request: %v", err)
-		return err
+		log.Println("Failed to generate webhook secret: ", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// Read response body
-	respBody, err := io.ReadAll(resp.Body)
+	webhook, err := s.helius.CreateWebhook(context.Background(), helius.WebhookRequest{
+		WebhookURL:       fmt.Sprintf("%s/webhook/%s", publicUrl, name),
+		WebhookType:      "enhanced",
+		TransactionTypes: txnTypes,
+		AccountAddresses: addresses,
+		TxnStatus:        helius.TxnStatusSuccess,
+		AuthHeader:       fmt.Sprintf("Bearer %s", authSecret),
+	})
 	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return err
-	}
-
-	// Check response status
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		log.Printf("Helius API error: %s - %s", resp.Status, string(respBody))
-		return err
-	}
-	jsonResp := &utils.HeliusWebhookResponse{}
-	if err = json.Unmarshal(respBody, &jsonResp); err != nil {
-		log.Println("Error occured while parsing json for create webhook: ", err)
-		return err
+		log.Println("Error occured while creating helius webhook: ", err)
+		return nil, err
 	}
 
 	var (
@@ -96,12 +67,14 @@ func (s *service) CreateWebhook(name string, txnType []IndexingStrategy, address
 	)
 
 	webhookConfig := HeliusWebhookConfig{
-		Id:           webhookUuid,
-		WebhookName:  name,
-		WebhookId:    jsonResp.WebhookId,
-		AddressCount: 1,
-		CreatedAt:    now,
-		UpdatedAt:    now,
+		Id:               webhookUuid,
+		WebhookName:      name,
+		WebhookId:        webhook.WebhookId,
+		AddressCount:     int32(len(addresses)),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		AuthSecret:       &authSecret,
+		TransactionTypes: txnTypes,
 	}
 
 	_, err = s.db.Exec(`
@@ -111,28 +84,51 @@ func (s *service) CreateWebhook(name string, txnType []IndexingStrategy, address
 			webhook_id,
 			address_count,
 			created_at,
-			updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6)
+			updated_at,
+			auth_secret,
+			transaction_types
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	`, webhookConfig.Id,
 		webhookConfig.WebhookName,
 		webhookConfig.WebhookId,
 		webhookConfig.AddressCount,
 		webhookConfig.CreatedAt,
 		webhookConfig.UpdatedAt,
+		webhookConfig.AuthSecret,
+		webhookConfig.TransactionTypes,
 	)
 
 	if err != nil {
 		log.Println("Error occured while inserting into helius webhook config table: ", err)
-		return err
+		return nil, err
 	}
 
-	return nil
+	if err = s.assignAddresses(webhookConfig.WebhookId, addresses); err != nil {
+		log.Println("Failed to record the addresses of the new webhook: ", err)
+		return nil, err
+	}
+
+	return &webhookConfig, nil
 }
 
 func (s *service) GetAllWebhooks() ([]HeliusWebhookConfig, error) {
 	var heliusWebhookCfg []HeliusWebhookConfig
 
-	rows, err := s.db.Query(`SELECT * FROM HeliusWebhookConfig`)
+	rows, err := s.db.Query(`
+		SELECT
+			id,
+			webhook_name,
+			webhook_id,
+			address_count,
+			created_at,
+			updated_at,
+			auth_secret,
+			previous_auth_secret,
+			previous_secret_expires_at,
+			array_to_json(transaction_types)
+		FROM helius_webhook_config
+		ORDER BY created_at
+	`)
 	if err != nil {
 		log.Println("Error occured while fetching webhookrecords", err)
 		return nil, err
@@ -140,23 +136,13 @@ func (s *service) GetAllWebhooks() ([]HeliusWebhookConfig, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var cfg HeliusWebhookConfig
-
-		err := rows.Scan(
-			&cfg.Id,
-			&cfg.WebhookName,
-			&cfg.WebhookId,
-			&cfg.AddressCount,
-			&cfg.CreatedAt,
-			&cfg.UpdatedAt,
-		)
-
+		cfg, err := scanWebhookConfig(rows)
 		if err != nil {
 			log.Println("Error scanning webhook config: ", err)
 			return nil, err
 		}
 
-		heliusWebhookCfg = append(heliusWebhookCfg, cfg)
+		heliusWebhookCfg = append(heliusWebhookCfg, *cfg)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -168,198 +154,196 @@ func (s *service) GetAllWebhooks() ([]HeliusWebhookConfig, error) {
 }
 
 func (s *service) GetWebhookConfigByName(name string) (HeliusWebhookConfig, error) {
-	var cfg HeliusWebhookConfig
-
-	err := s.db.QueryRow(`
-		SELECT *
+	row := s.db.QueryRow(`
+		SELECT
+			id,
+			webhook_name,
+			webhook_id,
+			address_count,
+			created_at,
+			updated_at,
+			auth_secret,
+			previous_auth_secret,
+			previous_secret_expires_at,
+			array_to_json(transaction_types)
 		FROM helius_webhook_config 
 		WHERE webhook_name = $1
-	`, name).Scan(
-		&cfg.Id,
-		&cfg.WebhookName,
-		&cfg.WebhookId,
-		&cfg.AddressCount,
-		&cfg.CreatedAt,
-		&cfg.UpdatedAt,
-	)
+	`, name)
 
+	cfg, err := scanWebhookConfig(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return cfg, fmt.Errorf("webhook config with name %s not found", name)
+			return HeliusWebhookConfig{}, fmt.Errorf("webhook config with name %s not found: %w", name, err)
 		}
 		log.Println("Error querying webhook config by name:", err)
-		return cfg, err
+		return HeliusWebhookConfig{}, err
 	}
 
-	return cfg, nil
+	return *cfg, nil
 }
 
-func (s *service) CreateOrUpdateWebhook(address string, txnType []IndexingStrategy) error {
-	heliusConfig, err := s.GetAllWebhooks()
-
-	if err != nil {
-		log.Println("Error occured while fetching webhooks", err)
-		return err
+// GetCurrentWebhookConfig returns the webhook as Helius has it, or nil when
+// Helius does not know it.
+func (s *service) GetCurrentWebhookConfig(webhookId string) (*helius.Webhook, error) {
+	webhook, err := s.helius.GetWebhook(context.Background(), webhookId)
+	if errors.Is(err, helius.ErrNotFound) {
+		return nil, nil
 	}
-
-	var (
-		name = fmt.Sprintf("webhook-%d", len(heliusConfig))
-	)
-	switch length := len(heliusConfig); length {
-	case 0:
-		if err := s.CreateWebhook(name, txnType, address); err != nil {
-			log.Println("Error occured while creating webhooks", err)
-			return err
-		}
-	default:
-		if err := s.UpdateWebhook(heliusConfig, address, txnType); err != nil {
-			log.Println("Error occured while updating webhook")
-			return err
-		}
+	if err != nil {
+		log.Printf("Failed to fetch webhook %s from helius: %v", webhookId, err)
+		return nil, err
 	}
 
-	return nil
+	return webhook, nil
 }
 
-func (s *service) UpdateWebhook(heliusConfig []HeliusWebhookConfig, address string, txnType []IndexingStrategy) error {
-	var (
-		authHeader = fmt.Sprintf("Bearer %s", os.Getenv("HELIUS_WEBHOOK_SECRET"))
-		txnStatus  = utils.TxnStatusSuccess
-	)
-
-	for _, config := range heliusConfig {
-		if config.AddressCount >= 99999 {
-			continue
-		}
-
-		webhookConfig, err := s.GetCurrentWebhookConfig(config.WebhookId)
-
-		if err != nil {
-			log.Println("Failed to get current webhook config from helius: ", err)
-			return err
-		}
-
-		body := map[string]interface{}{
-			"webhookURL":  fmt.Sprintf("%s/webhook/%s", publicUrl, config.WebhookName),
-			"webhookType": "enhanced",
-			"txnStatus":   txnStatus,
-			"authHeader":  authHeader,
-		}
-
-		uniqueTxnTypes := make(map[string]bool)
-		for _, txnType := range webhookConfig.TransactionTypes {
-			uniqueTxnTypes[txnType] = true
-		}
+// RotateWebhookSecret gives a webhook a new auth secret and pushes it to
+// Helius. The old secret stays valid for overlap, which covers calls Helius
+// already had in flight with it.
+func (s *service) RotateWebhookSecret(name string, overlap time.Duration) (*HeliusWebhookConfig, error) {
+	cfg, err := s.GetWebhookConfigByName(name)
+	if err != nil {
+		return nil, err
+	}
 
-		var notPresentTxnTypes []string
-		for _, newType := range txnType {
-			txnTypeStr := string(newType)
-			if _, exists := uniqueTxnTypes[txnTypeStr]; !exists {
-				notPresentTxnTypes = append(notPresentTxnTypes, txnTypeStr)
-				uniqueTxnTypes[txnTypeStr] = true
-			}
-		}
+	newSecret, err := generateSecret("")
+	if err != nil {
+		log.Println("Failed to generate webhook secret: ", err)
+		return nil, err
+	}
 
-		addressExists := slices.Contains(webhookConfig.AccountAddresses, address)
+	previousSecret := cfg.AuthSecret
+	if previousSecret == nil && heliusWebhookSecret != "" {
+		previousSecret = &heliusWebhookSecret
+	}
+	expiresAt := time.Now().Add(overlap)
 
-		if len(notPresentTxnTypes) == 0 && addressExists {
-			return nil // No changes needed
-		}
+	// The receiver has to accept the new secret before Helius starts sending it
+	_, err = s.db.Exec(`
+		UPDATE helius_webhook_config
+		SET auth_secret = $1,
+			previous_auth_secret = $2,
+			previous_secret_expires_at = $3,
+			updated_at = $4
+		WHERE id = $5
+	`, newSecret, previousSecret, expiresAt, time.Now(), cfg.Id)
+	if err != nil {
+		log.Println("Failed to store rotated webhook secret: ", err)
+		return nil, err
+	}
 
-		if !addressExists {
-			body["accountAddresses"] = append(webhookConfig.AccountAddresses, address)
-		}
+	if err = s.putWebhookAuthHeader(cfg.WebhookId, fmt.Sprintf("Bearer %s", newSecret)); err != nil {
+		log.Printf("Failed to push rotated secret for webhook %s to helius: %v", name, err)
 
-		if len(notPresentTxnTypes) > 0 {
-			body["transactionTypes"] = append(webhookConfig.TransactionTypes, notPresentTxnTypes...)
+		// Helius still sends the old secret, put it back as the current one
+		_, restoreErr := s.db.Exec(`
+			UPDATE helius_webhook_config
+			SET auth_secret = $1,
+				previous_auth_secret = $2,
+				previous_secret_expires_at = $3,
+				updated_at = $4
+			WHERE id = $5
+		`, cfg.AuthSecret, cfg.PreviousAuthSecret, cfg.PreviousSecretExpiresAt, time.Now(), cfg.Id)
+		if restoreErr != nil {
+			log.Println("Failed to restore webhook secret after failed rotation: ", restoreErr)
 		}
+		return nil, err
+	}
 
-		jsonBody, err := json.Marshal(body)
-		if err != nil {
-			log.Printf("Error marshaling webhook request body: %v", err)
-			return err
-		}
+	cfg.AuthSecret = &newSecret
+	cfg.PreviousAuthSecret = previousSecret
+	cfg.PreviousSecretExpiresAt = &expiresAt
+	return &cfg, nil
+}
 
-		url := fmt.Sprintf("%s/webhooks?api-key=%s", heliusApiUrl, heliusApiKey)
-		req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonBody))
-		if err != nil {
-			log.Printf("Error creating request: %v", err)
-			return err
-		}
+// putWebhookAuthHeader re-registers a webhook with Helius unchanged apart
+// from its auth header.
+func (s *service) putWebhookAuthHeader(webhookId string, authHeader string) error {
+	_, err := s.editWebhook(webhookId, func(webhook *helius.Webhook) bool {
+		webhook.AuthHeader = authHeader
+		return true
+	})
+	return err
+}
 
-		// Set content type header
-		req.Header.Set("Content-Type", "application/json")
+// editWebhook fetches a webhook from Helius, lets edit change it and PUTs
+// it back. Helius replaces the whole webhook on PUT, so every field is sent
+// again. Nothing is sent if edit returns false. It returns the webhook as
+// Helius now has it.
+func (s *service) editWebhook(webhookId string, edit func(webhook *helius.Webhook) bool) (*helius.Webhook, error) {
+	current, err := s.GetCurrentWebhookConfig(webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("webhook %s not found on helius", webhookId)
+	}
 
-		// Send the request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("Error sending webhook creation request: %v", err)
-			return err
-		}
-		defer resp.Body.Close()
+	if !edit(current) {
+		return current, nil
+	}
 
-		// Read response body
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("Error reading response body: %v", err)
-			return err
-		}
+	return s.helius.EditWebhook(context.Background(), webhookId, current.Request())
+}
 
-		// Check response status
-		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-			log.Printf("Helius API error: %s - %s", resp.Status, string(respBody))
-			return err
-		}
+// AcceptedSecrets returns the secrets a call to this webhook may carry at
+// the given time: the current one, plus the previous one during the overlap
+// window after a rotation.
+func (c HeliusWebhookConfig) AcceptedSecrets(now time.Time) []string {
+	var secrets []string
 
-		jsonResp := &utils.HeliusWebhookResponse{}
-		if err = json.Unmarshal(respBody, &jsonResp); err != nil {
-			log.Println("Error occured while parsing json for create webhook: ", err)
-			return err
-		}
+	if c.AuthSecret != nil && *c.AuthSecret != "" {
+		secrets = append(secrets, *c.AuthSecret)
+	} else if heliusWebhookSecret != "" {
+		secrets = append(secrets, heliusWebhookSecret)
+	}
 
-		_, err = s.db.Exec(`
-			UPDATE helius_webhook_config
-			SET address_count = $1
-			WHERE helius_webhook_id = $2
-		`, config.AddressCount+1, jsonResp.WebhookId)
-		if err != nil {
-			log.Println("Failed to update the address count")
-		}
-		break
+	if c.PreviousAuthSecret != nil && *c.PreviousAuthSecret != "" &&
+		c.PreviousSecretExpiresAt != nil && now.Before(*c.PreviousSecretExpiresAt) {
+		secrets = append(secrets, *c.PreviousAuthSecret)
 	}
 
-	return nil
+	return secrets
 }
 
-func (s *service) GetCurrentWebhookConfig(webhookId string) (*utils.HeliusWebhookResponse, error) {
-	url := fmt.Sprintf("%s/webhooks/%s?api-key=%s", heliusApiUrl, webhookId, heliusApiKey)
-
-	resp, err := http.Get(url)
+func scanWebhookConfig(row rowScanner) (*HeliusWebhookConfig, error) {
+	var (
+		cfg      HeliusWebhookConfig
+		txnTypes []byte
+	)
 
+	err := row.Scan(
+		&cfg.Id,
+		&cfg.WebhookName,
+		&cfg.WebhookId,
+		&cfg.AddressCount,
+		&cfg.CreatedAt,
+		&cfg.UpdatedAt,
+		&cfg.AuthSecret,
+		&cfg.PreviousAuthSecret,
+		&cfg.PreviousSecretExpiresAt,
+		&txnTypes,
+	)
 	if err != nil {
-		log.Println("Failed to fetch url")
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Println("Request Failed for fetching Webhook Config")
-		return nil, err
+	// NULL for webhooks the pool has not edited yet
+	if txnTypes != nil {
+		if err = json.Unmarshal(txnTypes, &cfg.TransactionTypes); err != nil {
+			return nil, err
+		}
 	}
 
-	respBody, err := io.ReadAll(resp.Body)
-
-	if err != nil {
-		log.Println("Failed to read response body")
-		return nil, err
-	}
-	defer resp.Body.Close()
+	return &cfg, nil
+}
 
-	jsonResp := &utils.HeliusWebhookResponse{}
-	if err = json.Unmarshal(respBody, &jsonResp); err != nil {
-		log.Println("Failed to Unmarshal into WebhookResponse struct: ", err)
-		return nil, err
+// generateSecret returns 32 random bytes, hex encoded, after prefix.
+func generateSecret(prefix string) (string, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
 	}
 
-	return jsonResp, nil
+	return prefix + hex.EncodeToString(secretBytes), nil
 }
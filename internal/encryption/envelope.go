@@ -0,0 +1,234 @@
+// Package encryption implements envelope encryption for secrets stored in
+// the database. Every record gets its own random data key, which encrypts
+// the record's fields with AES-256-GCM. The data key is itself encrypted
+// (wrapped) with a key-encryption key from the Keyring and stored next to
+// the record along with that key's id, so records wrapped with an older key
+// stay readable while a rotation is in progress.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const keySize = 32
+
+// Keyring holds the key-encryption keys by id. New envelopes are always
+// wrapped with the active key.
+type Keyring struct {
+	keys     map[string][]byte
+	activeId string
+}
+
+// LoadKeyring reads the keys from CREDENTIALS_KEYS, or from the file named by
+// CREDENTIALS_KEYS_FILE, in the format accepted by ParseKeyring.
+// CREDENTIALS_ACTIVE_KEY_ID picks the active key, the first one by default.
+func LoadKeyring() (*Keyring, error) {
+	spec := os.Getenv("CREDENTIALS_KEYS")
+	if path := os.Getenv("CREDENTIALS_KEYS_FILE"); spec == "" && path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CREDENTIALS_KEYS_FILE: %w", err)
+		}
+		spec = string(contents)
+	}
+	if strings.TrimSpace(spec) == "" {
+		return nil, fmt.Errorf("CREDENTIALS_KEYS or CREDENTIALS_KEYS_FILE must be set")
+	}
+
+	return ParseKeyring(spec, os.Getenv("CREDENTIALS_ACTIVE_KEY_ID"))
+}
+
+// ParseKeyring parses "<id>:<base64 32 byte key>" entries separated by
+// commas or newlines. An empty activeId makes the first entry active.
+func ParseKeyring(spec string, activeId string) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string][]byte)}
+
+	entries := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid key entry, expected <id>:<base64 key>")
+		}
+		if _, exists := keyring.keys[id]; exists {
+			return nil, fmt.Errorf("key %q listed twice", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("key %q must be %d bytes, base64 encoded", id, keySize)
+		}
+
+		keyring.keys[id] = key
+		if keyring.activeId == "" {
+			keyring.activeId = id
+		}
+	}
+
+	if len(keyring.keys) == 0 {
+		return nil, fmt.Errorf("no keys configured")
+	}
+	if activeId != "" {
+		if _, ok := keyring.keys[activeId]; !ok {
+			return nil, fmt.Errorf("active key %q is not in the keyring", activeId)
+		}
+		keyring.activeId = activeId
+	}
+
+	return keyring, nil
+}
+
+// ActiveKeyId returns the id of the key new envelopes are wrapped with.
+func (k *Keyring) ActiveKeyId() string {
+	return k.activeId
+}
+
+// Envelope is an unwrapped data key for one record. scope binds every
+// ciphertext to the record, so values cannot be swapped between records.
+type Envelope struct {
+	keyId      string
+	wrappedKey string
+	scope      string
+	aead       cipher.AEAD
+}
+
+// NewEnvelope creates a fresh data key for the record identified by scope,
+// wrapped with the active key.
+func (k *Keyring) NewEnvelope(scope string) (*Envelope, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := seal(k.keys[k.activeId], dataKey, wrapScope(scope, k.activeId))
+	if err != nil {
+		return nil, err
+	}
+
+	return newEnvelope(k.activeId, wrappedKey, scope, dataKey)
+}
+
+// OpenEnvelope unwraps a stored data key with the key it was wrapped with.
+func (k *Keyring) OpenEnvelope(keyId string, wrappedKey string, scope string) (*Envelope, error) {
+	kek, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("key %q is not in the keyring", keyId)
+	}
+
+	dataKey, err := open(kek, wrappedKey, wrapScope(scope, keyId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	return newEnvelope(keyId, wrappedKey, scope, dataKey)
+}
+
+// KeyId is the id of the key-encryption key that wrapped the data key.
+func (e *Envelope) KeyId() string {
+	return e.keyId
+}
+
+// WrappedKey is the encrypted data key to store with the record.
+func (e *Envelope) WrappedKey() string {
+	return e.wrappedKey
+}
+
+// Seal encrypts one field of the record.
+func (e *Envelope) Seal(field string, plaintext string) (string, error) {
+	return sealWith(e.aead, []byte(plaintext), fieldScope(e.scope, field))
+}
+
+// Open decrypts one field of the record.
+func (e *Envelope) Open(field string, ciphertext string) (string, error) {
+	plaintext, err := openWith(e.aead, ciphertext, fieldScope(e.scope, field))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", field, err)
+	}
+
+	return string(plaintext), nil
+}
+
+func newEnvelope(keyId string, wrappedKey string, scope string, dataKey []byte) (*Envelope, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		keyId:      keyId,
+		wrappedKey: wrappedKey,
+		scope:      scope,
+		aead:       aead,
+	}, nil
+}
+
+func wrapScope(scope string, keyId string) []byte {
+	return []byte("key|" + keyId + "|" + scope)
+}
+
+func fieldScope(scope string, field string) []byte {
+	return []byte("field|" + field + "|" + scope)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func seal(key []byte, plaintext []byte, additionalData []byte) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	return sealWith(aead, plaintext, additionalData)
+}
+
+func open(key []byte, ciphertext string, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return openWith(aead, ciphertext, additionalData)
+}
+
+// sealWith returns base64(nonce || ciphertext).
+func sealWith(aead cipher.AEAD, plaintext []byte, additionalData []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, additionalData)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openWith(aead cipher.AEAD, ciphertext string, additionalData []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
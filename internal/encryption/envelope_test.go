@@ -0,0 +1,86 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, keySize))
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	keyring, err := ParseKeyring("k1:"+testKey(1), "")
+	if err != nil {
+		t.Fatalf("failed to parse keyring: %v", err)
+	}
+
+	envelope, err := keyring.NewEnvelope("cred-1")
+	if err != nil {
+		t.Fatalf("failed to create envelope: %v", err)
+	}
+	ciphertext, err := envelope.Seal("password", "hunter2")
+	if err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+
+	reopened, err := keyring.OpenEnvelope(envelope.KeyId(), envelope.WrappedKey(), "cred-1")
+	if err != nil {
+		t.Fatalf("failed to open envelope: %v", err)
+	}
+	plaintext, err := reopened.Open("password", ciphertext)
+	if err != nil || plaintext != "hunter2" {
+		t.Fatalf("expected hunter2; got %q (err %v)", plaintext, err)
+	}
+
+	// Ciphertexts are bound to their record and field
+	if _, err := reopened.Open("connection_string", ciphertext); err == nil {
+		t.Error("expected opening under another field to fail")
+	}
+	if _, err := keyring.OpenEnvelope(envelope.KeyId(), envelope.WrappedKey(), "cred-2"); err == nil {
+		t.Error("expected unwrapping for another record to fail")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old, err := ParseKeyring("k1:"+testKey(1), "")
+	if err != nil {
+		t.Fatalf("failed to parse keyring: %v", err)
+	}
+	envelope, err := old.NewEnvelope("cred-1")
+	if err != nil {
+		t.Fatalf("failed to create envelope: %v", err)
+	}
+
+	// The new key is active, the old one is kept to read existing records
+	rotated, err := ParseKeyring("k2:"+testKey(2)+"\nk1:"+testKey(1), "")
+	if err != nil {
+		t.Fatalf("failed to parse keyring: %v", err)
+	}
+	if rotated.ActiveKeyId() != "k2" {
+		t.Errorf("expected k2 to be active; got %s", rotated.ActiveKeyId())
+	}
+	if _, err := rotated.OpenEnvelope("k1", envelope.WrappedKey(), "cred-1"); err != nil {
+		t.Errorf("expected records wrapped with k1 to stay readable: %v", err)
+	}
+}
+
+func TestParseKeyringErrors(t *testing.T) {
+	cases := map[string]struct {
+		spec   string
+		active string
+	}{
+		"empty":          {"", ""},
+		"missing id":     {":" + testKey(1), ""},
+		"short key":      {"k1:" + base64.StdEncoding.EncodeToString([]byte("short")), ""},
+		"duplicate id":   {"k1:" + testKey(1) + ",k1:" + testKey(2), ""},
+		"unknown active": {"k1:" + testKey(1), "k2"},
+	}
+
+	for name, c := range cases {
+		if _, err := ParseKeyring(c.spec, c.active); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
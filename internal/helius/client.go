@@ -0,0 +1,263 @@
+// Package helius is a typed client for the Helius webhook and DAS APIs.
+package helius
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+const (
+	defaultAPIURL      = "https://api.helius.xyz/v0"
+	defaultRPCURL      = "https://mainnet.helius-rpc.com"
+	defaultTimeout     = 10 * time.Second
+	defaultMaxAttempts = 4
+	defaultBackoff     = 500 * time.Millisecond
+	maxBackoff         = 10 * time.Second
+	// Longest Retry-After the client waits for before giving up
+	maxRetryAfter = 30 * time.Second
+)
+
+var (
+	// ErrNotFound is matched by errors for webhooks and assets Helius does
+	// not know.
+	ErrNotFound = errors.New("helius: not found")
+	// ErrRateLimited is matched by errors for calls still rate limited after
+	// every attempt.
+	ErrRateLimited = errors.New("helius: rate limited")
+)
+
+// APIError is a non-2xx response from Helius.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("helius api error: %d %s - %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// Config configures a Client, zero fields take their defaults.
+type Config struct {
+	APIURL      string
+	RPCURL      string
+	APIKey      string
+	Timeout     time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+// ConfigFromEnv reads HELIUS_API_URL, HELIUS_RPC_URL, HELIUS_API_KEY,
+// HELIUS_TIMEOUT, HELIUS_MAX_ATTEMPTS and HELIUS_BACKOFF.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		APIURL: os.Getenv("HELIUS_API_URL"),
+		RPCURL: os.Getenv("HELIUS_RPC_URL"),
+		APIKey: os.Getenv("HELIUS_API_KEY"),
+	}
+	cfg.Timeout, _ = time.ParseDuration(os.Getenv("HELIUS_TIMEOUT"))
+	cfg.MaxAttempts, _ = strconv.Atoi(os.Getenv("HELIUS_MAX_ATTEMPTS"))
+	cfg.Backoff, _ = time.ParseDuration(os.Getenv("HELIUS_BACKOFF"))
+	return cfg
+}
+
+// Client calls Helius, retrying rate limited calls, network errors and 5xx
+// responses with a doubling backoff, or after the Retry-After Helius asks
+// for. Creating a webhook is not idempotent, so it is only retried when it
+// was rate limited.
+type Client struct {
+	apiURL      string
+	rpcURL      string
+	apiKey      string
+	http        *http.Client
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// NewClient returns a client configured from the environment.
+func NewClient() *Client {
+	return NewClientWithConfig(ConfigFromEnv())
+}
+
+func NewClientWithConfig(cfg Config) *Client {
+	if cfg.APIURL == "" {
+		cfg.APIURL = defaultAPIURL
+	}
+	if cfg.RPCURL == "" {
+		cfg.RPCURL = defaultRPCURL
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultBackoff
+	}
+
+	return &Client{
+		apiURL:      strings.TrimRight(cfg.APIURL, "/"),
+		rpcURL:      strings.TrimRight(cfg.RPCURL, "/"),
+		apiKey:      cfg.APIKey,
+		http:        &http.Client{Timeout: cfg.Timeout},
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     cfg.Backoff,
+	}
+}
+
+// do sends a JSON request and decodes the response into out when it is not
+// nil. Only rate limited calls are retried unless idempotent is set.
+func (c *Client) do(ctx context.Context, method string, url string, body any, out any, idempotent bool) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+	}
+
+	backoff := c.backoff
+	for attempt := 1; ; attempt++ {
+		respBody, retryAfter, err := c.doOnce(ctx, method, url, payload)
+		if err == nil {
+			if out == nil || len(respBody) == 0 {
+				return nil
+			}
+			if err = json.Unmarshal(respBody, out); err != nil {
+				return fmt.Errorf("failed to decode response: %w", err)
+			}
+			return nil
+		}
+
+		if attempt >= c.maxAttempts || !retryable(err, idempotent) {
+			return err
+		}
+
+		wait := backoff
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if wait > maxRetryAfter {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method string, url string, payload []byte) ([]byte, time.Duration, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, &networkError{err: err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, 0, &networkError{err: err}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), &APIError{
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(respBody)),
+		}
+	}
+
+	return respBody, 0, nil
+}
+
+// networkError is a call that got no response, it may or may not have
+// reached Helius.
+type networkError struct {
+	err error
+}
+
+func (e *networkError) Error() string {
+	return fmt.Sprintf("failed to call helius: %v", e.err)
+}
+
+func (e *networkError) Unwrap() error {
+	return e.err
+}
+
+func retryable(err error, idempotent bool) bool {
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+	if !idempotent {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+
+	var netErr *networkError
+	return errors.As(err, &netErr)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date, 0 when it is missing or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+func (c *Client) apiEndpoint(path string) string {
+	return fmt.Sprintf("%s%s?api-key=%s", c.apiURL, path, c.apiKey)
+}
+
+func (c *Client) rpcEndpoint() string {
+	return fmt.Sprintf("%s/?api-key=%s", c.rpcURL, c.apiKey)
+}
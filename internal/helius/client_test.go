@@ -0,0 +1,183 @@
+package helius
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestWebhookLifecycle(t *testing.T) {
+	fake := NewFakeServer("test-key")
+	defer fake.Close()
+	client := fake.Client()
+	ctx := context.Background()
+
+	created, err := client.CreateWebhook(ctx, WebhookRequest{
+		WebhookURL:       "https://indexer.example/webhook/webhook-0",
+		TransactionTypes: []string{"NFT_SALE"},
+		AccountAddresses: []string{"address-1"},
+		WebhookType:      "enhanced",
+		TxnStatus:        TxnStatusSuccess,
+		AuthHeader:       "Bearer secret",
+	})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	webhook, err := client.GetWebhook(ctx, created.WebhookId)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if webhook.AuthHeader != "Bearer secret" || !slices.Equal(webhook.AccountAddresses, []string{"address-1"}) {
+		t.Fatalf("unexpected webhook: %+v", webhook)
+	}
+
+	req := webhook.Request()
+	req.AccountAddresses = append(req.AccountAddresses, "address-2")
+	if _, err = client.EditWebhook(ctx, webhook.WebhookId, req); err != nil {
+		t.Fatalf("edit failed: %v", err)
+	}
+
+	webhooks, err := client.ListWebhooks(ctx)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(webhooks) != 1 || !slices.Equal(webhooks[0].AccountAddresses, []string{"address-1", "address-2"}) {
+		t.Fatalf("edit was not applied: %+v", webhooks)
+	}
+	if webhooks[0].AuthHeader != "Bearer secret" {
+		t.Fatalf("edit dropped the auth header: %+v", webhooks[0])
+	}
+
+	if err = client.DeleteWebhook(ctx, webhook.WebhookId); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err = client.GetWebhook(ctx, webhook.WebhookId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+	if err = client.DeleteWebhook(ctx, webhook.WebhookId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	fake := NewFakeServer("test-key")
+	defer fake.Close()
+	client := fake.Client()
+	ctx := context.Background()
+
+	fake.FailNext(2, http.StatusTooManyRequests, "0")
+	if _, err := client.ListWebhooks(ctx); err != nil {
+		t.Fatalf("rate limited call was not retried: %v", err)
+	}
+	if calls := fake.Calls(); calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+
+	fake.FailNext(defaultMaxAttempts, http.StatusServiceUnavailable, "")
+	var apiErr *APIError
+	if _, err := client.ListWebhooks(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the last 503 once attempts ran out, got %v", err)
+	}
+
+	// Creating a webhook twice would leave a duplicate behind
+	fake.FailNext(1, http.StatusBadGateway, "")
+	before := fake.Calls()
+	_, err := client.CreateWebhook(ctx, WebhookRequest{WebhookURL: "https://indexer.example", WebhookType: "enhanced"})
+	if err == nil || fake.Calls() != before+1 {
+		t.Fatalf("create was retried after a 502: %v, %d calls", err, fake.Calls()-before)
+	}
+
+	fake.FailNext(1, http.StatusTooManyRequests, "")
+	if _, err = client.CreateWebhook(ctx, WebhookRequest{WebhookURL: "https://indexer.example", WebhookType: "enhanced"}); err != nil {
+		t.Fatalf("rate limited create was not retried: %v", err)
+	}
+
+	// A Retry-After longer than the client waits is returned straight away
+	fake.FailNext(1, http.StatusTooManyRequests, "3600")
+	before = fake.Calls()
+	if _, err = client.ListWebhooks(ctx); !errors.Is(err, ErrRateLimited) || fake.Calls() != before+1 {
+		t.Fatalf("expected ErrRateLimited without waiting, got %v", err)
+	}
+}
+
+func TestGetAsset(t *testing.T) {
+	fake := NewFakeServer("test-key")
+	defer fake.Close()
+	client := fake.Client()
+	ctx := context.Background()
+
+	fake.AddAsset(Asset{
+		Id:        "mint-1",
+		Interface: "FungibleToken",
+		Content:   AssetContent{Metadata: &AssetMetadata{Name: "Token", Symbol: "TKN"}},
+	})
+
+	asset, err := client.GetAsset(ctx, "mint-1")
+	if err != nil {
+		t.Fatalf("getAsset failed: %v", err)
+	}
+	if asset.Content.Metadata == nil || asset.Content.Metadata.Symbol != "TKN" {
+		t.Fatalf("unexpected asset: %+v", asset)
+	}
+
+	if _, err = client.GetAsset(ctx, "mint-2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	assets, err := client.GetAssetBatch(ctx, []string{"mint-2", "mint-1"})
+	if err != nil {
+		t.Fatalf("getAssetBatch failed: %v", err)
+	}
+	if assets[0] != nil || assets[1] == nil || assets[1].Id != "mint-1" {
+		t.Fatalf("unexpected batch: %+v", assets)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("2"); got.Seconds() != 2 {
+		t.Fatalf("expected 2s, got %v", got)
+	}
+	if got := parseRetryAfter("soon"); got != 0 {
+		t.Fatalf("expected 0 for an invalid value, got %v", got)
+	}
+}
+
+func TestSignaturesForAddress(t *testing.T) {
+	fake := NewFakeServer("test-key")
+	defer fake.Close()
+	client := fake.Client()
+	ctx := context.Background()
+
+	for i, signature := range []string{"a", "b", "c"} {
+		txn := json.RawMessage(`{"slot": ` + strconv.Itoa(i) + `, "transaction": {"signatures": ["` + signature + `"]}}`)
+		fake.AddTransaction(SignatureInfo{Signature: signature, Slot: int64(i)}, txn, "address-1")
+	}
+
+	page, err := client.GetSignaturesForAddress(ctx, "address-1", "", "", 2)
+	if err != nil {
+		t.Fatalf("getSignaturesForAddress failed: %v", err)
+	}
+	if len(page) != 2 || page[0].Signature != "c" || page[1].Signature != "b" {
+		t.Fatalf("expected the newest signatures first, got %+v", page)
+	}
+
+	page, err = client.GetSignaturesForAddress(ctx, "address-1", "b", "", 2)
+	if err != nil {
+		t.Fatalf("getSignaturesForAddress failed: %v", err)
+	}
+	if len(page) != 1 || page[0].Signature != "a" {
+		t.Fatalf("expected the page below b, got %+v", page)
+	}
+
+	if _, err = client.GetTransaction(ctx, "a"); err != nil {
+		t.Fatalf("getTransaction failed: %v", err)
+	}
+	if _, err = client.GetTransaction(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package helius
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Asset is what the DAS API returns for a token or NFT, trimmed to the
+// fields the indexer reads.
+type Asset struct {
+	Interface string       `json:"interface"`
+	Id        string       `json:"id"`
+	Content   AssetContent `json:"content"`
+	TokenInfo *AssetToken  `json:"token_info,omitempty"`
+	Ownership *AssetOwner  `json:"ownership,omitempty"`
+}
+
+type AssetContent struct {
+	Schema   string         `json:"$schema"`
+	JsonUri  string         `json:"json_uri"`
+	Files    []any          `json:"files"`
+	Metadata *AssetMetadata `json:"metadata"`
+}
+
+type AssetMetadata struct {
+	Attributes    []any  `json:"attributes"`
+	Description   string `json:"description"`
+	Name          string `json:"name"`
+	Symbol        string `json:"symbol"`
+	TokenStandard string `json:"token_standard"`
+}
+
+type AssetToken struct {
+	Symbol       string `json:"symbol"`
+	Supply       uint64 `json:"supply"`
+	Decimals     int    `json:"decimals"`
+	TokenProgram string `json:"token_program"`
+}
+
+type AssetOwner struct {
+	Owner string `json:"owner"`
+}
+
+// RPCError is a JSON-RPC error returned with a 200 response.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("helius rpc error %d: %s", e.Code, e.Message)
+}
+
+// Is matches ErrNotFound for the error DAS returns for unknown assets.
+func (e *RPCError) Is(target error) bool {
+	return target == ErrNotFound && e.Code == -32000 && strings.Contains(strings.ToLower(e.Message), "not found")
+}
+
+type rpcRequest struct {
+	JsonRpc string `json:"jsonrpc"`
+	Id      string `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// call runs a JSON-RPC method, every method the client uses is a read and
+// safe to retry.
+func (c *Client) call(ctx context.Context, method string, params any, out any) error {
+	var resp rpcResponse
+	req := rpcRequest{JsonRpc: "2.0", Id: "solana-indexer", Method: method, Params: params}
+	if err := c.do(ctx, http.MethodPost, c.rpcEndpoint(), req, &resp, true); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return fmt.Errorf("%s returned no result: %w", method, ErrNotFound)
+	}
+
+	return json.Unmarshal(resp.Result, out)
+}
+
+// GetAsset returns an error matching ErrNotFound when Helius has no asset
+// with the id.
+func (c *Client) GetAsset(ctx context.Context, id string) (*Asset, error) {
+	var asset Asset
+	if err := c.call(ctx, "getAsset", map[string]any{"id": id}, &asset); err != nil {
+		return nil, err
+	}
+	return &asset, nil
+}
+
+// GetAssetBatch returns the assets in the order of ids, nil for the ones
+// Helius does not know.
+func (c *Client) GetAssetBatch(ctx context.Context, ids []string) ([]*Asset, error) {
+	var assets []*Asset
+	if err := c.call(ctx, "getAssetBatch", map[string]any{"ids": ids}, &assets); err != nil {
+		return nil, err
+	}
+	if len(assets) != len(ids) {
+		return nil, fmt.Errorf("getAssetBatch returned %d assets for %d ids", len(assets), len(ids))
+	}
+	return assets, nil
+}
@@ -0,0 +1,363 @@
+package helius
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FakeServer is an in-memory Helius for tests. It serves the webhook CRUD
+// endpoints, the getAsset and getAssetBatch DAS methods and the
+// getSignaturesForAddress and getTransaction RPC methods, and can be told to
+// fail the next calls to exercise retries.
+type FakeServer struct {
+	server *httptest.Server
+	apiKey string
+
+	mu       sync.Mutex
+	webhooks map[string]Webhook
+	order    []string
+	assets   map[string]Asset
+	// Signatures per address, newest first, and transactions by signature
+	signatures   map[string][]SignatureInfo
+	transactions map[string]json.RawMessage
+	enhanced     map[string]json.RawMessage
+	nextId       int
+	failures     []fakeFailure
+	calls        int
+}
+
+type fakeFailure struct {
+	status     int
+	retryAfter string
+}
+
+// NewFakeServer starts a fake accepting apiKey. Close it when done.
+func NewFakeServer(apiKey string) *FakeServer {
+	f := &FakeServer{
+		apiKey:   apiKey,
+		webhooks: make(map[string]Webhook),
+		assets:   make(map[string]Asset),
+
+		signatures:   make(map[string][]SignatureInfo),
+		transactions: make(map[string]json.RawMessage),
+		enhanced:     make(map[string]json.RawMessage),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v0/webhooks", f.listWebhooks)
+	mux.HandleFunc("POST /v0/webhooks", f.createWebhook)
+	mux.HandleFunc("GET /v0/webhooks/{id}", f.getWebhook)
+	mux.HandleFunc("PUT /v0/webhooks/{id}", f.editWebhook)
+	mux.HandleFunc("DELETE /v0/webhooks/{id}", f.deleteWebhook)
+	mux.HandleFunc("POST /v0/transactions", f.enhancedTransactions)
+	mux.HandleFunc("POST /rpc/", f.rpc)
+
+	f.server = httptest.NewServer(f.intercept(mux))
+	return f
+}
+
+func (f *FakeServer) Close() {
+	f.server.Close()
+}
+
+// Config returns a client config pointing at the fake, with a backoff short
+// enough for tests.
+func (f *FakeServer) Config() Config {
+	return Config{
+		APIURL:  f.server.URL + "/v0",
+		RPCURL:  f.server.URL + "/rpc",
+		APIKey:  f.apiKey,
+		Timeout: 5 * time.Second,
+		Backoff: time.Millisecond,
+	}
+}
+
+// Client returns a client for the fake.
+func (f *FakeServer) Client() *Client {
+	return NewClientWithConfig(f.Config())
+}
+
+// AddAsset makes the asset available to getAsset and getAssetBatch.
+func (f *FakeServer) AddAsset(asset Asset) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.assets[asset.Id] = asset
+}
+
+// AddTransaction makes txn available to getTransaction and lists it in the
+// getSignaturesForAddress results of every address. Transactions are added
+// oldest first.
+func (f *FakeServer) AddTransaction(info SignatureInfo, txn json.RawMessage, addresses ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.transactions[info.Signature] = txn
+	for _, address := range addresses {
+		f.signatures[address] = append([]SignatureInfo{info}, f.signatures[address]...)
+	}
+}
+
+// AddEnhancedTransaction makes the parsed form of a transaction available to
+// the enhanced transactions endpoint.
+func (f *FakeServer) AddEnhancedTransaction(signature string, txn json.RawMessage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.enhanced[signature] = txn
+}
+
+// FailNext answers the next n calls with status, sending retryAfter as the
+// Retry-After header when it is not empty.
+func (f *FakeServer) FailNext(n int, status int, retryAfter string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for range n {
+		f.failures = append(f.failures, fakeFailure{status: status, retryAfter: retryAfter})
+	}
+}
+
+// Calls returns how many calls the fake received, failed ones included.
+func (f *FakeServer) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// Webhooks returns the webhooks in the order they were created.
+func (f *FakeServer) Webhooks() []Webhook {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	webhooks := make([]Webhook, 0, len(f.order))
+	for _, id := range f.order {
+		webhooks = append(webhooks, f.webhooks[id])
+	}
+	return webhooks
+}
+
+func (f *FakeServer) intercept(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.calls++
+		var failure *fakeFailure
+		if len(f.failures) > 0 {
+			failure = &f.failures[0]
+			f.failures = f.failures[1:]
+		}
+		f.mu.Unlock()
+
+		if failure != nil {
+			if failure.retryAfter != "" {
+				w.Header().Set("Retry-After", failure.retryAfter)
+			}
+			http.Error(w, http.StatusText(failure.status), failure.status)
+			return
+		}
+
+		if r.URL.Query().Get("api-key") != f.apiKey {
+			http.Error(w, "invalid api key", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *FakeServer) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	writeFakeJSON(w, f.Webhooks())
+}
+
+func (f *FakeServer) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.WebhookURL == "" || req.WebhookType == "" {
+		http.Error(w, "invalid webhook", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.nextId++
+	webhook := fakeWebhook(fmt.Sprintf("fake-webhook-%d", f.nextId), req)
+	f.webhooks[webhook.WebhookId] = webhook
+	f.order = append(f.order, webhook.WebhookId)
+	f.mu.Unlock()
+
+	writeFakeJSON(w, webhook)
+}
+
+func (f *FakeServer) getWebhook(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	webhook, ok := f.webhooks[r.PathValue("id")]
+	f.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	writeFakeJSON(w, webhook)
+}
+
+func (f *FakeServer) editWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.WebhookURL == "" {
+		http.Error(w, "invalid webhook", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	id := r.PathValue("id")
+	_, ok := f.webhooks[id]
+	webhook := fakeWebhook(id, req)
+	if ok {
+		f.webhooks[id] = webhook
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	writeFakeJSON(w, webhook)
+}
+
+func (f *FakeServer) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	id := r.PathValue("id")
+	_, ok := f.webhooks[id]
+	delete(f.webhooks, id)
+	f.order = slices.DeleteFunc(f.order, func(existing string) bool { return existing == id })
+	f.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *FakeServer) enhancedTransactions(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Transactions []string `json:"transactions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Transactions) > MaxEnhancedTransactions {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	txns := []json.RawMessage{}
+	for _, signature := range req.Transactions {
+		if txn, ok := f.enhanced[signature]; ok {
+			txns = append(txns, txn)
+		}
+	}
+	f.mu.Unlock()
+
+	writeFakeJSON(w, txns)
+}
+
+func (f *FakeServer) rpc(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Id     string          `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	response := map[string]any{"jsonrpc": "2.0", "id": req.Id}
+	switch req.Method {
+	case "getAsset":
+		var params struct {
+			Id string `json:"id"`
+		}
+		json.Unmarshal(req.Params, &params)
+		if asset, ok := f.assets[params.Id]; ok {
+			response["result"] = asset
+		} else {
+			response["error"] = RPCError{Code: -32000, Message: "Asset Not Found"}
+		}
+	case "getAssetBatch":
+		var params struct {
+			Ids []string `json:"ids"`
+		}
+		json.Unmarshal(req.Params, &params)
+		assets := make([]*Asset, len(params.Ids))
+		for i, id := range params.Ids {
+			if asset, ok := f.assets[id]; ok {
+				assets[i] = &asset
+			}
+		}
+		response["result"] = assets
+	case "getSignaturesForAddress":
+		var params []json.RawMessage
+		var address string
+		var options struct {
+			Before string `json:"before"`
+			Until  string `json:"until"`
+			Limit  int    `json:"limit"`
+		}
+		json.Unmarshal(req.Params, &params)
+		if len(params) == 2 {
+			json.Unmarshal(params[0], &address)
+			json.Unmarshal(params[1], &options)
+		}
+		signatures := f.signatures[address]
+		if options.Before != "" {
+			index := slices.IndexFunc(signatures, func(info SignatureInfo) bool { return info.Signature == options.Before })
+			signatures = signatures[index+1:]
+		}
+		if options.Until != "" {
+			if index := slices.IndexFunc(signatures, func(info SignatureInfo) bool { return info.Signature == options.Until }); index >= 0 {
+				signatures = signatures[:index]
+			}
+		}
+		if options.Limit > 0 && len(signatures) > options.Limit {
+			signatures = signatures[:options.Limit]
+		}
+		response["result"] = append([]SignatureInfo{}, signatures...)
+	case "getTransaction":
+		var params []json.RawMessage
+		var signature string
+		json.Unmarshal(req.Params, &params)
+		if len(params) > 0 {
+			json.Unmarshal(params[0], &signature)
+		}
+		if txn, ok := f.transactions[signature]; ok {
+			response["result"] = txn
+		} else {
+			response["result"] = nil
+		}
+	default:
+		response["error"] = RPCError{Code: -32601, Message: "Method not found: " + strconv.Quote(req.Method)}
+	}
+
+	writeFakeJSON(w, response)
+}
+
+func fakeWebhook(id string, req WebhookRequest) Webhook {
+	return Webhook{
+		WebhookId:        id,
+		Wallet:           "fake-wallet",
+		WebhookURL:       req.WebhookURL,
+		TransactionTypes: req.TransactionTypes,
+		AccountAddresses: req.AccountAddresses,
+		WebhookType:      req.WebhookType,
+		TxnStatus:        req.TxnStatus,
+		AuthHeader:       req.AuthHeader,
+	}
+}
+
+func writeFakeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
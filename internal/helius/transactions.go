@@ -0,0 +1,72 @@
+package helius
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// MaxEnhancedTransactions is the most signatures GetEnhancedTransactions
+// takes per call.
+const MaxEnhancedTransactions = 100
+
+// SignatureInfo is an entry of getSignaturesForAddress.
+type SignatureInfo struct {
+	Signature string `json:"signature"`
+	Slot      int64  `json:"slot"`
+	BlockTime *int64 `json:"blockTime"`
+	Err       any    `json:"err"`
+}
+
+// GetSignaturesForAddress returns up to limit signatures involving address,
+// newest first. A non empty before starts the page below that signature, and
+// a non empty until ends the results above that one.
+func (c *Client) GetSignaturesForAddress(ctx context.Context, address string, before string, until string, limit int) ([]SignatureInfo, error) {
+	options := map[string]any{"limit": limit, "commitment": "finalized"}
+	if before != "" {
+		options["before"] = before
+	}
+	if until != "" {
+		options["until"] = until
+	}
+
+	var signatures []SignatureInfo
+	if err := c.call(ctx, "getSignaturesForAddress", []any{address, options}, &signatures); err != nil {
+		return nil, err
+	}
+	return signatures, nil
+}
+
+// GetTransaction returns the transaction with the jsonParsed encoding, as
+// the node sent it. It returns an error matching ErrNotFound when the node
+// does not have it.
+func (c *Client) GetTransaction(ctx context.Context, signature string) (json.RawMessage, error) {
+	options := map[string]any{
+		"encoding":                       "jsonParsed",
+		"maxSupportedTransactionVersion": 0,
+		"commitment":                     "finalized",
+	}
+
+	var txn json.RawMessage
+	if err := c.call(ctx, "getTransaction", []any{signature, options}, &txn); err != nil {
+		return nil, err
+	}
+	return txn, nil
+}
+
+// GetEnhancedTransactions returns the transactions as a JSON array, parsed
+// the way enhanced webhooks send them. Signatures Helius does not know are
+// left out.
+func (c *Client) GetEnhancedTransactions(ctx context.Context, signatures []string) (json.RawMessage, error) {
+	if len(signatures) > MaxEnhancedTransactions {
+		return nil, fmt.Errorf("helius: at most %d transactions per call, got %d", MaxEnhancedTransactions, len(signatures))
+	}
+
+	var txns json.RawMessage
+	req := map[string]any{"transactions": signatures}
+	if err := c.do(ctx, http.MethodPost, c.apiEndpoint("/transactions"), req, &txns, true); err != nil {
+		return nil, err
+	}
+	return txns, nil
+}
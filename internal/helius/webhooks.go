@@ -0,0 +1,92 @@
+package helius
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+type TxnStatus string
+
+const (
+	TxnStatusAll     TxnStatus = "all"
+	TxnStatusSuccess TxnStatus = "success"
+	TxnStatusFailed  TxnStatus = "failed"
+)
+
+// Webhook is a webhook as Helius returns it.
+type Webhook struct {
+	WebhookId        string    `json:"webhookID"`
+	Wallet           string    `json:"wallet"`
+	WebhookURL       string    `json:"webhookURL"`
+	TransactionTypes []string  `json:"transactionTypes"`
+	AccountAddresses []string  `json:"accountAddresses"`
+	WebhookType      string    `json:"webhookType"`
+	TxnStatus        TxnStatus `json:"txnStatus"`
+	AuthHeader       string    `json:"authHeader"`
+}
+
+// WebhookRequest creates a webhook, or replaces every field of one.
+type WebhookRequest struct {
+	WebhookURL       string    `json:"webhookURL"`
+	TransactionTypes []string  `json:"transactionTypes"`
+	AccountAddresses []string  `json:"accountAddresses"`
+	WebhookType      string    `json:"webhookType"`
+	TxnStatus        TxnStatus `json:"txnStatus,omitempty"`
+	AuthHeader       string    `json:"authHeader,omitempty"`
+}
+
+// Request returns the request that PUTs the webhook back unchanged.
+func (w Webhook) Request() WebhookRequest {
+	return WebhookRequest{
+		WebhookURL:       w.WebhookURL,
+		TransactionTypes: w.TransactionTypes,
+		AccountAddresses: w.AccountAddresses,
+		WebhookType:      w.WebhookType,
+		TxnStatus:        w.TxnStatus,
+		AuthHeader:       w.AuthHeader,
+	}
+}
+
+func (c *Client) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := c.do(ctx, http.MethodGet, c.apiEndpoint("/webhooks"), nil, &webhooks, true); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// GetWebhook returns an error matching ErrNotFound for unknown webhooks.
+func (c *Client) GetWebhook(ctx context.Context, webhookId string) (*Webhook, error) {
+	var webhook Webhook
+	if err := c.do(ctx, http.MethodGet, c.apiEndpoint("/webhooks/"+url.PathEscape(webhookId)), nil, &webhook, true); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (c *Client) CreateWebhook(ctx context.Context, req WebhookRequest) (*Webhook, error) {
+	var webhook Webhook
+	if err := c.do(ctx, http.MethodPost, c.apiEndpoint("/webhooks"), req, &webhook, false); err != nil {
+		return nil, err
+	}
+	if webhook.WebhookId == "" {
+		return nil, errors.New("helius: created webhook has no id")
+	}
+	return &webhook, nil
+}
+
+// EditWebhook replaces every field of a webhook with req.
+func (c *Client) EditWebhook(ctx context.Context, webhookId string, req WebhookRequest) (*Webhook, error) {
+	var webhook Webhook
+	if err := c.do(ctx, http.MethodPut, c.apiEndpoint("/webhooks/"+url.PathEscape(webhookId)), req, &webhook, true); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// DeleteWebhook returns an error matching ErrNotFound for unknown webhooks.
+func (c *Client) DeleteWebhook(ctx context.Context, webhookId string) error {
+	return c.do(ctx, http.MethodDelete, c.apiEndpoint("/webhooks/"+url.PathEscape(webhookId)), nil, nil, true)
+}
@@ -0,0 +1,119 @@
+package ingest
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+)
+
+// GeyserAccountUpdate is an account write as a Geyser plugin streams it,
+// forwarded as JSON by a relay.
+type GeyserAccountUpdate struct {
+	Slot         int64  `json:"slot"`
+	Pubkey       string `json:"pubkey"`
+	Owner        string `json:"owner"`
+	Lamports     uint64 `json:"lamports"`
+	Data         string `json:"data"` // base64
+	WriteVersion uint64 `json:"writeVersion"`
+	TxnSignature string `json:"txnSignature"`
+}
+
+// GeyserAccountUpdateType is the transaction type account updates are
+// indexed under. Helius has no such type, so only strategies listing it
+// receive them.
+const GeyserAccountUpdateType = "ACCOUNT_UPDATE"
+
+// Geyser receives account updates. Each one becomes a payload for the
+// account, and token accounts also name their mint and owner so
+// subscriptions to either match. Calls are signed like SolanaRPC calls.
+type Geyser struct {
+	secret string
+}
+
+func NewGeyser(secret string) *Geyser {
+	return &Geyser{secret: secret}
+}
+
+func (p *Geyser) Name() string {
+	return "geyser"
+}
+
+func (p *Geyser) Verify(r *http.Request, receiverName string, body []byte) error {
+	return verifySignature(r, p.secret, body, time.Now())
+}
+
+// Normalize accepts one update or an array of them. Updates without a
+// transaction, such as the snapshot sent at startup, are skipped since
+// payloads are deduplicated by signature.
+func (p *Geyser) Normalize(body []byte) ([]kafka.WebhookPayload, error) {
+	items, err := splitArray(body)
+	if err != nil {
+		return nil, err
+	}
+
+	payloads := make([]kafka.WebhookPayload, 0, len(items))
+	for _, item := range items {
+		var update GeyserAccountUpdate
+		if err := json.Unmarshal(item, &update); err != nil {
+			log.Println("Error occured while parsing account update, Invalid Json, err: ", err)
+			return nil, err
+		}
+		if update.Pubkey == "" {
+			return nil, errors.New("account update has no pubkey")
+		}
+		if update.TxnSignature == "" {
+			continue
+		}
+
+		payloads = append(payloads, update.payload())
+	}
+
+	return payloads, nil
+}
+
+func (u GeyserAccountUpdate) payload() kafka.WebhookPayload {
+	account := kafka.AccountData{Account: u.Pubkey}
+	if balance, ok := u.tokenBalance(); ok {
+		account.TokenBalanceChanges = []kafka.TokenBalanceChange{balance}
+	}
+
+	return kafka.WebhookPayload{
+		AccountData: []kafka.AccountData{account},
+		Events:      map[string]interface{}{},
+		Signature:   u.TxnSignature,
+		Slot:        u.Slot,
+		Source:      "UNKNOWN",
+		Type:        GeyserAccountUpdateType,
+	}
+}
+
+// tokenBalance decodes an SPL token account. Geyser sends the new state and
+// not a change, so the amount is the balance after the write and decimals,
+// which live on the mint, are left at 0.
+func (u GeyserAccountUpdate) tokenBalance() (kafka.TokenBalanceChange, bool) {
+	if u.Owner != solana.TokenProgramID.String() && u.Owner != solana.Token2022ProgramID.String() {
+		return kafka.TokenBalanceChange{}, false
+	}
+
+	data, err := base64.StdEncoding.DecodeString(u.Data)
+	if err != nil || len(data) < 72 {
+		return kafka.TokenBalanceChange{}, false
+	}
+
+	return kafka.TokenBalanceChange{
+		Mint:         solana.PublicKeyFromBytes(data[0:32]).String(),
+		TokenAccount: u.Pubkey,
+		UserAccount:  solana.PublicKeyFromBytes(data[32:64]).String(),
+		RawTokenAmount: kafka.RawTokenAmnt{
+			TokenAmount: strconv.FormatUint(binary.LittleEndian.Uint64(data[64:72]), 10),
+		},
+	}, true
+}
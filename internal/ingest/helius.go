@@ -0,0 +1,90 @@
+package ingest
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+)
+
+// Helius receives enhanced transaction webhooks. Their shape is the internal
+// payload model, so normalising only decodes them.
+type Helius struct {
+	db database.Service
+}
+
+func NewHelius(db database.Service) *Helius {
+	return &Helius{db: db}
+}
+
+func (h *Helius) Name() string {
+	return "helius"
+}
+
+// Verify checks the Authorization header Helius sends against the secrets
+// of the named webhook.
+func (h *Helius) Verify(r *http.Request, receiverName string, body []byte) error {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return &Rejection{Reason: "missing_header"}
+	}
+
+	cfg, err := h.db.GetWebhookConfigByName(receiverName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &Rejection{Reason: "unknown_receiver"}
+		}
+		log.Printf("Failed to load webhook config for %s: %v", receiverName, err)
+		return err
+	}
+
+	if !validWebhookAuth(authHeader, cfg.AcceptedSecrets(time.Now())) {
+		log.Printf("Rejected webhook call for %s with an invalid secret", receiverName)
+		return &Rejection{Reason: "invalid_secret"}
+	}
+
+	return nil
+}
+
+func (h *Helius) Normalize(body []byte) ([]kafka.WebhookPayload, error) {
+	return NormalizeHelius(body)
+}
+
+// NormalizeHelius decodes an array of enhanced transactions, as webhooks and
+// the enhanced transactions API return them.
+func NormalizeHelius(body []byte) ([]kafka.WebhookPayload, error) {
+	var payloads []kafka.WebhookPayload
+	if err := json.Unmarshal(body, &payloads); err != nil {
+		log.Println("Error occured while parsing body, Invalid Json, err: ", err)
+		return nil, err
+	}
+
+	return payloads, nil
+}
+
+// validWebhookAuth reports whether authHeader is "Bearer <secret>" for any
+// of the accepted secrets. Every secret is compared so the time taken does
+// not reveal which one matched.
+func validWebhookAuth(authHeader string, secrets []string) bool {
+	token, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+
+	matched := 0
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		matched |= subtle.ConstantTimeCompare([]byte(token), []byte(secret))
+	}
+
+	return matched == 1
+}
@@ -0,0 +1,42 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+func TestValidWebhookAuth(t *testing.T) {
+	current, previous := "current", "previous"
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+
+	cfg := database.HeliusWebhookConfig{
+		AuthSecret:              &current,
+		PreviousAuthSecret:      &previous,
+		PreviousSecretExpiresAt: &expiresAt,
+	}
+
+	cases := []struct {
+		name   string
+		header string
+		at     time.Time
+		want   bool
+	}{
+		{"current secret", "Bearer current", now, true},
+		{"previous secret in overlap", "Bearer previous", now, true},
+		{"previous secret after overlap", "Bearer previous", now.Add(2 * time.Hour), false},
+		{"wrong secret", "Bearer wrong", now, false},
+		{"missing bearer prefix", "current", now, false},
+		{"empty token", "Bearer ", now, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := validWebhookAuth(c.header, cfg.AcceptedSecrets(c.at)); got != c.want {
+				t.Errorf("validWebhookAuth(%q) = %v; want %v", c.header, got, c.want)
+			}
+		})
+	}
+}
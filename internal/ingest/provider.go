@@ -0,0 +1,66 @@
+package ingest
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/kafka"
+)
+
+// Provider turns the calls of one ingestion vendor into WebhookPayloads, so
+// the worker does not depend on the shape any vendor sends.
+type Provider interface {
+	// Name is the provider's route segment, e.g. "rpc" for /ingest/rpc.
+	Name() string
+
+	// Verify authenticates a call made to receiverName. It returns a
+	// *Rejection when the caller could not be authenticated, and any other
+	// error when verification itself failed.
+	Verify(r *http.Request, receiverName string, body []byte) error
+
+	// Normalize parses a verified body into the internal payload model.
+	Normalize(body []byte) ([]kafka.WebhookPayload, error)
+}
+
+// Rejection is returned by Verify for unauthenticated calls. Reason is the
+// label they are counted under in indexer_webhook_rejected_total.
+type Rejection struct {
+	Reason string
+}
+
+func (e *Rejection) Error() string {
+	return "ingest call rejected: " + e.Reason
+}
+
+// signatureMaxAge bounds how old the timestamp of a signed call may be, so a
+// captured call cannot be replayed later.
+const signatureMaxAge = 5 * time.Minute
+
+// verifySignature checks a call signed like the http sink signs deliveries:
+// X-Indexer-Signature is "sha256=" followed by the HMAC of
+// "<X-Indexer-Timestamp>.<body>" under secret.
+func verifySignature(r *http.Request, secret string, body []byte, now time.Time) error {
+	timestamp := r.Header.Get(kafka.HeaderDeliveryTimestamp)
+	signature, ok := strings.CutPrefix(r.Header.Get(kafka.HeaderDeliverySignature), "sha256=")
+	if timestamp == "" || !ok {
+		return &Rejection{Reason: "missing_header"}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return &Rejection{Reason: "invalid_timestamp"}
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > signatureMaxAge || age < -signatureMaxAge {
+		return &Rejection{Reason: "expired_timestamp"}
+	}
+
+	expected := kafka.SignDelivery(secret, timestamp, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return &Rejection{Reason: "invalid_signature"}
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+)
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`[]`)
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	cases := []struct {
+		name      string
+		timestamp string
+		signature string
+		reason    string
+	}{
+		{"valid", timestamp, "sha256=" + kafka.SignDelivery("secret", timestamp, body), ""},
+		{"wrong secret", timestamp, "sha256=" + kafka.SignDelivery("other", timestamp, body), "invalid_signature"},
+		{"missing signature", timestamp, "", "missing_header"},
+		{"expired", strconv.FormatInt(now.Add(-time.Hour).Unix(), 10), "sha256=" + kafka.SignDelivery("secret", strconv.FormatInt(now.Add(-time.Hour).Unix(), 10), body), "expired_timestamp"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/ingest/rpc", bytes.NewReader(body))
+			r.Header.Set(kafka.HeaderDeliveryTimestamp, c.timestamp)
+			r.Header.Set(kafka.HeaderDeliverySignature, c.signature)
+
+			err := verifySignature(r, "secret", body, now)
+			var rejection *Rejection
+			switch {
+			case c.reason == "" && err != nil:
+				t.Fatalf("expected the call to be accepted, got %v", err)
+			case c.reason != "" && (!errors.As(err, &rejection) || rejection.Reason != c.reason):
+				t.Fatalf("expected rejection %q, got %v", c.reason, err)
+			}
+		})
+	}
+}
+
+const rpcTokenTransfer = `{
+	"jsonrpc": "2.0",
+	"id": 1,
+	"result": {
+		"slot": 250000000,
+		"blockTime": 1700000000,
+		"meta": {
+			"err": null,
+			"fee": 5000,
+			"preBalances": [1000000, 2039280, 2039280],
+			"postBalances": [995000, 2039280, 2039280],
+			"preTokenBalances": [
+				{"accountIndex": 1, "mint": "mint-1", "owner": "owner-a", "uiTokenAmount": {"amount": "500", "decimals": 2}},
+				{"accountIndex": 2, "mint": "mint-1", "owner": "owner-b", "uiTokenAmount": {"amount": "0", "decimals": 2}}
+			],
+			"postTokenBalances": [
+				{"accountIndex": 1, "mint": "mint-1", "owner": "owner-a", "uiTokenAmount": {"amount": "250", "decimals": 2}},
+				{"accountIndex": 2, "mint": "mint-1", "owner": "owner-b", "uiTokenAmount": {"amount": "250", "decimals": 2}}
+			],
+			"innerInstructions": []
+		},
+		"transaction": {
+			"signatures": ["signature-1"],
+			"message": {
+				"accountKeys": [
+					{"pubkey": "owner-a", "signer": true, "writable": true},
+					{"pubkey": "token-account-a", "signer": false, "writable": true},
+					{"pubkey": "token-account-b", "signer": false, "writable": true}
+				],
+				"instructions": [{
+					"program": "spl-token",
+					"programId": "TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA",
+					"parsed": {"type": "transfer", "info": {"source": "token-account-a", "destination": "token-account-b", "authority": "owner-a", "amount": "250"}}
+				}]
+			}
+		}
+	}
+}`
+
+func TestSolanaRPCNormalize(t *testing.T) {
+	payloads, err := NewSolanaRPC("secret").Normalize([]byte(`[` + rpcTokenTransfer + `, {"jsonrpc": "2.0", "id": 2, "result": null}]`))
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	if len(payloads) != 1 {
+		t.Fatalf("expected the missing transaction to be skipped, got %d payloads", len(payloads))
+	}
+
+	payload := payloads[0]
+	if payload.Signature != "signature-1" || payload.Type != "TRANSFER" || payload.FeePayer != "owner-a" || payload.Timestamp != 1700000000 {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+
+	if len(payload.TokenTransfers) != 1 {
+		t.Fatalf("expected one token transfer, got %+v", payload.TokenTransfers)
+	}
+	transfer := payload.TokenTransfers[0]
+	if transfer.Mint != "mint-1" || transfer.FromUserAccount != "owner-a" || transfer.ToUserAccount != "owner-b" || transfer.TokenAmount != 2.5 {
+		t.Fatalf("unexpected token transfer: %+v", transfer)
+	}
+
+	if change := payload.AccountData[0].NativeBalanceChange.Int64(); change != -5000 {
+		t.Fatalf("expected the fee payer to lose 5000 lamports, got %d", change)
+	}
+	changes := payload.AccountData[1].TokenBalanceChanges
+	if len(changes) != 1 || changes[0].RawTokenAmount.TokenAmount != "-250" || changes[0].Mint != "mint-1" {
+		t.Fatalf("unexpected token balance changes: %+v", changes)
+	}
+}
+
+func TestGeyserNormalize(t *testing.T) {
+	mint := solana.PublicKeyFromBytes(bytes.Repeat([]byte{1}, 32))
+	owner := solana.PublicKeyFromBytes(bytes.Repeat([]byte{2}, 32))
+
+	data := make([]byte, 165)
+	copy(data[0:32], mint[:])
+	copy(data[32:64], owner[:])
+	binary.LittleEndian.PutUint64(data[64:72], 42)
+
+	body := `[
+		{"slot": 1, "pubkey": "token-account", "owner": "` + solana.TokenProgramID.String() + `", "data": "` + base64.StdEncoding.EncodeToString(data) + `", "txnSignature": "signature-1"},
+		{"slot": 1, "pubkey": "startup-account", "owner": "` + solana.SystemProgramID.String() + `", "data": ""}
+	]`
+
+	payloads, err := NewGeyser("secret").Normalize([]byte(body))
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	if len(payloads) != 1 {
+		t.Fatalf("expected the update without a transaction to be skipped, got %d payloads", len(payloads))
+	}
+
+	payload := payloads[0]
+	if payload.Type != GeyserAccountUpdateType || payload.Signature != "signature-1" {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+	changes := payload.AccountData[0].TokenBalanceChanges
+	if len(changes) != 1 || changes[0].Mint != mint.String() || changes[0].UserAccount != owner.String() || changes[0].RawTokenAmount.TokenAmount != "42" {
+		t.Fatalf("token account was not decoded: %+v", changes)
+	}
+}
@@ -0,0 +1,417 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/kafka"
+)
+
+// SolanaRPC receives getTransaction results fetched with the jsonParsed
+// encoding, e.g. from a relay polling a plain Solana RPC node. Calls are
+// signed with the shared secret the same way the http sink signs
+// deliveries.
+//
+// Plain RPC has no transaction types, so they are inferred from the parsed
+// instructions: token mints are TOKEN_MINT, SOL and token transfers are
+// TRANSFER and everything else is UNKNOWN.
+type SolanaRPC struct {
+	secret string
+}
+
+func NewSolanaRPC(secret string) *SolanaRPC {
+	return &SolanaRPC{secret: secret}
+}
+
+func (p *SolanaRPC) Name() string {
+	return "rpc"
+}
+
+func (p *SolanaRPC) Verify(r *http.Request, receiverName string, body []byte) error {
+	return verifySignature(r, p.secret, body, time.Now())
+}
+
+func (p *SolanaRPC) Normalize(body []byte) ([]kafka.WebhookPayload, error) {
+	return NormalizeRPCTransactions(body)
+}
+
+// NormalizeRPCTransactions accepts a getTransaction JSON-RPC response, a bare
+// result or an array of either. Transactions the node did not find are
+// skipped.
+func NormalizeRPCTransactions(body []byte) ([]kafka.WebhookPayload, error) {
+	items, err := splitArray(body)
+	if err != nil {
+		return nil, err
+	}
+
+	payloads := make([]kafka.WebhookPayload, 0, len(items))
+	for _, item := range items {
+		var envelope struct {
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(item, &envelope); err != nil {
+			log.Println("Error occured while parsing rpc body, Invalid Json, err: ", err)
+			return nil, err
+		}
+		if len(envelope.Error) > 0 && string(envelope.Error) != "null" {
+			return nil, fmt.Errorf("rpc response carries an error: %s", envelope.Error)
+		}
+		if envelope.Result != nil {
+			item = envelope.Result
+		}
+		if string(item) == "null" {
+			continue
+		}
+
+		var txn rpcTransaction
+		if err := json.Unmarshal(item, &txn); err != nil {
+			log.Println("Error occured while parsing rpc transaction, err: ", err)
+			return nil, err
+		}
+
+		payload, err := txn.payload()
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, payload)
+	}
+
+	return payloads, nil
+}
+
+type rpcTransaction struct {
+	Slot        int64                `json:"slot"`
+	BlockTime   *int64               `json:"blockTime"`
+	Meta        *rpcMeta             `json:"meta"`
+	Transaction rpcSignedTransaction `json:"transaction"`
+}
+
+type rpcSignedTransaction struct {
+	Signatures []string `json:"signatures"`
+	Message    struct {
+		AccountKeys  []rpcAccountKey  `json:"accountKeys"`
+		Instructions []rpcInstruction `json:"instructions"`
+	} `json:"message"`
+}
+
+type rpcMeta struct {
+	Err               any               `json:"err"`
+	Fee               int32             `json:"fee"`
+	PreBalances       []uint64          `json:"preBalances"`
+	PostBalances      []uint64          `json:"postBalances"`
+	PreTokenBalances  []rpcTokenBalance `json:"preTokenBalances"`
+	PostTokenBalances []rpcTokenBalance `json:"postTokenBalances"`
+	InnerInstructions []struct {
+		Index        int              `json:"index"`
+		Instructions []rpcInstruction `json:"instructions"`
+	} `json:"innerInstructions"`
+}
+
+type rpcTokenBalance struct {
+	AccountIndex  int    `json:"accountIndex"`
+	Mint          string `json:"mint"`
+	Owner         string `json:"owner"`
+	UiTokenAmount struct {
+		Amount   string `json:"amount"`
+		Decimals int8   `json:"decimals"`
+	} `json:"uiTokenAmount"`
+}
+
+// rpcAccountKey is an object with jsonParsed and a bare address otherwise.
+type rpcAccountKey struct {
+	Pubkey string `json:"pubkey"`
+}
+
+func (k *rpcAccountKey) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &k.Pubkey)
+	}
+	type plain rpcAccountKey
+	return json.Unmarshal(data, (*plain)(k))
+}
+
+// rpcInstruction is parsed for programs the node knows, and carries raw
+// accounts and data for the rest.
+type rpcInstruction struct {
+	Program   string          `json:"program"`
+	ProgramId string          `json:"programId"`
+	Accounts  []string        `json:"accounts"`
+	Data      string          `json:"data"`
+	Parsed    json.RawMessage `json:"parsed"`
+}
+
+// parsedInstruction is the parsed form of the system and spl-token
+// instructions the adapter reads. Amounts are numbers for lamports and
+// strings for tokens.
+type parsedInstruction struct {
+	Type string `json:"type"`
+	Info struct {
+		Source      string      `json:"source"`
+		Destination string      `json:"destination"`
+		Account     string      `json:"account"`
+		Mint        string      `json:"mint"`
+		Authority   string      `json:"authority"`
+		Lamports    uint64      `json:"lamports"`
+		Amount      json.Number `json:"amount"`
+		TokenAmount *struct {
+			Amount   string `json:"amount"`
+			Decimals int8   `json:"decimals"`
+		} `json:"tokenAmount"`
+	} `json:"info"`
+}
+
+func (i rpcInstruction) parsed() (parsedInstruction, bool) {
+	var parsed parsedInstruction
+	if len(i.Parsed) == 0 || i.Parsed[0] != '{' {
+		return parsed, false
+	}
+	if err := json.Unmarshal(i.Parsed, &parsed); err != nil {
+		return parsed, false
+	}
+	return parsed, true
+}
+
+func (t rpcTransaction) payload() (kafka.WebhookPayload, error) {
+	if len(t.Transaction.Signatures) == 0 {
+		return kafka.WebhookPayload{}, errors.New("rpc transaction has no signature")
+	}
+	meta := t.Meta
+	if meta == nil {
+		meta = &rpcMeta{}
+	}
+
+	keys := make([]string, len(t.Transaction.Message.AccountKeys))
+	for i, key := range t.Transaction.Message.AccountKeys {
+		keys[i] = key.Pubkey
+	}
+
+	payload := kafka.WebhookPayload{
+		Signature:        t.Transaction.Signatures[0],
+		Slot:             t.Slot,
+		Fee:              meta.Fee,
+		Source:           "UNKNOWN",
+		Type:             "UNKNOWN",
+		TransactionError: meta.Err,
+		Events:           map[string]interface{}{},
+	}
+	if t.BlockTime != nil {
+		payload.Timestamp = *t.BlockTime
+	}
+	if len(keys) > 0 {
+		payload.FeePayer = keys[0]
+	}
+
+	payload.AccountData = accountData(keys, meta)
+	tokens := tokenAccounts(keys, meta)
+
+	inner := make(map[int][]rpcInstruction)
+	for _, set := range meta.InnerInstructions {
+		inner[set.Index] = append(inner[set.Index], set.Instructions...)
+	}
+
+	for index, instruction := range t.Transaction.Message.Instructions {
+		converted := kafka.Instruction{Accounts: instruction.Accounts, Data: instruction.Data}
+		if converted.Data == "" && len(instruction.Parsed) > 0 {
+			converted.Data = string(instruction.Parsed)
+		}
+		for _, child := range inner[index] {
+			data := child.Data
+			if data == "" && len(child.Parsed) > 0 {
+				data = string(child.Parsed)
+			}
+			converted.InnerInstructions = append(converted.InnerInstructions, kafka.InnerInstruction{
+				Accounts:  child.Accounts,
+				Data:      data,
+				ProgramId: child.ProgramId,
+			})
+		}
+		payload.Instructions = append(payload.Instructions, converted)
+
+		// Only top level instructions decide the type, inner ones are what
+		// the outer program did to carry it out
+		switch txnType := transferType(instruction); {
+		case txnType == "TOKEN_MINT":
+			payload.Type = txnType
+		case txnType == "TRANSFER" && payload.Type == "UNKNOWN":
+			payload.Type = txnType
+		}
+
+		for _, child := range append([]rpcInstruction{instruction}, inner[index]...) {
+			addTransfer(&payload, child, tokens)
+		}
+	}
+
+	return payload, nil
+}
+
+func transferType(instruction rpcInstruction) string {
+	parsed, ok := instruction.parsed()
+	if !ok {
+		return ""
+	}
+
+	switch {
+	case instruction.Program == "spl-token" && (parsed.Type == "mintTo" || parsed.Type == "mintToChecked"):
+		return "TOKEN_MINT"
+	case instruction.Program == "spl-token" && (parsed.Type == "transfer" || parsed.Type == "transferChecked"):
+		return "TRANSFER"
+	case instruction.Program == "system" && (parsed.Type == "transfer" || parsed.Type == "transferWithSeed"):
+		return "TRANSFER"
+	}
+	return ""
+}
+
+// addTransfer records SOL transfers, token transfers and token mints the
+// way Helius reports them, mints as transfers without a sender.
+func addTransfer(payload *kafka.WebhookPayload, instruction rpcInstruction, tokens map[string]rpcTokenBalance) {
+	parsed, ok := instruction.parsed()
+	if !ok {
+		return
+	}
+	info := parsed.Info
+
+	switch instruction.Program {
+	case "system":
+		if parsed.Type != "transfer" && parsed.Type != "transferWithSeed" {
+			return
+		}
+		payload.NativeTransfers = append(payload.NativeTransfers, kafka.NativeTransfer{
+			Amount:          new(big.Int).SetUint64(info.Lamports),
+			FromUserAccount: info.Source,
+			ToUserAccount:   info.Destination,
+		})
+
+	case "spl-token":
+		transfer := kafka.TokenTransfer{Mint: info.Mint, TokenStandard: "Fungible"}
+		amount := info.Amount.String()
+		var decimals int8
+
+		switch parsed.Type {
+		case "transfer", "transferChecked":
+			transfer.FromTokenAccount = info.Source
+			transfer.FromUserAccount = tokens[info.Source].Owner
+			transfer.ToTokenAccount = info.Destination
+			transfer.ToUserAccount = tokens[info.Destination].Owner
+			if transfer.FromUserAccount == "" {
+				transfer.FromUserAccount = info.Authority
+			}
+			if transfer.Mint == "" {
+				transfer.Mint = tokens[info.Source].Mint
+			}
+			decimals = tokens[info.Source].UiTokenAmount.Decimals
+		case "mintTo", "mintToChecked":
+			transfer.ToTokenAccount = info.Account
+			transfer.ToUserAccount = tokens[info.Account].Owner
+			decimals = tokens[info.Account].UiTokenAmount.Decimals
+		default:
+			return
+		}
+		if info.TokenAmount != nil {
+			amount = info.TokenAmount.Amount
+			decimals = info.TokenAmount.Decimals
+		}
+
+		transfer.TokenAmount = uiAmount(amount, decimals)
+		payload.TokenTransfers = append(payload.TokenTransfers, transfer)
+	}
+}
+
+// accountData lists every account with its SOL change and the changes of
+// the token accounts among them.
+func accountData(keys []string, meta *rpcMeta) []kafka.AccountData {
+	pre := make(map[int]rpcTokenBalance)
+	for _, balance := range meta.PreTokenBalances {
+		pre[balance.AccountIndex] = balance
+	}
+	post := make(map[int]rpcTokenBalance)
+	for _, balance := range meta.PostTokenBalances {
+		post[balance.AccountIndex] = balance
+	}
+
+	data := make([]kafka.AccountData, 0, len(keys))
+	for i, key := range keys {
+		account := kafka.AccountData{Account: key, NativeBalanceChange: new(big.Int)}
+		if i < len(meta.PreBalances) && i < len(meta.PostBalances) {
+			account.NativeBalanceChange.Sub(
+				new(big.Int).SetUint64(meta.PostBalances[i]),
+				new(big.Int).SetUint64(meta.PreBalances[i]),
+			)
+		}
+
+		before, hadBefore := pre[i]
+		after, hasAfter := post[i]
+		if hadBefore || hasAfter {
+			balance := after
+			if !hasAfter {
+				balance = before
+			}
+			change := new(big.Int)
+			change.SetString(after.UiTokenAmount.Amount, 10)
+			previous, _ := new(big.Int).SetString(before.UiTokenAmount.Amount, 10)
+			if previous != nil {
+				change.Sub(change, previous)
+			}
+
+			account.TokenBalanceChanges = append(account.TokenBalanceChanges, kafka.TokenBalanceChange{
+				Mint:         balance.Mint,
+				TokenAccount: key,
+				UserAccount:  balance.Owner,
+				RawTokenAmount: kafka.RawTokenAmnt{
+					Decimals:    balance.UiTokenAmount.Decimals,
+					TokenAmount: change.String(),
+				},
+			})
+		}
+
+		data = append(data, account)
+	}
+
+	return data
+}
+
+// tokenAccounts maps token account addresses to their balance entry, which
+// names the mint and owner transfers leave out.
+func tokenAccounts(keys []string, meta *rpcMeta) map[string]rpcTokenBalance {
+	tokens := make(map[string]rpcTokenBalance)
+	for _, balances := range [][]rpcTokenBalance{meta.PreTokenBalances, meta.PostTokenBalances} {
+		for _, balance := range balances {
+			if balance.AccountIndex < len(keys) {
+				tokens[keys[balance.AccountIndex]] = balance
+			}
+		}
+	}
+	return tokens
+}
+
+func uiAmount(amount string, decimals int8) float64 {
+	raw, ok := new(big.Float).SetString(amount)
+	if !ok {
+		return 0
+	}
+	scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	value, _ := raw.Quo(raw, scale).Float64()
+	return value
+}
+
+// splitArray returns the elements of a JSON array, or the body itself when
+// it is a single value.
+func splitArray(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		return []json.RawMessage{body}, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		log.Println("Error occured while parsing body, Invalid Json, err: ", err)
+		return nil, err
+	}
+	return items, nil
+}
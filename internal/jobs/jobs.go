@@ -0,0 +1,88 @@
+package jobs
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// StaleAfter is how long a running job may go without a checkpoint or
+	// heartbeat before it is assumed to have lost its runner and is claimed
+	// again
+	StaleAfter = 2 * time.Minute
+	// A single step of a job, e.g. a backfill page, can take longer than
+	// StaleAfter, so running jobs are heartbeated in between
+	heartbeatInterval = StaleAfter / 4
+)
+
+// Job is a claimed background job, backed by a row of the database.
+type Job interface {
+	// Run works on the job from its last checkpoint until it is done.
+	Run(ctx context.Context) error
+	// Heartbeat keeps the running job from going stale.
+	Heartbeat(ctx context.Context) error
+	// Finish marks the job done, or records why Run failed.
+	Finish(ctx context.Context, cause error) error
+}
+
+// ClaimFunc marks the next job to run as running and returns it, or nil
+// when there is nothing to run. Running jobs that went StaleAfter without
+// a checkpoint or heartbeat are claimed again.
+type ClaimFunc func(ctx context.Context, staleAfter time.Duration) (Job, error)
+
+// RunPending runs claimed jobs until none is left and returns how many it
+// ran, failed runs included. A job stopped by ctx stays running and is
+// resumed from its checkpoint once it goes stale.
+func RunPending(ctx context.Context, claim ClaimFunc) (int, error) {
+	ran := 0
+	for ctx.Err() == nil {
+		job, err := claim(ctx, StaleAfter)
+		if err != nil {
+			return ran, err
+		}
+		if job == nil {
+			return ran, nil
+		}
+
+		stopHeartbeat := heartbeat(ctx, job)
+		err = job.Run(ctx)
+		stopHeartbeat()
+		if ctx.Err() != nil {
+			return ran, ctx.Err()
+		}
+		if err := job.Finish(ctx, err); err != nil {
+			return ran, err
+		}
+		ran++
+	}
+
+	return ran, ctx.Err()
+}
+
+// heartbeat heartbeats the job every heartbeatInterval until the returned
+// function is called.
+func heartbeat(ctx context.Context, job Job) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// A missed heartbeat is retried on the next tick
+			job.Heartbeat(ctx)
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
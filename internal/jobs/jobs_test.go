@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeJob struct {
+	runErr   error
+	finished bool
+	cause    error
+}
+
+func (j *fakeJob) Run(ctx context.Context) error       { return j.runErr }
+func (j *fakeJob) Heartbeat(ctx context.Context) error { return nil }
+
+func (j *fakeJob) Finish(ctx context.Context, cause error) error {
+	j.finished = true
+	j.cause = cause
+	return nil
+}
+
+func TestRunPending(t *testing.T) {
+	failure := errors.New("page failed")
+	queued := []*fakeJob{{}, {runErr: failure}}
+	claimed := 0
+
+	ran, err := RunPending(context.Background(), func(ctx context.Context, staleAfter time.Duration) (Job, error) {
+		if staleAfter != StaleAfter {
+			t.Errorf("expected jobs to go stale after %v; got %v", StaleAfter, staleAfter)
+		}
+		if claimed == len(queued) {
+			return nil, nil
+		}
+		claimed++
+		return queued[claimed-1], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ran != 2 {
+		t.Errorf("expected 2 jobs to run; got %d", ran)
+	}
+	if !queued[0].finished || queued[0].cause != nil {
+		t.Errorf("expected the first job to finish cleanly; got %+v", queued[0])
+	}
+	if !queued[1].finished || queued[1].cause != failure {
+		t.Errorf("expected the second job to finish with its failure; got %+v", queued[1])
+	}
+}
+
+func TestRunPendingLeavesCancelledJobsRunning(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &fakeJob{}
+
+	ran, err := RunPending(ctx, func(ctx context.Context, staleAfter time.Duration) (Job, error) {
+		cancel()
+		return job, nil
+	})
+	if !errors.Is(err, context.Canceled) || ran != 0 {
+		t.Errorf("expected the run to stop with the context; got %d, %v", ran, err)
+	}
+	if job.finished {
+		t.Error("a job stopped by its context must stay running to be resumed")
+	}
+}
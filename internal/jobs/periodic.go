@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// RunPeriodically runs fn every interval read from envVar, falling back to
+// defaultInterval, until the returned function is called. That function
+// cancels the context of the current run and waits for it to end. With no
+// interval configured and a zero default nothing runs.
+func RunPeriodically(name string, envVar string, defaultInterval time.Duration, fn func(ctx context.Context) error) func() {
+	interval, err := time.ParseDuration(os.Getenv(envVar))
+	if err != nil || interval <= 0 {
+		interval = defaultInterval
+	}
+	if interval <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := fn(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to run the %s: %v", name, err)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
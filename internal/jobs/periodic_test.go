@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunPeriodically(t *testing.T) {
+	t.Setenv("TEST_INTERVAL", "5ms")
+
+	var runs atomic.Int32
+	stop := RunPeriodically("test loop", "TEST_INTERVAL", time.Hour, func(ctx context.Context) error {
+		runs.Add(1)
+		// A failed run does not stop the loop
+		return errors.New("run failed")
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for runs.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+
+	stopped := runs.Load()
+	if stopped < 2 {
+		t.Fatalf("expected the loop to run every interval; ran %d times", stopped)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if runs.Load() != stopped {
+		t.Error("expected no run once stopped")
+	}
+}
+
+func TestRunPeriodicallyDisabled(t *testing.T) {
+	t.Setenv("TEST_INTERVAL", "")
+
+	stop := RunPeriodically("test loop", "TEST_INTERVAL", 0, func(ctx context.Context) error {
+		t.Error("expected a loop without an interval not to run")
+		return nil
+	})
+	time.Sleep(10 * time.Millisecond)
+	stop()
+}
@@ -0,0 +1,140 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+const (
+	defaultBatchSize     = 500
+	defaultFlushInterval = time.Second
+)
+
+// BatchConfig controls how many records the worker collects before writing
+// them to user databases, and how long it waits for a batch to fill up.
+type BatchConfig struct {
+	Size          int
+	FlushInterval time.Duration
+}
+
+// LoadBatchConfig reads INDEX_BATCH_SIZE and INDEX_FLUSH_INTERVAL from the
+// environment.
+func LoadBatchConfig() BatchConfig {
+	config := BatchConfig{
+		Size:          defaultBatchSize,
+		FlushInterval: defaultFlushInterval,
+	}
+
+	if size, err := strconv.Atoi(os.Getenv("INDEX_BATCH_SIZE")); err == nil && size > 0 {
+		config.Size = size
+	}
+	if interval, err := time.ParseDuration(os.Getenv("INDEX_FLUSH_INTERVAL")); err == nil && interval > 0 {
+		config.FlushInterval = interval
+	}
+
+	return config
+}
+
+// IndexBatch groups jobs by the sink and user they target and writes each
+// group with a single Write call. Groups are written concurrently, so a slow
+// destination only holds up its own user. If a group fails, every job in it
+// is returned as a failure so the whole group is retried together, unless
+// the sink reported which jobs failed.
+func IndexBatch(jobs []IndexJob) []IndexFailure {
+	var (
+		keys  []sinkKey
+		byKey = make(map[sinkKey][]IndexJob)
+	)
+
+	for _, job := range jobs {
+		key := sinkKey{
+			kind:   job.Subscription.Sink,
+			userId: job.Subscription.UserId,
+		}
+		if key.kind == "" {
+			key.kind = database.SinkPostgres
+		}
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], job)
+	}
+
+	// Failures are kept per group to return them in a stable order
+	groupFailures := make([][]IndexFailure, len(keys))
+
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key sinkKey) {
+			defer wg.Done()
+			groupFailures[i] = writeGroup(key, byKey[key])
+		}(i, key)
+	}
+	wg.Wait()
+
+	var failures []IndexFailure
+	for _, group := range groupFailures {
+		failures = append(failures, group...)
+	}
+
+	return failures
+}
+
+// writeGroup writes the jobs of one sink and returns those that failed.
+func writeGroup(key sinkKey, jobs []IndexJob) []IndexFailure {
+	sink, release, err := sinks.get(key)
+	if err != nil {
+		log.Printf("Failed to prepare %s sink for user: %s, err: %v", key.kind, key.userId, err)
+		return indexFailures(jobs, nil, err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err = sink.Write(ctx, jobs)
+	cancel()
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("Failed to index %d payloads to %s sink for user: %s, err: %v", len(jobs), key.kind, key.userId, err)
+
+	var jobErrs JobErrors
+	if errors.As(err, &jobErrs) {
+		return indexFailures(jobs, jobErrs, err)
+	}
+
+	// Drop the sink so the retry starts from a freshly prepared one
+	sinks.discard(key, sink)
+	return indexFailures(jobs, nil, err)
+}
+
+// indexFailures returns a failure for every job in jobErrs, or for every job
+// when jobErrs is nil.
+func indexFailures(jobs []IndexJob, jobErrs JobErrors, err error) []IndexFailure {
+	var failures []IndexFailure
+	for i, job := range jobs {
+		jobErr := err
+		if jobErrs != nil {
+			var ok bool
+			if jobErr, ok = jobErrs[i]; !ok {
+				continue
+			}
+		}
+
+		failures = append(failures, IndexFailure{
+			SubscriptionId: subscriptionRef(job.Subscription),
+			Payload:        job.Payload,
+			Record:         job.Record,
+			Err:            jobErr,
+		})
+	}
+	return failures
+}
@@ -0,0 +1,133 @@
+package kafka
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+func TestBuildInsert(t *testing.T) {
+	rows := [][]any{
+		{"a", 1},
+		{"b", 2},
+	}
+
+	query, args := buildInsert("INSERT INTO t (name, n) VALUES", "ON CONFLICT DO NOTHING", rows)
+
+	expected := "INSERT INTO t (name, n) VALUES ($1, $2), ($3, $4) ON CONFLICT DO NOTHING"
+	if query != expected {
+		t.Errorf("expected query %q; got %q", expected, query)
+	}
+
+	if len(args) != 4 || args[0] != "a" || args[3] != 2 {
+		t.Errorf("expected args flattened in row order; got %v", args)
+	}
+}
+
+func TestChunkRows(t *testing.T) {
+	// 7 columns fit 9362 rows under the limit, with one parameter to spare
+	const columns = 7
+	rows := make([][]any, 2*(maxInsertParams/columns)+5)
+	for i := range rows {
+		rows[i] = make([]any, columns)
+	}
+
+	chunks := chunkRows(rows)
+
+	var sizes []int
+	for _, chunk := range chunks {
+		sizes = append(sizes, len(chunk))
+		if _, args := buildInsert("INSERT INTO t VALUES", "", chunk); len(args) > maxInsertParams {
+			t.Errorf("a chunk of %d rows needs %d parameters", len(chunk), len(args))
+		}
+	}
+	if want := []int{9362, 9362, 5}; !slices.Equal(sizes, want) {
+		t.Errorf("expected chunks of %v rows; got %v", want, sizes)
+	}
+
+	if chunks := chunkRows(nil); len(chunks) != 0 {
+		t.Errorf("expected no chunks without rows; got %d", len(chunks))
+	}
+}
+
+func TestIndexBatchGroupsFailures(t *testing.T) {
+	kind := database.SinkKind("test-batch")
+	errDown := errors.New("destination down")
+	errRow := errors.New("bad row")
+
+	var (
+		mu      sync.Mutex
+		created = make(map[string]*fakeSink)
+	)
+	RegisterSink(kind, func(userId string) (Sink, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		sink := &fakeSink{}
+		switch userId {
+		case "down":
+			sink.writeErr = errDown
+		case "partial":
+			// Only the second job of the batch fails
+			sink.writeErr = JobErrors{1: errRow}
+		}
+		created[userId] = sink
+		return sink, nil
+	})
+	t.Cleanup(func() {
+		sinkFactoriesMu.Lock()
+		delete(sinkFactories, kind)
+		sinkFactoriesMu.Unlock()
+	})
+
+	job := func(userId string, subscriptionId string) IndexJob {
+		return IndexJob{Subscription: database.SubscriptionLookup{
+			SubscriptionId: subscriptionId,
+			UserId:         userId,
+			Sink:           kind,
+		}}
+	}
+	jobs := []IndexJob{
+		job("ok", "ok-0"),
+		job("down", "down-0"),
+		job("partial", "partial-0"),
+		job("ok", "ok-1"),
+		job("down", "down-1"),
+		job("partial", "partial-1"),
+		job("partial", "partial-2"),
+	}
+
+	failures := IndexBatch(jobs)
+
+	var failed []string
+	for _, failure := range failures {
+		failed = append(failed, failure.SubscriptionId)
+		wantErr := errDown
+		if failure.SubscriptionId == "partial-1" {
+			wantErr = errRow
+		}
+		if !errors.Is(failure.Err, wantErr) {
+			t.Errorf("expected %s to fail with %v; got %v", failure.SubscriptionId, wantErr, failure.Err)
+		}
+	}
+	// A failed group fails whole, a sink reporting its jobs only fails those
+	if want := []string{"down-0", "down-1", "partial-1"}; !slices.Equal(failed, want) {
+		t.Errorf("expected failures %v; got %v", want, failed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for userId, want := range map[string]int{"ok": 2, "down": 2, "partial": 3} {
+		if got := created[userId].batchSizes; !slices.Equal(got, []int{want}) {
+			t.Errorf("expected one write of %d jobs for %s; got %v", want, userId, got)
+		}
+	}
+	if !created["down"].isClosed() {
+		t.Error("expected the failed sink to be discarded")
+	}
+	if created["partial"].isClosed() {
+		t.Error("a sink reporting failed jobs was discarded")
+	}
+}
@@ -2,24 +2,204 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
 )
 
-func (m *KafkaClientManager) ConsumeWebhookPayload() {
-	ctx := context.Background()
+const defaultWorkerConcurrency = 4
+
+// WorkerConcurrency returns the number of consumer goroutines to run, read
+// from WORKER_CONCURRENCY and falling back to defaultWorkerConcurrency.
+func WorkerConcurrency() int {
+	workers, err := strconv.Atoi(os.Getenv("WORKER_CONCURRENCY"))
+	if err != nil || workers <= 0 {
+		return defaultWorkerConcurrency
+	}
 
+	return workers
+}
+
+// ConsumeWebhookPayload runs a pool of consumer group members that index
+// webhook records until ctx is cancelled, plus one member draining the
+// retry topics. It returns once every worker has committed its last
+// processed batch and left the group.
+func (m *KafkaClientManager) ConsumeWebhookPayload(ctx context.Context, workers int) error {
+	policy := LoadRetryPolicy()
+	batch := LoadBatchConfig()
+
+	if err := VerifyConsumerSchemas(); err != nil {
+		log.Printf("Record schemas are not compatible with this worker: %v", err)
+		return err
+	}
+
+	clients := make([]*kgo.Client, 0, workers+1)
+	closeAll := func() {
+		for _, c := range clients {
+			c.Close()
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		client, err := NewConsumerClient(consumerGroup, os.Getenv("KAFKA_TOPIC"))
+		if err != nil {
+			log.Printf("Failed to create consumer client %d: %v", i, err)
+			closeAll()
+			return err
+		}
+		clients = append(clients, client)
+	}
+
+	retryClient, err := NewConsumerClient(retryConsumerGroup, policy.RetryTopics()...)
+	if err != nil {
+		log.Printf("Failed to create retry consumer client: %v", err)
+		closeAll()
+		return err
+	}
+	clients = append(clients, retryClient)
+
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(id int, client *kgo.Client) {
+			defer wg.Done()
+			defer client.Close()
+
+			log.Printf("Consumer worker %d started", id)
+			consumeRecords(ctx, client, policy, batch)
+			log.Printf("Consumer worker %d stopped", id)
+		}(i, client)
+	}
+
+	wg.Wait()
+	sinks.closeAll()
+	return nil
+}
+
+// consumeRecords polls the client's topic and indexes records in batches
+// until ctx is cancelled. A batch is flushed once it holds batch.Size
+// records or batch.FlushInterval has passed since polling started, and its
+// offsets are committed only for records that have been written or handed
+// to the retry policy. A shutdown in the middle of a batch therefore
+// neither drops nor replays the records that were already handled. Retry
+// records that are not due yet pause their partition instead of blocking
+// the batch.
+func consumeRecords(ctx context.Context, client *kgo.Client, policy RetryPolicy, batch BatchConfig) {
 	for {
-		fetches := m.client.PollFetches(ctx)
+		var (
+			pending  []*kgo.Record
+			deadline = time.Now().Add(batch.FlushInterval)
+		)
+
+		for len(pending) < batch.Size && ctx.Err() == nil && time.Now().Before(deadline) {
+			pollCtx, cancel := context.WithDeadline(ctx, deadline)
+			fetches := client.PollRecords(pollCtx, batch.Size-len(pending))
+			cancel()
+
+			if fetches.IsClientClosed() {
+				return
+			}
+
+			fetches.EachError(func(topic string, partition int32, err error) {
+				if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+					log.Printf("Error occured while consuming webhook payloads from %s[%d]: %v", topic, partition, err)
+				}
+			})
+
+			pending = append(pending, fetches.Records()...)
+		}
 
-		if errs := fetches.Errors(); len(errs) > 0 {
-			log.Println("Error occured while consuming webhook payloads", errs)
+		if ctx.Err() != nil {
+			// Nothing in pending was written yet, leave it for redelivery
+			client.AllowRebalance()
+			return
 		}
 
-		iter := fetches.RecordIter()
+		pending, deferred := deferRetries(policy, pending, time.Now())
+		processed := indexRecords(ctx, client, policy, pending)
+		commitProcessed(client, processed)
+		pauseUntilDue(client, deferred)
+		client.AllowRebalance()
+	}
+}
+
+// indexRecords matches every record to its subscriptions, writes all of
+// them with IndexBatch and routes whatever failed to the retry policy. It
+// returns the records that are safe to commit.
+func indexRecords(ctx context.Context, client *kgo.Client, policy RetryPolicy, records []*kgo.Record) []*kgo.Record {
+	var (
+		jobs       []IndexJob
+		matched    []*kgo.Record
+		recordErrs = make(map[*kgo.Record]error)
+	)
 
-		for !iter.Done() {
-			record := iter.Next()
-			StoreRecordForInterestedUsers(record)
+	for _, record := range records {
+		recordJobs, err := MatchRecord(record)
+		if err != nil {
+			recordErrs[record] = err
+		} else {
+			jobs = append(jobs, recordJobs...)
 		}
+		matched = append(matched, record)
+	}
+
+	failures := IndexBatch(jobs)
+	recordSeenSignatures(jobs, failures)
+
+	failuresByRecord := make(map[*kgo.Record][]IndexFailure)
+	for _, failure := range failures {
+		failuresByRecord[failure.Record] = append(failuresByRecord[failure.Record], failure)
+	}
+
+	processed := make([]*kgo.Record, 0, len(matched))
+	for _, record := range matched {
+		failures, err := failuresByRecord[record], recordErrs[record]
+		if (err != nil || len(failures) > 0) && !routeFailures(ctx, client, policy, record, failures, err) {
+			break
+		}
+		processed = append(processed, record)
+	}
+
+	return processed
+}
+
+// routeFailures hands a failed record to the retry policy, retrying until
+// it succeeds. It returns false only if ctx is cancelled first, in which
+// case the record stays uncommitted and is redelivered after the restart.
+func routeFailures(ctx context.Context, client *kgo.Client, policy RetryPolicy, record *kgo.Record, failures []IndexFailure, recordErr error) bool {
+	for {
+		err := policy.HandleIndexFailures(ctx, client, record, failures, recordErr)
+		if err == nil {
+			return true
+		}
+
+		log.Printf("Failed to route failed record %s[%d]@%d, err: %v", record.Topic, record.Partition, record.Offset, err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// commitProcessed commits the offsets of records that have been handled.
+// It deliberately does not use the worker context, which is already
+// cancelled when the last batch of a shutdown is committed.
+func commitProcessed(client *kgo.Client, records []*kgo.Record) {
+	if len(records) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.CommitRecords(ctx, records...); err != nil {
+		log.Printf("Failed to commit offsets for %d records, err: %v", len(records), err)
 	}
 }
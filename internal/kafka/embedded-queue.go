@@ -0,0 +1,301 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// embeddedTopic is the topic embedded records are given for matching and
+// dead letters, which have no Kafka topic
+const embeddedTopic = "queue_records"
+
+// EmbeddedQueue keeps records in the queue_records table of the main
+// database, for deployments without Kafka. Records are the ones that would
+// be produced to KAFKA_TOPIC, and go through the same matching and sinks.
+// Retries are new records that only become available after their backoff,
+// so there is no retry topic, and dead letters are only kept in
+// dead_letter_records.
+type EmbeddedQueue struct {
+	db database.Service
+}
+
+// NewEmbeddedQueue creates a queue stored in db.
+func NewEmbeddedQueue(db database.Service) *EmbeddedQueue {
+	return &EmbeddedQueue{db: db}
+}
+
+// ProduceWebhookPayload stores the transactions of a batch, one record each.
+func (q *EmbeddedQueue) ProduceWebhookPayload(ctx context.Context, payloads []WebhookPayload, receiverName string) error {
+	return q.enqueue(ctx, payloads, receiverName)
+}
+
+func (q *EmbeddedQueue) produceFetched(payloads []WebhookPayload, receiverName string, headers ...kgo.RecordHeader) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return q.enqueue(ctx, payloads, receiverName, headers...)
+}
+
+func (q *EmbeddedQueue) enqueue(ctx context.Context, payloads []WebhookPayload, receiverName string, headers ...kgo.RecordHeader) error {
+	version, err := producerSchemaVersion()
+	if err != nil {
+		log.Println("Invalid record schema, err: ", err)
+		return err
+	}
+
+	records, err := transactionRecords(payloads, version, receiverName, headers...)
+	if err != nil {
+		log.Println("Invalid Json Payload, err: ", err)
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	queued := make([]database.QueueRecord, 0, len(records))
+	for _, record := range records {
+		queued = append(queued, queueRecord(record, time.Time{}))
+	}
+
+	if err := q.db.EnqueueRecords(ctx, queued); err != nil {
+		log.Printf("Failed to queue %d %s records, err: %v", len(queued), receiverName, err)
+		return err
+	}
+
+	return nil
+}
+
+// ReplayDeadLetter queues a dead-lettered record again with a fresh attempt
+// budget, targeted at the subscription that failed.
+func (q *EmbeddedQueue) ReplayDeadLetter(record database.DeadLetterRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	queued := database.QueueRecord{
+		Key:   deadLetterKey(record),
+		Value: record.Payload,
+		Headers: map[string]string{
+			headerReceiver:       record.ReceiverName,
+			headerTimestamp:      time.Now().Format(time.RFC3339),
+			headerSubscriptionId: record.SubscriptionId,
+			headerSchemaVersion:  strconv.Itoa(schemaVersionJSON),
+		},
+	}
+
+	if err := q.db.EnqueueRecords(ctx, []database.QueueRecord{queued}); err != nil {
+		log.Printf("Failed to replay dead-letter record %s, err: %v", record.Id, err)
+		return err
+	}
+
+	return nil
+}
+
+// Close does nothing, records are stored as they are produced.
+func (q *EmbeddedQueue) Close() {}
+
+// ConsumeWebhookPayload runs workers that claim batches of available
+// records, index them and delete them, until ctx is cancelled. A batch cut
+// off by the shutdown is delivered again to the next worker locking its
+// shard.
+func (q *EmbeddedQueue) ConsumeWebhookPayload(ctx context.Context, workers int) error {
+	policy := LoadRetryPolicy()
+	batch := LoadBatchConfig()
+
+	if err := VerifyConsumerSchemas(); err != nil {
+		log.Printf("Record schemas are not compatible with this worker: %v", err)
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			log.Printf("Embedded queue worker %d started", id)
+			q.consumeRecords(ctx, policy, batch)
+			log.Printf("Embedded queue worker %d stopped", id)
+		}(i)
+	}
+
+	wg.Wait()
+	sinks.closeAll()
+	return nil
+}
+
+// consumeRecords claims up to batch.Size records of a shard at a time,
+// waiting batch.FlushInterval whenever none is available.
+func (q *EmbeddedQueue) consumeRecords(ctx context.Context, policy RetryPolicy, batch BatchConfig) {
+	for ctx.Err() == nil {
+		claimed, release, err := q.db.ClaimQueueRecords(ctx, batch.Size)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error occured while claiming queued records: %v", err)
+		}
+
+		if len(claimed) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(batch.FlushInterval):
+			}
+			continue
+		}
+
+		q.handleClaimed(ctx, policy, claimed)
+		release()
+	}
+}
+
+// handleClaimed indexes claimed records and completes them, while their
+// shard is locked.
+func (q *EmbeddedQueue) handleClaimed(ctx context.Context, policy RetryPolicy, claimed []database.QueueRecord) {
+	records := make([]*kgo.Record, 0, len(claimed))
+	for _, queued := range claimed {
+		records = append(records, kafkaRecord(queued))
+	}
+
+	ids, requeue := indexQueuedRecords(policy, records)
+	if ctx.Err() != nil {
+		// Nothing is deleted, the records are delivered again
+		return
+	}
+
+	completeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	err := q.db.CompleteQueueRecords(completeCtx, ids, requeue)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to complete %d queued records, err: %v", len(ids), err)
+	}
+}
+
+// indexQueuedRecords matches and writes the records like the Kafka worker.
+// It returns the ids of the handled records, all of them, and the retries
+// to queue for those that failed.
+func indexQueuedRecords(policy RetryPolicy, records []*kgo.Record) ([]int64, []database.QueueRecord) {
+	var (
+		jobs       []IndexJob
+		recordErrs = make(map[*kgo.Record]error)
+	)
+
+	for _, record := range records {
+		recordJobs, err := MatchRecord(record)
+		if err != nil {
+			recordErrs[record] = err
+			continue
+		}
+		jobs = append(jobs, recordJobs...)
+	}
+
+	failures := IndexBatch(jobs)
+	recordSeenSignatures(jobs, failures)
+
+	failuresByRecord := make(map[*kgo.Record][]IndexFailure)
+	for _, failure := range failures {
+		failuresByRecord[failure.Record] = append(failuresByRecord[failure.Record], failure)
+	}
+
+	var (
+		ids     = make([]int64, 0, len(records))
+		requeue []database.QueueRecord
+	)
+	for _, record := range records {
+		ids = append(ids, record.Offset)
+		requeue = append(requeue, policy.retryQueued(record, failuresByRecord[record], recordErrs[record])...)
+	}
+
+	return ids, requeue
+}
+
+// retryQueued is HandleIndexFailures for the embedded queue. It returns the
+// records to queue again, available after their backoff, and stores those
+// that spent their attempt budget as dead letters.
+func (p RetryPolicy) retryQueued(record *kgo.Record, failures []IndexFailure, recordErr error) []database.QueueRecord {
+	attempt := recordAttempt(record) + 1
+
+	type retry struct {
+		value          []byte
+		subscriptionId string
+		cause          error
+	}
+	var retries []retry
+
+	if recordErr != nil {
+		if errors.Is(recordErr, ErrMalformedRecord) {
+			attempt = p.MaxAttempts
+		}
+		retries = append(retries, retry{record.Value, recordHeader(record, headerSubscriptionId), recordErr})
+	}
+
+	for _, failure := range failures {
+		value, err := EncodeRecordValue([]WebhookPayload{failure.Payload}, recordSchemaVersion(record))
+		if err != nil {
+			// The payload was decoded from this record, so this cannot
+			// happen unless the schema is broken
+			log.Printf("Failed to encode failed payload %s, err: %v", failure.Payload.Signature, err)
+			value = record.Value
+		}
+		retries = append(retries, retry{value, failure.SubscriptionId, failure.Err})
+	}
+
+	var requeue []database.QueueRecord
+	for _, r := range retries {
+		if attempt >= p.MaxAttempts {
+			storeDeadLetter(record, r.value, r.subscriptionId, attempt, r.cause)
+			continue
+		}
+
+		out := &kgo.Record{
+			Key:   record.Key,
+			Value: r.value,
+			Headers: []kgo.RecordHeader{
+				{Key: headerReceiver, Value: []byte(recordReceiver(record))},
+				{Key: headerTimestamp, Value: []byte(time.Now().Format(time.RFC3339))},
+				{Key: headerAttempt, Value: []byte(strconv.Itoa(attempt))},
+				{Key: headerLastError, Value: []byte(r.cause.Error())},
+				{Key: headerSubscriptionId, Value: []byte(r.subscriptionId)},
+				schemaVersionHeader(recordSchemaVersion(record)),
+			},
+		}
+		requeue = append(requeue, queueRecord(out, time.Now().Add(p.Backoff(attempt))))
+	}
+
+	return requeue
+}
+
+// queueRecord converts a record to its queue_records row.
+func queueRecord(record *kgo.Record, availableAt time.Time) database.QueueRecord {
+	headers := make(map[string]string, len(record.Headers))
+	for _, header := range record.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+
+	return database.QueueRecord{
+		Key:         string(record.Key),
+		Value:       record.Value,
+		Headers:     headers,
+		AvailableAt: availableAt,
+	}
+}
+
+// kafkaRecord converts a queue_records row back to the record it was
+// produced as. Its id stands in for the offset.
+func kafkaRecord(queued database.QueueRecord) *kgo.Record {
+	record := &kgo.Record{
+		Topic:     embeddedTopic,
+		Key:       []byte(queued.Key),
+		Value:     queued.Value,
+		Offset:    queued.Id,
+		Timestamp: queued.CreatedAt,
+	}
+	for key, value := range queued.Headers {
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: key, Value: []byte(value)})
+	}
+
+	return record
+}
@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestQueueRecordRoundTrip(t *testing.T) {
+	record := &kgo.Record{
+		Key:   []byte("mint"),
+		Value: []byte(`[{"signature":"sig"}]`),
+		Headers: []kgo.RecordHeader{
+			{Key: headerReceiver, Value: []byte("webhook-0")},
+			schemaVersionHeader(schemaVersionJSON),
+		},
+	}
+
+	queued := queueRecord(record, time.Time{})
+	queued.Id = 42
+
+	got := kafkaRecord(queued)
+	if string(got.Key) != "mint" || string(got.Value) != string(record.Value) || got.Offset != 42 {
+		t.Errorf("unexpected record %+v", got)
+	}
+	if recordReceiver(got) != "webhook-0" || recordSchemaVersion(got) != schemaVersionJSON {
+		t.Errorf("headers were not kept: %+v", got.Headers)
+	}
+}
+
+func TestRetryQueued(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseBackoff: time.Minute, MaxBackoff: time.Hour}
+	record := &kgo.Record{
+		Key:   []byte("mint"),
+		Value: []byte(`[]`),
+		Headers: []kgo.RecordHeader{
+			{Key: headerReceiver, Value: []byte("webhook-0")},
+			{Key: headerAttempt, Value: []byte("1")},
+		},
+	}
+	failures := []IndexFailure{
+		{SubscriptionId: "sub-1", Payload: WebhookPayload{Signature: "sig-1"}, Record: record, Err: errors.New("down")},
+		{SubscriptionId: "sub-2", Payload: WebhookPayload{Signature: "sig-2"}, Record: record, Err: errors.New("down")},
+	}
+
+	requeue := policy.retryQueued(record, failures, nil)
+	if len(requeue) != 2 {
+		t.Fatalf("expected one retry per failed subscription; got %d", len(requeue))
+	}
+
+	for i, queued := range requeue {
+		if queued.Headers[headerSubscriptionId] != failures[i].SubscriptionId {
+			t.Errorf("retry %d: expected subscription %s; got %s", i, failures[i].SubscriptionId, queued.Headers[headerSubscriptionId])
+		}
+		if queued.Headers[headerAttempt] != "2" {
+			t.Errorf("retry %d: expected attempt 2; got %s", i, queued.Headers[headerAttempt])
+		}
+		if time.Until(queued.AvailableAt) < time.Minute {
+			t.Errorf("retry %d: expected to wait for the backoff; available at %v", i, queued.AvailableAt)
+		}
+
+		payloads, err := decodeRecord(kafkaRecord(database.QueueRecord{Value: queued.Value, Headers: queued.Headers}))
+		if err != nil || len(payloads) != 1 || payloads[0].Signature != failures[i].Payload.Signature {
+			t.Errorf("retry %d: expected only the failed payload; got %+v, %v", i, payloads, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package kafka
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var (
+	// BackfillReceiver is the receiver name backfilled transactions are
+	// produced under.
+	BackfillReceiver = ProviderReceiver("backfill")
+	// CatchUpReceiver is the receiver name of transactions the gap detector
+	// found missing.
+	CatchUpReceiver = ProviderReceiver("catch-up")
+)
+
+// PushBackfillToProducer queues transactions fetched by a backfill job. They
+// only match the subscription the job is for, and carry the job id in the
+// backfill header.
+func PushBackfillToProducer(q Queue, payloads []WebhookPayload, subscriptionId string, jobId string) error {
+	return q.produceFetched(payloads, BackfillReceiver,
+		kgo.RecordHeader{Key: headerSubscriptionId, Value: []byte(subscriptionId)},
+		kgo.RecordHeader{Key: headerBackfill, Value: []byte(jobId)},
+	)
+}
+
+// PushCatchUpToProducer queues transactions of address the worker never
+// received, for every subscription watching it.
+func PushCatchUpToProducer(q Queue, payloads []WebhookPayload, address string) error {
+	return q.produceFetched(payloads, CatchUpReceiver,
+		kgo.RecordHeader{Key: headerCatchUp, Value: []byte(address)},
+	)
+}
+
+// produceFetched produces transactions the indexer fetched itself rather
+// than received, one record per transaction, and waits for them to be
+// acknowledged.
+func (m *KafkaClientManager) produceFetched(payloads []WebhookPayload, receiverName string, headers ...kgo.RecordHeader) error {
+	version, err := producerSchemaVersion()
+	if err != nil {
+		log.Println("Invalid record schema, err: ", err)
+		return err
+	}
+
+	records, err := transactionRecords(payloads, version, receiverName, headers...)
+	if err != nil {
+		log.Println("Invalid Json Payload, err: ", err)
+		return err
+	}
+
+	client, err := m.GetClient()
+	if err != nil {
+		log.Printf("Failed to create Kafka producer: %v", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := client.ProduceSync(ctx, records...).FirstErr(); err != nil {
+		log.Printf("Failed to produce %s records, err: %v", receiverName, err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,490 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+const (
+	fileFormatJSONL   = "jsonl"
+	fileFormatParquet = "parquet"
+
+	defaultFileMaxBytes       = 128 << 20
+	defaultFileRotateInterval = time.Hour
+	defaultFileMaxOpenFiles   = 32
+
+	// Leftover .tmp files are only recovered once no running sink can still
+	// be writing them, i.e. this long past their rotation age
+	fileRecoveryGrace = 2 * time.Minute
+)
+
+func init() {
+	RegisterSink(database.SinkFile, newFileSink)
+}
+
+// fileRow is the Parquet layout of a payload. The full payload is kept as
+// JSON next to the columns most queries filter on.
+type fileRow struct {
+	Signature   string `parquet:"signature"`
+	Slot        int64  `parquet:"slot"`
+	Timestamp   int64  `parquet:"timestamp,timestamp(millisecond)"`
+	Type        string `parquet:"type"`
+	Source      string `parquet:"source"`
+	Fee         int32  `parquet:"fee"`
+	FeePayer    string `parquet:"fee_payer"`
+	Description string `parquet:"description"`
+	Payload     string `parquet:"payload,json"`
+}
+
+// fileSink appends payloads to rotating files under FILE_SINK_DIR, laid out
+// as <user>/<table>/dt=<UTC date>/part-<time>.<format>. Files are written
+// with a .tmp suffix and renamed on rotation, so readers only ever see
+// complete files. Every Write is fsynced before it returns, and .tmp files
+// left behind by a crash are finalised by recoverFiles.
+type fileSink struct {
+	dir            string
+	format         string
+	maxBytes       int64
+	rotateInterval time.Duration
+	maxOpenFiles   int
+
+	mu      sync.Mutex
+	writers map[string]*partitionWriter
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newFileSink(userId string) (Sink, error) {
+	baseDir := os.Getenv("FILE_SINK_DIR")
+	if baseDir == "" {
+		return nil, fmt.Errorf("FILE_SINK_DIR environment variable is not set")
+	}
+
+	format := os.Getenv("FILE_SINK_FORMAT")
+	if format == "" {
+		format = fileFormatJSONL
+	}
+	if format != fileFormatJSONL && format != fileFormatParquet {
+		return nil, fmt.Errorf("unsupported FILE_SINK_FORMAT %q", format)
+	}
+
+	maxBytes, err := strconv.ParseInt(os.Getenv("FILE_SINK_MAX_BYTES"), 10, 64)
+	if err != nil || maxBytes <= 0 {
+		maxBytes = defaultFileMaxBytes
+	}
+	rotateInterval, err := time.ParseDuration(os.Getenv("FILE_SINK_ROTATE_INTERVAL"))
+	if err != nil || rotateInterval <= 0 {
+		rotateInterval = defaultFileRotateInterval
+	}
+	maxOpenFiles, err := strconv.Atoi(os.Getenv("FILE_SINK_MAX_OPEN_FILES"))
+	if err != nil || maxOpenFiles <= 0 {
+		maxOpenFiles = defaultFileMaxOpenFiles
+	}
+
+	return &fileSink{
+		dir:            filepath.Join(baseDir, safePathSegment(userId)),
+		format:         format,
+		maxBytes:       maxBytes,
+		rotateInterval: rotateInterval,
+		maxOpenFiles:   maxOpenFiles,
+		writers:        make(map[string]*partitionWriter),
+		stop:           make(chan struct{}),
+	}, nil
+}
+
+// Prepare creates the user's directory, recovers files left behind by a
+// crash and starts rotating files by age.
+func (s *fileSink) Prepare(ctx context.Context) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	err := s.recoverFiles(time.Now())
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.stopped.Add(1)
+	go s.rotateByAge()
+
+	return nil
+}
+
+// Write appends every payload to the file for its subscription table and
+// transaction date and fsyncs the files before returning. Signatures already
+// written to the open file are skipped, so a retried batch does not
+// duplicate rows within a file. A batch that fails after some of its rows
+// were written, e.g. because a file failed to rotate, is retried into a new
+// file, so rows can repeat across files and readers dedupe on signature.
+func (s *fileSink) Write(ctx context.Context, batch []IndexJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	touched := make(map[*partitionWriter]bool)
+	for _, job := range batch {
+		date := time.Unix(job.Payload.Timestamp, 0).UTC().Format(time.DateOnly)
+		partition := filepath.Join(safePathSegment(job.Subscription.TableName), "dt="+date)
+
+		writer, err := s.writerFor(partition)
+		if err != nil {
+			return err
+		}
+
+		if err = writer.write(job.Payload); err != nil {
+			return err
+		}
+		touched[writer] = true
+	}
+
+	for writer := range touched {
+		// Rotated to make room for another partition, already synced
+		if s.writers[writer.partition] != writer {
+			continue
+		}
+		if err := writer.flush(); err != nil {
+			return err
+		}
+		if err := writer.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync %s: %w", writer.path, err)
+		}
+		if writer.size >= s.maxBytes {
+			if err := s.rotate(writer); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close stops the rotation loop and finalises every open file.
+func (s *fileSink) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.stopped.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for _, writer := range s.writers {
+		if err := s.rotate(writer); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (s *fileSink) rotateByAge() {
+	defer s.stopped.Done()
+
+	ticker := time.NewTicker(min(s.rotateInterval, time.Minute))
+	defer ticker.Stop()
+
+	lastRecovery := time.Now()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for _, writer := range s.writers {
+				if now.Sub(writer.openedAt) >= s.rotateInterval {
+					if err := s.rotate(writer); err != nil {
+						log.Printf("Failed to rotate %s, err: %v", writer.path, err)
+					}
+				}
+			}
+			// Files of a crashed worker too young to recover on Prepare
+			if now.Sub(lastRecovery) >= s.rotateInterval {
+				if err := s.recoverFiles(now); err != nil {
+					log.Printf("Failed to recover files under %s, err: %v", s.dir, err)
+				}
+				lastRecovery = now
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+var tmpFileName = regexp.MustCompile(`^part-(\d{8}T\d{6})-\d+\.(jsonl|parquet)\.tmp$`)
+
+// recoverFiles finalises the .tmp files no sink can still be writing: they
+// were opened longer ago than files live before rotation. A JSONL file is
+// cut after its last complete line and renamed. A Parquet file only has its
+// footer once closed, so one without it is renamed to .corrupt and its time
+// range has to be replayed. Callers must hold s.mu.
+func (s *fileSink) recoverFiles(now time.Time) error {
+	open := make(map[string]bool, len(s.writers))
+	for _, writer := range s.writers {
+		open[writer.path+".tmp"] = true
+	}
+
+	return filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		match := tmpFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil || open[path] {
+			return nil
+		}
+		openedAt, err := time.Parse("20060102T150405", match[1])
+		if err != nil || now.Sub(openedAt) < s.rotateInterval+fileRecoveryGrace {
+			return nil
+		}
+
+		final := strings.TrimSuffix(path, ".tmp")
+		if match[2] == fileFormatParquet {
+			if err := checkParquetFile(path); err != nil {
+				log.Printf("Quarantining unreadable file %s, err: %v", path, err)
+				return os.Rename(path, final+".corrupt")
+			}
+		} else if err := truncatePartialLine(path); err != nil {
+			return fmt.Errorf("failed to recover %s: %w", path, err)
+		}
+
+		log.Printf("Recovered file %s", final)
+		return os.Rename(path, final)
+	})
+}
+
+// checkParquetFile returns an error unless the file has a readable footer.
+func checkParquetFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = parquet.OpenFile(file, info.Size())
+	return err
+}
+
+// truncatePartialLine drops whatever follows the last newline of a file,
+// the part of a line a crash cut short, and fsyncs the file.
+func truncatePartialLine(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	end := info.Size()
+	block := make([]byte, 64<<10)
+	for end > 0 {
+		start := max(end-int64(len(block)), 0)
+		n, err := file.ReadAt(block[:end-start], start)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if i := bytes.LastIndexByte(block[:n], '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+
+	if end < info.Size() {
+		if err := file.Truncate(end); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
+
+// writerFor returns the open file for a partition, opening a new one if
+// needed. Callers must hold s.mu.
+func (s *fileSink) writerFor(partition string) (*partitionWriter, error) {
+	if writer, ok := s.writers[partition]; ok {
+		return writer, nil
+	}
+
+	// A backfill spanning many dates would otherwise hold a file open per date
+	if len(s.writers) >= s.maxOpenFiles {
+		if err := s.rotate(s.leastRecentlyUsed()); err != nil {
+			return nil, err
+		}
+	}
+
+	dir := filepath.Join(s.dir, partition)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	path := filepath.Join(dir, fmt.Sprintf("part-%s-%d.%s", now.Format("20060102T150405"), now.UnixNano()%1e9, s.format))
+
+	writer, err := openPartitionWriter(path, s.format)
+	if err != nil {
+		return nil, err
+	}
+	writer.partition = partition
+	s.writers[partition] = writer
+
+	return writer, nil
+}
+
+// leastRecentlyUsed returns the open file written to longest ago. Callers
+// must hold s.mu.
+func (s *fileSink) leastRecentlyUsed() *partitionWriter {
+	var oldest *partitionWriter
+	for _, writer := range s.writers {
+		if oldest == nil || writer.usedAt.Before(oldest.usedAt) {
+			oldest = writer
+		}
+	}
+	return oldest
+}
+
+// rotate fsyncs and closes a file and moves it to its final name. Callers
+// must hold s.mu.
+func (s *fileSink) rotate(writer *partitionWriter) error {
+	delete(s.writers, writer.partition)
+
+	if err := writer.close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", writer.path, err)
+	}
+
+	return os.Rename(writer.path+".tmp", writer.path)
+}
+
+// partitionWriter is one open output file.
+type partitionWriter struct {
+	partition  string
+	path       string
+	file       *os.File
+	buffered   *bufio.Writer
+	parquet    *parquet.GenericWriter[fileRow]
+	size       int64
+	openedAt   time.Time
+	usedAt     time.Time
+	signatures map[string]bool
+}
+
+func openPartitionWriter(path string, format string) (*partitionWriter, error) {
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &partitionWriter{
+		path:       path,
+		file:       file,
+		openedAt:   time.Now(),
+		usedAt:     time.Now(),
+		signatures: make(map[string]bool),
+	}
+
+	if format == fileFormatParquet {
+		writer.parquet = parquet.NewGenericWriter[fileRow](file, parquet.Compression(&parquet.Zstd))
+	} else {
+		writer.buffered = bufio.NewWriter(file)
+	}
+
+	return writer, nil
+}
+
+func (w *partitionWriter) write(payload WebhookPayload) error {
+	w.usedAt = time.Now()
+	if w.signatures[payload.Signature] {
+		return nil
+	}
+
+	jsonBlob, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if w.parquet != nil {
+		_, err = w.parquet.Write([]fileRow{{
+			Signature:   payload.Signature,
+			Slot:        payload.Slot,
+			Timestamp:   payload.Timestamp * 1000,
+			Type:        payload.Type,
+			Source:      payload.Source,
+			Fee:         payload.Fee,
+			FeePayer:    payload.FeePayer,
+			Description: payload.Description,
+			Payload:     string(jsonBlob),
+		}})
+	} else {
+		_, err = w.buffered.Write(append(jsonBlob, '\n'))
+	}
+	if err != nil {
+		return err
+	}
+
+	w.signatures[payload.Signature] = true
+	w.size += int64(len(jsonBlob)) + 1
+	return nil
+}
+
+// flush pushes the rows of a batch to the file, as buffered JSONL lines or
+// as one Parquet row group.
+func (w *partitionWriter) flush() error {
+	if w.parquet != nil {
+		return w.parquet.Flush()
+	}
+	return w.buffered.Flush()
+}
+
+func (w *partitionWriter) close() error {
+	if w.parquet != nil {
+		if err := w.parquet.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+	} else if err := w.buffered.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// safePathSegment turns a table name or id into a single directory name.
+func safePathSegment(name string) string {
+	segment := unsafePathChars.ReplaceAllString(name, "_")
+	if segment == "" || segment == "." || segment == ".." {
+		return "_"
+	}
+	return segment
+}
@@ -0,0 +1,180 @@
+package kafka
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+func fileSinkJobs() []IndexJob {
+	subscription := database.SubscriptionLookup{Id: "sub-1", TableName: "bonk"}
+	return []IndexJob{
+		{Subscription: subscription, Payload: WebhookPayload{Signature: "sig-1", Timestamp: 1700000000, Type: "SWAP"}},
+		{Subscription: subscription, Payload: WebhookPayload{Signature: "sig-2", Timestamp: 1700000000, Type: "SWAP"}},
+		{Subscription: subscription, Payload: WebhookPayload{Signature: "sig-1", Timestamp: 1700000000, Type: "SWAP"}},
+	}
+}
+
+func writeFileSink(t *testing.T, format string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("FILE_SINK_DIR", dir)
+	t.Setenv("FILE_SINK_FORMAT", format)
+
+	sink, err := newFileSink("user/1")
+	if err != nil {
+		t.Fatalf("failed to create file sink: %v", err)
+	}
+	if err = sink.Prepare(context.Background()); err != nil {
+		t.Fatalf("failed to prepare file sink: %v", err)
+	}
+	if err = sink.Write(context.Background(), fileSinkJobs()); err != nil {
+		t.Fatalf("failed to write batch: %v", err)
+	}
+	if err = sink.Close(); err != nil {
+		t.Fatalf("failed to close file sink: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "user_1", "bonk", "dt=2023-11-14", "part-*."+format))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one rotated %s file; got %v (err %v)", format, files, err)
+	}
+
+	return files[0]
+}
+
+func TestFileSinkJSONL(t *testing.T) {
+	path := writeFileSink(t, fileFormatJSONL)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var signatures []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var payload WebhookPayload
+		if err := json.Unmarshal(scanner.Bytes(), &payload); err != nil {
+			t.Fatalf("invalid JSONL line %q: %v", scanner.Text(), err)
+		}
+		signatures = append(signatures, payload.Signature)
+	}
+
+	if len(signatures) != 2 || signatures[0] != "sig-1" || signatures[1] != "sig-2" {
+		t.Errorf("expected signatures [sig-1 sig-2] without duplicates; got %v", signatures)
+	}
+}
+
+func TestFileSinkParquet(t *testing.T) {
+	path := writeFileSink(t, fileFormatParquet)
+
+	rows, err := parquet.ReadFile[fileRow](path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+
+	if len(rows) != 2 || rows[0].Signature != "sig-1" || rows[1].Type != "SWAP" {
+		t.Errorf("expected two deduplicated rows; got %+v", rows)
+	}
+}
+
+func TestFileSinkRecoversLeftoverFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FILE_SINK_DIR", dir)
+
+	partition := filepath.Join(dir, "user_1", "bonk", "dt=2023-11-14")
+	if err := os.MkdirAll(partition, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	recent := fmt.Sprintf("part-%s-1.jsonl.tmp", time.Now().UTC().Format("20060102T150405"))
+	files := map[string]string{
+		// A crash cut the second line short
+		"part-20231114T000000-1.jsonl.tmp": "{\"signature\":\"sig-1\"}\n{\"signa",
+		// Parquet without its footer
+		"part-20231114T000000-2.parquet.tmp": "PAR1 row group",
+		// Possibly still written by another worker
+		recent: "{\"signature\":\"sig-2\"}\n",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(partition, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink, err := newFileSink("user/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sink.Prepare(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	recovered, err := os.ReadFile(filepath.Join(partition, "part-20231114T000000-1.jsonl"))
+	if err != nil {
+		t.Fatalf("expected the JSONL file to be recovered: %v", err)
+	}
+	if string(recovered) != "{\"signature\":\"sig-1\"}\n" {
+		t.Errorf("expected the partial line to be cut; got %q", recovered)
+	}
+	if _, err := os.Stat(filepath.Join(partition, "part-20231114T000000-2.parquet.corrupt")); err != nil {
+		t.Errorf("expected the unreadable Parquet file to be quarantined: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(partition, recent)); err != nil {
+		t.Errorf("a recent file was recovered while it may still be written: %v", err)
+	}
+}
+
+func TestFileSinkCapsOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FILE_SINK_DIR", dir)
+	t.Setenv("FILE_SINK_MAX_OPEN_FILES", "2")
+
+	sink, err := newFileSink("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sink.Prepare(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A backfill spanning three days
+	subscription := database.SubscriptionLookup{Id: "sub-1", TableName: "bonk"}
+	var jobs []IndexJob
+	for day := range 3 {
+		jobs = append(jobs, IndexJob{Subscription: subscription, Payload: WebhookPayload{
+			Signature: fmt.Sprintf("sig-%d", day),
+			Timestamp: 1700000000 + int64(day)*86400,
+		}})
+	}
+	if err = sink.Write(context.Background(), jobs); err != nil {
+		t.Fatal(err)
+	}
+
+	if open := len(sink.(*fileSink).writers); open != 2 {
+		t.Errorf("expected at most 2 open files; got %d", open)
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, "user-1", "bonk", "dt=2023-11-14", "part-*.jsonl"))
+	if len(rotated) != 1 {
+		t.Errorf("expected the least recently used file to be rotated; got %v", rotated)
+	}
+
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	final, _ := filepath.Glob(filepath.Join(dir, "user-1", "bonk", "dt=*", "part-*.jsonl"))
+	if len(final) != 3 {
+		t.Errorf("expected a file per day; got %v", final)
+	}
+}
@@ -0,0 +1,137 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Destination URLs are chosen by users, so the indexer must not be usable to
+// reach the services next to it. A destination has to resolve to public
+// addresses only, which is checked when the URL is saved and again on every
+// connection, as the DNS answer can change in between.
+
+// ValidateDestinationURL checks that rawURL is an absolute http or https URL
+// whose host only resolves to public addresses.
+func ValidateDestinationURL(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Hostname() == "" {
+		return fmt.Errorf("destination_url must be an absolute http or https url")
+	}
+
+	host := parsed.Hostname()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("destination_url host %s could not be resolved", host)
+	}
+
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return fmt.Errorf("destination_url host %s resolves to a non public address", host)
+		}
+	}
+
+	return nil
+}
+
+// deniedPrefixes are the special-purpose ranges the netip predicates miss,
+// none of which is reachable on the internet.
+var deniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // This network
+	netip.MustParsePrefix("100.64.0.0/10"),   // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // Documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // Benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // Documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // Documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // Reserved and broadcast
+	netip.MustParsePrefix("::/96"),           // IPv4-compatible
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // Local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // Discard
+	netip.MustParsePrefix("2001::/32"),       // Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // Documentation
+}
+
+var (
+	// NAT64 addresses end with the IPv4 address they are translated to
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	// 6to4 addresses hold the IPv4 address of their relay in bits 16 to 48
+	sixToFourPrefix = netip.MustParsePrefix("2002::/16")
+)
+
+// publicAddr reports whether addr can be reached on the internet, as
+// opposed to loopback, link-local (e.g. cloud metadata), private,
+// unspecified and other special-purpose addresses. IPv6 addresses that
+// embed an IPv4 address are only public if it is.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() ||
+		addr.IsLoopback() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() ||
+		addr.IsPrivate() ||
+		addr.IsUnspecified() {
+		return false
+	}
+
+	for _, prefix := range deniedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	b := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return publicAddr(netip.AddrFrom4([4]byte(b[12:16])))
+	case sixToFourPrefix.Contains(addr):
+		return publicAddr(netip.AddrFrom4([4]byte(b[2:6])))
+	}
+
+	return true
+}
+
+// dialPublicOnly is a net.Dialer Control that refuses connections to non
+// public addresses. It runs after DNS resolution, so a destination that
+// resolved to a public address when it was saved cannot be rebound.
+func dialPublicOnly(network string, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("refusing to dial %s: %w", address, err)
+	}
+	if !publicAddr(addrPort.Addr()) {
+		return fmt.Errorf("refusing to dial non public address %s", address)
+	}
+	return nil
+}
+
+// newDestinationClient returns the client deliveries are sent with. It
+// ignores proxy settings, whose address would be checked instead of the
+// destination's, and redirects go through the same dialer.
+func newDestinationClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   dialPublicOnly,
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
@@ -0,0 +1,250 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+const (
+	HeaderDeliveryId        = "X-Indexer-Delivery-Id"
+	HeaderDeliveryTimestamp = "X-Indexer-Timestamp"
+	HeaderDeliverySignature = "X-Indexer-Signature"
+
+	defaultHTTPSinkTimeout     = 10 * time.Second
+	defaultHTTPSinkConcurrency = 8
+)
+
+func init() {
+	RegisterSink(database.SinkHTTP, newHTTPSink)
+}
+
+// deliveryStore is the part of database.Service the http sink needs.
+type deliveryStore interface {
+	GetSigningSecret(userId string) (string, error)
+	UpsertHttpDelivery(delivery database.HttpDelivery) error
+	GetDeliveredHttpDeliveryIds(ids []string) (map[string]bool, error)
+}
+
+// httpDeliveryBody is what the user's endpoint receives for every matched
+// transaction.
+type httpDeliveryBody struct {
+	DeliveryId     string         `json:"delivery_id"`
+	SubscriptionId string         `json:"subscription_id"`
+	TokenAddress   string         `json:"token_address"`
+	Strategy       string         `json:"strategy"`
+	Transaction    WebhookPayload `json:"transaction"`
+}
+
+// httpSink POSTs every transaction to the subscription's destination URL,
+// signed with the user's signing secret. Each attempt is logged in
+// http_deliveries so users can see what was sent and what failed.
+type httpSink struct {
+	userId      string
+	client      *http.Client
+	store       deliveryStore
+	timeout     time.Duration
+	concurrency int
+}
+
+func newHTTPSink(userId string) (Sink, error) {
+	timeout, err := time.ParseDuration(os.Getenv("HTTP_SINK_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = defaultHTTPSinkTimeout
+	}
+	concurrency, err := strconv.Atoi(os.Getenv("HTTP_SINK_CONCURRENCY"))
+	if err != nil || concurrency <= 0 {
+		concurrency = defaultHTTPSinkConcurrency
+	}
+
+	return &httpSink{
+		userId:      userId,
+		client:      newDestinationClient(timeout),
+		store:       database.New(),
+		timeout:     timeout,
+		concurrency: concurrency,
+	}, nil
+}
+
+// Prepare checks the user has a signing secret, nothing is sent unsigned.
+func (s *httpSink) Prepare(ctx context.Context) error {
+	_, err := s.store.GetSigningSecret(s.userId)
+	return err
+}
+
+// Write delivers the batch with at most concurrency requests in flight, each
+// given the sink's timeout. Deliveries that already succeeded are skipped,
+// so a batch retried from Kafka only resends what failed. A failed delivery
+// is attempted once and returned in JobErrors, it is retried through the
+// retry topic rather than here so that a slow endpoint does not hold up the
+// worker.
+func (s *httpSink) Write(ctx context.Context, batch []IndexJob) error {
+	// Looked up per batch so a rotated secret is used straight away
+	secret, err := s.store.GetSigningSecret(s.userId)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, len(batch))
+	for i, job := range batch {
+		ids[i] = httpDeliveryId(subscriptionRef(job.Subscription), job.Payload.Signature)
+	}
+	delivered, err := s.store.GetDeliveredHttpDeliveryIds(ids)
+	if err != nil {
+		return err
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		failed = make(JobErrors)
+		slots  = make(chan struct{}, s.concurrency)
+	)
+	for i, job := range batch {
+		if delivered[ids[i]] {
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			mu.Lock()
+			failed[i] = ctx.Err()
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, job IndexJob) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if err := s.deliverJob(ctx, secret, ids[i], job); err != nil {
+				mu.Lock()
+				failed[i] = err
+				mu.Unlock()
+			}
+		}(i, job)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return failed
+	}
+
+	return nil
+}
+
+// Close is a no-op, the http client holds nothing that needs releasing.
+func (s *httpSink) Close() error {
+	return nil
+}
+
+func (s *httpSink) deliverJob(ctx context.Context, secret string, deliveryId string, job IndexJob) error {
+	subscriptionId := subscriptionRef(job.Subscription)
+
+	if job.Subscription.DestinationURL == nil || *job.Subscription.DestinationURL == "" {
+		return fmt.Errorf("subscription %s has no destination url", subscriptionId)
+	}
+	url := *job.Subscription.DestinationURL
+
+	body, err := json.Marshal(httpDeliveryBody{
+		DeliveryId:     deliveryId,
+		SubscriptionId: subscriptionId,
+		TokenAddress:   job.Subscription.TokenAddress,
+		Strategy:       string(job.Subscription.Strategy),
+		Transaction:    job.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	responseStatus, deliverErr := s.post(ctx, url, secret, deliveryId, body)
+
+	now := time.Now()
+	delivery := database.HttpDelivery{
+		Id:             deliveryId,
+		UserId:         s.userId,
+		SubscriptionId: subscriptionId,
+		Signature:      job.Payload.Signature,
+		URL:            url,
+		Status:         database.HttpDeliveryDelivered,
+		Attempts:       1,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if responseStatus != 0 {
+		delivery.ResponseStatus = &responseStatus
+	}
+	if deliverErr != nil {
+		lastError := deliverErr.Error()
+		delivery.Status = database.HttpDeliveryFailed
+		delivery.LastError = &lastError
+	}
+
+	if err := s.store.UpsertHttpDelivery(delivery); err != nil {
+		// The receiver can dedupe on the delivery id if this leads to a resend
+		log.Printf("Failed to log http delivery %s, err: %v", deliveryId, err)
+	}
+
+	return deliverErr
+}
+
+// post sends body to url once and returns the response status.
+func (s *httpSink) post(ctx context.Context, url string, secret string, deliveryId string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDeliveryId, deliveryId)
+	req.Header.Set(HeaderDeliveryTimestamp, timestamp)
+	req.Header.Set(HeaderDeliverySignature, "sha256="+SignDelivery(secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, nil
+	}
+
+	return resp.StatusCode, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+}
+
+// SignDelivery returns the hex HMAC-SHA256 of "<timestamp>.<body>" under the
+// user's signing secret. Receivers recompute it to verify a delivery.
+func SignDelivery(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// httpDeliveryId is stable for a subscription and transaction, so retries
+// of the same transaction reuse one id and receivers can dedupe on it.
+func httpDeliveryId(subscriptionId string, signature string) string {
+	sum := sha256.Sum256([]byte(subscriptionId + ":" + signature))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,286 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+type fakeDeliveryStore struct {
+	mu         sync.Mutex
+	secret     string
+	deliveries map[string]database.HttpDelivery
+}
+
+func (f *fakeDeliveryStore) GetSigningSecret(userId string) (string, error) {
+	return f.secret, nil
+}
+
+func (f *fakeDeliveryStore) UpsertHttpDelivery(delivery database.HttpDelivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if existing, ok := f.deliveries[delivery.Id]; ok {
+		delivery.Attempts += existing.Attempts
+	}
+	f.deliveries[delivery.Id] = delivery
+	return nil
+}
+
+func (f *fakeDeliveryStore) GetDeliveredHttpDeliveryIds(ids []string) (map[string]bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delivered := make(map[string]bool)
+	for _, id := range ids {
+		if f.deliveries[id].Status == database.HttpDeliveryDelivered {
+			delivered[id] = true
+		}
+	}
+	return delivered, nil
+}
+
+func TestHTTPSinkSignsAndResendsFailures(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		received []httpDeliveryBody
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+
+		body, _ := io.ReadAll(r.Body)
+		expected := "sha256=" + SignDelivery("secret", r.Header.Get(HeaderDeliveryTimestamp), body)
+		if r.Header.Get(HeaderDeliverySignature) != expected {
+			t.Errorf("bad signature %q; want %q", r.Header.Get(HeaderDeliverySignature), expected)
+		}
+
+		// Fail the first request to exercise the resend
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var delivery httpDeliveryBody
+		if err := json.Unmarshal(body, &delivery); err != nil {
+			t.Errorf("failed to decode delivery: %v", err)
+		}
+		if r.Header.Get(HeaderDeliveryId) != delivery.DeliveryId {
+			t.Errorf("delivery id header %q does not match body %q", r.Header.Get(HeaderDeliveryId), delivery.DeliveryId)
+		}
+		received = append(received, delivery)
+	}))
+	defer server.Close()
+
+	store := &fakeDeliveryStore{secret: "secret", deliveries: make(map[string]database.HttpDelivery)}
+	sink := &httpSink{
+		userId:      "user-1",
+		client:      server.Client(),
+		store:       store,
+		timeout:     time.Second,
+		concurrency: 2,
+	}
+
+	url := server.URL
+	subscription := database.SubscriptionLookup{Id: "sub-1", DestinationURL: &url}
+	batch := []IndexJob{
+		{Subscription: subscription, Payload: WebhookPayload{Signature: "sig-1"}},
+		{Subscription: subscription, Payload: WebhookPayload{Signature: "sig-2"}},
+	}
+
+	// Failures are not retried inline, only the failed job is reported
+	err := sink.Write(context.Background(), batch)
+	var jobErrs JobErrors
+	if !errors.As(err, &jobErrs) || len(jobErrs) != 1 {
+		t.Fatalf("expected one failed job; got %v", err)
+	}
+
+	// Delivered transactions are not sent again when a batch is retried
+	if err := sink.Write(context.Background(), batch); err != nil {
+		t.Fatalf("second write failed: %v", err)
+	}
+
+	if requests != 3 || len(received) != 2 {
+		t.Fatalf("expected 3 requests and 2 deliveries; got %d and %d", requests, len(received))
+	}
+
+	attempts := 0
+	for _, signature := range []string{"sig-1", "sig-2"} {
+		delivery := store.deliveries[httpDeliveryId("sub-1", signature)]
+		if delivery.Status != database.HttpDeliveryDelivered {
+			t.Errorf("expected %s delivered; got %s", signature, delivery.Status)
+		}
+		attempts += delivery.Attempts
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts logged; got %d", attempts)
+	}
+}
+
+func TestHTTPSinkLogsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	store := &fakeDeliveryStore{secret: "secret", deliveries: make(map[string]database.HttpDelivery)}
+	sink := &httpSink{
+		userId:      "user-1",
+		client:      server.Client(),
+		store:       store,
+		timeout:     time.Second,
+		concurrency: 2,
+	}
+
+	url := server.URL
+	batch := []IndexJob{{
+		Subscription: database.SubscriptionLookup{Id: "sub-1", DestinationURL: &url},
+		Payload:      WebhookPayload{Signature: "sig-1"},
+	}}
+
+	if err := sink.Write(context.Background(), batch); err == nil {
+		t.Fatal("expected write to fail")
+	}
+
+	delivery := store.deliveries[httpDeliveryId("sub-1", "sig-1")]
+	if delivery.Status != database.HttpDeliveryFailed || delivery.Attempts != 1 {
+		t.Errorf("expected one failed attempt; got %s after %d", delivery.Status, delivery.Attempts)
+	}
+	if delivery.ResponseStatus == nil || *delivery.ResponseStatus != http.StatusBadRequest {
+		t.Errorf("expected response status 400; got %v", delivery.ResponseStatus)
+	}
+}
+
+func TestHTTPSinkBoundsSlowEndpoints(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	store := &fakeDeliveryStore{secret: "secret", deliveries: make(map[string]database.HttpDelivery)}
+	sink := &httpSink{
+		userId:      "user-1",
+		client:      server.Client(),
+		store:       store,
+		timeout:     50 * time.Millisecond,
+		concurrency: 2,
+	}
+
+	url := server.URL
+	var batch []IndexJob
+	for _, signature := range []string{"sig-1", "sig-2", "sig-3", "sig-4"} {
+		batch = append(batch, IndexJob{
+			Subscription: database.SubscriptionLookup{Id: "sub-1", DestinationURL: &url},
+			Payload:      WebhookPayload{Signature: signature},
+		})
+	}
+
+	start := time.Now()
+	err := sink.Write(context.Background(), batch)
+	elapsed := time.Since(start)
+
+	var jobErrs JobErrors
+	if !errors.As(err, &jobErrs) || len(jobErrs) != len(batch) {
+		t.Fatalf("expected every delivery to time out; got %v", err)
+	}
+	// Two deliveries at a time take two timeouts, and no more
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected two rounds of the delivery timeout; took %v", elapsed)
+	}
+}
+
+func TestValidateDestinationURL(t *testing.T) {
+	cases := map[string]bool{
+		"https://93.184.216.34/hook":               true,
+		"http://127.0.0.1:8080/hook":               false,
+		"http://169.254.169.254/latest/meta-data/": false,
+		"http://10.0.0.5/hook":                     false,
+		"http://192.168.1.1/hook":                  false,
+		"http://[::1]/hook":                        false,
+		"http://[::ffff:127.0.0.1]/hook":           false,
+		"http://0.0.0.0/hook":                      false,
+		"http://100.64.0.1/hook":                   false,
+		"http://[64:ff9b::a9fe:a9fe]/hook":         false,
+		"ftp://93.184.216.34/hook":                 false,
+		"/hook":                                    false,
+	}
+
+	for rawURL, valid := range cases {
+		err := ValidateDestinationURL(context.Background(), rawURL)
+		if (err == nil) != valid {
+			t.Errorf("%s: expected valid %t; got %v", rawURL, valid, err)
+		}
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	cases := map[string]bool{
+		"93.184.216.34":          true,
+		"2606:2800:220:1::":      true,
+		"0.1.2.3":                false,
+		"100.64.0.1":             false,
+		"100.127.255.254":        false,
+		"192.0.0.8":              false,
+		"192.0.2.1":              false,
+		"198.18.0.1":             false,
+		"198.19.255.255":         false,
+		"203.0.113.7":            false,
+		"240.0.0.1":              false,
+		"255.255.255.255":        false,
+		"::ffff:100.64.0.1":      false,
+		"::10.0.0.1":             false,
+		"64:ff9b::93.184.216.34": true,
+		"64:ff9b::127.0.0.1":     false,
+		"64:ff9b::10.0.0.1":      false,
+		"64:ff9b::a9fe:a9fe":     false,
+		"64:ff9b:1::1":           false,
+		"2002:5db8:d822::1":      true,
+		"2002:7f00:1::1":         false,
+		"2001:0:4136:e378::1":    false,
+		"2001:db8::1":            false,
+		"fd00::1":                false,
+		"fe80::1":                false,
+	}
+
+	for raw, public := range cases {
+		if got := publicAddr(netip.MustParseAddr(raw)); got != public {
+			t.Errorf("%s: expected public %t; got %t", raw, public, got)
+		}
+	}
+}
+
+func TestHTTPSinkRefusesPrivateAddresses(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	// The dial check also stops a destination that resolves to a private
+	// address only after it was validated
+	resp, err := newDestinationClient(time.Second).Post(server.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected the loopback destination to be refused")
+	}
+	if requested {
+		t.Error("the loopback destination was reached")
+	}
+}
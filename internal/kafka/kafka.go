@@ -1,16 +1,28 @@
 package kafka
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"os"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
+const (
+	consumerGroup      = "webhook-payload-1"
+	retryConsumerGroup = "webhook-payload-1-retry"
+)
+
+const defaultProducerBuffer = 10000
+
 type KafkaClientManager struct {
 	client *kgo.Client
+	err    error
 	once   sync.Once
 }
 
@@ -19,34 +31,87 @@ func NewKafkaClientManager() *KafkaClientManager {
 	return &KafkaClientManager{}
 }
 
-// GetClient returns a singleton Kafka client
+// GetClient returns a singleton Kafka client. It is shared by every caller
+// and buffers at most KAFKA_PRODUCER_BUFFER records (default 10000), so it
+// must only be closed with Close.
 func (m *KafkaClientManager) GetClient() (*kgo.Client, error) {
-	var err error
 	m.once.Do(func() {
 		// Read Kafka configuration from environment variables
 		kafkaURL := os.Getenv("KAFKA_URL")
 		if kafkaURL == "" {
-			err = fmt.Errorf("KAFKA_URL environment variable is not set")
+			m.err = fmt.Errorf("KAFKA_URL environment variable is not set")
 			return
 		}
 
+		buffer, err := strconv.Atoi(os.Getenv("KAFKA_PRODUCER_BUFFER"))
+		if err != nil || buffer <= 0 {
+			buffer = defaultProducerBuffer
+		}
+
 		// Configure Kafka client options
 		opts := []kgo.Opt{
 			kgo.SeedBrokers(kafkaURL),
-			kgo.ConsumerGroup("webhook-payload-1"),
-			kgo.RecordPartitioner(kgo.RoundRobinPartitioner()),
+			kgo.ConsumerGroup(consumerGroup),
+			// Records are keyed by address, see PartitionKey
+			kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
 			kgo.ProducerBatchCompression(kgo.SnappyCompression()),
 			kgo.ProduceRequestTimeout(10 * time.Second),
 			kgo.RequiredAcks(kgo.AllISRAcks()),
+			kgo.MaxBufferedRecords(buffer),
 		}
 
 		// Create Kafka client
-		m.client, err = kgo.NewClient(opts...)
+		m.client, m.err = kgo.NewClient(opts...)
 	})
 
-	if err != nil {
-		return nil, err
+	if m.err != nil {
+		return nil, m.err
 	}
 
 	return m.client, nil
 }
+
+// Close waits up to 10 seconds for buffered records to be acknowledged and
+// closes the client. It is meant for shutdown, the manager cannot produce
+// afterwards.
+func (m *KafkaClientManager) Close() {
+	m.once.Do(func() {
+		m.err = fmt.Errorf("kafka client is closed")
+	})
+	if m.client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := m.client.Flush(ctx); err != nil {
+		log.Printf("Failed to flush the Kafka producer, err: %v", err)
+	}
+	m.client.Close()
+}
+
+// NewConsumerClient creates a member of group consuming topics. Offsets are
+// never committed automatically, and rebalances are held off while a poll
+// is being processed so a batch is committed before its partitions can move
+// to another member.
+func NewConsumerClient(group string, topics ...string) (*kgo.Client, error) {
+	kafkaURL := os.Getenv("KAFKA_URL")
+	if kafkaURL == "" {
+		return nil, fmt.Errorf("KAFKA_URL environment variable is not set")
+	}
+
+	if len(topics) == 0 || slices.Contains(topics, "") {
+		return nil, fmt.Errorf("consumer topic for group %s is not set", group)
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(kafkaURL),
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeTopics(topics...),
+		kgo.DisableAutoCommit(),
+		kgo.BlockRebalanceOnPoll(),
+	}
+
+	return kgo.NewClient(opts...)
+}
@@ -0,0 +1,95 @@
+package kafka
+
+import (
+	"os"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Every transaction is produced as its own record, keyed by the address it
+// is primarily about. The client hashes keys to partitions, so the
+// transactions of a token are consumed in the order they were produced.
+
+// PartitionKey returns the address a transaction is primarily about: the
+// NFT of an NFT event, else the first mint it moves, else its fee payer.
+func PartitionKey(payload WebhookPayload) string {
+	if mint := nftEventMint(payload.Events); mint != "" {
+		return mint
+	}
+
+	for _, transfer := range payload.TokenTransfers {
+		if transfer.Mint != "" {
+			return transfer.Mint
+		}
+	}
+
+	for _, accountData := range payload.AccountData {
+		for _, change := range accountData.TokenBalanceChanges {
+			if change.Mint != "" {
+				return change.Mint
+			}
+		}
+	}
+
+	if payload.FeePayer != "" {
+		return payload.FeePayer
+	}
+	return payload.Signature
+}
+
+// nftEventMint returns the mint of the first NFT in events.nft, which Helius
+// sets for bids, listings and sales.
+func nftEventMint(events map[string]interface{}) string {
+	nftEvent, ok := events["nft"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	nfts, ok := nftEvent["nfts"].([]interface{})
+	if !ok || len(nfts) == 0 {
+		return ""
+	}
+	nft, ok := nfts[0].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	mint, _ := nft["mint"].(string)
+	return mint
+}
+
+// transactionRecords returns one record per payload, keyed by its
+// PartitionKey and encoded with the given schema version.
+func transactionRecords(payloads []WebhookPayload, version int, receiverName string, headers ...kgo.RecordHeader) ([]*kgo.Record, error) {
+	topic := os.Getenv("KAFKA_TOPIC")
+	timestamp := []byte(time.Now().Format(time.RFC3339))
+
+	records := make([]*kgo.Record, 0, len(payloads))
+	for _, payload := range payloads {
+		value, err := EncodeRecordValue([]WebhookPayload{payload}, version)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, &kgo.Record{
+			Topic: topic,
+			Key:   []byte(PartitionKey(payload)),
+			Value: value,
+			Headers: append([]kgo.RecordHeader{
+				{Key: headerReceiver, Value: []byte(receiverName)},
+				{Key: headerTimestamp, Value: timestamp},
+				schemaVersionHeader(version),
+			}, headers...),
+		})
+	}
+
+	return records, nil
+}
+
+// recordReceiver returns the receiver a record was produced for. Records
+// produced before they were keyed by address carry it only as their key.
+func recordReceiver(record *kgo.Record) string {
+	if receiverName := recordHeader(record, headerReceiver); receiverName != "" {
+		return receiverName
+	}
+	return string(record.Key)
+}
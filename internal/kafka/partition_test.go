@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestPartitionKey(t *testing.T) {
+	cases := map[string]WebhookPayload{
+		"nft-mint": {
+			Events: map[string]interface{}{
+				"nft": map[string]interface{}{
+					"nfts": []interface{}{map[string]interface{}{"mint": "nft-mint"}},
+				},
+			},
+			TokenTransfers: []TokenTransfer{{Mint: "sol-mint"}},
+		},
+		"transfer-mint": {
+			TokenTransfers: []TokenTransfer{{Mint: ""}, {Mint: "transfer-mint"}},
+			FeePayer:       "payer",
+		},
+		"balance-mint": {
+			AccountData: []AccountData{{TokenBalanceChanges: []TokenBalanceChange{{Mint: "balance-mint"}}}},
+			FeePayer:    "payer",
+		},
+		"payer": {FeePayer: "payer", Signature: "sig"},
+		"sig":   {Signature: "sig"},
+	}
+
+	for want, payload := range cases {
+		if got := PartitionKey(payload); got != want {
+			t.Errorf("expected key %q; got %q", want, got)
+		}
+	}
+}
+
+func TestTransactionRecords(t *testing.T) {
+	payloads := []WebhookPayload{
+		{Signature: "a", TokenTransfers: []TokenTransfer{{Mint: "mint-1"}}},
+		{Signature: "b", TokenTransfers: []TokenTransfer{{Mint: "mint-2"}}},
+	}
+
+	records, err := transactionRecords(payloads, schemaVersionJSON, "webhook-0", kgo.RecordHeader{Key: headerCatchUp, Value: []byte("mint-1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected one record per transaction; got %d", len(records))
+	}
+	if string(records[1].Key) != "mint-2" {
+		t.Errorf("expected the second record keyed by mint-2; got %q", records[1].Key)
+	}
+	if got := recordReceiver(records[0]); got != "webhook-0" {
+		t.Errorf("expected receiver webhook-0; got %q", got)
+	}
+	if got := recordHeader(records[0], headerCatchUp); got != "mint-1" {
+		t.Errorf("expected the extra header to be kept; got %q", got)
+	}
+
+	// Records produced before keys were addresses carry the receiver as key
+	if got := recordReceiver(&kgo.Record{Key: []byte("webhook-1")}); got != "webhook-1" {
+		t.Errorf("expected receiver webhook-1 from the key; got %q", got)
+	}
+}
@@ -0,0 +1,471 @@
+package kafka
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/scythe504/solana-indexer/internal/metrics"
+	"github.com/scythe504/solana-indexer/internal/utils"
+)
+
+// Postgres accepts at most 65535 bind parameters per statement
+const maxInsertParams = 65535
+
+func init() {
+	RegisterSink(database.SinkPostgres, newPostgresSink)
+}
+
+// postgresSink writes payloads to the user's own Postgres database, as raw
+// JSONB per subscription table plus the shared normalized tables.
+type postgresSink struct {
+	userId string
+
+	mu sync.Mutex
+	// Subscription tables known to exist in ensuredDb, the pool they were
+	// created through
+	ensuredDb *sql.DB
+	ensured   map[string]bool
+}
+
+func newPostgresSink(userId string) (Sink, error) {
+	return &postgresSink{userId: userId}, nil
+}
+
+// Prepare checks the user has a reachable database. Opening the pool also
+// brings the normalized tables up to the latest schema version.
+func (s *postgresSink) Prepare(ctx context.Context) error {
+	_, release, err := s.userDatabase()
+	if err != nil {
+		return err
+	}
+	release()
+	return nil
+}
+
+// Write stores the batch in one transaction. The credentials are looked up
+// on every write so edits take effect without restarting the worker; the
+// pool itself comes from the database package's cache.
+func (s *postgresSink) Write(ctx context.Context, batch []IndexJob) error {
+	db, release, err := s.userDatabase()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err = s.ensureTables(ctx, db, batch); err != nil {
+		return err
+	}
+
+	return writeDestinationBatch(ctx, db, batch)
+}
+
+// ensureTables creates the subscription tables of the batch the first time
+// the sink writes to them through db. A new pool, e.g. after the
+// credentials changed, may point at another database, so it starts over. A
+// table dropped since fails the write, which discards the sink and with it
+// what it ensured.
+func (s *postgresSink) ensureTables(ctx context.Context, db *sql.DB, batch []IndexJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ensuredDb != db {
+		s.ensuredDb = db
+		s.ensured = make(map[string]bool)
+	}
+
+	for _, job := range batch {
+		table := job.Subscription.TableName
+		if s.ensured[table] {
+			continue
+		}
+		if err := ensureRawTable(ctx, db, table); err != nil {
+			return err
+		}
+		s.ensured[table] = true
+	}
+
+	return nil
+}
+
+// Close is a no-op, pools are owned and evicted by the pool cache.
+func (s *postgresSink) Close() error {
+	return nil
+}
+
+// userDatabase returns the user's pool and the function releasing it.
+func (s *postgresSink) userDatabase() (*sql.DB, func(), error) {
+	dbConfig, err := database.Service.GetDatabaseConfig(database.New(), s.userId)
+	if err != nil {
+		log.Printf("Error occured while fetching for database config for user: %s, err: %v", s.userId, err)
+		return nil, nil, err
+	}
+
+	db, release, err := database.Service.GetUserDatabase(database.New(), dbConfig)
+	if err != nil {
+		log.Printf("Error occured while connecting to database, userId: %s\n", s.userId)
+		return nil, nil, err
+	}
+
+	return db, release, nil
+}
+
+// writeDestinationBatch writes the raw payloads of every job into their
+// subscription tables, and the normalized rows once per transaction, all in
+// one transaction.
+func writeDestinationBatch(ctx context.Context, db *sql.DB, jobs []IndexJob) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Failed to start transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		tables  []string
+		byTable = make(map[string][]WebhookPayload)
+		seen    = make(map[string]map[string]bool)
+	)
+
+	for _, job := range jobs {
+		table := job.Subscription.TableName
+		if _, ok := byTable[table]; !ok {
+			tables = append(tables, table)
+			seen[table] = make(map[string]bool)
+		}
+		// A redelivered record can carry the same transaction twice
+		if seen[table][job.Payload.Signature] {
+			metrics.DedupeHits.Add(1)
+			continue
+		}
+		seen[table][job.Payload.Signature] = true
+		byTable[table] = append(byTable[table], job.Payload)
+	}
+
+	var (
+		normalized []WebhookPayload
+		signatures = make(map[string]bool)
+	)
+
+	for _, table := range tables {
+		payloads := byTable[table]
+		inserted, err := insertRawPayloads(ctx, tx, table, payloads)
+		if err != nil {
+			return err
+		}
+
+		// The rest were already indexed for this subscription, this is a
+		// redelivery from Helius or Kafka
+		metrics.DedupeHits.Add(int64(len(payloads)) - inserted)
+
+		for _, payload := range payloads {
+			if !signatures[payload.Signature] {
+				signatures[payload.Signature] = true
+				normalized = append(normalized, payload)
+			}
+		}
+	}
+
+	if err = CreateAndInsertNormalizedData(ctx, tx, normalized); err != nil {
+		log.Println("Failed to insert normalized data, err: ", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Failed to commit transaction, changes will be rolled back")
+		return err
+	}
+
+	return nil
+}
+
+// ensureRawTable creates a subscription table. Tables created before
+// signatures were tracked get the column and its unique index added.
+func ensureRawTable(ctx context.Context, db *sql.DB, tableName string) error {
+	tableQueries := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id VARCHAR(255) NOT NULL PRIMARY KEY, signature VARCHAR(255), jsonData JSONB)", tableName),
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS signature VARCHAR(255)", tableName),
+		fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_signature_key ON %s (signature)", tableName, tableName),
+	}
+	for _, query := range tableQueries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			log.Printf("Failed to create table %s cannot proceed, err: %v", tableName, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertRawPayloads stores the payloads as JSONB in a subscription table and
+// returns how many of them were new.
+func insertRawPayloads(ctx context.Context, tx *sql.Tx, tableName string, payloads []WebhookPayload) (int64, error) {
+	rows := make([][]any, 0, len(payloads))
+	for _, payload := range payloads {
+		jsonBlob, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("Failed to encode payload %s into json, err: %v", payload.Signature, err)
+			return 0, err
+		}
+		rows = append(rows, []any{utils.GenerateUUID(), payload.Signature, string(jsonBlob)})
+	}
+
+	return insertRows(ctx, tx,
+		fmt.Sprintf("INSERT INTO %s (id, signature, jsonData) VALUES", tableName),
+		"ON CONFLICT (signature) DO NOTHING",
+		rows, nil,
+	)
+}
+
+// CreateAndInsertNormalizedData stores the payloads in the normalized tables,
+// which are created by the user database migrations when the pool is
+// opened. Payloads whose signature is already stored, possibly through
+// another subscription in the same database, are skipped along with their
+// children.
+func CreateAndInsertNormalizedData(ctx context.Context, tx *sql.Tx, payloads []WebhookPayload) error {
+	if len(payloads) == 0 {
+		return nil
+	}
+
+	// Insert payload metadata, keeping track of which ones were new
+	payloadRows := make([][]any, 0, len(payloads))
+	for _, payload := range payloads {
+		payloadRows = append(payloadRows, []any{
+			utils.GenerateUUID(),
+			payload.Signature,
+			payload.Slot,
+			time.Unix(payload.Timestamp, 0),
+			payload.Source,
+			payload.Fee,
+			payload.FeePayer,
+			payload.Type,
+			payload.Description,
+		})
+	}
+
+	payloadIds := make(map[string]string)
+	_, err := insertRows(ctx, tx,
+		`INSERT INTO normalized_webhook_payloads
+		(id, signature, slot, timestamp, source, fee, fee_payer, transaction_type, description)
+		VALUES`,
+		"ON CONFLICT (signature) DO NOTHING RETURNING id, signature",
+		payloadRows,
+		func(rows *sql.Rows) error {
+			var id, signature string
+			if err := rows.Scan(&id, &signature); err != nil {
+				return err
+			}
+			payloadIds[signature] = id
+			return nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook payload: %v", err)
+	}
+
+	var nativeTransferRows, tokenTransferRows [][]any
+
+	for _, payload := range payloads {
+		payloadID, ok := payloadIds[payload.Signature]
+		if !ok {
+			continue
+		}
+
+		// Insert account data, the generated ids are needed for the token
+		// balance changes so these go in one at a time
+		for _, accountData := range payload.AccountData {
+			var accountDataID int
+			err = tx.QueryRowContext(ctx, `
+				INSERT INTO normalized_account_data
+				(payload_id, account, native_balance_change)
+				VALUES ($1, $2, $3)
+				RETURNING id
+			`,
+				payloadID,
+				accountData.Account,
+				bigIntString(accountData.NativeBalanceChange),
+			).Scan(&accountDataID)
+			if err != nil {
+				return fmt.Errorf("failed to insert account data: %v", err)
+			}
+
+			tokenChangeRows := make([][]any, 0, len(accountData.TokenBalanceChanges))
+			for _, tokenChange := range accountData.TokenBalanceChanges {
+				tokenChangeRows = append(tokenChangeRows, []any{
+					accountDataID,
+					tokenChange.Mint,
+					tokenChange.TokenAccount,
+					tokenChange.UserAccount,
+					tokenChange.RawTokenAmount.TokenAmount,
+					tokenChange.RawTokenAmount.Decimals,
+				})
+			}
+
+			_, err = insertRows(ctx, tx,
+				`INSERT INTO normalized_token_balance_changes
+				(account_data_id, mint, token_account, user_account, token_amount, decimals)
+				VALUES`,
+				"", tokenChangeRows, nil,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to insert token balance changes: %v", err)
+			}
+		}
+
+		for _, transfer := range payload.NativeTransfers {
+			nativeTransferRows = append(nativeTransferRows, []any{
+				payloadID,
+				transfer.FromUserAccount,
+				transfer.ToUserAccount,
+				bigIntString(transfer.Amount),
+			})
+		}
+
+		for _, transfer := range payload.TokenTransfers {
+			tokenTransferRows = append(tokenTransferRows, []any{
+				payloadID,
+				transfer.FromTokenAccount,
+				transfer.FromUserAccount,
+				transfer.ToTokenAccount,
+				transfer.ToUserAccount,
+				transfer.Mint,
+				transfer.TokenAmount,
+				transfer.TokenStandard,
+			})
+		}
+	}
+
+	_, err = insertRows(ctx, tx,
+		`INSERT INTO normalized_native_transfers
+		(payload_id, from_user_account, to_user_account, amount)
+		VALUES`,
+		"", nativeTransferRows, nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert native transfers: %v", err)
+	}
+
+	_, err = insertRows(ctx, tx,
+		`INSERT INTO normalized_token_transfers
+		(payload_id, from_token_account, from_user_account,
+		 to_token_account, to_user_account, mint,
+		 token_amount, token_standard)
+		VALUES`,
+		"", tokenTransferRows, nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert token transfers: %v", err)
+	}
+
+	return nil
+}
+
+// chunkRows splits rows so no chunk needs more than maxInsertParams bind
+// parameters.
+func chunkRows(rows [][]any) [][][]any {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	chunkSize := maxInsertParams / len(rows[0])
+	chunks := make([][][]any, 0, (len(rows)+chunkSize-1)/chunkSize)
+	for start := 0; start < len(rows); start += chunkSize {
+		chunks = append(chunks, rows[start:min(start+chunkSize, len(rows))])
+	}
+	return chunks
+}
+
+// insertRows runs head followed by a multi-row VALUES list and tail,
+// splitting the rows so each statement stays under the bind parameter
+// limit. When scan is set the statement is expected to use RETURNING and
+// scan is called for every returned row. It returns the number of rows
+// inserted.
+func insertRows(ctx context.Context, tx *sql.Tx, head string, tail string, rows [][]any, scan func(*sql.Rows) error) (int64, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	var inserted int64
+	for _, chunk := range chunkRows(rows) {
+		query, args := buildInsert(head, tail, chunk)
+
+		if scan == nil {
+			result, err := tx.ExecContext(ctx, query, args...)
+			if err != nil {
+				return inserted, err
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return inserted, err
+			}
+			inserted += affected
+			continue
+		}
+
+		returned, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return inserted, err
+		}
+		for returned.Next() {
+			if err := scan(returned); err != nil {
+				returned.Close()
+				return inserted, err
+			}
+			inserted++
+		}
+		err = returned.Err()
+		returned.Close()
+		if err != nil {
+			return inserted, err
+		}
+	}
+
+	return inserted, nil
+}
+
+// buildInsert renders rows as ($1, $2), ($3, $4)... between head and tail.
+func buildInsert(head string, tail string, rows [][]any) (string, []any) {
+	var (
+		query strings.Builder
+		args  = make([]any, 0, len(rows)*len(rows[0]))
+	)
+
+	query.WriteString(head)
+	for i, row := range rows {
+		if i > 0 {
+			query.WriteString(",")
+		}
+		query.WriteString(" (")
+		for j, value := range row {
+			if j > 0 {
+				query.WriteString(", ")
+			}
+			args = append(args, value)
+			query.WriteString("$")
+			query.WriteString(strconv.Itoa(len(args)))
+		}
+		query.WriteString(")")
+	}
+	if tail != "" {
+		query.WriteString(" ")
+		query.WriteString(tail)
+	}
+
+	return query.String(), args
+}
+
+func bigIntString(value *big.Int) *string {
+	if value == nil {
+		return nil
+	}
+	s := value.String()
+	return &s
+}
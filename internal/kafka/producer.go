@@ -2,9 +2,8 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"log"
-	"os"
 	"sync"
 	"time"
 
@@ -12,47 +11,72 @@ import (
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
-func (m *KafkaClientManager) ProduceWebhookPayload(client *kgo.Client, payload []WebhookPayload, receiverName string) error {
-	jsonPayload, err := json.Marshal(payload)
+// ErrProducerFull is returned when the producer's buffer has no room for a
+// batch. Nothing waits for room, the sender is expected to retry later.
+var ErrProducerFull = errors.New("kafka producer buffer is full")
 
+// ProduceWebhookPayload produces every transaction of the batch as its own
+// record, keyed by its PartitionKey and encoded with the producer's schema
+// version. It returns once every record is acknowledged, or with the first
+// error, which is ErrProducerFull when the buffer had no room.
+func (m *KafkaClientManager) ProduceWebhookPayload(ctx context.Context, payload []WebhookPayload, receiverName string) error {
+	version, err := producerSchemaVersion()
 	if err != nil {
-		log.Println("Invalid Json Payload, err: ", err)
+		log.Println("Invalid record schema, err: ", err)
 		return err
 	}
 
-	topic := os.Getenv("KAFKA_TOPIC")
-	record := &kgo.Record {
-		Topic: topic,
-		Key: []byte(receiverName),
-		Value: jsonPayload,
-		Headers: []kgo.RecordHeader{
-			{
-				Key: "receiver",
-				Value: []byte(receiverName),
-			},
-			{
-				Key: "timestamp",
-				Value: []byte(time.Now().Format(time.RFC3339)),
-			},
-		},
+	records, err := transactionRecords(payload, version, receiverName)
+	if err != nil {
+		log.Println("Invalid Json Payload, err: ", err)
+		return err
+	}
+	if len(records) == 0 {
+		return nil
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
+	client, err := m.GetClient()
+	if err != nil {
+		log.Printf("Failed to create Kafka producer: %v", err)
+		return err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	client.Produce(ctx, record, func (r *kgo.Record, err error){
-		defer wg.Done()
-		if err != nil {
-			log.Printf("Error Producing message, err: %v\n", err)
-		}else {
-			log.Printf("Message Produced to topic: %v\n", r.Topic)
-		}
-		
-	})
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	wg.Add(len(records))
+
+	for _, record := range records {
+		// TryProduce fails straight away instead of blocking when the
+		// buffer is full
+		client.TryProduce(ctx, record, func(r *kgo.Record, err error) {
+			defer wg.Done()
+			if err == nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if firstErr == nil || errors.Is(err, kgo.ErrMaxBuffered) {
+				firstErr = err
+			}
+		})
+	}
 	wg.Wait()
 
+	if errors.Is(firstErr, kgo.ErrMaxBuffered) {
+		return ErrProducerFull
+	}
+	if firstErr != nil {
+		log.Printf("Error Producing message, err: %v\n", firstErr)
+		return firstErr
+	}
+
+	log.Printf("Produced %d messages to topic: %v\n", len(records), records[0].Topic)
 	return nil
-}
\ No newline at end of file
+}
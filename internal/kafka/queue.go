@@ -0,0 +1,53 @@
+package kafka
+
+import (
+	"context"
+	"os"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// QueueBackendEmbedded selects the embedded queue, which keeps records in
+// the main database instead of Kafka.
+const QueueBackendEmbedded = "embedded"
+
+// Queue carries transactions from ingestion to the worker. Both backends
+// hold the same records, so matching, batching, retries and dead letters
+// behave the same whichever one is used.
+type Queue interface {
+	// ProduceWebhookPayload queues the transactions of a batch and returns
+	// once they are stored.
+	ProduceWebhookPayload(ctx context.Context, payloads []WebhookPayload, receiverName string) error
+	// ConsumeWebhookPayload indexes queued records with a pool of workers
+	// until ctx is cancelled.
+	ConsumeWebhookPayload(ctx context.Context, workers int) error
+	// ReplayDeadLetter queues a dead-lettered record again, targeted at the
+	// subscription that failed.
+	ReplayDeadLetter(record database.DeadLetterRecord) error
+	// Close releases the queue once nothing produces to it anymore.
+	Close()
+
+	produceFetched(payloads []WebhookPayload, receiverName string, headers ...kgo.RecordHeader) error
+}
+
+var (
+	_ Queue = (*KafkaClientManager)(nil)
+	_ Queue = (*EmbeddedQueue)(nil)
+)
+
+// NewQueue returns the queue selected by QUEUE_BACKEND, Kafka unless it is
+// set to embedded.
+func NewQueue() Queue {
+	if EmbeddedQueueEnabled() {
+		return NewEmbeddedQueue(database.New())
+	}
+
+	return NewKafkaClientManager()
+}
+
+// EmbeddedQueueEnabled reports whether QUEUE_BACKEND selects the embedded
+// queue.
+func EmbeddedQueueEnabled() bool {
+	return os.Getenv("QUEUE_BACKEND") == QueueBackendEmbedded
+}
@@ -0,0 +1,774 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/big"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/scythe504/solana-indexer/internal/schema"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Record values are versioned by the schema_version header. Version 1 is the
+// JSON array of payloads records used to be, and is assumed when the header
+// is missing. Versions 2 and 3 are a Protobuf WebhookBatch compressed with
+// zstd, described by schemas/webhook-payload/v<version>.proto. Amounts held
+// in big.Int are encoded as decimal strings. Version 2 carries the free-form
+// events and transaction errors as JSON, version 3 as JsonValue trees.
+
+const (
+	schemaSubject            = "webhook-payload"
+	schemaVersionJSON        = 1
+	schemaVersionProtobuf    = 2
+	schemaVersionTypedEvents = 3
+)
+
+// webhookPayloadSchema returns the Protobuf schema the encoder below writes
+// for version. It has to match schemas/webhook-payload/v<version>.json.
+func webhookPayloadSchema(version int) schema.Schema {
+	payload := []schema.Field{
+		{Number: 1, Name: "signature", Type: "string"},
+		{Number: 2, Name: "slot", Type: "int64"},
+		{Number: 3, Name: "timestamp", Type: "int64"},
+		{Number: 4, Name: "type", Type: "string"},
+		{Number: 5, Name: "source", Type: "string"},
+		{Number: 6, Name: "description", Type: "string"},
+		{Number: 7, Name: "fee", Type: "int32"},
+		{Number: 8, Name: "fee_payer", Type: "string"},
+		{Number: 9, Name: "account_data", Type: "AccountData", Repeated: true},
+		{Number: 10, Name: "instructions", Type: "Instruction", Repeated: true},
+		{Number: 11, Name: "native_transfers", Type: "NativeTransfer", Repeated: true},
+		{Number: 12, Name: "token_transfers", Type: "TokenTransfer", Repeated: true},
+	}
+	if version == schemaVersionProtobuf {
+		payload = append(payload,
+			schema.Field{Number: 13, Name: "events_json", Type: "bytes"},
+			schema.Field{Number: 14, Name: "transaction_error_json", Type: "bytes"},
+		)
+	} else {
+		payload = append(payload,
+			schema.Field{Number: 15, Name: "events", Type: "JsonObject"},
+			schema.Field{Number: 16, Name: "transaction_error", Type: "JsonValue"},
+		)
+	}
+
+	s := schema.Schema{
+		Subject:     schemaSubject,
+		Version:     version,
+		Encoding:    schema.EncodingProtobuf,
+		Compression: "zstd",
+		Root:        "WebhookBatch",
+		Messages: map[string][]schema.Field{
+			"WebhookBatch": {
+				{Number: 1, Name: "payloads", Type: "WebhookPayload", Repeated: true},
+			},
+			"WebhookPayload": payload,
+			"AccountData": {
+				{Number: 1, Name: "id", Type: "string"},
+				{Number: 2, Name: "account", Type: "string"},
+				{Number: 3, Name: "native_balance_change", Type: "string"},
+				{Number: 4, Name: "token_balance_changes", Type: "TokenBalanceChange", Repeated: true},
+			},
+			"TokenBalanceChange": {
+				{Number: 1, Name: "mint", Type: "string"},
+				{Number: 2, Name: "raw_token_amount", Type: "RawTokenAmount"},
+				{Number: 3, Name: "token_account", Type: "string"},
+				{Number: 4, Name: "user_account", Type: "string"},
+			},
+			"RawTokenAmount": {
+				{Number: 1, Name: "decimals", Type: "int32"},
+				{Number: 2, Name: "token_amount", Type: "string"},
+			},
+			"Instruction": {
+				{Number: 1, Name: "id", Type: "string"},
+				{Number: 2, Name: "accounts", Type: "string", Repeated: true},
+				{Number: 3, Name: "data", Type: "string"},
+				{Number: 4, Name: "inner_instructions", Type: "InnerInstruction", Repeated: true},
+			},
+			"InnerInstruction": {
+				{Number: 1, Name: "accounts", Type: "string", Repeated: true},
+				{Number: 2, Name: "data", Type: "string"},
+				{Number: 3, Name: "program_id", Type: "string"},
+			},
+			"NativeTransfer": {
+				{Number: 1, Name: "amount", Type: "string"},
+				{Number: 2, Name: "from_user_account", Type: "string"},
+				{Number: 3, Name: "to_user_account", Type: "string"},
+			},
+			"TokenTransfer": {
+				{Number: 1, Name: "from_token_account", Type: "string"},
+				{Number: 2, Name: "from_user_account", Type: "string"},
+				{Number: 3, Name: "mint", Type: "string"},
+				{Number: 4, Name: "to_token_account", Type: "string"},
+				{Number: 5, Name: "to_user_account", Type: "string"},
+				{Number: 6, Name: "token_amount", Type: "double"},
+				{Number: 7, Name: "token_standard", Type: "string"},
+			},
+		},
+	}
+	if version == schemaVersionTypedEvents {
+		s.Messages["JsonValue"] = []schema.Field{
+			{Number: 1, Name: "null_value", Type: "bool"},
+			{Number: 2, Name: "number_value", Type: "double"},
+			{Number: 3, Name: "string_value", Type: "string"},
+			{Number: 4, Name: "bool_value", Type: "bool"},
+			{Number: 5, Name: "object_value", Type: "JsonObject"},
+			{Number: 6, Name: "list_value", Type: "JsonList"},
+		}
+		s.Messages["JsonObject"] = []schema.Field{
+			{Number: 1, Name: "fields", Type: "JsonField", Repeated: true},
+		}
+		s.Messages["JsonField"] = []schema.Field{
+			{Number: 1, Name: "key", Type: "string"},
+			{Number: 2, Name: "value", Type: "JsonValue"},
+		}
+		s.Messages["JsonList"] = []schema.Field{
+			{Number: 1, Name: "values", Type: "JsonValue", Repeated: true},
+		}
+	}
+
+	return s
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// producerSchemaVersion returns the version records are produced with,
+// read from KAFKA_SCHEMA_VERSION (default 1). Protobuf versions are opt-in,
+// to be set once every consumer reads them, and are checked against the
+// schema registry once.
+var producerSchemaVersion = sync.OnceValues(func() (int, error) {
+	version := schemaVersionJSON
+	if configured := os.Getenv("KAFKA_SCHEMA_VERSION"); configured != "" {
+		var err error
+		if version, err = strconv.Atoi(configured); err != nil {
+			return 0, fmt.Errorf("invalid KAFKA_SCHEMA_VERSION %q", configured)
+		}
+	}
+
+	switch version {
+	case schemaVersionJSON:
+		return version, nil
+	case schemaVersionProtobuf, schemaVersionTypedEvents:
+		registry, err := schema.LoadRegistry()
+		if err != nil {
+			return 0, err
+		}
+		if err := registry.Verify(webhookPayloadSchema(version)); err != nil {
+			return 0, err
+		}
+		return version, nil
+	default:
+		return 0, fmt.Errorf("unknown KAFKA_SCHEMA_VERSION %d", version)
+	}
+})
+
+// VerifyConsumerSchemas checks that every version registered for record
+// values can be decoded, so no producer writes records this consumer would
+// dead-letter.
+func VerifyConsumerSchemas() error {
+	registry, err := schema.LoadRegistry()
+	if err != nil {
+		return err
+	}
+
+	for _, registered := range registry.Versions(schemaSubject) {
+		switch registered.Version {
+		case schemaVersionJSON:
+		case schemaVersionProtobuf, schemaVersionTypedEvents:
+			if err := registry.Verify(webhookPayloadSchema(registered.Version)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("schema %s v%d is registered but cannot be decoded", schemaSubject, registered.Version)
+		}
+	}
+
+	return nil
+}
+
+// EncodeRecordValue encodes payloads as a record value of the given schema
+// version.
+func EncodeRecordValue(payloads []WebhookPayload, version int) ([]byte, error) {
+	switch version {
+	case schemaVersionJSON:
+		return json.Marshal(payloads)
+	case schemaVersionProtobuf, schemaVersionTypedEvents:
+		var batch []byte
+		for _, payload := range payloads {
+			encoded, err := encodePayload(payload, version)
+			if err != nil {
+				return nil, err
+			}
+			batch = appendMessage(batch, 1, encoded)
+		}
+		return zstdEncoder.EncodeAll(batch, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown schema version %d", version)
+	}
+}
+
+// DecodeRecordValue decodes a record value of the given schema version.
+func DecodeRecordValue(value []byte, version int) ([]WebhookPayload, error) {
+	var payloads []WebhookPayload
+	switch version {
+	case schemaVersionJSON:
+		if err := json.Unmarshal(value, &payloads); err != nil {
+			return nil, err
+		}
+	case schemaVersionProtobuf, schemaVersionTypedEvents:
+		batch, err := zstdDecoder.DecodeAll(value, nil)
+		if err != nil {
+			return nil, err
+		}
+		err = eachField(batch, func(f protoField) error {
+			if f.num != 1 {
+				return nil
+			}
+			payload, err := decodePayload(f.bytes)
+			if err != nil {
+				return err
+			}
+			payloads = append(payloads, payload)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown schema version %d", version)
+	}
+
+	return payloads, nil
+}
+
+// decodeRecord decodes the value of a record by its schema_version header.
+func decodeRecord(record *kgo.Record) ([]WebhookPayload, error) {
+	return DecodeRecordValue(record.Value, recordSchemaVersion(record))
+}
+
+func recordSchemaVersion(record *kgo.Record) int {
+	version, err := strconv.Atoi(recordHeader(record, headerSchemaVersion))
+	if err != nil {
+		return schemaVersionJSON
+	}
+	return version
+}
+
+func schemaVersionHeader(version int) kgo.RecordHeader {
+	return kgo.RecordHeader{Key: headerSchemaVersion, Value: []byte(strconv.Itoa(version))}
+}
+
+func encodePayload(p WebhookPayload, version int) ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, p.Signature)
+	b = appendVarint(b, 2, uint64(p.Slot))
+	b = appendVarint(b, 3, uint64(p.Timestamp))
+	b = appendString(b, 4, p.Type)
+	b = appendString(b, 5, p.Source)
+	b = appendString(b, 6, p.Description)
+	b = appendVarint(b, 7, uint64(int64(p.Fee)))
+	b = appendString(b, 8, p.FeePayer)
+
+	for _, accountData := range p.AccountData {
+		var m []byte
+		m = appendString(m, 1, accountData.ID)
+		m = appendString(m, 2, accountData.Account)
+		m = appendBigInt(m, 3, accountData.NativeBalanceChange)
+		for _, change := range accountData.TokenBalanceChanges {
+			var c []byte
+			c = appendString(c, 1, change.Mint)
+			var amount []byte
+			amount = appendVarint(amount, 1, uint64(int64(change.RawTokenAmount.Decimals)))
+			amount = appendString(amount, 2, change.RawTokenAmount.TokenAmount)
+			c = appendMessage(c, 2, amount)
+			c = appendString(c, 3, change.TokenAccount)
+			c = appendString(c, 4, change.UserAccount)
+			m = appendMessage(m, 4, c)
+		}
+		b = appendMessage(b, 9, m)
+	}
+
+	for _, instruction := range p.Instructions {
+		var m []byte
+		m = appendString(m, 1, instruction.Id)
+		m = appendRepeatedString(m, 2, instruction.Accounts)
+		m = appendString(m, 3, instruction.Data)
+		for _, inner := range instruction.InnerInstructions {
+			var i []byte
+			i = appendRepeatedString(i, 1, inner.Accounts)
+			i = appendString(i, 2, inner.Data)
+			i = appendString(i, 3, inner.ProgramId)
+			m = appendMessage(m, 4, i)
+		}
+		b = appendMessage(b, 10, m)
+	}
+
+	for _, transfer := range p.NativeTransfers {
+		var m []byte
+		m = appendBigInt(m, 1, transfer.Amount)
+		m = appendString(m, 2, transfer.FromUserAccount)
+		m = appendString(m, 3, transfer.ToUserAccount)
+		b = appendMessage(b, 11, m)
+	}
+
+	for _, transfer := range p.TokenTransfers {
+		var m []byte
+		m = appendString(m, 1, transfer.FromTokenAccount)
+		m = appendString(m, 2, transfer.FromUserAccount)
+		m = appendString(m, 3, transfer.Mint)
+		m = appendString(m, 4, transfer.ToTokenAccount)
+		m = appendString(m, 5, transfer.ToUserAccount)
+		if transfer.TokenAmount != 0 {
+			m = protowire.AppendTag(m, 6, protowire.Fixed64Type)
+			m = protowire.AppendFixed64(m, math.Float64bits(transfer.TokenAmount))
+		}
+		m = appendString(m, 7, transfer.TokenStandard)
+		b = appendMessage(b, 12, m)
+	}
+
+	var err error
+	if version == schemaVersionProtobuf {
+		if p.Events != nil {
+			if b, err = appendJSON(b, 13, p.Events); err != nil {
+				return nil, err
+			}
+		}
+		if p.TransactionError != nil {
+			if b, err = appendJSON(b, 14, p.TransactionError); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+
+	if p.Events != nil {
+		events, err := encodeJSONObject(p.Events)
+		if err != nil {
+			return nil, err
+		}
+		b = appendMessage(b, 15, events)
+	}
+	if p.TransactionError != nil {
+		transactionError, err := encodeJSONValue(p.TransactionError)
+		if err != nil {
+			return nil, err
+		}
+		b = appendMessage(b, 16, transactionError)
+	}
+
+	return b, nil
+}
+
+func decodePayload(b []byte) (WebhookPayload, error) {
+	var p WebhookPayload
+	err := eachField(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			p.Signature = f.string()
+		case 2:
+			p.Slot = int64(f.varint)
+		case 3:
+			p.Timestamp = int64(f.varint)
+		case 4:
+			p.Type = f.string()
+		case 5:
+			p.Source = f.string()
+		case 6:
+			p.Description = f.string()
+		case 7:
+			p.Fee = int32(int64(f.varint))
+		case 8:
+			p.FeePayer = f.string()
+		case 9:
+			accountData, err := decodeAccountData(f.bytes)
+			if err != nil {
+				return err
+			}
+			p.AccountData = append(p.AccountData, accountData)
+		case 10:
+			instruction, err := decodeInstruction(f.bytes)
+			if err != nil {
+				return err
+			}
+			p.Instructions = append(p.Instructions, instruction)
+		case 11:
+			var transfer NativeTransfer
+			err := eachField(f.bytes, func(f protoField) error {
+				switch f.num {
+				case 1:
+					return decodeBigInt(f, &transfer.Amount)
+				case 2:
+					transfer.FromUserAccount = f.string()
+				case 3:
+					transfer.ToUserAccount = f.string()
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			p.NativeTransfers = append(p.NativeTransfers, transfer)
+		case 12:
+			var transfer TokenTransfer
+			err := eachField(f.bytes, func(f protoField) error {
+				switch f.num {
+				case 1:
+					transfer.FromTokenAccount = f.string()
+				case 2:
+					transfer.FromUserAccount = f.string()
+				case 3:
+					transfer.Mint = f.string()
+				case 4:
+					transfer.ToTokenAccount = f.string()
+				case 5:
+					transfer.ToUserAccount = f.string()
+				case 6:
+					transfer.TokenAmount = math.Float64frombits(f.fixed64)
+				case 7:
+					transfer.TokenStandard = f.string()
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			p.TokenTransfers = append(p.TokenTransfers, transfer)
+		case 13:
+			return json.Unmarshal(f.bytes, &p.Events)
+		case 14:
+			return json.Unmarshal(f.bytes, &p.TransactionError)
+		case 15:
+			events, err := decodeJSONObject(f.bytes)
+			p.Events = events
+			return err
+		case 16:
+			transactionError, err := decodeJSONValue(f.bytes)
+			p.TransactionError = transactionError
+			return err
+		}
+		return nil
+	})
+
+	return p, err
+}
+
+func decodeAccountData(b []byte) (AccountData, error) {
+	var accountData AccountData
+	err := eachField(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			accountData.ID = f.string()
+		case 2:
+			accountData.Account = f.string()
+		case 3:
+			return decodeBigInt(f, &accountData.NativeBalanceChange)
+		case 4:
+			var change TokenBalanceChange
+			err := eachField(f.bytes, func(f protoField) error {
+				switch f.num {
+				case 1:
+					change.Mint = f.string()
+				case 2:
+					return eachField(f.bytes, func(f protoField) error {
+						switch f.num {
+						case 1:
+							change.RawTokenAmount.Decimals = int8(int64(f.varint))
+						case 2:
+							change.RawTokenAmount.TokenAmount = f.string()
+						}
+						return nil
+					})
+				case 3:
+					change.TokenAccount = f.string()
+				case 4:
+					change.UserAccount = f.string()
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			accountData.TokenBalanceChanges = append(accountData.TokenBalanceChanges, change)
+		}
+		return nil
+	})
+
+	return accountData, err
+}
+
+func decodeInstruction(b []byte) (Instruction, error) {
+	var instruction Instruction
+	err := eachField(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			instruction.Id = f.string()
+		case 2:
+			instruction.Accounts = append(instruction.Accounts, f.string())
+		case 3:
+			instruction.Data = f.string()
+		case 4:
+			var inner InnerInstruction
+			err := eachField(f.bytes, func(f protoField) error {
+				switch f.num {
+				case 1:
+					inner.Accounts = append(inner.Accounts, f.string())
+				case 2:
+					inner.Data = f.string()
+				case 3:
+					inner.ProgramId = f.string()
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			instruction.InnerInstructions = append(instruction.InnerInstructions, inner)
+		}
+		return nil
+	})
+
+	return instruction, err
+}
+
+// encodeJSONValue encodes a value decoded from JSON as a JsonValue. Values
+// of other Go types are passed through JSON first.
+func encodeJSONValue(value any) ([]byte, error) {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	case float64:
+		b = protowire.AppendTag(b, 2, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case string:
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	case bool:
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	case map[string]interface{}:
+		object, err := encodeJSONObject(v)
+		if err != nil {
+			return nil, err
+		}
+		b = appendMessage(b, 5, object)
+	case []interface{}:
+		var list []byte
+		for _, element := range v {
+			encoded, err := encodeJSONValue(element)
+			if err != nil {
+				return nil, err
+			}
+			list = appendMessage(list, 1, encoded)
+		}
+		b = appendMessage(b, 6, list)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var decoded any
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			return nil, err
+		}
+		return encodeJSONValue(decoded)
+	}
+
+	return b, nil
+}
+
+// encodeJSONObject encodes an object as a JsonObject, its keys sorted so
+// equal objects encode the same.
+func encodeJSONObject(object map[string]interface{}) ([]byte, error) {
+	var b []byte
+	for _, key := range slices.Sorted(maps.Keys(object)) {
+		value, err := encodeJSONValue(object[key])
+		if err != nil {
+			return nil, err
+		}
+		var field []byte
+		field = protowire.AppendTag(field, 1, protowire.BytesType)
+		field = protowire.AppendString(field, key)
+		field = appendMessage(field, 2, value)
+		b = appendMessage(b, 1, field)
+	}
+	return b, nil
+}
+
+func decodeJSONValue(b []byte) (any, error) {
+	var value any
+	err := eachField(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			value = nil
+		case 2:
+			value = math.Float64frombits(f.fixed64)
+		case 3:
+			value = f.string()
+		case 4:
+			value = protowire.DecodeBool(f.varint)
+		case 5:
+			object, err := decodeJSONObject(f.bytes)
+			value = object
+			return err
+		case 6:
+			list := []interface{}{}
+			err := eachField(f.bytes, func(f protoField) error {
+				if f.num != 1 {
+					return nil
+				}
+				element, err := decodeJSONValue(f.bytes)
+				list = append(list, element)
+				return err
+			})
+			value = list
+			return err
+		}
+		return nil
+	})
+
+	return value, err
+}
+
+func decodeJSONObject(b []byte) (map[string]interface{}, error) {
+	object := make(map[string]interface{})
+	err := eachField(b, func(f protoField) error {
+		if f.num != 1 {
+			return nil
+		}
+		var key string
+		var value any
+		err := eachField(f.bytes, func(f protoField) error {
+			switch f.num {
+			case 1:
+				key = f.string()
+			case 2:
+				var err error
+				value, err = decodeJSONValue(f.bytes)
+				return err
+			}
+			return nil
+		})
+		object[key] = value
+		return err
+	})
+
+	return object, err
+}
+
+// protoField is a decoded field. Only the value matching its wire type is
+// set.
+type protoField struct {
+	num     protowire.Number
+	typ     protowire.Type
+	varint  uint64
+	fixed64 uint64
+	bytes   []byte
+}
+
+func (f protoField) string() string {
+	return string(f.bytes)
+}
+
+// eachField calls fn with every field of a message, in the order they were
+// written. Fields of unexpected wire types are passed on with a zero value.
+func eachField(b []byte, fn func(f protoField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.fixed64, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendRepeatedString writes every element, empty ones included, so the
+// decoded slice keeps its positions.
+func appendRepeatedString(b []byte, num protowire.Number, values []string) []byte {
+	for _, s := range values {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	return b
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func appendBigInt(b []byte, num protowire.Number, v *big.Int) []byte {
+	if v == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v.String())
+}
+
+func appendJSON(b []byte, num protowire.Number, value any) ([]byte, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return appendMessage(b, num, encoded), nil
+}
+
+func decodeBigInt(f protoField, v **big.Int) error {
+	n, ok := new(big.Int).SetString(f.string(), 10)
+	if !ok {
+		return errors.New("invalid big integer " + strconv.Quote(f.string()))
+	}
+	*v = n
+	return nil
+}
@@ -0,0 +1,259 @@
+package kafka
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/scythe504/solana-indexer/internal/schema"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestRecordValueRoundTrip(t *testing.T) {
+	payloads := []WebhookPayload{
+		{
+			Signature: "sig-1",
+			Slot:      250_000_000,
+			Timestamp: 1_717_000_000,
+			Type:      "NFT_SALE",
+			Source:    "MAGIC_EDEN",
+			Fee:       5000,
+			FeePayer:  "payer",
+			AccountData: []AccountData{{
+				Account:             "account",
+				NativeBalanceChange: big.NewInt(-1_000_000_000_000),
+				TokenBalanceChanges: []TokenBalanceChange{{
+					Mint:           "mint",
+					RawTokenAmount: RawTokenAmnt{Decimals: 9, TokenAmount: "-5"},
+					UserAccount:    "user",
+				}},
+			}},
+			Instructions: []Instruction{{
+				Accounts:          []string{"a", "", "c"},
+				Data:              "data",
+				InnerInstructions: []InnerInstruction{{Accounts: []string{"d"}, ProgramId: "program"}},
+			}},
+			NativeTransfers: []NativeTransfer{{Amount: big.NewInt(42), FromUserAccount: "from"}},
+			TokenTransfers:  []TokenTransfer{{Mint: "mint", TokenAmount: 1.5, TokenStandard: "NonFungible"}},
+			Events: map[string]interface{}{
+				"nft": map[string]interface{}{"amount": float64(3), "buyer": nil, "nfts": []interface{}{}, "sold": false},
+			},
+			TransactionError: map[string]interface{}{"InstructionError": []interface{}{float64(0), "Custom"}},
+		},
+		{Signature: "sig-2", Type: "TRANSFER"},
+	}
+
+	for _, version := range []int{schemaVersionJSON, schemaVersionProtobuf, schemaVersionTypedEvents} {
+		value, err := EncodeRecordValue(payloads, version)
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+
+		record := &kgo.Record{Value: value, Headers: []kgo.RecordHeader{schemaVersionHeader(version)}}
+		decoded, err := decodeRecord(record)
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if !reflect.DeepEqual(decoded, payloads) {
+			t.Errorf("v%d: expected %+v; got %+v", version, payloads, decoded)
+		}
+	}
+
+	// Records produced before the header existed are JSON
+	legacy, _ := EncodeRecordValue(payloads, schemaVersionJSON)
+	if decoded, err := decodeRecord(&kgo.Record{Value: legacy}); err != nil || len(decoded) != 2 {
+		t.Errorf("expected the legacy record to decode; got %d payloads, err: %v", len(decoded), err)
+	}
+}
+
+func TestWebhookPayloadSchemaIsRegistered(t *testing.T) {
+	registry, err := schema.Load("../../schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []int{schemaVersionProtobuf, schemaVersionTypedEvents} {
+		if err := registry.Verify(webhookPayloadSchema(version)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// completePayload sets every field of every message, so the encoder writes
+// them all.
+func completePayload() WebhookPayload {
+	return WebhookPayload{
+		Signature:   "sig",
+		Slot:        1,
+		Timestamp:   2,
+		Type:        "NFT_SALE",
+		Source:      "MAGIC_EDEN",
+		Description: "description",
+		Fee:         5000,
+		FeePayer:    "payer",
+		AccountData: []AccountData{{
+			ID:                  "id",
+			Account:             "account",
+			NativeBalanceChange: big.NewInt(-1),
+			TokenBalanceChanges: []TokenBalanceChange{{
+				Mint:           "mint",
+				RawTokenAmount: RawTokenAmnt{Decimals: 9, TokenAmount: "5"},
+				TokenAccount:   "token-account",
+				UserAccount:    "user",
+			}},
+		}},
+		Instructions: []Instruction{{
+			Id:                "id",
+			Accounts:          []string{"a"},
+			Data:              "data",
+			InnerInstructions: []InnerInstruction{{Accounts: []string{"b"}, Data: "data", ProgramId: "program"}},
+		}},
+		NativeTransfers: []NativeTransfer{{Amount: big.NewInt(1), FromUserAccount: "from", ToUserAccount: "to"}},
+		TokenTransfers: []TokenTransfer{{
+			FromTokenAccount: "from-token",
+			FromUserAccount:  "from",
+			Mint:             "mint",
+			ToTokenAccount:   "to-token",
+			ToUserAccount:    "to",
+			TokenAmount:      1.5,
+			TokenStandard:    "Fungible",
+		}},
+		Events: map[string]interface{}{
+			"nft": map[string]interface{}{"amount": float64(1), "buyer": nil, "nfts": []interface{}{"mint"}, "sold": true},
+		},
+		TransactionError: "error",
+	}
+}
+
+// wireTypes are the wire types of the scalar types of the registry.
+var wireTypes = map[string]protowire.Type{
+	"string": protowire.BytesType,
+	"bytes":  protowire.BytesType,
+	"bool":   protowire.VarintType,
+	"int32":  protowire.VarintType,
+	"int64":  protowire.VarintType,
+	"double": protowire.Fixed64Type,
+}
+
+func TestEncoderMatchesRegistry(t *testing.T) {
+	registry, err := schema.Load("../../schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, registered := range registry.Versions(schemaSubject) {
+		if registered.Encoding != schema.EncodingProtobuf {
+			continue
+		}
+
+		value, err := EncodeRecordValue([]WebhookPayload{completePayload()}, registered.Version)
+		if err != nil {
+			t.Fatalf("v%d: %v", registered.Version, err)
+		}
+		batch, err := zstdDecoder.DecodeAll(value, nil)
+		if err != nil {
+			t.Fatalf("v%d: %v", registered.Version, err)
+		}
+
+		written := make(map[string]bool)
+		checkWireFields(t, registered, registered.Root, batch, written)
+		for message, fields := range registered.Messages {
+			for _, field := range fields {
+				if !written[message+"."+field.Name] {
+					t.Errorf("v%d: %s.%s is registered but never written", registered.Version, message, field.Name)
+				}
+			}
+		}
+	}
+}
+
+// checkWireFields checks that every field of the encoded message is
+// registered with its wire type, and records the ones written.
+func checkWireFields(t *testing.T, s schema.Schema, message string, b []byte, written map[string]bool) {
+	t.Helper()
+
+	err := eachField(b, func(f protoField) error {
+		index := slices.IndexFunc(s.Messages[message], func(field schema.Field) bool { return field.Number == int(f.num) })
+		if index < 0 {
+			t.Errorf("v%d: field %d of %s is written but not registered", s.Version, f.num, message)
+			return nil
+		}
+		field := s.Messages[message][index]
+		written[message+"."+field.Name] = true
+
+		expected, scalar := wireTypes[field.Type]
+		if !scalar {
+			expected = protowire.BytesType
+		}
+		if f.typ != expected {
+			t.Errorf("v%d: %s.%s is written as wire type %d, registered as %s", s.Version, message, field.Name, f.typ, field.Type)
+			return nil
+		}
+		if !scalar {
+			checkWireFields(t, s, field.Type, f.bytes, written)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("v%d: %v", s.Version, err)
+	}
+}
+
+var (
+	protoMessageLine = regexp.MustCompile(`^message (\w+) \{$`)
+	protoFieldLine   = regexp.MustCompile(`^(repeated )?(\w+) (\w+) = (\d+);$`)
+)
+
+func TestProtoFilesMatchRegistry(t *testing.T) {
+	registry, err := schema.Load("../../schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, registered := range registry.Versions(schemaSubject) {
+		if registered.Encoding != schema.EncodingProtobuf {
+			continue
+		}
+
+		file := fmt.Sprintf("../../schemas/%s/v%d.proto", schemaSubject, registered.Version)
+		body, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Oneofs nest in messages, so the message ends at its own brace
+		messages := make(map[string][]schema.Field)
+		var message string
+		depth := 0
+		for _, line := range strings.Split(string(body), "\n") {
+			line = strings.TrimSpace(line)
+			if match := protoMessageLine.FindStringSubmatch(line); match != nil && depth == 0 {
+				message = match[1]
+				messages[message] = []schema.Field{}
+			}
+			if match := protoFieldLine.FindStringSubmatch(line); match != nil && message != "" {
+				number, _ := strconv.Atoi(match[4])
+				messages[message] = append(messages[message], schema.Field{
+					Number:   number,
+					Name:     match[3],
+					Type:     match[2],
+					Repeated: match[1] != "",
+				})
+			}
+			depth += strings.Count(line, "{") - strings.Count(line, "}")
+			if depth == 0 {
+				message = ""
+			}
+		}
+
+		if !reflect.DeepEqual(messages, registered.Messages) {
+			t.Errorf("%s does not match the registered v%d: %+v", file, registered.Version, messages)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// ListOffsets asks for the latest offset with this timestamp
+const latestOffsetTimestamp = -1
+
+// ReplayOffsets maps the time range to offsets of every partition of
+// KAFKA_TOPIC with the broker's timestamp index. A partition starts at its
+// first record produced at or after from and ends before its first record
+// produced at or after to, or at its end when there is none.
+func (m *KafkaClientManager) ReplayOffsets(ctx context.Context, from time.Time, to time.Time) ([]database.ReplayPartition, error) {
+	client, err := m.GetClient()
+	if err != nil {
+		return nil, err
+	}
+
+	topic := os.Getenv("KAFKA_TOPIC")
+	partitions, err := topicPartitions(ctx, client, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	latest, err := listOffsets(ctx, client, topic, partitions, latestOffsetTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	starts, err := listOffsets(ctx, client, topic, partitions, from.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	ends, err := listOffsets(ctx, client, topic, partitions, to.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := make([]database.ReplayPartition, 0, len(partitions))
+	for _, partition := range partitions {
+		// -1 means no record was produced at or after the timestamp
+		start, end := starts[partition], ends[partition]
+		if start < 0 {
+			start = latest[partition]
+		}
+		if end < 0 {
+			end = latest[partition]
+		}
+		if end < start {
+			end = start
+		}
+
+		ranges = append(ranges, database.ReplayPartition{
+			Partition:   partition,
+			StartOffset: start,
+			EndOffset:   end,
+			NextOffset:  start,
+		})
+	}
+
+	return ranges, nil
+}
+
+// HighWatermarks returns the offset the next record of every partition of
+// KAFKA_TOPIC will be produced at.
+func (m *KafkaClientManager) HighWatermarks(ctx context.Context, partitions []int32) (map[int32]int64, error) {
+	client, err := m.GetClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return listOffsets(ctx, client, os.Getenv("KAFKA_TOPIC"), partitions, latestOffsetTimestamp)
+}
+
+// NewReplayClient creates a client reading KAFKA_TOPIC directly from the
+// next offset of every unfinished partition. It is not a group member, so
+// the worker's committed offsets are left alone. Control records are kept,
+// so the replay can tell it read past transaction markers.
+func NewReplayClient(partitions []database.ReplayPartition) (*kgo.Client, error) {
+	kafkaURL := os.Getenv("KAFKA_URL")
+	if kafkaURL == "" {
+		return nil, fmt.Errorf("KAFKA_URL environment variable is not set")
+	}
+
+	offsets := make(map[int32]kgo.Offset)
+	for _, partition := range partitions {
+		if !partition.Done() {
+			offsets[partition.Partition] = kgo.NewOffset().At(partition.NextOffset)
+		}
+	}
+
+	return kgo.NewClient(
+		kgo.SeedBrokers(kafkaURL),
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{os.Getenv("KAFKA_TOPIC"): offsets}),
+		kgo.KeepControlRecords(),
+	)
+}
+
+func topicPartitions(ctx context.Context, client *kgo.Client, topic string) ([]int32, error) {
+	req := kmsg.NewPtrMetadataRequest()
+	reqTopic := kmsg.NewMetadataRequestTopic()
+	reqTopic.Topic = kmsg.StringPtr(topic)
+	req.Topics = append(req.Topics, reqTopic)
+
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata of %s: %w", topic, err)
+	}
+	if len(resp.Topics) != 1 {
+		return nil, fmt.Errorf("no metadata for topic %s", topic)
+	}
+	if err := kerr.ErrorForCode(resp.Topics[0].ErrorCode); err != nil {
+		return nil, fmt.Errorf("failed to load metadata of %s: %w", topic, err)
+	}
+
+	partitions := make([]int32, 0, len(resp.Topics[0].Partitions))
+	for _, partition := range resp.Topics[0].Partitions {
+		partitions = append(partitions, partition.Partition)
+	}
+
+	return partitions, nil
+}
+
+// listOffsets returns the first offset of every partition with a record
+// produced at or after the timestamp in milliseconds, or -1 when there is
+// none.
+func listOffsets(ctx context.Context, client *kgo.Client, topic string, partitions []int32, timestamp int64) (map[int32]int64, error) {
+	req := kmsg.NewPtrListOffsetsRequest()
+	req.ReplicaID = -1
+	reqTopic := kmsg.NewListOffsetsRequestTopic()
+	reqTopic.Topic = topic
+	for _, partition := range partitions {
+		reqPartition := kmsg.NewListOffsetsRequestTopicPartition()
+		reqPartition.Partition = partition
+		reqPartition.Timestamp = timestamp
+		reqTopic.Partitions = append(reqTopic.Partitions, reqPartition)
+	}
+	req.Topics = append(req.Topics, reqTopic)
+
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets of %s: %w", topic, err)
+	}
+
+	offsets := make(map[int32]int64, len(partitions))
+	for _, respTopic := range resp.Topics {
+		for _, partition := range respTopic.Partitions {
+			if err := kerr.ErrorForCode(partition.ErrorCode); err != nil {
+				return nil, fmt.Errorf("failed to list offsets of %s[%d]: %w", topic, partition.Partition, err)
+			}
+			offsets[partition.Partition] = partition.Offset
+		}
+	}
+	if len(offsets) != len(partitions) {
+		return nil, fmt.Errorf("offsets of %s are missing partitions", topic)
+	}
+
+	return offsets, nil
+}
@@ -0,0 +1,376 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/scythe504/solana-indexer/internal/utils"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	headerReceiver       = "receiver"
+	headerTimestamp      = "timestamp"
+	headerAttempt        = "attempt"
+	headerLastError      = "last_error"
+	headerSubscriptionId = "subscription_id"
+	headerRetryAfter     = "retry_after"
+	headerBackfill       = "backfill"
+	headerCatchUp        = "catch_up"
+	headerSchemaVersion  = "schema_version"
+)
+
+// RetryPolicy decides where records that failed to index are sent next and
+// how long they wait before being tried again. Retries go to one topic per
+// backoff, RetryTopic suffixed with the first attempt waiting that long, so
+// every record of a retry partition waits as long as the ones before it and
+// a long backoff never holds up a shorter one.
+type RetryPolicy struct {
+	RetryTopic      string
+	DeadLetterTopic string
+	MaxAttempts     int
+	BaseBackoff     time.Duration
+	MaxBackoff      time.Duration
+}
+
+// LoadRetryPolicy reads the retry policy from the environment. The retry and
+// dead-letter topics default to KAFKA_TOPIC suffixed with -retry and -dlq.
+func LoadRetryPolicy() RetryPolicy {
+	topic := os.Getenv("KAFKA_TOPIC")
+	policy := RetryPolicy{
+		RetryTopic:      os.Getenv("KAFKA_RETRY_TOPIC"),
+		DeadLetterTopic: os.Getenv("KAFKA_DLQ_TOPIC"),
+		MaxAttempts:     5,
+		BaseBackoff:     5 * time.Second,
+		MaxBackoff:      10 * time.Minute,
+	}
+
+	if policy.RetryTopic == "" {
+		policy.RetryTopic = topic + "-retry"
+	}
+	if policy.DeadLetterTopic == "" {
+		policy.DeadLetterTopic = topic + "-dlq"
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("KAFKA_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		policy.MaxAttempts = attempts
+	}
+	if backoff, err := time.ParseDuration(os.Getenv("KAFKA_RETRY_BACKOFF")); err == nil && backoff > 0 {
+		policy.BaseBackoff = backoff
+	}
+	if backoff, err := time.ParseDuration(os.Getenv("KAFKA_RETRY_MAX_BACKOFF")); err == nil && backoff > 0 {
+		policy.MaxBackoff = backoff
+	}
+
+	return policy
+}
+
+// Backoff returns how long a record waits before the given attempt. The
+// delay doubles with every attempt and is capped at MaxBackoff.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt <= 1 {
+		return p.BaseBackoff
+	}
+
+	backoff := p.BaseBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+
+	return backoff
+}
+
+// RetryTopicFor returns the retry topic of the given attempt. Attempts
+// capped at the same backoff share the topic of the first of them.
+func (p RetryPolicy) RetryTopicFor(attempt int) string {
+	tier := max(attempt, 1)
+	for tier > 1 && p.Backoff(tier-1) == p.Backoff(attempt) {
+		tier--
+	}
+
+	return fmt.Sprintf("%s-%d", p.RetryTopic, tier)
+}
+
+// RetryTopics returns every retry topic records can be sent to, plus
+// RetryTopic itself, which held every retry before they were split by
+// backoff and is still drained.
+func (p RetryPolicy) RetryTopics() []string {
+	topics := []string{p.RetryTopic}
+	for attempt := 1; attempt < p.MaxAttempts; attempt++ {
+		if topic := p.RetryTopicFor(attempt); !slices.Contains(topics, topic) {
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics
+}
+
+// HandleIndexFailures routes a record that failed to index. A record that
+// failed as a whole is forwarded unchanged, while per-subscription failures
+// are split into one record per subscription so that a retry only writes to
+// the destination that failed. Records are produced synchronously, so the
+// caller can commit the source offset once this returns nil.
+func (p RetryPolicy) HandleIndexFailures(ctx context.Context, client *kgo.Client, record *kgo.Record, failures []IndexFailure, recordErr error) error {
+	attempt := recordAttempt(record) + 1
+
+	if recordErr != nil {
+		if errors.Is(recordErr, ErrMalformedRecord) {
+			attempt = p.MaxAttempts
+		}
+		subscriptionId := recordHeader(record, headerSubscriptionId)
+		if err := p.forward(ctx, client, record, record.Value, subscriptionId, attempt, recordErr); err != nil {
+			return err
+		}
+	}
+
+	for _, failure := range failures {
+		value, err := EncodeRecordValue([]WebhookPayload{failure.Payload}, recordSchemaVersion(record))
+		if err != nil {
+			log.Printf("Failed to encode failed payload %s, err: %v", failure.Payload.Signature, err)
+			return err
+		}
+		if err := p.forward(ctx, client, record, value, failure.SubscriptionId, attempt, failure.Err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forward produces value to the retry topic, or to the dead-letter topic
+// once the attempt budget is spent.
+func (p RetryPolicy) forward(ctx context.Context, client *kgo.Client, source *kgo.Record, value []byte, subscriptionId string, attempt int, cause error) error {
+	receiverName := recordReceiver(source)
+	topic := p.RetryTopicFor(attempt)
+	deadLetter := attempt >= p.MaxAttempts
+	if deadLetter {
+		topic = p.DeadLetterTopic
+	}
+
+	headers := []kgo.RecordHeader{
+		{Key: headerReceiver, Value: []byte(receiverName)},
+		{Key: headerTimestamp, Value: []byte(time.Now().Format(time.RFC3339))},
+		{Key: headerAttempt, Value: []byte(strconv.Itoa(attempt))},
+		{Key: headerLastError, Value: []byte(cause.Error())},
+		{Key: headerSubscriptionId, Value: []byte(subscriptionId)},
+		schemaVersionHeader(recordSchemaVersion(source)),
+	}
+	if !deadLetter {
+		retryAfter := time.Now().Add(p.Backoff(attempt))
+		headers = append(headers, kgo.RecordHeader{
+			Key:   headerRetryAfter,
+			Value: []byte(retryAfter.Format(time.RFC3339Nano)),
+		})
+	}
+
+	out := &kgo.Record{
+		Topic:   topic,
+		Key:     source.Key,
+		Value:   value,
+		Headers: headers,
+	}
+
+	if err := client.ProduceSync(ctx, out).FirstErr(); err != nil {
+		log.Printf("Failed to produce record to %s, err: %v", topic, err)
+		return err
+	}
+
+	if !deadLetter {
+		return nil
+	}
+
+	storeDeadLetter(source, value, subscriptionId, attempt, cause)
+
+	return nil
+}
+
+// storeDeadLetter indexes a dead-lettered record for the admin API. The
+// record is already safe on the dead-letter topic or table, so a failure is
+// only logged and must not block the partition.
+func storeDeadLetter(source *kgo.Record, value []byte, subscriptionId string, attempt int, cause error) {
+	log.Printf("Record for subscription %q dead-lettered after %d attempts: %v", subscriptionId, attempt, cause)
+
+	deadLetterRecord := database.DeadLetterRecord{
+		Id:              utils.GenerateUUID(),
+		ReceiverName:    recordReceiver(source),
+		SubscriptionId:  subscriptionId,
+		Payload:         jsonValue(value, recordSchemaVersion(source)),
+		Attempts:        attempt,
+		LastError:       cause.Error(),
+		SourceTopic:     source.Topic,
+		SourcePartition: source.Partition,
+		SourceOffset:    source.Offset,
+		CreatedAt:       time.Now(),
+	}
+
+	if err := database.New().CreateDeadLetterRecord(deadLetterRecord); err != nil {
+		log.Printf("Failed to store dead-letter record %s, err: %v", deadLetterRecord.Id, err)
+	}
+}
+
+// retryDue reports whether the record's retry_after time has passed.
+// Records without a readable retry_after are always due.
+func retryDue(record *kgo.Record, now time.Time) bool {
+	retryAfter, err := time.Parse(time.RFC3339Nano, recordHeader(record, headerRetryAfter))
+	if err != nil {
+		return true
+	}
+	return !retryAfter.After(now)
+}
+
+// topicPartition identifies a partition across topics.
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// deferRetries splits records into the ones to index now and, per retry
+// topic partition, the first record that is not due yet. Records of a
+// partition that follow its deferred record are dropped, as they are
+// fetched again once the partition is rewound to it.
+func deferRetries(policy RetryPolicy, records []*kgo.Record, now time.Time) ([]*kgo.Record, map[topicPartition]*kgo.Record) {
+	retryTopics := policy.RetryTopics()
+	due := make([]*kgo.Record, 0, len(records))
+	deferred := make(map[topicPartition]*kgo.Record)
+
+	for _, record := range records {
+		if !slices.Contains(retryTopics, record.Topic) {
+			due = append(due, record)
+			continue
+		}
+		partition := topicPartition{record.Topic, record.Partition}
+		if _, ok := deferred[partition]; ok {
+			continue
+		}
+		if !retryDue(record, now) {
+			deferred[partition] = record
+			continue
+		}
+		due = append(due, record)
+	}
+
+	return due, deferred
+}
+
+// pauseUntilDue stops fetching every partition holding a deferred record,
+// rewinds it to that record and resumes it once the record is due, so a
+// long backoff neither blocks the other partitions nor holds up a
+// rebalance. It must be called between polls, while rebalancing is blocked
+// and no commit is in flight.
+func pauseUntilDue(client *kgo.Client, deferred map[topicPartition]*kgo.Record) {
+	if len(deferred) == 0 {
+		return
+	}
+
+	offsets := make(map[string]map[int32]kgo.EpochOffset)
+	for partition, record := range deferred {
+		paused := map[string][]int32{partition.topic: {partition.partition}}
+		client.PauseFetchPartitions(paused)
+
+		if offsets[partition.topic] == nil {
+			offsets[partition.topic] = make(map[int32]kgo.EpochOffset)
+		}
+		offsets[partition.topic][partition.partition] = kgo.EpochOffset{Epoch: record.LeaderEpoch, Offset: record.Offset}
+
+		delay := time.Second
+		if retryAfter, err := time.Parse(time.RFC3339Nano, recordHeader(record, headerRetryAfter)); err == nil {
+			delay = time.Until(retryAfter)
+		}
+		// An earlier timer may resume the partition first, its record is
+		// then simply deferred again
+		time.AfterFunc(delay, func() {
+			client.ResumeFetchPartitions(paused)
+		})
+	}
+
+	client.SetOffsets(offsets)
+}
+
+// ReplayDeadLetter produces a dead-lettered record back onto KAFKA_TOPIC
+// with a fresh attempt budget, targeted at the subscription that failed.
+func (m *KafkaClientManager) ReplayDeadLetter(record database.DeadLetterRecord) error {
+	client, err := m.GetClient()
+	if err != nil {
+		log.Printf("Failed to create Kafka producer: %v", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out := &kgo.Record{
+		Topic: os.Getenv("KAFKA_TOPIC"),
+		Key:   []byte(deadLetterKey(record)),
+		Value: record.Payload,
+		Headers: []kgo.RecordHeader{
+			{Key: headerReceiver, Value: []byte(record.ReceiverName)},
+			{Key: headerTimestamp, Value: []byte(time.Now().Format(time.RFC3339))},
+			{Key: headerSubscriptionId, Value: []byte(record.SubscriptionId)},
+			schemaVersionHeader(schemaVersionJSON),
+		},
+	}
+
+	if err := client.ProduceSync(ctx, out).FirstErr(); err != nil {
+		return fmt.Errorf("failed to replay dead-letter record %s: %w", record.Id, err)
+	}
+
+	return nil
+}
+
+// jsonValue returns a record value as the JSON payloads dead-letter records
+// are stored and replayed as. Values that do not decode are kept as they
+// are.
+func jsonValue(value []byte, version int) []byte {
+	if version == schemaVersionJSON {
+		return value
+	}
+
+	payloads, err := DecodeRecordValue(value, version)
+	if err != nil {
+		return value
+	}
+	encoded, err := json.Marshal(payloads)
+	if err != nil {
+		return value
+	}
+	return encoded
+}
+
+// deadLetterKey keys a replayed record like the transaction it holds.
+// Malformed records fall back to their receiver name.
+func deadLetterKey(record database.DeadLetterRecord) string {
+	var payloads []WebhookPayload
+	if err := json.Unmarshal(record.Payload, &payloads); err != nil || len(payloads) == 0 {
+		return record.ReceiverName
+	}
+	return PartitionKey(payloads[0])
+}
+
+func recordHeader(record *kgo.Record, key string) string {
+	for _, header := range record.Headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+
+	return ""
+}
+
+func recordAttempt(record *kgo.Record) int {
+	attempt, err := strconv.Atoi(recordHeader(record, headerAttempt))
+	if err != nil {
+		return 0
+	}
+
+	return attempt
+}
@@ -0,0 +1,156 @@
+package kafka
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		BaseBackoff: time.Second,
+		MaxBackoff:  10 * time.Second,
+	}
+
+	cases := map[int]time.Duration{
+		0: time.Second,
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 8 * time.Second,
+		5: 10 * time.Second,
+		9: 10 * time.Second,
+	}
+
+	for attempt, expected := range cases {
+		if got := policy.Backoff(attempt); got != expected {
+			t.Errorf("attempt %d: expected backoff %v; got %v", attempt, expected, got)
+		}
+	}
+}
+
+func TestRecordAttempt(t *testing.T) {
+	record := &kgo.Record{
+		Headers: []kgo.RecordHeader{
+			{Key: headerReceiver, Value: []byte("webhook-0")},
+			{Key: headerAttempt, Value: []byte("3")},
+		},
+	}
+
+	if got := recordAttempt(record); got != 3 {
+		t.Errorf("expected attempt 3; got %d", got)
+	}
+
+	if got := recordAttempt(&kgo.Record{}); got != 0 {
+		t.Errorf("expected attempt 0 for a record without headers; got %d", got)
+	}
+}
+
+func TestDeferRetries(t *testing.T) {
+	policy := RetryPolicy{RetryTopic: "retries"}
+	now := time.Now()
+
+	retryRecord := func(partition int32, offset int64, retryAfter time.Time) *kgo.Record {
+		return &kgo.Record{
+			Topic:     policy.RetryTopic,
+			Partition: partition,
+			Offset:    offset,
+			Headers: []kgo.RecordHeader{
+				{Key: headerRetryAfter, Value: []byte(retryAfter.Format(time.RFC3339Nano))},
+			},
+		}
+	}
+
+	webhook := &kgo.Record{Topic: "webhooks", Offset: 7}
+	dueFirst := retryRecord(0, 1, now.Add(-time.Second))
+	notDue := retryRecord(0, 2, now.Add(time.Minute))
+	// Due, but behind a record that is not
+	dueAfter := retryRecord(0, 3, now.Add(-time.Second))
+	otherPartition := retryRecord(1, 1, now.Add(-time.Second))
+	noHeader := &kgo.Record{Topic: policy.RetryTopic, Partition: 2}
+
+	due, deferred := deferRetries(policy, []*kgo.Record{webhook, dueFirst, notDue, dueAfter, otherPartition, noHeader}, now)
+
+	expected := []*kgo.Record{webhook, dueFirst, otherPartition, noHeader}
+	if len(due) != len(expected) {
+		t.Fatalf("expected %d due records; got %d", len(expected), len(due))
+	}
+	for i, record := range expected {
+		if due[i] != record {
+			t.Errorf("due record %d: expected %s[%d]@%d; got %s[%d]@%d", i, record.Topic, record.Partition, record.Offset, due[i].Topic, due[i].Partition, due[i].Offset)
+		}
+	}
+
+	if len(deferred) != 1 || deferred[topicPartition{policy.RetryTopic, 0}] != notDue {
+		t.Errorf("expected partition 0 to be deferred to offset 2; got %v", deferred)
+	}
+}
+
+func TestRetryTopicFor(t *testing.T) {
+	policy := RetryPolicy{
+		RetryTopic:  "retries",
+		MaxAttempts: 6,
+		BaseBackoff: time.Second,
+		MaxBackoff:  4 * time.Second,
+	}
+
+	// Attempts 3 and later are capped at the same backoff
+	cases := map[int]string{
+		1: "retries-1",
+		2: "retries-2",
+		3: "retries-3",
+		4: "retries-3",
+		5: "retries-3",
+	}
+	for attempt, expected := range cases {
+		if got := policy.RetryTopicFor(attempt); got != expected {
+			t.Errorf("attempt %d: expected %s; got %s", attempt, expected, got)
+		}
+	}
+
+	expected := []string{"retries", "retries-1", "retries-2", "retries-3"}
+	if got := policy.RetryTopics(); !slices.Equal(got, expected) {
+		t.Errorf("expected retry topics %v; got %v", expected, got)
+	}
+}
+
+func TestDeferRetriesWithMixedAttempts(t *testing.T) {
+	policy := RetryPolicy{
+		RetryTopic:  "retries",
+		MaxAttempts: 5,
+		BaseBackoff: time.Second,
+		MaxBackoff:  time.Hour,
+	}
+	now := time.Now()
+
+	// Records of different attempts, all produced to partition 0 of their
+	// retry topic, in the order they failed
+	retryRecord := func(attempt int, offset int64) *kgo.Record {
+		retryAfter := now.Add(-2 * time.Second).Add(policy.Backoff(attempt))
+		return &kgo.Record{
+			Topic:     policy.RetryTopicFor(attempt),
+			Partition: 0,
+			Offset:    offset,
+			Headers: []kgo.RecordHeader{
+				{Key: headerAttempt, Value: []byte(strconv.Itoa(attempt))},
+				{Key: headerRetryAfter, Value: []byte(retryAfter.Format(time.RFC3339Nano))},
+			},
+		}
+	}
+	longBackoff := retryRecord(4, 0)
+	firstRetry := retryRecord(1, 0)
+	secondRetry := retryRecord(1, 1)
+	behindLongBackoff := retryRecord(4, 1)
+
+	due, deferred := deferRetries(policy, []*kgo.Record{longBackoff, firstRetry, secondRetry, behindLongBackoff}, now)
+
+	if !slices.Equal(due, []*kgo.Record{firstRetry, secondRetry}) {
+		t.Errorf("expected the first attempts to be due behind the long backoff; got %d due records", len(due))
+	}
+	if len(deferred) != 1 || deferred[topicPartition{policy.RetryTopicFor(4), 0}] != longBackoff {
+		t.Errorf("expected only the long backoff to be deferred; got %v", deferred)
+	}
+}
@@ -0,0 +1,261 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+// Sink is a destination for indexed transactions. A sink is created per
+// user and kind, prepared once, and then receives every batch of jobs for
+// that user's subscriptions targeting it. Write may be called from several
+// worker goroutines at once.
+type Sink interface {
+	// Prepare connects to the destination and makes sure it can accept
+	// writes, e.g. by creating tables or directories.
+	Prepare(ctx context.Context) error
+
+	// Write stores the batch. It must be safe to call again with the same
+	// jobs, since failed batches are retried. A sink that can tell which
+	// jobs failed returns JobErrors.
+	Write(ctx context.Context, batch []IndexJob) error
+
+	// Close flushes and releases anything the sink holds open.
+	Close() error
+}
+
+// JobErrors is returned by Write when only some jobs of a batch failed. It
+// maps the index of every failed job in the batch to its error, so only
+// those are retried.
+type JobErrors map[int]error
+
+func (e JobErrors) Error() string {
+	first := -1
+	for i := range e {
+		if first < 0 || i < first {
+			first = i
+		}
+	}
+	return fmt.Sprintf("%d jobs failed, first: %v", len(e), e[first])
+}
+
+// SinkFactory creates the sink of one kind for a user.
+type SinkFactory func(userId string) (Sink, error)
+
+var (
+	sinkFactoriesMu sync.RWMutex
+	sinkFactories   = make(map[database.SinkKind]SinkFactory)
+)
+
+// RegisterSink makes a sink kind available to subscriptions. It is meant to
+// be called from init functions and panics if the kind is registered twice.
+func RegisterSink(kind database.SinkKind, factory SinkFactory) {
+	sinkFactoriesMu.Lock()
+	defer sinkFactoriesMu.Unlock()
+
+	if _, ok := sinkFactories[kind]; ok {
+		panic(fmt.Sprintf("sink %q registered twice", kind))
+	}
+	sinkFactories[kind] = factory
+}
+
+// HasSink reports whether a sink kind is registered.
+func HasSink(kind database.SinkKind) bool {
+	sinkFactoriesMu.RLock()
+	defer sinkFactoriesMu.RUnlock()
+
+	_, ok := sinkFactories[kind]
+	return ok
+}
+
+type sinkKey struct {
+	kind   database.SinkKind
+	userId string
+}
+
+const defaultSinkIdleTimeout = 10 * time.Minute
+
+// sinkCache holds the prepared sinks shared by every worker goroutine.
+// Entries are reference counted: a discarded or evicted sink is only closed
+// once the writers that got it from get have released it.
+type sinkCache struct {
+	mu          sync.Mutex
+	entries     map[sinkKey]*cachedSink
+	idleTimeout time.Duration
+	lastSweep   time.Time
+}
+
+type cachedSink struct {
+	sink     Sink
+	refs     int
+	lastUsed time.Time
+	// Set once the entry left the cache, the last release closes it
+	removed bool
+}
+
+var sinks = newSinkCache(sinkIdleTimeout())
+
+func newSinkCache(idleTimeout time.Duration) *sinkCache {
+	return &sinkCache{
+		entries:     make(map[sinkKey]*cachedSink),
+		idleTimeout: idleTimeout,
+		lastSweep:   time.Now(),
+	}
+}
+
+// sinkIdleTimeout reads SINK_IDLE_TIMEOUT, after which an unused sink is
+// closed.
+func sinkIdleTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("SINK_IDLE_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return defaultSinkIdleTimeout
+	}
+	return timeout
+}
+
+// get returns the prepared sink for key, creating and preparing it on first
+// use, and a release function the caller must call once it is done writing.
+func (c *sinkCache) get(key sinkKey) (Sink, func(), error) {
+	c.evictIdle(time.Now())
+
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok {
+		c.mu.Unlock()
+		return entry.sink, c.acquire(entry), nil
+	}
+	c.mu.Unlock()
+
+	sinkFactoriesMu.RLock()
+	factory, ok := sinkFactories[key.kind]
+	sinkFactoriesMu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown sink %q", key.kind)
+	}
+
+	sink, err := factory(key.userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Prepare outside the lock so a slow destination does not hold up the
+	// other workers
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err = sink.Prepare(ctx); err != nil {
+		sink.Close()
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	// Another worker may have prepared the same sink in the meantime
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &cachedSink{sink: sink}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	if ok {
+		sink.Close()
+	}
+	return entry.sink, c.acquire(entry), nil
+}
+
+// acquire takes a reference on entry and returns the function releasing it.
+func (c *sinkCache) acquire(entry *cachedSink) func() {
+	c.mu.Lock()
+	entry.refs++
+	entry.lastUsed = time.Now()
+	c.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			entry.refs--
+			entry.lastUsed = time.Now()
+			closeNow := entry.removed && entry.refs == 0
+			c.mu.Unlock()
+
+			if closeNow {
+				closeSink(entry.sink)
+			}
+		})
+	}
+}
+
+// discard removes the sink for key from the cache, so the next get prepares
+// a new one. It is closed once its last writer releases it.
+func (c *sinkCache) discard(key sinkKey, sink Sink) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	// A writer holding an older sink must not discard its replacement
+	if !ok || entry.sink != sink {
+		c.mu.Unlock()
+		return
+	}
+	closeNow := c.removeLocked(key, entry)
+	c.mu.Unlock()
+
+	if closeNow {
+		closeSink(entry.sink)
+	}
+}
+
+// evictIdle closes the sinks nobody has used for the idle timeout. It sweeps
+// at most once per idle timeout.
+func (c *sinkCache) evictIdle(now time.Time) {
+	var idle []Sink
+
+	c.mu.Lock()
+	if now.Sub(c.lastSweep) < c.idleTimeout {
+		c.mu.Unlock()
+		return
+	}
+	c.lastSweep = now
+
+	for key, entry := range c.entries {
+		if entry.refs == 0 && now.Sub(entry.lastUsed) >= c.idleTimeout {
+			c.removeLocked(key, entry)
+			idle = append(idle, entry.sink)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, sink := range idle {
+		closeSink(sink)
+	}
+}
+
+// removeLocked drops entry from the cache and reports whether it can be
+// closed straight away.
+func (c *sinkCache) removeLocked(key sinkKey, entry *cachedSink) bool {
+	delete(c.entries, key)
+	entry.removed = true
+	return entry.refs == 0
+}
+
+// closeAll closes every sink, used when the worker shuts down and nothing
+// writes anymore.
+func (c *sinkCache) closeAll() {
+	c.mu.Lock()
+	entries := c.entries
+	c.entries = make(map[sinkKey]*cachedSink)
+	c.mu.Unlock()
+
+	for _, entry := range entries {
+		closeSink(entry.sink)
+	}
+}
+
+func closeSink(sink Sink) {
+	if err := sink.Close(); err != nil {
+		log.Printf("Failed to close %T, err: %v", sink, err)
+	}
+}
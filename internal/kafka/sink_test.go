@@ -0,0 +1,198 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+type fakeSink struct {
+	mu       sync.Mutex
+	prepared bool
+	closed   bool
+	writes   int
+	// Number of jobs of every Write call
+	batchSizes []int
+	// Write fails while set
+	writeErr error
+}
+
+func (s *fakeSink) Prepare(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prepared = true
+	return nil
+}
+
+func (s *fakeSink) Write(ctx context.Context, batch []IndexJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("write to a closed sink")
+	}
+	s.writes++
+	s.batchSizes = append(s.batchSizes, len(batch))
+	return s.writeErr
+}
+
+func (s *fakeSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeSink) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// registerFakeSinks registers a sink kind creating fake sinks and returns
+// every sink it created.
+func registerFakeSinks(t *testing.T, kind database.SinkKind) func() []*fakeSink {
+	var (
+		mu      sync.Mutex
+		created []*fakeSink
+	)
+	RegisterSink(kind, func(userId string) (Sink, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		sink := &fakeSink{}
+		created = append(created, sink)
+		return sink, nil
+	})
+	t.Cleanup(func() {
+		sinkFactoriesMu.Lock()
+		delete(sinkFactories, kind)
+		sinkFactoriesMu.Unlock()
+	})
+
+	return func() []*fakeSink {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*fakeSink(nil), created...)
+	}
+}
+
+func TestRegisterSink(t *testing.T) {
+	kind := database.SinkKind("test-registry")
+	if HasSink(kind) {
+		t.Fatalf("%s is registered before the test", kind)
+	}
+
+	registerFakeSinks(t, kind)
+	if !HasSink(kind) {
+		t.Fatalf("expected %s to be registered", kind)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a kind twice to panic")
+		}
+	}()
+	RegisterSink(kind, func(userId string) (Sink, error) { return &fakeSink{}, nil })
+}
+
+func TestSinkCacheSharesPreparedSinks(t *testing.T) {
+	kind := database.SinkKind("test-shared")
+	created := registerFakeSinks(t, kind)
+	cache := newSinkCache(time.Hour)
+
+	first, releaseFirst, err := cache.get(sinkKey{kind: kind, userId: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, releaseSecond, err := cache.get(sinkKey{kind: kind, userId: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseFirst()
+	releaseSecond()
+
+	if first != second || len(created()) != 1 || !created()[0].prepared {
+		t.Errorf("expected one prepared sink per key; got %d", len(created()))
+	}
+
+	if _, _, err := cache.get(sinkKey{kind: "test-unknown", userId: "user-1"}); err == nil {
+		t.Error("expected an unknown kind to fail")
+	}
+}
+
+func TestSinkCacheDiscardWaitsForWriters(t *testing.T) {
+	kind := database.SinkKind("test-discard")
+	created := registerFakeSinks(t, kind)
+	cache := newSinkCache(time.Hour)
+	key := sinkKey{kind: kind, userId: "user-1"}
+
+	sink, releaseWriter, err := cache.get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, releaseFailed, err := cache.get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One writer failed and discards the sink while the other still writes
+	cache.discard(key, failed)
+	releaseFailed()
+	if created()[0].isClosed() {
+		t.Fatal("a discarded sink was closed while a writer still held it")
+	}
+	if err := sink.Write(context.Background(), nil); err != nil {
+		t.Fatalf("write after discard failed: %v", err)
+	}
+
+	replacement, releaseReplacement, err := cache.get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseReplacement()
+	if replacement == sink {
+		t.Fatal("expected a discarded sink to be replaced")
+	}
+
+	// A late discard of the old sink leaves its replacement alone
+	cache.discard(key, sink)
+	releaseWriter()
+	if !created()[0].isClosed() {
+		t.Error("expected the discarded sink to be closed by its last writer")
+	}
+	if created()[1].isClosed() {
+		t.Error("the replacement was closed by a discard of the old sink")
+	}
+}
+
+func TestSinkCacheEvictsIdleSinks(t *testing.T) {
+	kind := database.SinkKind("test-idle")
+	created := registerFakeSinks(t, kind)
+	cache := newSinkCache(time.Minute)
+
+	_, releaseIdle, err := cache.get(sinkKey{kind: kind, userId: "idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseIdle()
+	_, releaseBusy, err := cache.get(sinkKey{kind: kind, userId: "busy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseBusy()
+
+	cache.evictIdle(time.Now().Add(2 * time.Minute))
+
+	if !created()[0].isClosed() {
+		t.Error("expected the idle sink to be closed")
+	}
+	if created()[1].isClosed() {
+		t.Error("a sink in use was evicted")
+	}
+	if _, ok := cache.entries[sinkKey{kind: kind, userId: "idle"}]; ok {
+		t.Error("expected the idle sink to leave the cache")
+	}
+}
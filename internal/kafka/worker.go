@@ -2,406 +2,332 @@ package kafka
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"slices"
-	"time"
+	"strings"
 
 	"github.com/scythe504/solana-indexer/internal/database"
-	"github.com/scythe504/solana-indexer/internal/utils"
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
-func ParseBodyAndPushToProducer(m *KafkaClientManager, body []byte, receiverName string) error {
+// providerReceiverPrefix marks receiver names of transactions that came
+// from an ingestion provider other than a Helius webhook. Those are matched
+// against every subscription instead of the ones of a single webhook.
+const providerReceiverPrefix = "provider:"
 
-	var jsonResp []WebhookPayload
-
-	err := json.Unmarshal(body, &jsonResp)
+// ProviderReceiver returns the receiver name records from provider are
+// produced under.
+func ProviderReceiver(provider string) string {
+	return providerReceiverPrefix + provider
+}
 
-	if err != nil {
-		log.Println("Error occured while parsing body, Invalid Json, err: ", err)
+// PushToProducer queues payloads that were already normalised by an
+// ingestion provider, and returns once the queue stored them.
+func PushToProducer(ctx context.Context, q Queue, payloads []WebhookPayload, receiverName string) error {
+	if err := q.ProduceWebhookPayload(ctx, payloads, receiverName); err != nil {
+		if !errors.Is(err, ErrProducerFull) {
+			log.Printf("Error occured while trying to produce, err: %v", err)
+		}
 		return err
 	}
 
-	kafkaClient, err := m.GetClient()
+	return nil
+}
+
+// ErrMalformedRecord marks a record whose value can never be indexed, so it
+// is dead-lettered straight away instead of being retried.
+var ErrMalformedRecord = errors.New("malformed webhook record")
+
+// IndexJob is a single payload matched to a single subscription.
+type IndexJob struct {
+	Subscription database.SubscriptionLookup
+	Payload      WebhookPayload
+	Record       *kgo.Record
+}
+
+// IndexFailure describes a payload that could not be written to the
+// destination of a single subscription.
+type IndexFailure struct {
+	SubscriptionId string
+	Payload        WebhookPayload
+	Record         *kgo.Record
+	Err            error
+}
+
+// StoreRecordForInterestedUsers indexes every payload in the record for the
+// subscriptions watching its addresses. A returned error means the record
+// as a whole could not be processed; failures for individual subscriptions
+// are returned separately so they can be retried on their own.
+func StoreRecordForInterestedUsers(record *kgo.Record) ([]IndexFailure, error) {
+	jobs, err := MatchRecord(record)
 	if err != nil {
-		log.Printf("Failed to create Kafka producer: %v", err)
-		return err
+		return nil, err
 	}
-	defer kafkaClient.Close()
 
-	if err = m.ProduceWebhookPayload(kafkaClient, jsonResp, receiverName); err != nil {
-		log.Printf("Error occured while trying to produce, err: %v", err)
-		return err
-	}
+	failures := IndexBatch(jobs)
+	recordSeenSignatures(jobs, failures)
 
-	return nil
+	return failures, nil
 }
 
-func StoreRecordForInterestedUsers(record *kgo.Record) error {
-	recordValue := record.Value
-	receiverName := string(record.Key)
+// MatchRecord decodes a record and returns one IndexJob for every payload
+// and subscription watching one of the payload's addresses. Records
+// carrying a subscription_id header only match that subscription.
+func MatchRecord(record *kgo.Record) ([]IndexJob, error) {
+	receiverName := recordReceiver(record)
+	if strings.HasPrefix(receiverName, providerReceiverPrefix) {
+		receiverName = ""
+	}
 
-	var jsonResp []WebhookPayload
+	return matchRecord(record, receiverName, recordHeader(record, headerSubscriptionId))
+}
 
-	if err := json.Unmarshal(recordValue, &jsonResp); err != nil {
-		log.Println("Error occured while parsing record value, err: ", err)
-		return err
+// MatchRecordForSubscription returns the jobs of a record for one
+// subscription only, whichever webhook received it. Records produced for
+// another subscription match nothing.
+func MatchRecordForSubscription(record *kgo.Record, subscriptionId string) ([]IndexJob, error) {
+	if target := recordHeader(record, headerSubscriptionId); target != "" && target != subscriptionId {
+		return nil, nil
 	}
 
-	var prevTxnType database.IndexingStrategy
+	return matchRecord(record, "", subscriptionId)
+}
 
-	for _, resp := range jsonResp {
+// matchRecord matches the payloads of a record against the subscriptions of
+// receiverName, or of every webhook when it is empty. A non empty
+// onlySubscription leaves out every other subscription.
+func matchRecord(record *kgo.Record, receiverName string, onlySubscription string) ([]IndexJob, error) {
+	jsonResp, err := decodeRecord(record)
+	if err != nil {
+		log.Println("Error occured while parsing record value, err: ", err)
+		return nil, fmt.Errorf("%w: %v", ErrMalformedRecord, err)
+	}
 
-		var finalInterestedSubscriptions []database.SubscriptionLookup
-		addressLookupSet := make(AddressSet)
-		var txnType database.IndexingStrategy
-		for _, strats := range database.StrategyTransactionTypes {
-			if slices.Contains(strats, resp.Type) {
-				txnType = database.IndexingStrategy(resp.Type)
-				prevTxnType = txnType
-				break
+	// Every payload's addresses are collected first, so subscriptions are
+	// looked up once per strategy for the whole record, and only for the
+	// addresses it touches
+	addressSets := make([]AddressSet, len(jsonResp))
+	strategyAddresses := make(map[database.IndexingStrategy]AddressSet)
+	for i, resp := range jsonResp {
+		addressSets[i] = payloadAddresses(resp)
+		for _, strategy := range strategiesForTxnType(resp.Type) {
+			if strategyAddresses[strategy] == nil {
+				strategyAddresses[strategy] = make(AddressSet)
+			}
+			for address := range addressSets[i] {
+				strategyAddresses[strategy].Add(address)
 			}
 		}
+	}
 
-		if txnType == "" {
-			continue
+	subscriptionsByStrategy := make(map[database.IndexingStrategy][]database.SubscriptionLookup)
+	for strategy, addresses := range strategyAddresses {
+		strategySubscriptions, err := database.New().GetSubscriptionsByTxnType(strategy, slices.Collect(maps.Keys(addresses)), receiverName)
+		if err != nil {
+			log.Printf("Error occurred while fetching subscriptions for strategy: %s, receiverName: %s, Error: %v", strategy, receiverName, err)
+			return nil, err
 		}
+		subscriptionsByStrategy[strategy] = strategySubscriptions
+	}
+
+	var jobs []IndexJob
+	for i, resp := range jsonResp {
+		addressLookupSet := addressSets[i]
 
 		var subscriptions []database.SubscriptionLookup
-		var err error
-		if prevTxnType != txnType {
-			subscriptions, err = database.Service.GetSubscriptionsByTxnType(database.New(), txnType, receiverName)
-			if err != nil {
-				log.Printf("Error occurred while fetching subscriptions for txnType: %s, receiverName: %s, Error: %v", txnType, receiverName, err)
-				return err
-			}
-			prevTxnType = txnType
+		for _, strategy := range strategiesForTxnType(resp.Type) {
+			subscriptions = append(subscriptions, subscriptionsByStrategy[strategy]...)
 		}
 
-		for _, accountData := range resp.AccountData {
-			if !addressLookupSet.Contains(accountData.Account) {
-				addressLookupSet[accountData.Account] = true
-			}
-			for _, tokenBalanceChanges := range accountData.TokenBalanceChanges {
-				if !addressLookupSet.Contains(tokenBalanceChanges.Mint) {
-					addressLookupSet[tokenBalanceChanges.Mint] = true
-				}
-				if !addressLookupSet.Contains(tokenBalanceChanges.UserAccount) {
-					addressLookupSet[tokenBalanceChanges.UserAccount] = true
-				}
+		if len(subscriptions) == 0 {
+			continue
+		}
 
-				if !addressLookupSet.Contains(tokenBalanceChanges.TokenAccount) {
-					addressLookupSet[tokenBalanceChanges.TokenAccount] = true
-				}
+		// A subscription with several strategies matching the same
+		// transaction type only gets the payload once
+		matched := make(map[string]bool)
+		for _, subscription := range subscriptions {
+			ref := subscriptionRef(subscription)
+			if onlySubscription != "" && ref != onlySubscription {
+				continue
 			}
-		}
+			if matched[ref] || !addressLookupSet.Contains(subscription.TokenAddress) {
+				continue
+			}
+			matched[ref] = true
 
-		if !addressLookupSet.Contains(resp.FeePayer) {
-			addressLookupSet[resp.FeePayer] = true
+			jobs = append(jobs, IndexJob{
+				Subscription: subscription,
+				Payload:      resp,
+				Record:       record,
+			})
 		}
+	}
 
-		for _, val := range resp.Instructions {
-			for _, acc := range val.Accounts {
-				if !addressLookupSet.Contains(acc) {
-					addressLookupSet[acc] = true
-				}
+	return jobs, nil
+}
 
+// payloadAddresses returns every address a payload touches.
+func payloadAddresses(resp WebhookPayload) AddressSet {
+	addresses := make(AddressSet)
+
+	for _, accountData := range resp.AccountData {
+		if !addresses.Contains(accountData.Account) {
+			addresses[accountData.Account] = true
+		}
+		for _, tokenBalanceChanges := range accountData.TokenBalanceChanges {
+			if !addresses.Contains(tokenBalanceChanges.Mint) {
+				addresses[tokenBalanceChanges.Mint] = true
 			}
-			for _, inner := range val.InnerInstructions {
-				for _, account := range inner.Accounts {
-					if !addressLookupSet.Contains(account) {
-						addressLookupSet[account] = true
-					}
-				}
+			if !addresses.Contains(tokenBalanceChanges.UserAccount) {
+				addresses[tokenBalanceChanges.UserAccount] = true
 			}
-		}
 
-		for _, val := range resp.NativeTransfers {
-			if !addressLookupSet.Contains(val.FromUserAccount) {
-				addressLookupSet[val.FromUserAccount] = true
-			}
-			if !addressLookupSet.Contains(val.ToUserAccount) {
-				addressLookupSet[val.ToUserAccount] = true
+			if !addresses.Contains(tokenBalanceChanges.TokenAccount) {
+				addresses[tokenBalanceChanges.TokenAccount] = true
 			}
 		}
+	}
 
-		for _, tokenTransfer := range resp.TokenTransfers {
-			if !addressLookupSet.Contains(tokenTransfer.FromUserAccount) {
-				addressLookupSet[tokenTransfer.FromUserAccount] = true
-			}
-			if !addressLookupSet.Contains(tokenTransfer.ToUserAccount) {
-				addressLookupSet[tokenTransfer.ToUserAccount] = true
-			}
-			if !addressLookupSet.Contains(tokenTransfer.FromTokenAccount) {
-				addressLookupSet[tokenTransfer.FromTokenAccount] = true
-			}
-			if !addressLookupSet.Contains(tokenTransfer.ToTokenAccount) {
-				addressLookupSet[tokenTransfer.ToTokenAccount] = true
-			}
-			if !addressLookupSet.Contains(tokenTransfer.Mint) {
-				addressLookupSet[tokenTransfer.Mint] = true
+	if !addresses.Contains(resp.FeePayer) {
+		addresses[resp.FeePayer] = true
+	}
+
+	for _, val := range resp.Instructions {
+		for _, acc := range val.Accounts {
+			if !addresses.Contains(acc) {
+				addresses[acc] = true
 			}
-		}
 
-		for _, subscription := range subscriptions {
-			for key := range addressLookupSet {
-				if subscription.TokenAddress == key {
-					finalInterestedSubscriptions = append(finalInterestedSubscriptions, subscription)
-					break
+		}
+		for _, inner := range val.InnerInstructions {
+			for _, account := range inner.Accounts {
+				if !addresses.Contains(account) {
+					addresses[account] = true
 				}
 			}
 		}
-
-		IndexDataForUsers(finalInterestedSubscriptions, resp)
 	}
 
-	return nil
-}
-
-func IndexDataForUsers(subscriptions []database.SubscriptionLookup, jsonPayload WebhookPayload) {
-	var prevUserId string
-	var dbConfig *database.UserDatabaseCredential
-	var err error
-	for _, subscription := range subscriptions {
-		if prevUserId != subscription.UserId {
-			dbConfig, err = database.Service.GetDatabaseConfig(database.New(), subscription.UserId)
-			if err != nil {
-				log.Printf("Error occured while fetching for database config for user: %s, err: %v", subscription.UserId, err)
-				continue
-			}
-			prevUserId = subscription.UserId
+	for _, val := range resp.NativeTransfers {
+		if !addresses.Contains(val.FromUserAccount) {
+			addresses[val.FromUserAccount] = true
+		}
+		if !addresses.Contains(val.ToUserAccount) {
+			addresses[val.ToUserAccount] = true
 		}
+	}
 
-		var (
-			dbUrl   = dbConfig.ConnectionString
-			connStr string
-		)
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		if dbUrl != nil {
-			connStr = *dbUrl
-		} else {
-			connStr = fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s", *dbConfig.User, *dbConfig.Password, *dbConfig.Host, *dbConfig.Port, *dbConfig.DatabaseName, *dbConfig.SSLMode)
+	for _, tokenTransfer := range resp.TokenTransfers {
+		if !addresses.Contains(tokenTransfer.FromUserAccount) {
+			addresses[tokenTransfer.FromUserAccount] = true
 		}
-		db, err := sql.Open("pgx", connStr)
-		if err != nil {
-			log.Printf("Error occured while connecting to database, userId: %s\n", dbConfig.UserId)
-			continue
+		if !addresses.Contains(tokenTransfer.ToUserAccount) {
+			addresses[tokenTransfer.ToUserAccount] = true
 		}
-		defer db.Close()
-		if err = db.PingContext(ctx); err != nil {
-			log.Printf("Error occured while connecting to database, userId: %s\n", dbConfig.UserId)
-			continue
+		if !addresses.Contains(tokenTransfer.FromTokenAccount) {
+			addresses[tokenTransfer.FromTokenAccount] = true
+		}
+		if !addresses.Contains(tokenTransfer.ToTokenAccount) {
+			addresses[tokenTransfer.ToTokenAccount] = true
+		}
+		if !addresses.Contains(tokenTransfer.Mint) {
+			addresses[tokenTransfer.Mint] = true
 		}
-		InsertPayloadInUserDatabase(ctx, db, jsonPayload, dbConfig.UserId, subscription.TableName)
-
 	}
-}
 
-func InsertPayloadInUserDatabase(ctx context.Context, db *sql.DB, payload WebhookPayload, userId string, tableName string) {
-	jsonBlob, err := json.Marshal(payload)
+	return addresses
+}
 
-	if err != nil {
-		log.Println("Failed to encode into json for userId: ", userId, err)
-		return
+// recordSeenSignatures tells the gap detector which transactions of watched
+// addresses were indexed, given the jobs of MatchRecord and the failures
+// IndexBatch returned for them. A failure only means they are fetched again
+// later, so it does not hold up indexing.
+func recordSeenSignatures(jobs []IndexJob, failures []IndexFailure) {
+	seen := seenSignatures(jobs, failures)
+	if err := database.New().RecordSeenSignatures(seen); err != nil {
+		log.Printf("Failed to record %d seen signatures: %v", len(seen), err)
 	}
-	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+}
 
-	db_uuid := utils.GenerateUUID()
-	if err != nil {
-		log.Printf("Failed to start transaction")
+// seenSignatures returns the signatures of the jobs that were indexed.
+// Failed jobs are left out, and so are retried and replayed records, which
+// carry an attempt or subscription_id header and arrive long after the
+// address's cursor moved past them.
+func seenSignatures(jobs []IndexJob, failures []IndexFailure) []database.SeenSignature {
+	type jobKey struct {
+		record         *kgo.Record
+		subscriptionId string
+		signature      string
 	}
-	defer tx.Rollback()
-	// Store raw json obj
-	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id VARCHAR(255) NOT NULL PRIMARY KEY, jsonData JSONB)", tableName)
-	_, err = tx.ExecContext(ctx, query)
-
-	if err != nil {
-		log.Println("Failed to create tables cannot proceed, userId: ", userId, err)
+	failed := make(map[jobKey]bool)
+	for _, failure := range failures {
+		failed[jobKey{failure.Record, failure.SubscriptionId, failure.Payload.Signature}] = true
 	}
 
-	insertQuery := fmt.Sprintf("INSERT INTO %s (id, jsonData) VALUES ($1, $2)", tableName)
-
-	_, err = tx.ExecContext(ctx, insertQuery, db_uuid, string(jsonBlob))
-
-	if err != nil {
-		log.Println("Failed to execute database query: ", userId, err)
-	}
+	var seen []database.SeenSignature
+	recorded := make(map[database.SeenSignature]bool)
+	for _, job := range jobs {
+		if recordAttempt(job.Record) > 0 || recordHeader(job.Record, headerSubscriptionId) != "" {
+			continue
+		}
+		if failed[jobKey{job.Record, subscriptionRef(job.Subscription), job.Payload.Signature}] {
+			continue
+		}
 
-	if err = CreateAndInsertNormalizedData(tx, payload, db_uuid); err != nil {
-		log.Println("Failed to insert normalized data, err: ", err)
+		signature := database.SeenSignature{
+			TokenAddress: job.Subscription.TokenAddress,
+			Signature:    job.Payload.Signature,
+			Slot:         job.Payload.Slot,
+		}
+		if !recorded[signature] {
+			recorded[signature] = true
+			seen = append(seen, signature)
+		}
 	}
 
-	if err = tx.Commit(); err != nil {
-		log.Println("Failed to commit transaction, changes will be rolled back")
-	}
+	return seen
 }
 
-func CreateAndInsertNormalizedData(tx *sql.Tx, payload WebhookPayload, payloadID string) error {
-	tableCreationQueries := []string{
-		`CREATE TABLE IF NOT EXISTS webhook_payloads (
-			id VARCHAR(255) PRIMARY KEY,
-			signature VARCHAR(255) NOT NULL,
-			slot BIGINT NOT NULL,
-			timestamp TIMESTAMP NOT NULL,
-			source VARCHAR(100),
-			fee INTEGER,
-			fee_payer VARCHAR(255),
-			transaction_type VARCHAR(50),
-			description TEXT
-		)`,
-		`CREATE TABLE IF NOT EXISTS account_data (
-			id SERIAL PRIMARY KEY,
-			payload_id VARCHAR(255) REFERENCES normalized_webhook_payloads(id),
-			account VARCHAR(255) NOT NULL,
-			native_balance_change NUMERIC(20,0)
-		)`,
-		`CREATE TABLE IF NOT EXISTS token_balance_changes (
-			id SERIAL PRIMARY KEY,
-			account_data_id INTEGER REFERENCES account_data(id),
-			mint VARCHAR(255) NOT NULL,
-			token_account VARCHAR(255) NOT NULL,
-			user_account VARCHAR(255) NOT NULL,
-			token_amount VARCHAR(100) NOT NULL,
-			decimals SMALLINT NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS native_transfers (
-			id SERIAL PRIMARY KEY,
-			payload_id VARCHAR(255) REFERENCES webhook_payloads(id),
-			from_user_account VARCHAR(255) NOT NULL,
-			to_user_account VARCHAR(255) NOT NULL,
-			amount NUMERIC(20,0) NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS token_transfers (
-			id SERIAL PRIMARY KEY,
-			payload_id VARCHAR(255) REFERENCES normalized_webhook_payloads(id),
-			from_token_account VARCHAR(255) NOT NULL,
-			from_user_account VARCHAR(255) NOT NULL,
-			to_token_account VARCHAR(255) NOT NULL,
-			to_user_account VARCHAR(255) NOT NULL,
-			mint VARCHAR(255) NOT NULL,
-			token_amount NUMERIC(20,8),
-			token_standard VARCHAR(50)
-		)`,
-	}
-
-	for _, query := range tableCreationQueries {
-		_, err := tx.Exec(query)
-		if err != nil {
-			return fmt.Errorf("failed to create normalized tables: %v", err)
+// strategiesForTxnType returns every indexing strategy that tracks the given
+// Helius transaction type.
+func strategiesForTxnType(txnType string) []database.IndexingStrategy {
+	var strategies []database.IndexingStrategy
+	for strategy, txnTypes := range database.StrategyTransactionTypes {
+		if slices.Contains(txnTypes, txnType) {
+			strategies = append(strategies, strategy)
 		}
 	}
 
-	// Insert payload metadata
-	payloadQuery := `
-		INSERT INTO normalized_webhook_payloads 
-		(id, signature, slot, timestamp, source, fee, fee_payer, transaction_type, description)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	`
-	_, err := tx.Exec(
-		payloadQuery,
-		payloadID,
-		payload.Signature,
-		payload.Slot,
-		time.Unix(payload.Timestamp, 0),
-		payload.Source,
-		payload.Fee,
-		payload.FeePayer,
-		payload.Type,
-		payload.Description,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to insert webhook payload: %v", err)
-	}
-
-	// Insert account data
-	for _, accountData := range payload.AccountData {
-		accountQuery := `
-			INSERT INTO normalized_account_data 
-			(payload_id, account, native_balance_change)
-			VALUES ($1, $2, $3)
-			RETURNING id
-		`
-		var accountDataID int
-		err = tx.QueryRow(
-			accountQuery,
-			payloadID,
-			accountData.Account,
-			accountData.NativeBalanceChange.String(),
-		).Scan(&accountDataID)
-		if err != nil {
-			return fmt.Errorf("failed to insert account data: %v", err)
-		}
-
-		// Insert token balance changes
-		for _, tokenChange := range accountData.TokenBalanceChanges {
-			tokenChangeQuery := `
-				INSERT INTO normalized_token_balance_changes
-				(account_data_id, mint, token_account, user_account, token_amount, decimals)
-				VALUES ($1, $2, $3, $4, $5, $6)
-			`
-			_, err = tx.Exec(
-				tokenChangeQuery,
-				accountDataID,
-				tokenChange.Mint,
-				tokenChange.TokenAccount,
-				tokenChange.UserAccount,
-				tokenChange.RawTokenAmount.TokenAmount,
-				tokenChange.RawTokenAmount.Decimals,
-			)
-			if err != nil {
-				return fmt.Errorf("failed to insert token balance changes: %v", err)
-			}
-		}
-	}
+	slices.Sort(strategies)
+	return strategies
+}
 
-	// Insert native transfers
-	for _, transfer := range payload.NativeTransfers {
-		nativeTransferQuery := `
-			INSERT INTO normalized_native_transfers
-			(payload_id, from_user_account, to_user_account, amount)
-			VALUES ($1, $2, $3, $4)
-		`
-		_, err = tx.Exec(
-			nativeTransferQuery,
-			payloadID,
-			transfer.FromUserAccount,
-			transfer.ToUserAccount,
-			transfer.Amount.String(),
-		)
-		if err != nil {
-			return fmt.Errorf("failed to insert native transfers: %v", err)
-		}
+// subscriptionRef identifies the subscription a lookup row was expanded
+// from. Lookup rows are rewritten whenever a subscription changes, so the
+// subscription id is what retries and deliveries refer to; rows from before
+// the lookup was linked to subscriptions fall back to their own id.
+func subscriptionRef(subscription database.SubscriptionLookup) string {
+	if subscription.SubscriptionId != "" {
+		return subscription.SubscriptionId
 	}
+	return subscription.Id
+}
 
-	// Insert token transfers
-	for _, transfer := range payload.TokenTransfers {
-		tokenTransferQuery := `
-			INSERT INTO normalized_token_transfers
-			(payload_id, from_token_account, from_user_account, 
-			 to_token_account, to_user_account, mint, 
-			 token_amount, token_standard)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		`
-		_, err = tx.Exec(
-			tokenTransferQuery,
-			payloadID,
-			transfer.FromTokenAccount,
-			transfer.FromUserAccount,
-			transfer.ToTokenAccount,
-			transfer.ToUserAccount,
-			transfer.Mint,
-			transfer.TokenAmount,
-			transfer.TokenStandard,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to insert token transfers: %v", err)
-		}
+// IndexDataForUsers writes the payload to the database of every given
+// subscription and returns one IndexFailure per subscription it could not
+// write to.
+func IndexDataForUsers(subscriptions []database.SubscriptionLookup, jsonPayload WebhookPayload) []IndexFailure {
+	jobs := make([]IndexJob, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		jobs = append(jobs, IndexJob{
+			Subscription: subscription,
+			Payload:      jsonPayload,
+		})
 	}
 
-	return nil
+	return IndexBatch(jobs)
 }
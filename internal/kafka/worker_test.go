@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestStrategiesForTxnType(t *testing.T) {
+	got := strategiesForTxnType("NFT_SALE")
+	want := []database.IndexingStrategy{database.NFTCurrentBids, database.NFTCurrentPrices}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v; got %v", want, got)
+	}
+
+	if got := strategiesForTxnType("UNKNOWN"); len(got) != 0 {
+		t.Errorf("expected no strategies for an unknown type; got %v", got)
+	}
+}
+
+func TestPayloadAddresses(t *testing.T) {
+	payload := WebhookPayload{
+		FeePayer:    "payer",
+		AccountData: []AccountData{{Account: "account"}},
+		Instructions: []Instruction{{
+			Accounts:          []string{"program-account"},
+			InnerInstructions: []InnerInstruction{{Accounts: []string{"inner-account"}}},
+		}},
+		NativeTransfers: []NativeTransfer{{FromUserAccount: "payer", ToUserAccount: "receiver"}},
+		TokenTransfers:  []TokenTransfer{{Mint: "mint", FromUserAccount: "payer", FromTokenAccount: "from-ata", ToUserAccount: "receiver", ToTokenAccount: "to-ata"}},
+	}
+
+	got := slices.Sorted(maps.Keys(payloadAddresses(payload)))
+	want := []string{"account", "from-ata", "inner-account", "mint", "payer", "program-account", "receiver", "to-ata"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v; got %v", want, got)
+	}
+}
+
+func TestSeenSignatures(t *testing.T) {
+	record := &kgo.Record{}
+	retried := &kgo.Record{Headers: []kgo.RecordHeader{{Key: headerAttempt, Value: []byte("1")}}}
+	replayed := &kgo.Record{Headers: []kgo.RecordHeader{{Key: headerSubscriptionId, Value: []byte("sub-1")}}}
+
+	job := func(record *kgo.Record, subscriptionId string, signature string) IndexJob {
+		return IndexJob{
+			Subscription: database.SubscriptionLookup{SubscriptionId: subscriptionId, TokenAddress: "mint"},
+			Payload:      WebhookPayload{Signature: signature, Slot: 1},
+			Record:       record,
+		}
+	}
+	jobs := []IndexJob{
+		job(record, "sub-1", "indexed"),
+		job(record, "sub-1", "failed"),
+		// Indexed for one subscription but failed for the other
+		job(record, "sub-1", "partly-failed"),
+		job(record, "sub-2", "partly-failed"),
+		job(retried, "sub-1", "retried"),
+		job(replayed, "sub-1", "replayed"),
+	}
+	failures := []IndexFailure{
+		{SubscriptionId: "sub-1", Payload: jobs[1].Payload, Record: record},
+		{SubscriptionId: "sub-2", Payload: jobs[3].Payload, Record: record},
+	}
+
+	var got []string
+	for _, seen := range seenSignatures(jobs, failures) {
+		got = append(got, seen.Signature)
+	}
+	want := []string{"indexed", "partly-failed"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v to be seen; got %v", want, got)
+	}
+}
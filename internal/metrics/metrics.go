@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"expvar"
+)
+
+// Counters are published through expvar and served as JSON by Handler.
+var (
+	// DedupeHits counts transactions skipped because their signature was
+	// already indexed in the destination table.
+	DedupeHits = expvar.NewInt("indexer_dedupe_hits_total")
+
+	// WebhookRejected counts calls to the webhook receiver that failed
+	// authentication, keyed by reason.
+	WebhookRejected = expvar.NewMap("indexer_webhook_rejected_total")
+
+	// WebhookDrift counts differences the webhook reconciler found between
+	// Helius and the active subscriptions, keyed by kind.
+	WebhookDrift = expvar.NewMap("indexer_webhook_drift_total")
+
+	// MissingTransactions counts transactions of watched addresses the
+	// worker never received, found and queued again by the gap detector.
+	MissingTransactions = expvar.NewInt("indexer_missing_transactions_total")
+
+	// ProducerRejected counts ingestion calls answered with 503 because the
+	// Kafka producer buffer was full.
+	ProducerRejected = expvar.NewInt("indexer_producer_rejected_total")
+)
+
+// Handler serves every published counter as JSON.
+var Handler = expvar.Handler
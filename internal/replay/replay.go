@@ -0,0 +1,303 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/scythe504/solana-indexer/internal/jobs"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	defaultMaxAttempts = 5
+	// A partition that returns nothing for this long has its high
+	// watermark checked, to tell whether it is replayed or stuck
+	idleTimeout = 10 * time.Second
+)
+
+// Runner works through queued replay jobs. Each job reads its time range of
+// KAFKA_TOPIC and writes the transactions matching its subscription to that
+// subscription's sink only, checkpointing after every batch. Sinks have to
+// accept the same transactions again for retries already, so replaying
+// transactions the destination still holds, or a batch cut off by a
+// restart, is safe.
+type Runner struct {
+	db          database.Service
+	kafka       *kafka.KafkaClientManager
+	batch       kafka.BatchConfig
+	maxAttempts int
+}
+
+// NewRunner reads REPLAY_MAX_ATTEMPTS (default 5), and batches like the
+// worker.
+func NewRunner(db database.Service, kafkaManager *kafka.KafkaClientManager) *Runner {
+	maxAttempts, err := strconv.Atoi(os.Getenv("REPLAY_MAX_ATTEMPTS"))
+	if err != nil || maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	return &Runner{
+		db:          db,
+		kafka:       kafkaManager,
+		batch:       kafka.LoadBatchConfig(),
+		maxAttempts: maxAttempts,
+	}
+}
+
+// RunPending runs claimed jobs until none is left and returns how many it
+// ran, failed runs included.
+func (r *Runner) RunPending(ctx context.Context) (int, error) {
+	return jobs.RunPending(ctx, r.claim)
+}
+
+func (r *Runner) claim(ctx context.Context, staleAfter time.Duration) (jobs.Job, error) {
+	job, err := r.db.ClaimReplayJob(ctx, staleAfter)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	return &replayJob{runner: r, job: job}, nil
+}
+
+// replayJob is a claimed replay job.
+type replayJob struct {
+	runner *Runner
+	job    *database.ReplayJob
+}
+
+func (j *replayJob) Run(ctx context.Context) error {
+	return j.runner.run(ctx, j.job)
+}
+
+func (j *replayJob) Heartbeat(ctx context.Context) error {
+	return j.runner.db.HeartbeatReplayJob(ctx, j.job.Id)
+}
+
+func (j *replayJob) Finish(ctx context.Context, cause error) error {
+	if cause != nil {
+		log.Printf("Replay job %s for subscription %s failed: %v", j.job.Id, j.job.SubscriptionId, cause)
+	}
+	return j.runner.db.FinishReplayJob(ctx, j.job.Id, cause, j.runner.maxAttempts)
+}
+
+func (r *Runner) run(ctx context.Context, job *database.ReplayJob) error {
+	partitions := job.Partitions
+	if partitions == nil {
+		var err error
+		partitions, err = r.kafka.ReplayOffsets(ctx, job.FromTime, job.ToTime)
+		if err != nil {
+			return fmt.Errorf("failed to look up offsets: %w", err)
+		}
+		if err := r.db.CheckpointReplayJob(ctx, job.Id, partitions, 0, 0); err != nil {
+			return err
+		}
+	}
+	if allDone(partitions) {
+		return nil
+	}
+
+	client, err := kafka.NewReplayClient(partitions)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	lastRecord := time.Now()
+	for !allDone(partitions) {
+		pollCtx, cancel := context.WithTimeout(ctx, r.batch.FlushInterval)
+		fetches := client.PollRecords(pollCtx, r.batch.Size)
+		cancel()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var fetchErr error
+		fetches.EachError(func(topic string, partition int32, err error) {
+			if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+				fetchErr = fmt.Errorf("failed to read %s[%d]: %w", topic, partition, err)
+			}
+		})
+		if fetchErr != nil {
+			return fetchErr
+		}
+
+		fetched := fetches.Records()
+		records := inRange(fetched, partitions)
+		if len(fetched) == 0 {
+			if time.Since(lastRecord) < idleTimeout {
+				continue
+			}
+			if err := r.finishDrained(ctx, job, partitions); err != nil {
+				return err
+			}
+			pauseDone(client, partitions)
+			lastRecord = time.Now()
+			continue
+		}
+		lastRecord = time.Now()
+
+		written, err := r.write(job, records)
+		if err != nil {
+			return err
+		}
+
+		advance(partitions, fetched)
+		if err := r.db.CheckpointReplayJob(ctx, job.Id, partitions, len(records), written); err != nil {
+			return err
+		}
+		pauseDone(client, partitions)
+	}
+
+	return nil
+}
+
+// finishDrained checks the high watermark of every unfinished partition
+// that stopped returning records. A partition is only finished once its
+// watermark shows nothing is left to read below its end offset, e.g.
+// because the log was truncated. A partition with records left that still
+// returns none fails the job, which resumes from its checkpoint.
+func (r *Runner) finishDrained(ctx context.Context, job *database.ReplayJob, partitions []database.ReplayPartition) error {
+	var unfinished []int32
+	for _, partition := range partitions {
+		if !partition.Done() {
+			unfinished = append(unfinished, partition.Partition)
+		}
+	}
+
+	watermarks, err := r.kafka.HighWatermarks(ctx, unfinished)
+	if err != nil {
+		return fmt.Errorf("failed to look up high watermarks: %w", err)
+	}
+
+	stuck := drained(partitions, watermarks)
+	if err := r.db.CheckpointReplayJob(ctx, job.Id, partitions, 0, 0); err != nil {
+		return err
+	}
+	if len(stuck) > 0 {
+		partition := stuck[0]
+		return fmt.Errorf("partition %d returned no records at offset %d of %d, high watermark %d",
+			partition.Partition, partition.NextOffset, partition.EndOffset, watermarks[partition.Partition])
+	}
+
+	return nil
+}
+
+// drained finishes every unfinished partition whose high watermark is not
+// past its next offset, and returns the unfinished partitions that still
+// have records to read.
+func drained(partitions []database.ReplayPartition, watermarks map[int32]int64) []database.ReplayPartition {
+	var stuck []database.ReplayPartition
+	for i := range partitions {
+		if partitions[i].Done() {
+			continue
+		}
+		watermark, ok := watermarks[partitions[i].Partition]
+		if ok && watermark <= partitions[i].NextOffset {
+			partitions[i].NextOffset = partitions[i].EndOffset
+			continue
+		}
+		stuck = append(stuck, partitions[i])
+	}
+	return stuck
+}
+
+// write indexes the records into the job's subscription and returns how
+// many transactions it wrote.
+func (r *Runner) write(job *database.ReplayJob, records []*kgo.Record) (int, error) {
+	var jobs []kafka.IndexJob
+	for _, record := range records {
+		recordJobs, err := kafka.MatchRecordForSubscription(record, job.SubscriptionId)
+		if err != nil {
+			// Malformed records were dead-lettered when they were consumed
+			if errors.Is(err, kafka.ErrMalformedRecord) {
+				continue
+			}
+			return 0, err
+		}
+
+		for _, indexJob := range recordJobs {
+			if inSlotRange(indexJob.Payload.Slot, job.FromSlot, job.ToSlot) {
+				jobs = append(jobs, indexJob)
+			}
+		}
+	}
+
+	if failures := kafka.IndexBatch(jobs); len(failures) > 0 {
+		return 0, fmt.Errorf("failed to write %d transactions: %w", len(failures), failures[0].Err)
+	}
+
+	return len(jobs), nil
+}
+
+// inRange returns the records before the end offset of their partition,
+// without control records.
+func inRange(records []*kgo.Record, partitions []database.ReplayPartition) []*kgo.Record {
+	ends := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		ends[partition.Partition] = partition.EndOffset
+	}
+
+	var kept []*kgo.Record
+	for _, record := range records {
+		if record.Attrs.IsControl() {
+			continue
+		}
+		if end, ok := ends[record.Partition]; ok && record.Offset < end {
+			kept = append(kept, record)
+		}
+	}
+	return kept
+}
+
+// advance moves the next offset of every partition past its records, up to
+// its end offset. Records arrive in order, so a record at or past the end
+// means the partition is done even if the offsets before it were not
+// records.
+func advance(partitions []database.ReplayPartition, records []*kgo.Record) {
+	for _, record := range records {
+		for i := range partitions {
+			if partitions[i].Partition == record.Partition && record.Offset >= partitions[i].NextOffset {
+				partitions[i].NextOffset = min(record.Offset+1, partitions[i].EndOffset)
+			}
+		}
+	}
+}
+
+// pauseDone stops fetching the partitions that are done.
+func pauseDone(client *kgo.Client, partitions []database.ReplayPartition) {
+	var done []int32
+	for _, partition := range partitions {
+		if partition.Done() {
+			done = append(done, partition.Partition)
+		}
+	}
+	if len(done) > 0 {
+		client.PauseFetchPartitions(map[string][]int32{os.Getenv("KAFKA_TOPIC"): done})
+	}
+}
+
+func allDone(partitions []database.ReplayPartition) bool {
+	for _, partition := range partitions {
+		if !partition.Done() {
+			return false
+		}
+	}
+	return true
+}
+
+func inSlotRange(slot int64, fromSlot *int64, toSlot *int64) bool {
+	if fromSlot != nil && slot < *fromSlot {
+		return false
+	}
+	if toSlot != nil && slot > *toSlot {
+		return false
+	}
+	return true
+}
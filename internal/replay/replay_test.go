@@ -0,0 +1,70 @@
+package replay
+
+import (
+	"testing"
+
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestAdvance(t *testing.T) {
+	partitions := []database.ReplayPartition{
+		{Partition: 0, StartOffset: 10, EndOffset: 13, NextOffset: 10},
+		{Partition: 1, StartOffset: 5, EndOffset: 5, NextOffset: 5},
+	}
+	records := []*kgo.Record{
+		{Partition: 0, Offset: 10},
+		{Partition: 0, Offset: 11},
+		{Partition: 0, Offset: 13},
+	}
+
+	kept := inRange(records, partitions)
+	if len(kept) != 2 {
+		t.Fatalf("expected the record past the end offset to be dropped, got %d records", len(kept))
+	}
+
+	advance(partitions, kept)
+	if partitions[0].NextOffset != 12 || allDone(partitions) {
+		t.Fatalf("expected partition 0 to continue at 12, got %+v", partitions[0])
+	}
+
+	// Offset 12 was not a record, reading past the end still finishes it
+	advance(partitions, []*kgo.Record{{Partition: 0, Offset: 13}})
+	if partitions[0].NextOffset != 13 || !allDone(partitions) {
+		t.Fatalf("expected every partition to be done, got %+v", partitions)
+	}
+}
+
+func TestInSlotRange(t *testing.T) {
+	from, to := int64(100), int64(200)
+	for slot, want := range map[int64]bool{99: false, 100: true, 200: true, 201: false} {
+		if got := inSlotRange(slot, &from, &to); got != want {
+			t.Errorf("inSlotRange(%d) = %t, want %t", slot, got, want)
+		}
+	}
+	if !inSlotRange(1, nil, nil) {
+		t.Error("expected every slot without a range")
+	}
+}
+
+func TestDrained(t *testing.T) {
+	partitions := []database.ReplayPartition{
+		// Read up to the watermark, the rest of the range is gone
+		{Partition: 0, StartOffset: 0, EndOffset: 10, NextOffset: 6},
+		// Records left below the watermark
+		{Partition: 1, StartOffset: 0, EndOffset: 10, NextOffset: 4},
+		{Partition: 2, StartOffset: 0, EndOffset: 5, NextOffset: 5},
+	}
+
+	stuck := drained(partitions, map[int32]int64{0: 6, 1: 12})
+
+	if !partitions[0].Done() {
+		t.Errorf("expected partition 0 to be finished by its watermark, got %+v", partitions[0])
+	}
+	if partitions[1].Done() || len(stuck) != 1 || stuck[0].Partition != 1 {
+		t.Errorf("expected only partition 1 to be stuck, got %+v", stuck)
+	}
+	if partitions[2].NextOffset != 5 {
+		t.Errorf("expected a finished partition to be left alone, got %+v", partitions[2])
+	}
+}
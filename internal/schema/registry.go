@@ -0,0 +1,198 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// The registry is a directory with one directory per subject, holding one
+// v<version>.json file per schema version. Schemas are only ever added, so
+// producers and consumers of different releases can check that what they
+// write and read is registered and that versions stay compatible.
+
+const defaultRegistryDir = "schemas"
+
+// EncodingProtobuf marks schemas whose messages are Protobuf encoded. Other
+// encodings, like the legacy JSON one, carry no field list.
+const EncodingProtobuf = "protobuf"
+
+// Field is a field of a Protobuf message. Type is a scalar type (string,
+// bytes, bool, int32, int64, double) or the name of another message of the
+// schema.
+type Field struct {
+	Number   int    `json:"number"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Repeated bool   `json:"repeated,omitempty"`
+}
+
+// Schema is one version of a subject.
+type Schema struct {
+	Subject  string `json:"subject"`
+	Version  int    `json:"version"`
+	Encoding string `json:"encoding"`
+	// Compression applied to the encoded value, if any
+	Compression string `json:"compression,omitempty"`
+	// Message the value is encoded as, for Protobuf schemas
+	Root     string             `json:"root,omitempty"`
+	Messages map[string][]Field `json:"messages,omitempty"`
+}
+
+// Registry holds every version of every subject, ordered by version.
+type Registry struct {
+	subjects map[string][]Schema
+}
+
+var versionFile = regexp.MustCompile(`^v(\d+)\.json$`)
+
+// LoadRegistry reads the registry from SCHEMA_REGISTRY_DIR (default
+// schemas) and checks that the versions of every subject are compatible.
+func LoadRegistry() (*Registry, error) {
+	dir := os.Getenv("SCHEMA_REGISTRY_DIR")
+	if dir == "" {
+		dir = defaultRegistryDir
+	}
+
+	return Load(dir)
+}
+
+// Load reads the registry in dir.
+func Load(dir string) (*Registry, error) {
+	subjects, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema registry: %w", err)
+	}
+
+	registry := &Registry{subjects: make(map[string][]Schema)}
+	for _, subject := range subjects {
+		if !subject.IsDir() {
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(dir, subject.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema registry: %w", err)
+		}
+
+		for _, file := range files {
+			match := versionFile.FindStringSubmatch(file.Name())
+			if match == nil {
+				continue
+			}
+
+			body, err := os.ReadFile(filepath.Join(dir, subject.Name(), file.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read schema %s/%s: %w", subject.Name(), file.Name(), err)
+			}
+
+			var s Schema
+			if err := json.Unmarshal(body, &s); err != nil {
+				return nil, fmt.Errorf("invalid schema %s/%s: %w", subject.Name(), file.Name(), err)
+			}
+			if version, _ := strconv.Atoi(match[1]); s.Subject != subject.Name() || s.Version != version {
+				return nil, fmt.Errorf("schema %s/%s declares %s v%d", subject.Name(), file.Name(), s.Subject, s.Version)
+			}
+			registry.subjects[s.Subject] = append(registry.subjects[s.Subject], s)
+		}
+	}
+
+	for subject, versions := range registry.subjects {
+		slices.SortFunc(versions, func(a, b Schema) int { return a.Version - b.Version })
+		for i, newer := range versions {
+			if err := newer.validate(); err != nil {
+				return nil, err
+			}
+			for _, older := range versions[:i] {
+				if err := Compatible(older, newer); err != nil {
+					return nil, fmt.Errorf("schema %s v%d: %w", subject, newer.Version, err)
+				}
+			}
+		}
+	}
+
+	return registry, nil
+}
+
+// Versions returns the registered versions of subject, oldest first.
+func (r *Registry) Versions(subject string) []Schema {
+	return r.subjects[subject]
+}
+
+// Verify checks that s is registered exactly as given, so whatever is
+// written with it can be read by every consumer using the registry.
+func (r *Registry) Verify(s Schema) error {
+	for _, registered := range r.subjects[s.Subject] {
+		if registered.Version != s.Version {
+			continue
+		}
+		if !reflect.DeepEqual(registered, s) {
+			return fmt.Errorf("schema %s v%d differs from the registered one", s.Subject, s.Version)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("schema %s v%d is not registered", s.Subject, s.Version)
+}
+
+// Compatible checks that data written with older can still be read with
+// newer. Protobuf fields may be added and removed, but a field number keeps
+// its type for good.
+func Compatible(older Schema, newer Schema) error {
+	if older.Encoding != EncodingProtobuf || newer.Encoding != EncodingProtobuf {
+		return nil
+	}
+
+	for message, olderFields := range older.Messages {
+		newerFields, ok := newer.Messages[message]
+		if !ok {
+			continue
+		}
+		for _, olderField := range olderFields {
+			for _, newerField := range newerFields {
+				if newerField.Number != olderField.Number {
+					continue
+				}
+				if newerField.Type != olderField.Type || newerField.Repeated != olderField.Repeated {
+					return fmt.Errorf("field %d of %s changed from %s in v%d", newerField.Number, message, olderField.Type, older.Version)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+var scalarTypes = []string{"string", "bytes", "bool", "int32", "int64", "double"}
+
+// validate checks that every field of a Protobuf schema has a known type
+// and a unique number.
+func (s Schema) validate() error {
+	if s.Encoding != EncodingProtobuf {
+		return nil
+	}
+	if _, ok := s.Messages[s.Root]; !ok {
+		return fmt.Errorf("schema %s v%d: root message %q is not defined", s.Subject, s.Version, s.Root)
+	}
+
+	for message, fields := range s.Messages {
+		numbers := make(map[int]bool)
+		for _, field := range fields {
+			if field.Number <= 0 || numbers[field.Number] {
+				return fmt.Errorf("schema %s v%d: %s has an invalid or repeated field number %d", s.Subject, s.Version, message, field.Number)
+			}
+			numbers[field.Number] = true
+
+			if _, ok := s.Messages[field.Type]; !ok && !slices.Contains(scalarTypes, field.Type) {
+				return fmt.Errorf("schema %s v%d: field %s.%s has unknown type %q", s.Subject, s.Version, message, field.Name, field.Type)
+			}
+		}
+	}
+
+	return nil
+}
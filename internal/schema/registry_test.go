@@ -0,0 +1,69 @@
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompatible(t *testing.T) {
+	older := Schema{
+		Subject:  "payload",
+		Version:  1,
+		Encoding: EncodingProtobuf,
+		Root:     "Payload",
+		Messages: map[string][]Field{
+			"Payload": {{Number: 1, Name: "signature", Type: "string"}, {Number: 2, Name: "slot", Type: "int64"}},
+		},
+	}
+
+	added := older
+	added.Version = 2
+	added.Messages = map[string][]Field{
+		"Payload": {{Number: 1, Name: "sig", Type: "string"}, {Number: 3, Name: "fee", Type: "int32"}},
+	}
+	if err := Compatible(older, added); err != nil {
+		t.Errorf("expected renamed, removed and added fields to be compatible; got %v", err)
+	}
+
+	changed := older
+	changed.Version = 2
+	changed.Messages = map[string][]Field{
+		"Payload": {{Number: 2, Name: "slot", Type: "string"}},
+	}
+	if err := Compatible(older, changed); err == nil {
+		t.Error("expected a field changing type to be incompatible")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	subject := filepath.Join(dir, "payload")
+	if err := os.Mkdir(subject, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(name string, body string) {
+		if err := os.WriteFile(filepath.Join(subject, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("v1.json", `{"subject": "payload", "version": 1, "encoding": "json"}`)
+	write("v2.json", `{"subject": "payload", "version": 2, "encoding": "protobuf", "root": "Payload",
+		"messages": {"Payload": [{"number": 1, "name": "slot", "type": "int64"}]}}`)
+	write("README.md", "not a schema")
+
+	registry, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions := registry.Versions("payload"); len(versions) != 2 || versions[1].Version != 2 {
+		t.Fatalf("expected versions 1 and 2; got %+v", versions)
+	}
+
+	write("v3.json", `{"subject": "payload", "version": 3, "encoding": "protobuf", "root": "Payload",
+		"messages": {"Payload": [{"number": 1, "name": "slot", "type": "string"}]}}`)
+	if _, err := Load(dir); err == nil {
+		t.Error("expected an incompatible version to be rejected")
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultWebhookSecretOverlap = 24 * time.Hour
+
+// adminMiddleware only lets through requests carrying the ADMIN_API_KEY in
+// the X-Admin-Key header. The admin API is disabled when the key is unset.
+func (s *Server) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminKey := os.Getenv("ADMIN_API_KEY")
+		if adminKey == "" {
+			http.Error(w, "Admin API is disabled", http.StatusForbidden)
+			return
+		}
+
+		providedKey := r.Header.Get("X-Admin-Key")
+		if subtle.ConstantTimeCompare([]byte(providedKey), []byte(adminKey)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	records, err := s.db.GetDeadLetterRecords(limit, offset)
+	if err != nil {
+		log.Println("Failed to list dead letter records: ", err)
+		http.Error(w, "Failed to list dead letter records", http.StatusInternalServerError)
+		return
+	}
+
+	// Payloads are left out of the listing, fetch a single record to see one
+	for i := range records {
+		records[i].Payload = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+func (s *Server) getDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	record, err := s.db.GetDeadLetterRecordById(id)
+	if err != nil {
+		log.Printf("Failed to get dead letter record %s: %v", id, err)
+		http.Error(w, "Dead letter record not found", http.StatusNotFound)
+		return
+	}
+
+	// Malformed records are dead-lettered as-is and may not be valid JSON
+	var payload interface{} = json.RawMessage(record.Payload)
+	if !json.Valid(record.Payload) {
+		payload = string(record.Payload)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":               record.Id,
+		"receiver_name":    record.ReceiverName,
+		"subscription_id":  record.SubscriptionId,
+		"payload":          payload,
+		"attempts":         record.Attempts,
+		"last_error":       record.LastError,
+		"source_topic":     record.SourceTopic,
+		"source_partition": record.SourcePartition,
+		"source_offset":    record.SourceOffset,
+		"created_at":       record.CreatedAt,
+		"replayed_at":      record.ReplayedAt,
+	})
+}
+
+func (s *Server) replayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	record, err := s.db.GetDeadLetterRecordById(id)
+	if err != nil {
+		log.Printf("Failed to get dead letter record %s: %v", id, err)
+		http.Error(w, "Dead letter record not found", http.StatusNotFound)
+		return
+	}
+
+	if err = s.queue.ReplayDeadLetter(*record); err != nil {
+		log.Println("Failed to replay dead letter record: ", err)
+		http.Error(w, "Failed to replay dead letter record", http.StatusInternalServerError)
+		return
+	}
+
+	if err = s.db.MarkDeadLetterReplayed(id); err != nil {
+		log.Printf("Replayed dead letter record %s but failed to mark it: %v", id, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status": "replayed"}`))
+}
+
+// rotateWebhookSecret gives a Helius webhook a new auth secret. The old one
+// is still accepted for the overlap query parameter, HELIUS_SECRET_OVERLAP
+// or 24h.
+func (s *Server) rotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	overlap, err := time.ParseDuration(r.URL.Query().Get("overlap"))
+	if err != nil || overlap < 0 {
+		overlap, err = time.ParseDuration(os.Getenv("HELIUS_SECRET_OVERLAP"))
+		if err != nil || overlap < 0 {
+			overlap = defaultWebhookSecretOverlap
+		}
+	}
+
+	cfg, err := s.db.RotateWebhookSecret(name, overlap)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to rotate secret for webhook %s: %v", name, err)
+		http.Error(w, "Failed to rotate webhook secret", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"webhook_name":               cfg.WebhookName,
+		"webhook_id":                 cfg.WebhookId,
+		"previous_secret_expires_at": cfg.PreviousSecretExpiresAt,
+	})
+}
+
+// deleteWebhook moves the addresses of a Helius webhook to the rest of the
+// pool and deletes it.
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	report, err := s.db.DeleteWebhook(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to delete webhook %s: %v", name, err)
+		http.Error(w, "Failed to delete webhook", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+type backfillRequest struct {
+	UntilSlot *int64     `json:"until_slot"`
+	UntilTime *time.Time `json:"until_time"`
+}
+
+// startBackfill queues a backfill of a subscription's address back to
+// until_slot or until_time. Without either it goes back to the address'
+// first transaction.
+func (s *Server) startBackfill(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	id := mux.Vars(r)["id"]
+
+	var req backfillRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid Json Payload", http.StatusBadRequest)
+			return
+		}
+	}
+	defer r.Body.Close()
+
+	if req.UntilSlot != nil && *req.UntilSlot < 0 {
+		http.Error(w, "until_slot must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.db.CreateBackfillJob(userId, id, req.UntilSlot, req.UntilTime)
+	if err != nil {
+		writeBackfillError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// getBackfill returns the progress of the subscription's latest backfill.
+func (s *Server) getBackfill(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	id := mux.Vars(r)["id"]
+
+	job, err := s.db.GetLatestBackfillJob(userId, id)
+	if err != nil {
+		writeBackfillError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func writeBackfillError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Backfill not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrSubscriptionPaused), errors.Is(err, database.ErrBackfillActive):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Failed to handle backfill for subscription %s: %v", id, err)
+		http.Error(w, "Failed to handle backfill", http.StatusInternalServerError)
+	}
+}
+
+// getSubscriptionGaps returns how far the subscription's address has been
+// received and checked, and the transactions found missing since.
+func (s *Server) getSubscriptionGaps(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	id := mux.Vars(r)["id"]
+
+	completeness, err := s.db.GetSubscriptionCompleteness(userId, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get gaps of subscription %s: %v", id, err)
+		http.Error(w, "Failed to get gaps", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(completeness)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+)
+
+// rotateSigningSecret replaces the user's http sink signing secret and
+// returns the new one. Deliveries are signed with it from the next batch.
+func (s *Server) rotateSigningSecret(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+
+	secret, err := s.db.RotateSigningSecret(userId)
+	if err != nil {
+		log.Printf("Failed to rotate signing secret for userId %s: %v", userId, err)
+		http.Error(w, "Failed to rotate signing secret", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"signing_secret": secret,
+	})
+}
+
+func (s *Server) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	query := r.URL.Query()
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	deliveries, err := s.db.GetHttpDeliveries(userId, query.Get("subscription_id"), query.Get("status"), limit, offset)
+	if err != nil {
+		log.Println("Failed to list http deliveries: ", err)
+		http.Error(w, "Failed to list deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func (s *Server) getDelivery(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	id := mux.Vars(r)["id"]
+
+	delivery, err := s.db.GetHttpDeliveryById(id)
+	if err != nil || delivery.UserId != userId {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// validDestinationURL rejects destinations that are not public http or https
+// endpoints, so subscriptions cannot make the worker call internal services.
+func validDestinationURL(ctx context.Context, destinationURL *string) error {
+	if destinationURL == nil || *destinationURL == "" {
+		return fmt.Errorf("destination_url is required for the http sink")
+	}
+
+	return kafka.ValidateDestinationURL(ctx, *destinationURL)
+}
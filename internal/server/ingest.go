@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/solana-indexer/internal/ingest"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+	"github.com/scythe504/solana-indexer/internal/metrics"
+)
+
+// handleWebhookReceiver takes the calls of the Helius webhook named in the
+// path.
+func (s *Server) handleWebhookReceiver(w http.ResponseWriter, r *http.Request) {
+	receiverName := mux.Vars(r)["receiverName"]
+	r = r.WithContext(context.WithValue(r.Context(), "receiverName", receiverName))
+
+	s.ingest(w, r, s.helius, receiverName)
+}
+
+// handleProviderIngest takes the calls of the other ingestion providers.
+// Their transactions are matched against every subscription, whichever
+// Helius webhook watches the address.
+func (s *Server) handleProviderIngest(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.providers[mux.Vars(r)["provider"]]
+	if !ok {
+		http.Error(w, "Unknown ingestion provider", http.StatusNotFound)
+		return
+	}
+
+	s.ingest(w, r, provider, kafka.ProviderReceiver(provider.Name()))
+}
+
+// ingest verifies a call with its provider, normalises the body and pushes
+// the payloads to Kafka.
+func (s *Server) ingest(w http.ResponseWriter, r *http.Request, provider ingest.Provider, receiverName string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Println("Error reading the request body: ", err)
+		http.Error(w, "Error reading the request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err = provider.Verify(r, receiverName, body); err != nil {
+		var rejection *ingest.Rejection
+		if errors.As(err, &rejection) {
+			metrics.WebhookRejected.Add(rejection.Reason, 1)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to authenticate webhook", http.StatusInternalServerError)
+		return
+	}
+
+	payloads, err := provider.Normalize(body)
+	if err != nil {
+		log.Printf("Invalid %s payload: %v", provider.Name(), err)
+		http.Error(w, "Error parsing the body to json: ", http.StatusBadRequest)
+		return
+	}
+
+	if err = kafka.PushToProducer(r.Context(), s.queue, payloads, receiverName); err != nil {
+		// The sender retries, so a full buffer sheds load instead of
+		// holding the request open
+		if errors.Is(err, kafka.ErrProducerFull) {
+			metrics.ProducerRejected.Add(1)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Producer queue is full", http.StatusServiceUnavailable)
+			return
+		}
+		log.Println("Failed to push the data to kafka producer", err)
+		http.Error(w, "Failed to queue the payload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status": "success"}`))
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+)
+
+// acceptAll is a provider that accepts every call as one payload.
+type acceptAll struct{}
+
+func (acceptAll) Name() string { return "test" }
+
+func (acceptAll) Verify(r *http.Request, receiverName string, body []byte) error { return nil }
+
+func (acceptAll) Normalize(body []byte) ([]kafka.WebhookPayload, error) {
+	return []kafka.WebhookPayload{{Signature: "sig"}}, nil
+}
+
+// contextQueue keeps the context payloads were produced with. Calling any
+// other queue method panics.
+type contextQueue struct {
+	kafka.Queue
+	ctx context.Context
+}
+
+func (q *contextQueue) ProduceWebhookPayload(ctx context.Context, payloads []kafka.WebhookPayload, receiverName string) error {
+	q.ctx = ctx
+	return ctx.Err()
+}
+
+func TestWebhookReceiverKeepsTheRequestContext(t *testing.T) {
+	queue := &contextQueue{}
+	s := &Server{queue: queue, helius: acceptAll{}}
+	router := mux.NewRouter()
+	router.HandleFunc("/webhook/{receiverName}", s.handleWebhookReceiver)
+
+	// A caller that went away cancels the produce
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodPost, "/webhook/webhook-0", strings.NewReader("{}")).WithContext(ctx)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if queue.ctx == nil || queue.ctx.Err() == nil {
+		t.Fatal("expected the payloads to be produced with the cancelled request context")
+	}
+	if got := queue.ctx.Value("receiverName"); got != "webhook-0" {
+		t.Errorf("expected the receiver in the context; got %v", got)
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected the cancelled produce to fail the call; got %d", w.Code)
+	}
+}
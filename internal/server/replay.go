@@ -0,0 +1,89 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/scythe504/solana-indexer/internal/kafka"
+)
+
+type replayRequest struct {
+	From     *time.Time `json:"from"`
+	To       *time.Time `json:"to"`
+	FromSlot *int64     `json:"from_slot"`
+	ToSlot   *int64     `json:"to_slot"`
+}
+
+// startReplay queues a replay of the records produced between from and to
+// into the subscription, e.g. after its destination lost data. from_slot and
+// to_slot narrow it down to the transactions of those slots.
+func (s *Server) startReplay(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	id := mux.Vars(r)["id"]
+
+	// Handled records are deleted from the embedded queue, there is nothing
+	// to replay
+	if kafka.EmbeddedQueueEnabled() {
+		http.Error(w, "Replays need the Kafka queue backend", http.StatusNotImplemented)
+		return
+	}
+
+	var req replayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Json Payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if req.From == nil || req.To == nil || !req.From.Before(*req.To) {
+		http.Error(w, "from and to are required and from must be before to", http.StatusBadRequest)
+		return
+	}
+	if req.FromSlot != nil && req.ToSlot != nil && *req.FromSlot > *req.ToSlot {
+		http.Error(w, "from_slot must not be after to_slot", http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.db.CreateReplayJob(userId, id, *req.From, *req.To, req.FromSlot, req.ToSlot)
+	if err != nil {
+		writeReplayError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// getReplay returns the progress of the subscription's latest replay.
+func (s *Server) getReplay(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	id := mux.Vars(r)["id"]
+
+	job, err := s.db.GetLatestReplayJob(userId, id)
+	if err != nil {
+		writeReplayError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func writeReplayError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Replay not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrSubscriptionPaused), errors.Is(err, database.ErrReplayActive):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Failed to handle replay for subscription %s: %v", id, err)
+		http.Error(w, "Failed to handle replay", http.StatusInternalServerError)
+	}
+}
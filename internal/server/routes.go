@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -19,6 +20,7 @@ import (
 	"github.com/markbates/goth/gothic"
 	"github.com/scythe504/solana-indexer/internal/database"
 	"github.com/scythe504/solana-indexer/internal/kafka"
+	"github.com/scythe504/solana-indexer/internal/metrics"
 	"github.com/scythe504/solana-indexer/internal/utils"
 )
 
@@ -37,6 +39,8 @@ func (s *Server) RegisterRoutes() http.Handler {
 
 	r.HandleFunc("/health", s.healthHandler)
 
+	r.Handle("/metrics", metrics.Handler())
+
 	r.HandleFunc("/auth/callback/{provider}", s.getAuthHandler)
 
 	r.HandleFunc("/auth/{provider}", s.beginAuthHandler)
@@ -45,6 +49,8 @@ func (s *Server) RegisterRoutes() http.Handler {
 
 	r.HandleFunc("/webhook/{receiverName}", s.handleWebhookReceiver)
 
+	r.HandleFunc("/ingest/{provider}", s.handleProviderIngest).Methods(http.MethodPost)
+
 	authRoutes := r.PathPrefix("/api").Subrouter()
 
 	authRoutes.Use(s.authMiddleWare)
@@ -53,8 +59,46 @@ func (s *Server) RegisterRoutes() http.Handler {
 
 	authRoutes.HandleFunc("/index-token", s.indexAddress)
 
+	authRoutes.HandleFunc("/subscriptions", s.listSubscriptions).Methods(http.MethodGet)
+
+	authRoutes.HandleFunc("/subscriptions/{id}", s.getSubscription).Methods(http.MethodGet)
+
+	authRoutes.HandleFunc("/subscriptions/{id}", s.updateSubscription).Methods(http.MethodPatch)
+
+	authRoutes.HandleFunc("/subscriptions/{id}", s.deleteSubscription).Methods(http.MethodDelete)
+
+	authRoutes.HandleFunc("/subscriptions/{id}/backfill", s.startBackfill).Methods(http.MethodPost)
+
+	authRoutes.HandleFunc("/subscriptions/{id}/backfill", s.getBackfill).Methods(http.MethodGet)
+
+	authRoutes.HandleFunc("/subscriptions/{id}/gaps", s.getSubscriptionGaps).Methods(http.MethodGet)
+
+	authRoutes.HandleFunc("/subscriptions/{id}/replay", s.startReplay).Methods(http.MethodPost)
+
+	authRoutes.HandleFunc("/subscriptions/{id}/replay", s.getReplay).Methods(http.MethodGet)
+
 	authRoutes.HandleFunc("/get-session", s.sessionHandler)
 
+	authRoutes.HandleFunc("/signing-secret", s.rotateSigningSecret).Methods(http.MethodPost)
+
+	authRoutes.HandleFunc("/deliveries", s.listDeliveries).Methods(http.MethodGet)
+
+	authRoutes.HandleFunc("/deliveries/{id}", s.getDelivery).Methods(http.MethodGet)
+
+	adminRoutes := r.PathPrefix("/admin").Subrouter()
+
+	adminRoutes.Use(s.adminMiddleware)
+
+	adminRoutes.HandleFunc("/dead-letters", s.listDeadLetters).Methods(http.MethodGet)
+
+	adminRoutes.HandleFunc("/dead-letters/{id}", s.getDeadLetter).Methods(http.MethodGet)
+
+	adminRoutes.HandleFunc("/dead-letters/{id}/replay", s.replayDeadLetter).Methods(http.MethodPost)
+
+	adminRoutes.HandleFunc("/webhooks/{name}/rotate-secret", s.rotateWebhookSecret).Methods(http.MethodPost)
+
+	adminRoutes.HandleFunc("/webhooks/{name}", s.deleteWebhook).Methods(http.MethodDelete)
+
 	return r
 }
 
@@ -170,31 +214,6 @@ func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(jsonResp)
 }
 
-func (s *Server) handleWebhookReceiver(w http.ResponseWriter, r *http.Request) {
-	receiverName := mux.Vars(r)["receiverName"]
-	r = r.WithContext(context.WithValue(context.Background(), "receiverName", receiverName))
-
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Println("Error reading the request body: ", err)
-		http.Error(w, "Error reading the request body", http.StatusBadRequest)
-		return
-	}
-	defer r.Body.Close()
-
-	err = kafka.ParseBodyAndPushToProducer(s.kafka, body, receiverName)
-
-	if err != nil {
-		log.Println("Invalid Json or failed to push the data to kafka producer", err)
-		http.Error(w, "Error parsing the body to json: ", http.StatusBadRequest)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status": "success"}`))
-}
-
 func (s *Server) createUserDatabase(w http.ResponseWriter, r *http.Request) {
 	userId := r.Context().Value("userId").(string)
 
@@ -396,16 +415,62 @@ func (s *Server) indexAddress(w http.ResponseWriter, r *http.Request) {
 
 	userId := r.Context().Value("userId").(string)
 
+	if addressData.Sink == "" {
+		addressData.Sink = database.SinkPostgres
+	}
+
+	if err = validStrategies(addressData.Strategies); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !kafka.HasSink(addressData.Sink) {
+		http.Error(w, fmt.Sprintf("Unknown sink: %s", addressData.Sink), http.StatusBadRequest)
+		return
+	}
+
+	// A signing secret is created with the first http subscription and only
+	// shown once, it can be rotated at /api/signing-secret
+	var signingSecret string
+	if addressData.Sink == database.SinkHTTP {
+		if err = validDestinationURL(r.Context(), addressData.DestinationURL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err = s.db.GetSigningSecret(userId); errors.Is(err, sql.ErrNoRows) {
+			signingSecret, err = s.db.RotateSigningSecret(userId)
+		}
+		if err != nil {
+			log.Printf("Failed to get signing secret for userId %s: %v", userId, err)
+			http.Error(w, "Failed to create signing secret", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		addressData.DestinationURL = nil
+	}
+
 	if err = s.db.CreateSubscription(
 		addressData.TokenAddress,
 		addressData.Strategies,
 		userId,
+		addressData.Sink,
+		addressData.DestinationURL,
 	); err != nil {
 		log.Println("Error occured while creating subscriptions, err: ", err)
 		http.Error(w, "Failed to create indexing for the given address", http.StatusInternalServerError)
 		return
 	}
 
+	if signingSecret != "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"success":        "token indexing started",
+			"signing_secret": signingSecret,
+		})
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`"success": "token indexing started"`))
 }
@@ -10,21 +10,29 @@ import (
 	_ "github.com/joho/godotenv/autoload"
 
 	"github.com/scythe504/solana-indexer/internal/database"
+	"github.com/scythe504/solana-indexer/internal/ingest"
 	"github.com/scythe504/solana-indexer/internal/kafka"
 )
 
 type Server struct {
-	port  int
-	db    database.Service
-	kafka *kafka.KafkaClientManager
+	port      int
+	db        database.Service
+	queue     kafka.Queue
+	helius    ingest.Provider
+	providers map[string]ingest.Provider
 }
 
-func NewServer() *http.Server {
+// NewServer queues the ingested payloads on queue, which the caller closes
+// once the server has shut down.
+func NewServer(queue kafka.Queue) *http.Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
+	db := database.New()
 	NewServer := &Server{
-		port:  port,
-		kafka: kafka.NewKafkaClientManager(),
-		db:    database.New(),
+		port:      port,
+		queue:     queue,
+		db:        db,
+		helius:    ingest.NewHelius(db),
+		providers: ingestProviders(),
 	}
 
 	// Declare Server config
@@ -38,3 +46,18 @@ func NewServer() *http.Server {
 
 	return server
 }
+
+// ingestProviders returns the providers served under /ingest/{provider}.
+// A provider is only enabled once its signing secret is set.
+func ingestProviders() map[string]ingest.Provider {
+	providers := make(map[string]ingest.Provider)
+	if secret := os.Getenv("INGEST_RPC_SECRET"); secret != "" {
+		rpc := ingest.NewSolanaRPC(secret)
+		providers[rpc.Name()] = rpc
+	}
+	if secret := os.Getenv("INGEST_GEYSER_SECRET"); secret != "" {
+		geyser := ingest.NewGeyser(secret)
+		providers[geyser.Name()] = geyser
+	}
+	return providers
+}
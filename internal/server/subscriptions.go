@@ -0,0 +1,127 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+func (s *Server) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+
+	subscriptions, err := s.db.GetSubscriptionsByUser(userId)
+	if err != nil {
+		log.Printf("Failed to list subscriptions for userId %s: %v", userId, err)
+		http.Error(w, "Failed to list subscriptions", http.StatusInternalServerError)
+		return
+	}
+
+	if subscriptions == nil {
+		subscriptions = []database.Subscription{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscriptions)
+}
+
+func (s *Server) getSubscription(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	id := mux.Vars(r)["id"]
+
+	subscription, err := s.db.GetSubscriptionById(userId, id)
+	if err != nil {
+		writeSubscriptionError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscription)
+}
+
+// updateSubscription edits the strategies or destination of a subscription,
+// or pauses and resumes it through the status flag.
+func (s *Server) updateSubscription(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	id := mux.Vars(r)["id"]
+
+	var update database.SubscriptionUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid Json Payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if update.Strategies != nil {
+		if err := validStrategies(*update.Strategies); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if update.DestinationURL != nil {
+		current, err := s.db.GetSubscriptionById(userId, id)
+		if err != nil {
+			writeSubscriptionError(w, id, err)
+			return
+		}
+		if current.Sink != database.SinkHTTP {
+			http.Error(w, "destination_url can only be set on http subscriptions", http.StatusBadRequest)
+			return
+		}
+		if err = validDestinationURL(r.Context(), update.DestinationURL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	subscription, err := s.db.UpdateSubscription(userId, id, update)
+	if err != nil {
+		writeSubscriptionError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscription)
+}
+
+func (s *Server) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	id := mux.Vars(r)["id"]
+
+	if err := s.db.DeleteSubscription(userId, id); err != nil {
+		writeSubscriptionError(w, id, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeSubscriptionError(w http.ResponseWriter, id string, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Failed to handle subscription %s: %v", id, err)
+	http.Error(w, "Failed to update subscription", http.StatusInternalServerError)
+}
+
+func validStrategies(strategies []database.IndexingStrategy) error {
+	if len(strategies) == 0 {
+		return fmt.Errorf("at least one indexing_strategy is required")
+	}
+
+	for _, strategy := range strategies {
+		if _, ok := database.StrategyTransactionTypes[strategy]; !ok {
+			return fmt.Errorf("unknown indexing_strategy: %s", strategy)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/scythe504/solana-indexer/internal/database"
+)
+
+// fakeSubscriptions stores the subscriptions of the handlers under test.
+// Calling any other database method panics.
+type fakeSubscriptions struct {
+	database.Service
+	subscriptions map[string]*database.Subscription
+	updates       []database.SubscriptionUpdate
+	// Every method fails with it while set
+	err error
+}
+
+func newFakeSubscriptions(subscriptions ...database.Subscription) *fakeSubscriptions {
+	f := &fakeSubscriptions{subscriptions: make(map[string]*database.Subscription)}
+	for i := range subscriptions {
+		f.subscriptions[subscriptions[i].Id] = &subscriptions[i]
+	}
+	return f
+}
+
+func (f *fakeSubscriptions) GetSubscriptionsByUser(userId string) ([]database.Subscription, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var subscriptions []database.Subscription
+	for _, subscription := range f.subscriptions {
+		if subscription.UserId == userId {
+			subscriptions = append(subscriptions, *subscription)
+		}
+	}
+	slices.SortFunc(subscriptions, func(a, b database.Subscription) int { return strings.Compare(a.Id, b.Id) })
+	return subscriptions, nil
+}
+
+func (f *fakeSubscriptions) GetSubscriptionById(userId string, id string) (*database.Subscription, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	subscription, ok := f.subscriptions[id]
+	if !ok || subscription.UserId != userId {
+		return nil, sql.ErrNoRows
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func (f *fakeSubscriptions) UpdateSubscription(userId string, id string, update database.SubscriptionUpdate) (*database.Subscription, error) {
+	subscription, err := f.GetSubscriptionById(userId, id)
+	if err != nil {
+		return nil, err
+	}
+	f.updates = append(f.updates, update)
+	if update.Strategies != nil {
+		subscription.Strategies = *update.Strategies
+	}
+	if update.Status != nil {
+		subscription.Status = *update.Status
+	}
+	if update.DestinationURL != nil {
+		subscription.DestinationURL = update.DestinationURL
+	}
+	f.subscriptions[id] = subscription
+	return subscription, nil
+}
+
+func (f *fakeSubscriptions) DeleteSubscription(userId string, id string) error {
+	if _, err := f.GetSubscriptionById(userId, id); err != nil {
+		return err
+	}
+	delete(f.subscriptions, id)
+	return nil
+}
+
+// serveSubscriptions sends a request to the subscription routes as user-1.
+func serveSubscriptions(db database.Service, method string, path string, body string) *httptest.ResponseRecorder {
+	s := &Server{db: db}
+	router := mux.NewRouter()
+	router.HandleFunc("/subscriptions", s.listSubscriptions).Methods(http.MethodGet)
+	router.HandleFunc("/subscriptions/{id}", s.getSubscription).Methods(http.MethodGet)
+	router.HandleFunc("/subscriptions/{id}", s.updateSubscription).Methods(http.MethodPatch)
+	router.HandleFunc("/subscriptions/{id}", s.deleteSubscription).Methods(http.MethodDelete)
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), "userId", "user-1"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func testSubscriptions() *fakeSubscriptions {
+	return newFakeSubscriptions(
+		database.Subscription{
+			Id:         "sub-1",
+			UserId:     "user-1",
+			Strategies: []database.IndexingStrategy{database.TokenCrossPlatformPrices},
+			Sink:       database.SinkPostgres,
+			Status:     true,
+		},
+		database.Subscription{
+			Id:         "sub-2",
+			UserId:     "user-1",
+			Strategies: []database.IndexingStrategy{database.NFTCurrentBids},
+			Sink:       database.SinkHTTP,
+			Status:     true,
+		},
+		database.Subscription{
+			Id:         "sub-other",
+			UserId:     "user-2",
+			Strategies: []database.IndexingStrategy{database.NFTCurrentBids},
+			Sink:       database.SinkPostgres,
+			Status:     true,
+		},
+	)
+}
+
+func TestListSubscriptions(t *testing.T) {
+	w := serveSubscriptions(testSubscriptions(), http.MethodGet, "/subscriptions", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status OK; got %d", w.Code)
+	}
+
+	var subscriptions []database.Subscription
+	if err := json.NewDecoder(w.Body).Decode(&subscriptions); err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 2 || subscriptions[0].Id != "sub-1" || subscriptions[1].Id != "sub-2" {
+		t.Errorf("expected the subscriptions of user-1; got %+v", subscriptions)
+	}
+
+	// No subscriptions is an empty list, not null
+	w = serveSubscriptions(newFakeSubscriptions(), http.MethodGet, "/subscriptions", "")
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Errorf("expected an empty list; got %s", body)
+	}
+
+	db := testSubscriptions()
+	db.err = errors.New("database down")
+	if w := serveSubscriptions(db, http.MethodGet, "/subscriptions", ""); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected a failed lookup to be a server error; got %d", w.Code)
+	}
+}
+
+func TestGetSubscription(t *testing.T) {
+	db := testSubscriptions()
+
+	w := serveSubscriptions(db, http.MethodGet, "/subscriptions/sub-1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status OK; got %d", w.Code)
+	}
+	var subscription database.Subscription
+	if err := json.NewDecoder(w.Body).Decode(&subscription); err != nil {
+		t.Fatal(err)
+	}
+	if subscription.Id != "sub-1" {
+		t.Errorf("expected sub-1; got %+v", subscription)
+	}
+
+	for _, path := range []string{"/subscriptions/missing", "/subscriptions/sub-other"} {
+		if w := serveSubscriptions(db, http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+			t.Errorf("expected %s to be not found; got %d", path, w.Code)
+		}
+	}
+}
+
+func TestUpdateSubscription(t *testing.T) {
+	cases := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{
+			name:   "strategies",
+			path:   "/subscriptions/sub-1",
+			body:   `{"indexing_strategy":["tokens_available_to_borrow"]}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "pause",
+			path:   "/subscriptions/sub-1",
+			body:   `{"status":false}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "destination of an http subscription",
+			path:   "/subscriptions/sub-2",
+			body:   `{"destination_url":"https://93.184.216.34/hook"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "invalid json",
+			path:   "/subscriptions/sub-1",
+			body:   `{`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "no strategies",
+			path:   "/subscriptions/sub-1",
+			body:   `{"indexing_strategy":[]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown strategy",
+			path:   "/subscriptions/sub-1",
+			body:   `{"indexing_strategy":["UNKNOWN"]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "destination of a postgres subscription",
+			path:   "/subscriptions/sub-1",
+			body:   `{"destination_url":"https://93.184.216.34/hook"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "private destination",
+			path:   "/subscriptions/sub-2",
+			body:   `{"destination_url":"http://127.0.0.1/hook"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "subscription of another user",
+			path:   "/subscriptions/sub-other",
+			body:   `{"status":false}`,
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := testSubscriptions()
+
+			w := serveSubscriptions(db, http.MethodPatch, c.path, c.body)
+			if w.Code != c.status {
+				t.Fatalf("expected status %d; got %d: %s", c.status, w.Code, w.Body.String())
+			}
+			if c.status != http.StatusOK {
+				if len(db.updates) != 0 {
+					t.Errorf("a rejected update reached the database: %+v", db.updates)
+				}
+				return
+			}
+
+			var subscription database.Subscription
+			if err := json.NewDecoder(w.Body).Decode(&subscription); err != nil {
+				t.Fatal(err)
+			}
+			stored := db.subscriptions[subscription.Id]
+			if !slices.Equal(subscription.Strategies, stored.Strategies) || subscription.Status != stored.Status {
+				t.Errorf("expected the updated subscription %+v; got %+v", *stored, subscription)
+			}
+		})
+	}
+}
+
+func TestDeleteSubscription(t *testing.T) {
+	db := testSubscriptions()
+
+	if w := serveSubscriptions(db, http.MethodDelete, "/subscriptions/sub-1", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected status No Content; got %d", w.Code)
+	}
+	if _, ok := db.subscriptions["sub-1"]; ok {
+		t.Error("expected sub-1 to be deleted")
+	}
+
+	if w := serveSubscriptions(db, http.MethodDelete, "/subscriptions/sub-other", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected another user's subscription to be not found; got %d", w.Code)
+	}
+	if _, ok := db.subscriptions["sub-other"]; !ok {
+		t.Error("another user's subscription was deleted")
+	}
+}
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Create dead_letter_records table
+CREATE TABLE dead_letter_records (
+    id VARCHAR(255) PRIMARY KEY,
+    receiver_name VARCHAR(255) NOT NULL,
+    subscription_id VARCHAR(255) NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_error TEXT NOT NULL,
+    source_topic VARCHAR(255) NOT NULL,
+    source_partition INTEGER NOT NULL,
+    source_offset BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    replayed_at TIMESTAMP
+);
+
+-- Add indexes for performance
+CREATE INDEX idx_dead_letter_records_created_at ON dead_letter_records(created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_dead_letter_records_created_at;
+DROP TABLE IF EXISTS dead_letter_records;
+-- +goose StatementEnd
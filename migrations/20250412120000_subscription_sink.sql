@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Record which sink each subscription writes to
+ALTER TABLE subscriptions ADD COLUMN sink VARCHAR(50) NOT NULL DEFAULT 'postgres';
+ALTER TABLE subscription_lookup ADD COLUMN sink VARCHAR(50) NOT NULL DEFAULT 'postgres';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE subscription_lookup DROP COLUMN IF EXISTS sink;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS sink;
+-- +goose StatementEnd
@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Endpoint the http sink posts a subscription's transactions to
+ALTER TABLE subscriptions ADD COLUMN destination_url TEXT;
+ALTER TABLE subscription_lookup ADD COLUMN destination_url TEXT;
+
+-- Secret used to sign http sink requests, one per user
+CREATE TABLE user_signing_secrets (
+    user_id VARCHAR(255) PRIMARY KEY,
+    secret VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Create http_deliveries table
+CREATE TABLE http_deliveries (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    subscription_id VARCHAR(255) NOT NULL,
+    signature VARCHAR(255) NOT NULL,
+    url TEXT NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    attempts INTEGER NOT NULL,
+    response_status INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Add indexes for performance
+CREATE INDEX idx_http_deliveries_user_id_created_at ON http_deliveries(user_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_http_deliveries_user_id_created_at;
+DROP TABLE IF EXISTS http_deliveries;
+DROP TABLE IF EXISTS user_signing_secrets;
+ALTER TABLE subscription_lookup DROP COLUMN IF EXISTS destination_url;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS destination_url;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The code has always queried helius_webhook_config
+ALTER TABLE helius_webhook_configs RENAME TO helius_webhook_config;
+
+-- Per-webhook secret Helius sends in the Authorization header. The previous
+-- secret keeps being accepted until previous_secret_expires_at so a rotation
+-- does not drop calls already in flight.
+ALTER TABLE helius_webhook_config ADD COLUMN auth_secret VARCHAR(255);
+ALTER TABLE helius_webhook_config ADD COLUMN previous_auth_secret VARCHAR(255);
+ALTER TABLE helius_webhook_config ADD COLUMN previous_secret_expires_at TIMESTAMP;
+CREATE UNIQUE INDEX idx_helius_webhook_config_webhook_name ON helius_webhook_config(webhook_name);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_helius_webhook_config_webhook_name;
+ALTER TABLE helius_webhook_config DROP COLUMN IF EXISTS previous_secret_expires_at;
+ALTER TABLE helius_webhook_config DROP COLUMN IF EXISTS previous_auth_secret;
+ALTER TABLE helius_webhook_config DROP COLUMN IF EXISTS auth_secret;
+ALTER TABLE helius_webhook_config RENAME TO helius_webhook_configs;
+-- +goose StatementEnd
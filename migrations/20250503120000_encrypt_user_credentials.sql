@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- db_password and connection_string are stored encrypted with a per-row data
+-- key. key_id names the key-encryption key that wrapped encrypted_data_key,
+-- rows without one are still plaintext until the next key rotation.
+ALTER TABLE user_database_credentials ALTER COLUMN db_password TYPE TEXT;
+ALTER TABLE user_database_credentials ADD COLUMN key_id VARCHAR(255);
+ALTER TABLE user_database_credentials ADD COLUMN encrypted_data_key TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE user_database_credentials DROP COLUMN IF EXISTS encrypted_data_key;
+ALTER TABLE user_database_credentials DROP COLUMN IF EXISTS key_id;
+ALTER TABLE user_database_credentials ALTER COLUMN db_password TYPE VARCHAR(255);
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Link every lookup row to the subscription it was expanded from
+ALTER TABLE subscription_lookup ADD COLUMN subscription_id VARCHAR(255) REFERENCES subscriptions(id) ON DELETE CASCADE;
+CREATE UNIQUE INDEX idx_subscription_lookup_subscription_strategy ON subscription_lookup(subscription_id, strategy);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_subscription_lookup_subscription_strategy;
+ALTER TABLE subscription_lookup DROP COLUMN IF EXISTS subscription_id;
+-- +goose StatementEnd
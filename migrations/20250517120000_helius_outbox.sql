@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Lookup rows point at the webhook config watching their address. Ids of
+-- webhooks we have no config for are cleared, the reconcile command assigns
+-- them again.
+UPDATE subscription_lookup
+SET helius_webhook_id = NULL
+WHERE helius_webhook_id IS NOT NULL
+    AND helius_webhook_id NOT IN (SELECT webhook_id FROM helius_webhook_config);
+ALTER TABLE subscription_lookup
+    ADD CONSTRAINT fk_subscription_lookup_helius_webhook
+    FOREIGN KEY (helius_webhook_id) REFERENCES helius_webhook_config(webhook_id) ON DELETE SET NULL;
+
+-- Helius calls a subscription change still owes. Entries are written in the
+-- same transaction as the change and stay pending until Helius accepted them.
+CREATE TABLE helius_outbox (
+    id VARCHAR(255) PRIMARY KEY,
+    subscription_id VARCHAR(255),
+    token_address VARCHAR(255) NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_helius_outbox_status_updated_at ON helius_outbox(status, updated_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_helius_outbox_status_updated_at;
+DROP TABLE IF EXISTS helius_outbox;
+ALTER TABLE subscription_lookup DROP CONSTRAINT IF EXISTS fk_subscription_lookup_helius_webhook;
+-- +goose StatementEnd
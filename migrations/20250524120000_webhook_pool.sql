@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Transaction types each webhook sends, so new addresses can go to a webhook
+-- that already sends what they need
+ALTER TABLE helius_webhook_config ADD COLUMN transaction_types TEXT[];
+
+-- The webhook watching each address
+CREATE TABLE helius_webhook_addresses (
+    token_address VARCHAR(255) PRIMARY KEY,
+    webhook_id VARCHAR(255) NOT NULL REFERENCES helius_webhook_config(webhook_id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_helius_webhook_addresses_webhook_id ON helius_webhook_addresses(webhook_id);
+
+INSERT INTO helius_webhook_addresses (token_address, webhook_id, created_at)
+SELECT DISTINCT ON (token_address) token_address, helius_webhook_id, NOW()
+FROM subscription_lookup
+WHERE helius_webhook_id IS NOT NULL
+ORDER BY token_address, last_updated DESC;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_helius_webhook_addresses_webhook_id;
+DROP TABLE IF EXISTS helius_webhook_addresses;
+ALTER TABLE helius_webhook_config DROP COLUMN IF EXISTS transaction_types;
+-- +goose StatementEnd
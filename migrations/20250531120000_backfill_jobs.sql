@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Historical backfills of a subscription's address. before_signature is the
+-- checkpoint: the oldest signature already fetched, where the next page
+-- starts.
+CREATE TABLE backfill_jobs (
+    id VARCHAR(255) PRIMARY KEY,
+    subscription_id VARCHAR(255) NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    token_address VARCHAR(255) NOT NULL,
+    until_slot BIGINT,
+    until_time TIMESTAMP,
+    status VARCHAR(32) NOT NULL DEFAULT 'pending',
+    before_signature VARCHAR(255),
+    signatures_scanned BIGINT NOT NULL DEFAULT 0,
+    transactions_produced BIGINT NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+-- A subscription runs one backfill at a time
+CREATE UNIQUE INDEX idx_backfill_jobs_active ON backfill_jobs(subscription_id) WHERE status IN ('pending', 'running');
+CREATE INDEX idx_backfill_jobs_status_updated_at ON backfill_jobs(status, updated_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_backfill_jobs_status_updated_at;
+DROP INDEX IF EXISTS idx_backfill_jobs_active;
+DROP TABLE IF EXISTS backfill_jobs;
+-- +goose StatementEnd
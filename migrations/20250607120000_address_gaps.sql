@@ -0,0 +1,47 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Per watched address, the newest transaction the worker received and how
+-- far the gap detector has compared it against the chain.
+CREATE TABLE address_cursors (
+    token_address VARCHAR(255) PRIMARY KEY,
+    last_slot BIGINT,
+    last_signature VARCHAR(255),
+    checked_slot BIGINT,
+    checked_signature VARCHAR(255),
+    checked_at TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Signatures the worker received for watched addresses. Rows are pruned once
+-- the gap detector has checked past them.
+CREATE TABLE address_signatures (
+    token_address VARCHAR(255) NOT NULL,
+    signature VARCHAR(255) NOT NULL,
+    slot BIGINT NOT NULL,
+    seen_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (token_address, signature)
+);
+CREATE INDEX idx_address_signatures_slot ON address_signatures(token_address, slot);
+
+-- Runs of transactions the worker never received, recorded by the gap
+-- detector once it has queued them again.
+CREATE TABLE address_gaps (
+    id VARCHAR(255) PRIMARY KEY,
+    token_address VARCHAR(255) NOT NULL,
+    from_slot BIGINT NOT NULL,
+    to_slot BIGINT NOT NULL,
+    signatures TEXT[] NOT NULL,
+    transaction_types TEXT[] NOT NULL,
+    detected_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_address_gaps_address_detected_at ON address_gaps(token_address, detected_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_address_gaps_address_detected_at;
+DROP TABLE IF EXISTS address_gaps;
+DROP INDEX IF EXISTS idx_address_signatures_slot;
+DROP TABLE IF EXISTS address_signatures;
+DROP TABLE IF EXISTS address_cursors;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Replays of KAFKA_TOPIC into a single subscription. partitions holds, per
+-- partition, the offsets the time range maps to and the next offset to
+-- read, which is the checkpoint.
+CREATE TABLE replay_jobs (
+    id VARCHAR(255) PRIMARY KEY,
+    subscription_id VARCHAR(255) NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    from_time TIMESTAMP NOT NULL,
+    to_time TIMESTAMP NOT NULL,
+    from_slot BIGINT,
+    to_slot BIGINT,
+    status VARCHAR(32) NOT NULL DEFAULT 'pending',
+    partitions JSONB,
+    records_total BIGINT NOT NULL DEFAULT 0,
+    records_scanned BIGINT NOT NULL DEFAULT 0,
+    transactions_written BIGINT NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+-- A subscription runs one replay at a time
+CREATE UNIQUE INDEX idx_replay_jobs_active ON replay_jobs(subscription_id) WHERE status IN ('pending', 'running');
+CREATE INDEX idx_replay_jobs_status_updated_at ON replay_jobs(status, updated_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_replay_jobs_status_updated_at;
+DROP INDEX IF EXISTS idx_replay_jobs_active;
+DROP TABLE IF EXISTS replay_jobs;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The embedded queue, used instead of Kafka when QUEUE_BACKEND is embedded.
+-- A row is a record with its key, value and headers. Workers lock rows with
+-- SKIP LOCKED until locked_until and delete them once they are handled.
+CREATE TABLE queue_records (
+    id BIGSERIAL PRIMARY KEY,
+    record_key TEXT NOT NULL,
+    value BYTEA NOT NULL,
+    headers JSONB NOT NULL,
+    available_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_queue_records_available_at ON queue_records(available_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_queue_records_available_at;
+DROP TABLE IF EXISTS queue_records;
+-- +goose StatementEnd
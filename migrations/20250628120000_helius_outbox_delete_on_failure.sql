@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Only the registration of a new subscription deletes it when it keeps
+-- failing. Registrations of edited or resumed subscriptions are left failed.
+ALTER TABLE helius_outbox ADD COLUMN delete_on_failure BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE helius_outbox SET delete_on_failure = TRUE WHERE action = 'register';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE helius_outbox DROP COLUMN IF EXISTS delete_on_failure;
+-- +goose StatementEnd
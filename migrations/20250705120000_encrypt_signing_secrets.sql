@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- secret is stored encrypted like user database credentials, rows without a
+-- key_id are still plaintext until the next key rotation.
+ALTER TABLE user_signing_secrets ALTER COLUMN secret TYPE TEXT;
+ALTER TABLE user_signing_secrets ADD COLUMN key_id VARCHAR(255);
+ALTER TABLE user_signing_secrets ADD COLUMN encrypted_data_key TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE user_signing_secrets DROP COLUMN IF EXISTS encrypted_data_key;
+ALTER TABLE user_signing_secrets DROP COLUMN IF EXISTS key_id;
+ALTER TABLE user_signing_secrets ALTER COLUMN secret TYPE VARCHAR(255);
+-- +goose StatementEnd
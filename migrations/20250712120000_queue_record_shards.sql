@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Records are spread over 64 shards by the hash of their key. A worker holds
+-- the advisory lock of a shard while it handles its records, so the records
+-- of a key are handled one batch at a time and in order. The shard lock
+-- replaces the per row locked_until.
+ALTER TABLE queue_records ADD COLUMN shard INTEGER NOT NULL DEFAULT 0;
+UPDATE queue_records SET shard = (hashtext(record_key) & 2147483647) % 64;
+ALTER TABLE queue_records ALTER COLUMN shard DROP DEFAULT;
+ALTER TABLE queue_records DROP COLUMN locked_until;
+CREATE INDEX idx_queue_records_shard ON queue_records(shard, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_queue_records_shard;
+ALTER TABLE queue_records ADD COLUMN locked_until TIMESTAMP;
+ALTER TABLE queue_records DROP COLUMN IF EXISTS shard;
+-- +goose StatementEnd